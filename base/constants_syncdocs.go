@@ -33,6 +33,8 @@ const (
 	RepairBackupPrefix               = SyncDocPrefix + "repair:backup:"                // RepairBackupPrefix is the doc prefix used to store a backup of a repaired document
 	RepairDryRunPrefix               = SyncDocPrefix + "repair:dryrun:"                // RepairDryRunPrefix is the doc prefix used to store a repaired document in dry-run mode
 	SGRStatusPrefix                  = SyncDocPrefix + "sgrStatus:"                    // SGRStatusPrefix is the doc prefix used to store ISGR status documents
+	DocCountCreatedKey               = SyncDocPrefix + "doc_count:created"             // DocCountCreatedKey is a counter document storing the number of documents created while a doc count quota is configured
+	DocCountPurgedKey                = SyncDocPrefix + "doc_count:purged"              // DocCountPurgedKey is a counter document storing the number of documents purged while a doc count quota is configured
 )
 
 // Sync Gateway Metadata documents that should be GroupID scoped and accessed via the "WithGroupID" helper methods below
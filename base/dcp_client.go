@@ -1,6 +1,7 @@
 package base
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"expvar"
@@ -20,6 +21,10 @@ const openStreamTimeout = 30 * time.Second
 const openRetryCount = uint32(10)
 const defaultNumWorkers = 8
 
+// Backoff between attempts to open a stream after a timeout.  Doubles from the initial value up to the maximum.
+const openStreamInitialBackoff = 100 * time.Millisecond
+const defaultOpenStreamMaxBackoff = 10 * time.Second
+
 const infiniteOpenStreamRetries = uint32(math.MaxUint32)
 
 type endStreamCallbackFunc func(e endStreamEvent)
@@ -35,6 +40,7 @@ type DCPClient struct {
 	numVbuckets                uint16                         // number of vbuckets on target data store
 	terminator                 chan bool                      // Used to close worker goroutines spawned by the DCPClient
 	doneChannel                chan error                     // Returns nil on successful completion of one-shot feed or external close of feed, error otherwise
+	stopped                    chan struct{}                  // Closed once the client has closed and its workers have finished
 	metadata                   DCPMetadataStore               // Implementation of DCPMetadataStore for metadata persistence
 	activeVbuckets             map[uint16]struct{}            // vbuckets that have an open stream
 	activeVbucketLock          sync.Mutex                     // Synchronization for activeVbuckets
@@ -48,19 +54,107 @@ type DCPClient struct {
 	dbStats                    *expvar.Map                    // Stats for database
 	agentPriority              gocbcore.DcpAgentPriority      // agentPriority specifies the priority level for a dcp stream
 	collectionIDs              []uint32                       // collectionIDs used by gocbcore, if empty, uses default collections
+	streamCollections          map[uint32]struct{}            // If set, the collections streams are opened for.  Document events for other collections are dropped
+	collection                 *Collection                    // Target collection, used to retrieve vbucket high seqnos for progress logging
+	progress                   *dcpProgress                   // Aggregate processing progress, updated by workers
+	progressLogInterval        time.Duration                  // If non-zero, aggregate progress is logged at this interval
+	trackProgress              bool                           // If true, completion is tracked against vbucket high seqnos even when progress isn't logged
+	keyFilter                  DCPKeyFilterFunc               // If set, only document events for keys accepted by the filter are sent to callback
+	useOSOBackfill             bool                           // If true, requests out-of-sequence-order backfill
+	workerQueueSize            int                            // Size of each worker's event queue.  Defaults to defaultQueueLength when zero
+	resumed                    chan struct{}                  // Non-nil while the client is paused, closed on resume
+	pauseLock                  sync.Mutex                     // Synchronization for resumed
+	rollbackHandler            DCPRollbackHandlerFunc         // If set, invoked when a vbucket's metadata is rolled back, before its stream is reopened
+	openStreamMaxBackoff       time.Duration                  // Maximum delay between attempts to open a stream
+	endSeqNos                  map[uint16]uint64              // If set, the sequence each vbucket's stream ends at
+	mutationBatches            []*mutationBatch               // If set, each vbucket's mutations not yet sent to its worker
+	mutationBatchSize          int                            // Maximum number of mutations in a batch
+	mutationBatchFlushInterval time.Duration                  // Maximum time a mutation is held in a batch
+	startPositions             DCPStartMetadata               // Caller-supplied start positions not yet verified against their vbucket's failover log
+	closedStreams              map[uint16]struct{}            // vbuckets whose stream was closed to backfill from zero, and hasn't ended yet
+	startPositionsLock         sync.Mutex                     // Synchronization for startPositions and closedStreams
+}
+
+// DCPRollbackHandlerFunc is invoked when KV requests a rollback for a vbucket.  rollbackSeq is the sequence the
+// vbucket's stream will be reopened from.
+type DCPRollbackHandlerFunc func(vbID uint16, rollbackSeq uint64)
+
+// DCPKeyFilterFunc returns true for keys whose document events should be sent to a DCPClient's callback.
+type DCPKeyFilterFunc func(key []byte) bool
+
+// ExcludeKeyPrefixes returns a DCPKeyFilterFunc that accepts all keys that don't start with one of the given prefixes.
+func ExcludeKeyPrefixes(prefixes ...string) DCPKeyFilterFunc {
+	prefixBytes := make([][]byte, len(prefixes))
+	for i, prefix := range prefixes {
+		prefixBytes[i] = []byte(prefix)
+	}
+	return func(key []byte) bool {
+		for _, prefix := range prefixBytes {
+			if bytes.HasPrefix(key, prefix) {
+				return false
+			}
+		}
+		return true
+	}
 }
 
+// DCPClientOptions configures a DCPClient.
+//
+// Stream events are processed by a pool of NumWorkers workers, each with a queue of WorkerQueueSize events.  Every
+// vbucket is assigned to a single worker, so events for a vbucket are always processed in the order DCP sent them (DCP
+// only guarantees ordering within a vbucket), but a slow callback for one vbucket holds up the other vbuckets assigned
+// to the same worker.  More workers reduce that head-of-line blocking at the cost of more concurrent callbacks.
+// Workers beyond one per vbucket are idle.  A longer queue absorbs bursts of mutations without blocking the DCP
+// connection, at the cost of memory and of more events to reprocess after a restart.
 type DCPClientOptions struct {
-	NumWorkers                 int
+	NumWorkers                 int // Number of workers processing stream events.  Defaults to defaultNumWorkers
+	WorkerQueueSize            int // Number of stream events each worker can queue.  Defaults to defaultQueueLength
 	OneShot                    bool
 	FailOnRollback             bool                      // When true, the DCP client will terminate on DCP rollback
 	InitialMetadata            []DCPMetadata             // When set, will be used as initial metadata for the DCP feed.  Will override any persisted metadata
+	StartMetadata              DCPStartMetadata          // If set, each vbucket's stream starts from its position here, overriding persisted and initial metadata.  A position whose vbUUID doesn't match the vbucket's failover log falls back to a backfill from zero
 	CheckpointPersistFrequency *time.Duration            // Overrides metadata persistence frequency - intended for test use
 	MetadataStoreType          DCPMetadataStoreType      // define storage type for DCPMetadata
 	GroupID                    string                    // specify GroupID, only used when MetadataStoreType is DCPMetadataCS
 	DbStats                    *expvar.Map               // Optional stats
 	AgentPriority              gocbcore.DcpAgentPriority // agentPriority specifies the priority level for a dcp stream
-	CollectionIDs              []uint32                  // CollectionIDs used by gocbcore, if empty, uses default collections
+	CollectionIDs              []uint32                  // Streams are only opened for these collections, if empty, uses default collections
+	ProgressLogInterval        time.Duration             // If non-zero, periodically logs aggregate feed progress.  Disabled by default
+	TrackProgress              bool                      // If true, completion is reported by Progress() even when ProgressLogInterval is zero
+	KeyFilter                  DCPKeyFilterFunc          // If set, document events for keys rejected by the filter aren't sent to the callback
+	UseOSOBackfill             bool                      // If true, allows KV to send backfills out of sequence order, which can be faster for collection-filtered streams
+	RollbackHandler            DCPRollbackHandlerFunc    // If set, invoked on rollback before the vbucket's stream is reopened, so that in-flight work for the vbucket can be discarded
+	OpenStreamMaxBackoff       time.Duration             // Maximum delay between attempts to open a stream that timed out.  Defaults to defaultOpenStreamMaxBackoff
+	EndSeqNos                  map[uint16]uint64         // If set, each vbucket's stream ends at its sequence here, and the client closes once all streams have ended.  Vbuckets not in the map end at their high seqno when opened, as for OneShot
+	MutationBatchSize          int                       // If greater than one, mutations are sent to the workers in batches of up to this many per vbucket, reducing channel contention for high volumes of small mutations.  Batches are also sent at the end of each snapshot and before any other event for the vbucket
+	MutationBatchFlushInterval time.Duration             // Maximum time a mutation is held in a batch.  Defaults to defaultMutationBatchFlushInterval
+	TLS                        *DCPClientTLSOptions      // If set, replaces the bucket spec's TLS settings for the client's connection to KV
+	CaughtUpHandler            func()                    // If set, invoked once every vbucket has processed up to its high seqno at the time the client was started, i.e. once the initial backfill has drained.  Invoked from a worker, so must not block
+}
+
+// DCPClientTLSOptions configures TLS for a DCPClient's connection to KV, when it needs to differ from the bucket's own
+// connection, for example to authenticate with a different client certificate.  Only valid when the bucket's server
+// uses a secure scheme (couchbases://).
+type DCPClientTLSOptions struct {
+	CACertPath    string // CA bundle used to verify KV's certificate.  If empty, the system root CAs are used
+	Certpath      string // Client certificate presented to KV, which is used to authenticate instead of the bucket's credentials
+	Keypath       string // Private key for Certpath
+	TLSSkipVerify bool   // If true, KV's certificate isn't verified.  Intended for test use
+}
+
+// applyTo returns a copy of spec using these TLS settings.
+func (o *DCPClientTLSOptions) applyTo(spec BucketSpec) (BucketSpec, error) {
+	if !spec.IsTLS() {
+		return spec, errors.New("DCP client TLS options require a secure (couchbases://) server")
+	}
+	if (o.Certpath == "") != (o.Keypath == "") {
+		return spec, errors.New("DCP client TLS options must set both Certpath and Keypath, or neither")
+	}
+	spec.CACertPath = o.CACertPath
+	spec.Certpath = o.Certpath
+	spec.Keypath = o.Keypath
+	spec.TLSSkipVerify = o.TLSSkipVerify
+	return spec, nil
 }
 
 func NewDCPClient(ID string, callback sgbucket.FeedEventCallbackFunc, options DCPClientOptions, collection *Collection) (*DCPClient, error) {
@@ -78,12 +172,21 @@ func NewDCPClient(ID string, callback sgbucket.FeedEventCallbackFunc, options DC
 	if options.AgentPriority == gocbcore.DcpAgentPriorityHigh {
 		return nil, fmt.Errorf("sync gateway should not set high priority for DCP feeds")
 	}
+
+	spec := collection.GetSpec()
+	if options.TLS != nil {
+		spec, err = options.TLS.applyTo(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	client := &DCPClient{
 		workers:             make([]*DCPWorker, numWorkers),
 		numVbuckets:         numVbuckets,
 		callback:            callback,
 		ID:                  ID,
-		spec:                collection.GetSpec(),
+		spec:                spec,
 		supportsCollections: collection.IsSupported(sgbucket.DataStoreFeatureCollections),
 		terminator:          make(chan bool),
 		doneChannel:         make(chan error, 1),
@@ -93,6 +196,39 @@ func NewDCPClient(ID string, callback sgbucket.FeedEventCallbackFunc, options DC
 		agentPriority:       options.AgentPriority,
 		collectionIDs:       options.CollectionIDs,
 		oneShot:             options.OneShot,
+		collection:          collection,
+		progress:            newDCPProgress(numVbuckets),
+		progressLogInterval: options.ProgressLogInterval,
+		trackProgress:       options.TrackProgress,
+		keyFilter:           options.KeyFilter,
+		useOSOBackfill:      options.UseOSOBackfill,
+		workerQueueSize:     options.WorkerQueueSize,
+		rollbackHandler:     options.RollbackHandler,
+	}
+
+	if client.supportsCollections {
+		client.streamCollections = make(map[uint32]struct{})
+		for _, collectionID := range client.streamCollectionIDs() {
+			client.streamCollections[collectionID] = struct{}{}
+		}
+	}
+
+	client.progress.caughtUpHandler = options.CaughtUpHandler
+	client.stopped = make(chan struct{})
+	client.endSeqNos = options.EndSeqNos
+
+	if options.MutationBatchSize > 1 {
+		client.mutationBatches = newMutationBatches(numVbuckets)
+		client.mutationBatchSize = options.MutationBatchSize
+		client.mutationBatchFlushInterval = defaultMutationBatchFlushInterval
+		if options.MutationBatchFlushInterval > 0 {
+			client.mutationBatchFlushInterval = options.MutationBatchFlushInterval
+		}
+	}
+
+	client.openStreamMaxBackoff = defaultOpenStreamMaxBackoff
+	if options.OpenStreamMaxBackoff > 0 {
+		client.openStreamMaxBackoff = options.OpenStreamMaxBackoff
 	}
 
 	// Initialize active vbuckets
@@ -115,6 +251,11 @@ func NewDCPClient(ID string, callback sgbucket.FeedEventCallbackFunc, options DC
 			client.metadata.SetMeta(uint16(vbID), meta)
 		}
 	}
+	if options.StartMetadata != nil {
+		if err := client.setStartPositions(options.StartMetadata); err != nil {
+			return nil, err
+		}
+	}
 
 	return client, nil
 }
@@ -126,6 +267,16 @@ func (dc *DCPClient) Start() (doneChan chan error, err error) {
 		return dc.doneChannel, err
 	}
 	dc.startWorkers()
+	dc.progress.setStartSeqs(dc.GetMetadata())
+	if dc.progressLogInterval > 0 || dc.trackProgress || dc.progress.caughtUpHandler != nil {
+		dc.initProgressBounds()
+	}
+	if dc.progressLogInterval > 0 {
+		dc.startProgressLogger(dc.progressLogInterval)
+	}
+	if dc.mutationBatches != nil {
+		dc.startMutationBatchFlusher(dc.mutationBatchFlushInterval)
+	}
 
 	for i := uint16(0); i < dc.numVbuckets; i++ {
 		openErr := dc.openStream(i, openRetryCount)
@@ -142,6 +293,71 @@ func (dc *DCPClient) Close() error {
 	return dc.getCloseError()
 }
 
+// Wait blocks until the client has stopped, whether on completion of a OneShot or EndSeqNos feed, on error or on Close,
+// and returns the error the client stopped with, if any.
+func (dc *DCPClient) Wait() error {
+	<-dc.stopped
+	return dc.getCloseError()
+}
+
+// Pause stops the client passing stream events to its workers, without closing the streams.  Stream events are
+// received on gocbcore's connection goroutines, which block until Resume is called, so gocbcore stops reading from the
+// DCP connections and KV stops sending once the connection buffers are full.  Events already queued for the workers
+// are still processed.  Stream ends are held too, so streams aren't reopened while the client is paused.
+func (dc *DCPClient) Pause() {
+	dc.pauseLock.Lock()
+	defer dc.pauseLock.Unlock()
+	if dc.resumed == nil {
+		InfofCtx(context.TODO(), KeyDCP, "Pausing DCP client %s", MD(dc.ID))
+		dc.resumed = make(chan struct{})
+	}
+}
+
+// Resume restarts the passing of stream events to the workers after Pause.
+func (dc *DCPClient) Resume() {
+	dc.pauseLock.Lock()
+	defer dc.pauseLock.Unlock()
+	if dc.resumed != nil {
+		InfofCtx(context.TODO(), KeyDCP, "Resuming DCP client %s", MD(dc.ID))
+		close(dc.resumed)
+		dc.resumed = nil
+	}
+}
+
+// Paused returns whether the client is paused.
+func (dc *DCPClient) Paused() bool {
+	dc.pauseLock.Lock()
+	defer dc.pauseLock.Unlock()
+	return dc.resumed != nil
+}
+
+// waitWhilePaused blocks until the client is resumed or closed, if it's paused.
+func (dc *DCPClient) waitWhilePaused() {
+	dc.pauseLock.Lock()
+	resumed := dc.resumed
+	dc.pauseLock.Unlock()
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-resumed:
+	case <-dc.terminator:
+	}
+}
+
+// Progress returns the client's aggregate progress.  Completion is only reported for clients created with
+// TrackProgress or ProgressLogInterval set.
+func (dc *DCPClient) Progress() DCPClientProgress {
+	return dc.progress.summary(0, 0)
+}
+
+// GetVbucketSequences returns the last sequence processed for each vbucket, for comparison with the vbucket high
+// seqnos to determine how far behind the client is.  Each vbucket's sequence is read atomically, so it's safe to call
+// while the client is running, but sequences for different vbuckets may be read at slightly different times.
+func (dc *DCPClient) GetVbucketSequences() map[uint16]uint64 {
+	return dc.progress.vbucketSeqs()
+}
+
 // GetMetadata returns metadata for all vbuckets
 func (dc *DCPClient) GetMetadata() []DCPMetadata {
 	metadata := make([]DCPMetadata, dc.numVbuckets)
@@ -173,6 +389,7 @@ func (dc *DCPClient) close() {
 	// Wait for all workers to finish before closing doneChannel
 	go func() {
 		dc.workersWg.Wait()
+		close(dc.stopped)
 		dc.doneChannel <- dc.getCloseError()
 		close(dc.doneChannel)
 	}()
@@ -206,6 +423,7 @@ func (dc *DCPClient) initAgent(spec BucketSpec) error {
 	agentConfig.KVConfig.PoolSize = 1
 	agentConfig.BucketName = spec.BucketName
 	agentConfig.DCPConfig.AgentPriority = dc.agentPriority
+	agentConfig.DCPConfig.UseOSOBackfill = dc.useOSOBackfill
 	agentConfig.SecurityConfig.Auth = auth
 	agentConfig.SecurityConfig.TLSRootCAProvider = tlsRootCAProvider
 	agentConfig.UserAgent = "SyncGatewayDCP"
@@ -255,8 +473,13 @@ func (dc *DCPClient) initAgent(spec BucketSpec) error {
 }
 
 func (dc *DCPClient) workerForVbno(vbNo uint16) *DCPWorker {
-	workerIndex := int(vbNo % uint16(len(dc.workers)))
-	return dc.workers[workerIndex]
+	return dc.workers[dc.workerIndexForVbno(vbNo)]
+}
+
+// workerIndexForVbno returns the index of the worker a vbucket's events are processed by.  vbuckets are distributed
+// evenly across the worker pool as vbNo % NumWorkers.
+func (dc *DCPClient) workerIndexForVbno(vbNo uint16) int {
+	return int(vbNo) % len(dc.workers)
 }
 
 // startWorkers initializes the DCP workers to receive stream events from eventFeed
@@ -269,14 +492,17 @@ func (dc *DCPClient) startWorkers() {
 	}
 
 	for vbNo := uint16(0); vbNo < dc.numVbuckets; vbNo++ {
-		workerIndex := int(vbNo % uint16(len(dc.workers)))
+		workerIndex := dc.workerIndexForVbno(vbNo)
 		assignedVbs[workerIndex] = append(assignedVbs[workerIndex], vbNo)
 	}
 
 	//
 	for index, _ := range dc.workers {
 		options := &DCPWorkerOptions{
+			eventQueueLength:     dc.workerQueueSize,
 			metaPersistFrequency: dc.checkpointPersistFrequency,
+			progress:             dc.progress,
+			dbStats:              dc.dbStats,
 		}
 		dc.workers[index] = NewDCPWorker(index, dc.metadata, dc.callback, dc.onStreamEnd, dc.terminator, nil, dc.checkpointPrefix, assignedVbs[index], options)
 		dc.workers[index].Start(&dc.workersWg)
@@ -288,6 +514,15 @@ func (dc *DCPClient) openStream(vbID uint16, maxRetries uint32) (err error) {
 	logCtx := context.TODO()
 	var openStreamErr error
 	var attempts uint32
+	var backoffAttempts int
+	sleeper := dc.openStreamSleeper()
+
+	// KV rejects streams that start after their end, so end the stream here if there's nothing left to stream
+	if endSeqNo, ok := dc.endSeqNos[vbID]; ok && uint64(dc.metadata.GetMeta(vbID).StartSeqNo) >= endSeqNo {
+		DebugfCtx(logCtx, KeyDCP, "Stream (vb:%d) already at end seq %d, not opening", vbID, endSeqNo)
+		dc.onStreamEnd(endStreamEvent{streamEventCommon: streamEventCommon{vbID: vbID}})
+		return nil
+	}
 	for {
 		// Cancel open for stopped client
 		select {
@@ -298,6 +533,9 @@ func (dc *DCPClient) openStream(vbID uint16, maxRetries uint32) (err error) {
 
 		openStreamErr = dc.openStreamRequest(vbID)
 		if openStreamErr == nil {
+			if backoffAttempts > 0 {
+				InfofCtx(logCtx, KeyDCP, "Opened stream for vbID %d after %d retries", vbID, backoffAttempts)
+			}
 			return nil
 		}
 
@@ -312,11 +550,32 @@ func (dc *DCPClient) openStream(vbID uint16, maxRetries uint32) (err error) {
 			if err != nil {
 				return fmt.Errorf("metadata rollback failed for vb %d: %v", vbID, err)
 			}
+		case errors.Is(openStreamErr, errStartPositionMismatch):
+			err := dc.backfillFromZero(vbID)
+			if err != nil {
+				return fmt.Errorf("unable to restart stream for vb %d from zero: %w", vbID, err)
+			}
 		case errors.Is(openStreamErr, gocbcore.ErrShutdown):
 			WarnfCtx(logCtx, "Closing stream for vbID %d, agent has been shut down", vbID)
 			return openStreamErr
 		case errors.Is(openStreamErr, ErrTimeout):
-			DebugfCtx(logCtx, KeyDCP, "Timeout attempting to open stream for vb %d, will retry", vbID)
+			// No backoff after the final attempt
+			if maxRetries != infiniteOpenStreamRetries && attempts > maxRetries {
+				break
+			}
+			backoffAttempts++
+			_, sleepMs := sleeper(backoffAttempts)
+			// Only warn once per backoff cycle, subsequent retries are logged at debug
+			if backoffAttempts == 1 {
+				WarnfCtx(logCtx, "Timeout attempting to open stream for vbID %d, will retry with backoff of up to %v", vbID, dc.openStreamMaxBackoff)
+			} else {
+				DebugfCtx(logCtx, KeyDCP, "Timeout attempting to open stream for vbID %d, retry %d in %dms", vbID, backoffAttempts, sleepMs)
+			}
+			select {
+			case <-dc.terminator:
+				return nil
+			case <-time.After(time.Duration(sleepMs) * time.Millisecond):
+			}
 		default:
 			WarnfCtx(logCtx, "Error opening stream for vbID %d: %v", vbID, openStreamErr)
 			return openStreamErr
@@ -332,15 +591,44 @@ func (dc *DCPClient) openStream(vbID uint16, maxRetries uint32) (err error) {
 	return fmt.Errorf("openStream failed to complete after %d attempts, last error: %w", openRetryCount, openStreamErr)
 }
 
+// openStreamSleeper returns the jittered, doubling RetrySleeper used between attempts to open a stream, capped at
+// openStreamMaxBackoff.
+func (dc *DCPClient) openStreamSleeper() RetrySleeper {
+	initialMs := int(openStreamInitialBackoff / time.Millisecond)
+	maxMs := int(dc.openStreamMaxBackoff / time.Millisecond)
+	if initialMs > maxMs {
+		initialMs = maxMs
+	}
+	// CreateIndefiniteMaxDoublingSleeperFunc doubles before returning, so start from half the initial backoff
+	return CreateJitteredSleeperFunc(CreateIndefiniteMaxDoublingSleeperFunc(initialMs/2, maxMs))
+}
+
+// rollback resets the metadata for a vbucket, so that its stream is reopened from the start, and notifies the rollback
+// handler if one is set.
 func (dc *DCPClient) rollback(vbID uint16) (err error) {
 	if dc.dbStats != nil {
 		dc.dbStats.Add("dcp_rollback_count", 1)
 	}
 	dc.metadata.Rollback(vbID)
+	dc.clearStartPosition(vbID)
+	rollbackSeq := uint64(dc.metadata.GetMeta(vbID).StartSeqNo)
+	dc.progress.seqRolledBack(vbID, rollbackSeq)
+	if dc.rollbackHandler != nil {
+		dc.rollbackHandler(vbID, rollbackSeq)
+	}
 	return nil
 }
 
 // openStreamRequest issues the OpenStream request, but doesn't perform any error handling.  Callers
+// streamCollectionIDs returns the IDs of the collections to open streams for on a collection-aware feed.  If no
+// collection IDs were specified, streams are filtered to the default collection.
+func (dc *DCPClient) streamCollectionIDs() []uint32 {
+	if len(dc.collectionIDs) == 0 {
+		return []uint32{DefaultCollectionID}
+	}
+	return dc.collectionIDs
+}
+
 // should generally use openStream() for error and retry handling
 func (dc *DCPClient) openStreamRequest(vbID uint16) error {
 
@@ -349,15 +637,13 @@ func (dc *DCPClient) openStreamRequest(vbID uint16) error {
 	options := gocbcore.OpenStreamOptions{}
 	// Always use a collection-aware feed if supported
 	if dc.supportsCollections {
-		// If no collection IDs specified, filter to the default collection
-		collIds := dc.collectionIDs
-		if len(collIds) == 0 {
-			collIds = []uint32{DefaultCollectionID}
-		}
-		options.FilterOptions = &gocbcore.OpenStreamFilterOptions{CollectionIDs: collIds}
+		options.FilterOptions = &gocbcore.OpenStreamFilterOptions{CollectionIDs: dc.streamCollectionIDs()}
 	}
+	endSeqNo := vbMeta.EndSeqNo
 	flags := memd.DcpStreamAddFlagActiveOnly
-	if dc.oneShot {
+	if vbEndSeqNo, ok := dc.endSeqNos[vbID]; ok {
+		endSeqNo = gocbcore.SeqNo(vbEndSeqNo)
+	} else if dc.oneShot || dc.endSeqNos != nil {
 		flags |= memd.DcpStreamAddFlagLatest
 	}
 
@@ -365,6 +651,9 @@ func (dc *DCPClient) openStreamRequest(vbID uint16) error {
 	openStreamCallback := func(f []gocbcore.FailoverEntry, err error) {
 		if err == nil {
 			err = dc.verifyFailoverLog(vbID, f)
+			if err == nil {
+				err = dc.verifyStartPosition(vbID, f)
+			}
 			if err == nil {
 				e := streamOpenEvent{
 					streamEventCommon: streamEventCommon{
@@ -381,7 +670,7 @@ func (dc *DCPClient) openStreamRequest(vbID uint16) error {
 		flags,
 		vbMeta.VbUUID,
 		vbMeta.StartSeqNo,
-		vbMeta.EndSeqNo,
+		endSeqNo,
 		vbMeta.SnapStartSeqNo,
 		vbMeta.SnapEndSeqNo,
 		dc,
@@ -440,12 +729,17 @@ func (dc *DCPClient) onStreamEnd(e endStreamEvent) {
 
 	if e.err == nil {
 		DebugfCtx(logCtx, KeyDCP, "Stream (vb:%d) closed, all items streamed", e.vbID)
+		dc.progress.streamEnded(e.vbID)
 		dc.deactivateVbucket(e.vbID)
 		return
 	}
 
 	if errors.Is(e.err, gocbcore.ErrDCPStreamClosed) {
 		DebugfCtx(logCtx, KeyDCP, "Stream (vb:%d) closed by DCPClient", e.vbID)
+		// Closed to be reopened from zero, which has already been done
+		if dc.takeClosedStream(e.vbID) {
+			return
+		}
 	}
 
 	if errors.Is(e.err, gocbcore.ErrDCPStreamStateChanged) || errors.Is(e.err, gocbcore.ErrDCPStreamTooSlow) ||
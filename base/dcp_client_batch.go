@@ -0,0 +1,89 @@
+package base
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultMutationBatchFlushInterval = 100 * time.Millisecond
+
+// mutationBatch holds the mutations for a vbucket that haven't yet been sent to its worker, when the client batches
+// mutations.
+type mutationBatch struct {
+	lock        sync.Mutex
+	mutations   []mutationEvent
+	snapshotEnd uint64 // End sequence of the vbucket's current snapshot
+}
+
+// newMutationBatches returns an empty batch for each vbucket.
+func newMutationBatches(numVbuckets uint16) []*mutationBatch {
+	batches := make([]*mutationBatch, numVbuckets)
+	for i := range batches {
+		batches[i] = &mutationBatch{}
+	}
+	return batches
+}
+
+// batchMutation adds a mutation to its vbucket's batch.  The batch is sent to the worker once it's full, or once it
+// reaches the end of the current snapshot.
+func (dc *DCPClient) batchMutation(e mutationEvent) {
+	batch := dc.mutationBatches[e.vbID]
+	batch.lock.Lock()
+	defer batch.lock.Unlock()
+	batch.mutations = append(batch.mutations, e)
+	if len(batch.mutations) >= dc.mutationBatchSize || e.seq >= batch.snapshotEnd {
+		dc.sendMutationBatch(batch)
+	}
+}
+
+// startMutationBatchSnapshot records the end sequence of a vbucket's new snapshot, so that its batch is flushed on
+// reaching it.
+func (dc *DCPClient) startMutationBatchSnapshot(vbID uint16, endSeq uint64) {
+	batch := dc.mutationBatches[vbID]
+	batch.lock.Lock()
+	defer batch.lock.Unlock()
+	batch.snapshotEnd = endSeq
+}
+
+// flushMutationBatch sends any mutations batched for a vbucket to its worker.  Called before any other event for the
+// vbucket is sent, so that events are still processed in the order DCP sent them.
+func (dc *DCPClient) flushMutationBatch(vbID uint16) {
+	batch := dc.mutationBatches[vbID]
+	batch.lock.Lock()
+	defer batch.lock.Unlock()
+	dc.sendMutationBatch(batch)
+}
+
+// sendMutationBatch sends a batch's mutations to the worker as a single event.  Requires batch.lock to be held, so that
+// no other event for the vbucket can be sent ahead of it.
+func (dc *DCPClient) sendMutationBatch(batch *mutationBatch) {
+	if len(batch.mutations) == 0 {
+		return
+	}
+	e := mutationBatchEvent{
+		streamEventCommon: batch.mutations[0].streamEventCommon,
+		mutations:         batch.mutations,
+	}
+	batch.mutations = make([]mutationEvent, 0, dc.mutationBatchSize)
+	dc.waitWhilePaused()
+	dc.workerForVbno(e.vbID).Send(e)
+}
+
+// startMutationBatchFlusher periodically flushes all batches, so that mutations aren't held indefinitely when the
+// feed is quiet.
+func (dc *DCPClient) startMutationBatchFlusher(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for vbID := range dc.mutationBatches {
+					dc.flushMutationBatch(uint16(vbID))
+				}
+			case <-dc.terminator:
+				return
+			}
+		}
+	}()
+}
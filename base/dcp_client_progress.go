@@ -0,0 +1,204 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// dcpProgress tracks aggregate processing progress for a DCPClient.  Counters are updated by the DCP workers and read
+// by the progress logger, so all access is atomic.
+type dcpProgress struct {
+	processed       uint64   // Number of mutations and deletions processed across all vbuckets
+	vbSeqs          []uint64 // Last sequence processed, per vbucket
+	vbStartSeqs     []uint64 // Sequence the stream was started from, per vbucket
+	vbEndSeqs       []uint64 // Sequence the stream is expected to end at, per vbucket.  math.MaxUint64 when unbounded.
+	vbCaughtUp      []uint32 // 1 once the vbucket has processed up to its end sequence, per vbucket
+	pendingCaughtUp int64    // Number of vbuckets yet to catch up
+	caughtUpHandler func()   // If set, invoked once every vbucket has caught up
+}
+
+func newDCPProgress(numVbuckets uint16) *dcpProgress {
+	p := &dcpProgress{
+		vbSeqs:      make([]uint64, numVbuckets),
+		vbStartSeqs: make([]uint64, numVbuckets),
+		vbEndSeqs:   make([]uint64, numVbuckets),
+		vbCaughtUp:  make([]uint32, numVbuckets),
+	}
+	for vbID := range p.vbEndSeqs {
+		p.vbEndSeqs[vbID] = math.MaxUint64
+	}
+	return p
+}
+
+// setStartSeqs records the sequence each vbucket's stream will start from as its processed sequence.
+func (p *dcpProgress) setStartSeqs(metadata []DCPMetadata) {
+	for vbID, meta := range metadata {
+		atomic.StoreUint64(&p.vbSeqs[vbID], uint64(meta.StartSeqNo))
+	}
+}
+
+// setBounds records the start and end sequence for each vbucket.  The end sequence is the lower of the stream's
+// EndSeqNo and the vbucket's high seqno at the time the client was started, so that streams opened without an explicit
+// end (one-shot streams ending at the latest sequence, or continuous streams catching up) report progress towards the
+// high seqno.  vbuckets without a high seqno and without an explicit EndSeqNo are unbounded.
+func (p *dcpProgress) setBounds(metadata []DCPMetadata, highSeqnos map[uint16]uint64) {
+	atomic.StoreInt64(&p.pendingCaughtUp, int64(len(metadata)))
+	for vbID, meta := range metadata {
+		startSeq := uint64(meta.StartSeqNo)
+		endSeq := uint64(meta.EndSeqNo)
+		if highSeq, ok := highSeqnos[uint16(vbID)]; ok && highSeq < endSeq {
+			endSeq = highSeq
+		}
+		if endSeq < startSeq {
+			endSeq = startSeq
+		}
+		atomic.StoreUint64(&p.vbStartSeqs[vbID], startSeq)
+		atomic.StoreUint64(&p.vbEndSeqs[vbID], endSeq)
+		atomic.StoreUint64(&p.vbSeqs[vbID], startSeq)
+		p.checkCaughtUp(uint16(vbID), startSeq)
+	}
+}
+
+// mutationProcessed is called by DCP workers after a mutation or deletion has been processed.
+func (p *dcpProgress) mutationProcessed(vbID uint16, seq uint64) {
+	p.countProcessed()
+	p.seqProcessed(vbID, seq)
+}
+
+// countProcessed is called by DCP workers after a mutation or deletion that doesn't advance the vbucket's sequence
+// has been processed, i.e. within an OSO snapshot.
+func (p *dcpProgress) countProcessed() {
+	atomic.AddUint64(&p.processed, 1)
+}
+
+// seqProcessed is called by DCP workers when a vbucket's sequence advances.
+func (p *dcpProgress) seqProcessed(vbID uint16, seq uint64) {
+	atomic.StoreUint64(&p.vbSeqs[vbID], seq)
+	p.checkCaughtUp(vbID, seq)
+}
+
+// checkCaughtUp marks a vbucket as caught up once seq reaches its end sequence, and invokes the caught up handler when
+// it's the last vbucket to do so.  Unbounded vbuckets never catch up.
+func (p *dcpProgress) checkCaughtUp(vbID uint16, seq uint64) {
+	if p.caughtUpHandler == nil || seq < atomic.LoadUint64(&p.vbEndSeqs[vbID]) {
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&p.vbCaughtUp[vbID], 0, 1) {
+		return
+	}
+	if atomic.AddInt64(&p.pendingCaughtUp, -1) == 0 {
+		p.caughtUpHandler()
+	}
+}
+
+// seqRolledBack is called when a vbucket is rolled back, and its stream will restart from seq.
+func (p *dcpProgress) seqRolledBack(vbID uint16, seq uint64) {
+	atomic.StoreUint64(&p.vbSeqs[vbID], seq)
+}
+
+// vbucketSeqs returns the last sequence processed for each vbucket.
+func (p *dcpProgress) vbucketSeqs() map[uint16]uint64 {
+	seqs := make(map[uint16]uint64, len(p.vbSeqs))
+	for vbID := range p.vbSeqs {
+		seqs[uint16(vbID)] = atomic.LoadUint64(&p.vbSeqs[vbID])
+	}
+	return seqs
+}
+
+// streamEnded is called when a vbucket's stream has ended after streaming all items, which completes the vbucket even
+// if the last items weren't visible to the client (e.g. filtered by collection).
+func (p *dcpProgress) streamEnded(vbID uint16) {
+	if endSeq := atomic.LoadUint64(&p.vbEndSeqs[vbID]); endSeq != math.MaxUint64 {
+		atomic.StoreUint64(&p.vbSeqs[vbID], endSeq)
+		p.checkCaughtUp(vbID, endSeq)
+	}
+}
+
+// DCPClientProgress is a point-in-time summary of DCPClient progress.
+type DCPClientProgress struct {
+	Processed         uint64  // Total mutations and deletions processed
+	Rate              float64 // Mutations and deletions processed per second since the previous summary
+	BoundedVbuckets   int     // Number of vbuckets with a known end sequence
+	CompletedVbuckets int     // Number of bounded vbuckets that have reached their end sequence
+	PercentComplete   float64 // Percentage of the bounded sequence range, from each vbucket's start sequence, that has been processed
+}
+
+func (p DCPClientProgress) String() string {
+	s := fmt.Sprintf("%d mutations processed (%.1f/s)", p.Processed, p.Rate)
+	if p.BoundedVbuckets > 0 {
+		s += fmt.Sprintf(", %d/%d bounded vbuckets complete (%.1f%%)", p.CompletedVbuckets, p.BoundedVbuckets, p.PercentComplete)
+	}
+	return s
+}
+
+// summary returns the current progress.  Rate is calculated relative to the given previous processed count and elapsed time.
+func (p *dcpProgress) summary(previousProcessed uint64, elapsed time.Duration) DCPClientProgress {
+	progress := DCPClientProgress{
+		Processed: atomic.LoadUint64(&p.processed),
+	}
+	if elapsed > 0 {
+		progress.Rate = float64(progress.Processed-previousProcessed) / elapsed.Seconds()
+	}
+
+	var processedRange, totalRange uint64
+	for vbID := range p.vbEndSeqs {
+		endSeq := atomic.LoadUint64(&p.vbEndSeqs[vbID])
+		if endSeq == math.MaxUint64 {
+			continue
+		}
+		progress.BoundedVbuckets++
+		startSeq := atomic.LoadUint64(&p.vbStartSeqs[vbID])
+		seq := atomic.LoadUint64(&p.vbSeqs[vbID])
+		if seq >= endSeq {
+			progress.CompletedVbuckets++
+			seq = endSeq
+		} else if seq < startSeq {
+			seq = startSeq
+		}
+		processedRange += seq - startSeq
+		totalRange += endSeq - startSeq
+	}
+	if totalRange > 0 {
+		progress.PercentComplete = 100 * float64(processedRange) / float64(totalRange)
+	} else if progress.BoundedVbuckets > 0 {
+		progress.PercentComplete = 100
+	}
+	return progress
+}
+
+// initProgressBounds sets the bounds that completion is measured against, from the vbucket high seqnos at the time
+// it's called.  Must be called before streams are opened.
+func (dc *DCPClient) initProgressBounds() {
+	_, highSeqnos, err := dc.collection.GetStatsVbSeqno(dc.numVbuckets, true)
+	if err != nil {
+		WarnfCtx(context.TODO(), "Unable to retrieve high seqnos for DCP client %s - progress will not include completion: %v", MD(dc.ID), err)
+		highSeqnos = nil
+	}
+	dc.progress.setBounds(dc.GetMetadata(), highSeqnos)
+}
+
+// startProgressLogger logs aggregate progress every interval, and once more when the client is closed.
+func (dc *DCPClient) startProgressLogger(interval time.Duration) {
+	logCtx := context.TODO()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastProcessed := uint64(0)
+		lastTime := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				progress := dc.progress.summary(lastProcessed, now.Sub(lastTime))
+				InfofCtx(logCtx, KeyDCP, "DCP client %s progress: %s", MD(dc.ID), progress)
+				lastProcessed, lastTime = progress.Processed, now
+			case <-dc.terminator:
+				progress := dc.progress.summary(lastProcessed, time.Since(lastTime))
+				InfofCtx(logCtx, KeyDCP, "DCP client %s closed, final progress: %s", MD(dc.ID), progress)
+				return
+			}
+		}
+	}()
+}
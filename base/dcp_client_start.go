@@ -0,0 +1,108 @@
+package base
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/couchbase/gocbcore/v10"
+)
+
+// DCPStartPosition is a point in a vbucket's history for a DCPClient's stream to start from: a sequence, and the
+// vbucket UUID it was seen under.
+type DCPStartPosition struct {
+	VbUUID gocbcore.VbUUID
+	SeqNo  uint64
+}
+
+// DCPStartMetadata is the position each vbucket's stream starts from, keyed by vbucket ID.
+type DCPStartMetadata map[uint16]DCPStartPosition
+
+// errStartPositionMismatch is returned when a vbucket's stream is opened from a start position whose vbucket UUID isn't
+// the one in the stream's failover log for its sequence.
+var errStartPositionMismatch = errors.New("start position doesn't match failover log")
+
+// setStartPositions sets the metadata of each vbucket with a start position so that its stream is opened from there.
+// Each position is verified against the failover log once its stream is open.
+func (dc *DCPClient) setStartPositions(positions DCPStartMetadata) error {
+	dc.startPositions = make(DCPStartMetadata, len(positions))
+	dc.closedStreams = make(map[uint16]struct{})
+	for vbID, position := range positions {
+		if vbID >= dc.numVbuckets {
+			return fmt.Errorf("start metadata for vbucket %d, but bucket only has %d vbuckets", vbID, dc.numVbuckets)
+		}
+		meta := dc.metadata.GetMeta(vbID)
+		meta.VbUUID = position.VbUUID
+		meta.StartSeqNo = gocbcore.SeqNo(position.SeqNo)
+		meta.SnapStartSeqNo = meta.StartSeqNo
+		meta.SnapEndSeqNo = meta.StartSeqNo
+		dc.metadata.SetMeta(vbID, meta)
+		dc.startPositions[vbID] = position
+	}
+	return nil
+}
+
+// verifyStartPosition checks a vbucket's start position, if it hasn't been checked yet, against the failover log its
+// stream was opened with.  A position whose vbucket UUID doesn't match the failover log's entry for its sequence comes
+// from a history that has since diverged, so can't be resumed from.
+func (dc *DCPClient) verifyStartPosition(vbID uint16, failoverLog []gocbcore.FailoverEntry) error {
+	dc.startPositionsLock.Lock()
+	defer dc.startPositionsLock.Unlock()
+	position, ok := dc.startPositions[vbID]
+	if !ok {
+		return nil
+	}
+	delete(dc.startPositions, vbID)
+	if vbUUID := getVbUUID(failoverLog, gocbcore.SeqNo(position.SeqNo)); vbUUID != position.VbUUID {
+		InfofCtx(context.TODO(), KeyDCP, "Start position for vbID %d (vbUUID %d, seq %d) doesn't match failover log vbUUID %d, will backfill from zero",
+			vbID, position.VbUUID, position.SeqNo, vbUUID)
+		return errStartPositionMismatch
+	}
+	return nil
+}
+
+// clearStartPosition discards a vbucket's start position without verifying it, once its metadata no longer starts
+// there.
+func (dc *DCPClient) clearStartPosition(vbID uint16) {
+	dc.startPositionsLock.Lock()
+	defer dc.startPositionsLock.Unlock()
+	delete(dc.startPositions, vbID)
+}
+
+// backfillFromZero closes a vbucket's stream that was opened from a mismatched start position, and rolls its metadata
+// back so that the stream is reopened from zero.
+func (dc *DCPClient) backfillFromZero(vbID uint16) error {
+	// The server ends the closed stream, which mustn't be treated as an error
+	dc.startPositionsLock.Lock()
+	dc.closedStreams[vbID] = struct{}{}
+	dc.startPositionsLock.Unlock()
+
+	closeStreamError := make(chan error, 1)
+	_, err := dc.agent.CloseStream(vbID, gocbcore.CloseStreamOptions{}, func(err error) {
+		closeStreamError <- err
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-closeStreamError:
+		if err != nil {
+			return err
+		}
+	case <-time.After(openStreamTimeout):
+		return ErrTimeout
+	}
+	return dc.rollback(vbID)
+}
+
+// takeClosedStream returns true if a vbucket's stream was closed by backfillFromZero and hasn't ended yet.
+func (dc *DCPClient) takeClosedStream(vbID uint16) bool {
+	dc.startPositionsLock.Lock()
+	defer dc.startPositionsLock.Unlock()
+	if _, ok := dc.closedStreams[vbID]; !ok {
+		return false
+	}
+	delete(dc.closedStreams, vbID)
+	return true
+}
@@ -39,6 +39,13 @@ type mutationEvent struct {
 	value      []byte
 }
 
+// mutationBatchEvent is a batch of consecutive mutations for a vbucket, sent to the worker as a single event when the
+// client batches mutations.
+type mutationBatchEvent struct {
+	streamEventCommon
+	mutations []mutationEvent
+}
+
 type streamOpenEvent struct {
 	streamEventCommon
 	failoverLogs []gocbcore.FailoverEntry
@@ -91,3 +98,16 @@ type seqnoAdvancedEvent struct {
 	streamEventCommon
 	seq uint64
 }
+
+// Flags set on DCP OSO snapshot markers
+const (
+	dcpOSOSnapshotStart = 0x01
+	dcpOSOSnapshotEnd   = 0x02
+)
+
+// osoSnapshotEvent marks the start or end of an out-of-sequence-order (OSO) snapshot.  The mutations and deletions
+// between the start and end markers aren't in sequence order.
+type osoSnapshotEvent struct {
+	streamEventCommon
+	start bool // true for the start marker, false for the end marker
+}
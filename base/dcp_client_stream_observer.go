@@ -6,10 +6,24 @@ import (
 	"github.com/couchbase/gocbcore/v10"
 )
 
+// Stats counting the document events received from DCP, split into those filtered by key or collection and those sent
+// on to be processed.  Only recorded when the client has DbStats.
+const (
+	dcpMutationReceivedStat  = "dcp_mutation_received"
+	dcpMutationFilteredStat  = "dcp_mutation_filtered"
+	dcpMutationProcessedStat = "dcp_mutation_processed"
+	dcpDeletionReceivedStat  = "dcp_deletion_received"
+	dcpDeletionFilteredStat  = "dcp_deletion_filtered"
+	dcpDeletionProcessedStat = "dcp_deletion_processed"
+)
+
 // DCPClient implementation of the gocbcore.StreamObserver interface.  Primarily routes events
 // to the DCPClient's workers to be processed, but performs the following additional functionality:
 //   - key-based filtering for document-based events (Deletion, Expiration, Mutation)
+//   - dropping document-based events for collections the streams weren't opened for
 //   - stream End handling, including restart on error
+//   - blocking while the client is paused, which stops gocbcore reading from the DCP connection
+//   - batching mutations per vbucket, when enabled
 func (dc *DCPClient) SnapshotMarker(snapshotMarker gocbcore.DcpSnapshotMarker) {
 
 	e := snapshotEvent{
@@ -21,14 +35,21 @@ func (dc *DCPClient) SnapshotMarker(snapshotMarker gocbcore.DcpSnapshotMarker) {
 		endSeq:       snapshotMarker.EndSeqNo,
 		snapshotType: snapshotMarker.SnapshotType,
 	}
-	dc.workerForVbno(snapshotMarker.VbID).Send(e)
+	dc.sendEvent(e)
+	if dc.mutationBatches != nil {
+		dc.startMutationBatchSnapshot(snapshotMarker.VbID, snapshotMarker.EndSeqNo)
+	}
 }
 
 func (dc *DCPClient) Mutation(mutation gocbcore.DcpMutation) {
 
-	if dc.filteredKey(mutation.Key) {
+	dc.addStat(dcpMutationReceivedStat)
+	if dc.filteredKey(mutation.Key) || dc.filteredCollection(mutation.CollectionID) {
+		dc.addStat(dcpMutationFilteredStat)
+		dc.filteredEvent(mutation.VbID, mutation.StreamID, mutation.SeqNo)
 		return
 	}
+	dc.addStat(dcpMutationProcessedStat)
 
 	e := mutationEvent{
 		streamEventCommon: streamEventCommon{
@@ -44,14 +65,22 @@ func (dc *DCPClient) Mutation(mutation gocbcore.DcpMutation) {
 		key:        mutation.Key,
 		value:      mutation.Value,
 	}
-	dc.workerForVbno(mutation.VbID).Send(e)
+	if dc.mutationBatches != nil {
+		dc.batchMutation(e)
+		return
+	}
+	dc.sendEvent(e)
 }
 
 func (dc *DCPClient) Deletion(deletion gocbcore.DcpDeletion) {
 
-	if dc.filteredKey(deletion.Key) {
+	dc.addStat(dcpDeletionReceivedStat)
+	if dc.filteredKey(deletion.Key) || dc.filteredCollection(deletion.CollectionID) {
+		dc.addStat(dcpDeletionFilteredStat)
+		dc.filteredEvent(deletion.VbID, deletion.StreamID, deletion.SeqNo)
 		return
 	}
+	dc.addStat(dcpDeletionProcessedStat)
 
 	e := deletionEvent{
 		streamEventCommon: streamEventCommon{
@@ -65,7 +94,7 @@ func (dc *DCPClient) Deletion(deletion gocbcore.DcpDeletion) {
 		key:        deletion.Key,
 		value:      deletion.Value,
 	}
-	dc.workerForVbno(deletion.VbID).Send(e)
+	dc.sendEvent(e)
 
 }
 
@@ -77,7 +106,7 @@ func (dc *DCPClient) End(end gocbcore.DcpStreamEnd, err error) {
 			streamID: end.StreamID,
 		},
 		err: err}
-	dc.workerForVbno(end.VbID).Send(e)
+	dc.sendEvent(e)
 
 }
 
@@ -112,11 +141,21 @@ func (dc *DCPClient) ModifyCollection(modification gocbcore.DcpCollectionModific
 }
 
 func (dc *DCPClient) OSOSnapshot(snapshot gocbcore.DcpOSOSnapshot) {
-	// Not used by SG at this time
+	if snapshot.SnapshotType&(dcpOSOSnapshotStart|dcpOSOSnapshotEnd) == 0 {
+		WarnfCtx(context.TODO(), "Unexpected DCP OSO snapshot type %d (vb:%d)", snapshot.SnapshotType, snapshot.VbID)
+		return
+	}
+	dc.sendEvent(osoSnapshotEvent{
+		streamEventCommon: streamEventCommon{
+			vbID:     snapshot.VbID,
+			streamID: snapshot.StreamID,
+		},
+		start: snapshot.SnapshotType&dcpOSOSnapshotStart != 0,
+	})
 }
 
 func (dc *DCPClient) SeqNoAdvanced(seqNoAdvanced gocbcore.DcpSeqNoAdvanced) {
-	dc.workerForVbno(seqNoAdvanced.VbID).Send(seqnoAdvancedEvent{
+	dc.sendEvent(seqnoAdvancedEvent{
 		streamEventCommon: streamEventCommon{
 			vbID:     seqNoAdvanced.VbID,
 			streamID: seqNoAdvanced.StreamID,
@@ -125,6 +164,46 @@ func (dc *DCPClient) SeqNoAdvanced(seqNoAdvanced gocbcore.DcpSeqNoAdvanced) {
 	})
 }
 
+// sendEvent sends a stream event to the worker for its vbucket, first blocking until the client is resumed if it's
+// paused.  When mutations are batched, any batched mutations for the vbucket are sent first.
+func (dc *DCPClient) sendEvent(e streamEvent) {
+	if dc.mutationBatches != nil {
+		dc.flushMutationBatch(e.VbID())
+	}
+	dc.waitWhilePaused()
+	dc.workerForVbno(e.VbID()).Send(e)
+}
+
+// filteredKey returns true if events for the key shouldn't be sent to the callback, based on the client's key filter.
 func (dc *DCPClient) filteredKey(key []byte) bool {
-	return false
+	return dc.keyFilter != nil && !dc.keyFilter(key)
+}
+
+// filteredCollection returns true if events for the collection shouldn't be sent to the callback.  Streams are only
+// opened for the client's collections, so this guards against any events for other collections reaching the callback.
+func (dc *DCPClient) filteredCollection(collectionID uint32) bool {
+	if dc.streamCollections == nil {
+		return false
+	}
+	_, ok := dc.streamCollections[collectionID]
+	return !ok
+}
+
+// filteredEvent sends a filtered document event to the worker as a sequence advance, so that the vbucket's checkpoint
+// and progress still move past it without invoking the callback.
+func (dc *DCPClient) filteredEvent(vbID uint16, streamID uint16, seq uint64) {
+	dc.sendEvent(seqnoAdvancedEvent{
+		streamEventCommon: streamEventCommon{
+			vbID:     vbID,
+			streamID: streamID,
+		},
+		seq: seq,
+	})
+}
+
+// addStat increments one of the client's event stats, if it has stats.
+func (dc *DCPClient) addStat(stat string) {
+	if dc.dbStats != nil {
+		dc.dbStats.Add(stat, 1)
+	}
 }
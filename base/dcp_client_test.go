@@ -2,8 +2,13 @@ package base
 
 import (
 	"bytes"
+	"expvar"
 	"fmt"
 	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -437,3 +442,956 @@ func TestBadAgentPriority(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, dcpClient)
 }
+
+// TestDCPClientTLSOptionsApply ensures TLS options replace the bucket spec's TLS settings, and are rejected for a
+// non-TLS server or a client certificate without its key.
+func TestDCPClientTLSOptionsApply(t *testing.T) {
+	spec := BucketSpec{
+		Server:     "couchbases://localhost",
+		BucketName: "bucket",
+		CACertPath: "/spec/ca.pem",
+		Certpath:   "/spec/cert.pem",
+		Keypath:    "/spec/key.pem",
+	}
+
+	tlsOptions := &DCPClientTLSOptions{CACertPath: "/dcp/ca.pem", Certpath: "/dcp/cert.pem", Keypath: "/dcp/key.pem"}
+	dcpSpec, err := tlsOptions.applyTo(spec)
+	require.NoError(t, err)
+	assert.Equal(t, "/dcp/ca.pem", dcpSpec.CACertPath)
+	assert.Equal(t, "/dcp/cert.pem", dcpSpec.Certpath)
+	assert.Equal(t, "/dcp/key.pem", dcpSpec.Keypath)
+	assert.False(t, dcpSpec.TLSSkipVerify)
+	assert.Equal(t, "bucket", dcpSpec.BucketName)
+	assert.Equal(t, "/spec/ca.pem", spec.CACertPath, "bucket spec shouldn't be modified")
+
+	// Without a client certificate, the bucket's credentials are used
+	dcpSpec, err = (&DCPClientTLSOptions{TLSSkipVerify: true}).applyTo(spec)
+	require.NoError(t, err)
+	assert.False(t, dcpSpec.UseClientCert())
+	assert.True(t, dcpSpec.TLSSkipVerify)
+
+	_, err = (&DCPClientTLSOptions{Certpath: "/dcp/cert.pem"}).applyTo(spec)
+	assert.Error(t, err)
+
+	spec.Server = "couchbase://localhost"
+	_, err = (&DCPClientTLSOptions{TLSSkipVerify: true}).applyTo(spec)
+	assert.Error(t, err)
+}
+
+// TestDCPClientTLS runs a one-shot feed using TLS options against a TLS test cluster, and ensures an invalid CA bundle
+// in the options prevents the client from starting.
+func TestDCPClientTLS(t *testing.T) {
+	if UnitTestUrlIsWalrus() {
+		t.Skip("This test only works against Couchbase Server")
+	}
+	if !ServerIsTLS(UnitTestUrl()) {
+		t.Skip("This test requires a TLS (couchbases://) test cluster")
+	}
+
+	bucket := GetTestBucket(t)
+	defer bucket.Close()
+
+	numDocs := 10
+	docPrefix := t.Name() + "_"
+	for i := 0; i < numDocs; i++ {
+		err := bucket.Set(fmt.Sprintf("%s%d", docPrefix, i), 0, nil, map[string]interface{}{"foo": "bar"})
+		require.NoError(t, err)
+	}
+
+	collection, err := AsCollection(bucket)
+	require.NoError(t, err)
+	var collectionIDs []uint32
+	if collection.IsSupported(sgbucket.DataStoreFeatureCollections) {
+		collectionID, err := collection.GetCollectionID()
+		require.NoError(t, err)
+		collectionIDs = append(collectionIDs, collectionID)
+	}
+	spec := collection.GetSpec()
+
+	t.Run("valid", func(t *testing.T) {
+		mutationCount := uint64(0)
+		callback := func(event sgbucket.FeedEvent) bool {
+			if bytes.HasPrefix(event.Key, []byte(docPrefix)) {
+				atomic.AddUint64(&mutationCount, 1)
+			}
+			return false
+		}
+		clientOptions := DCPClientOptions{
+			OneShot:       true,
+			CollectionIDs: collectionIDs,
+			TLS:           &DCPClientTLSOptions{CACertPath: spec.CACertPath, TLSSkipVerify: TestTLSSkipVerify()},
+		}
+		dcpClient, err := NewDCPClient(t.Name(), callback, clientOptions, collection)
+		require.NoError(t, err)
+		doneChan, err := dcpClient.Start()
+		defer func() {
+			_ = dcpClient.Close()
+		}()
+		require.NoError(t, err)
+
+		select {
+		case err := <-doneChan:
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(numDocs), atomic.LoadUint64(&mutationCount))
+		case <-time.After(oneShotDCPTimeout):
+			require.Fail(t, "timeout waiting for one-shot feed to complete")
+		}
+	})
+
+	t.Run("invalid CA", func(t *testing.T) {
+		caCertPath := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(caCertPath, []byte("not a certificate"), 0600))
+
+		clientOptions := DCPClientOptions{
+			OneShot:       true,
+			CollectionIDs: collectionIDs,
+			TLS:           &DCPClientTLSOptions{CACertPath: caCertPath},
+		}
+		dcpClient, err := NewDCPClient(t.Name(), func(sgbucket.FeedEvent) bool { return false }, clientOptions, collection)
+		require.NoError(t, err)
+		_, err = dcpClient.Start()
+		defer func() {
+			_ = dcpClient.Close()
+		}()
+		assert.Error(t, err)
+	})
+}
+
+// TestDCPClientProgressLogging verifies that aggregate progress is logged at the configured interval while a feed is
+// running, and that a one-shot feed reports completion against the vbucket high seqnos and the number of mutations
+// it processed.
+func TestDCPClientProgressLogging(t *testing.T) {
+
+	if UnitTestUrlIsWalrus() {
+		t.Skip("This test only works against Couchbase Server")
+	}
+
+	SetUpTestLogging(t, LevelInfo, KeyDCP)
+
+	bucket := GetTestBucket(t)
+	defer bucket.Close()
+
+	numDocs := 100
+	body := map[string]interface{}{"foo": "bar"}
+	for i := 0; i < numDocs; i++ {
+		key := fmt.Sprintf("%s_%d", t.Name(), i)
+		err := bucket.Set(key, 0, nil, body)
+		require.NoError(t, err)
+	}
+
+	collection, err := AsCollection(bucket)
+	require.NoError(t, err)
+	var collectionIDs []uint32
+	if collection.IsSupported(sgbucket.DataStoreFeatureCollections) {
+		collectionID, err := collection.GetCollectionID()
+		require.NoError(t, err)
+		collectionIDs = append(collectionIDs, collectionID)
+	}
+	numVbuckets, err := collection.GetMaxVbno()
+	require.NoError(t, err)
+
+	progressLogInterval := 100 * time.Millisecond
+
+	// Continuous feed, progress should be logged at the configured interval while the feed is open
+	continuousFeedID := t.Name() + "_continuous"
+	continuousClient, err := NewDCPClient(continuousFeedID, func(event sgbucket.FeedEvent) bool { return false }, DCPClientOptions{
+		CollectionIDs:       collectionIDs,
+		ProgressLogInterval: progressLogInterval,
+	}, collection)
+	require.NoError(t, err)
+	AssertLogContains(t, "DCP client "+continuousFeedID+" progress:", func() {
+		_, startErr := continuousClient.Start()
+		require.NoError(t, startErr)
+		time.Sleep(3 * progressLogInterval)
+	})
+	require.NoError(t, continuousClient.Close())
+
+	// One-shot feed, bounded by the high seqnos at start, should report every vbucket complete once done
+	mutationCount := uint64(0)
+	counterCallback := func(event sgbucket.FeedEvent) bool {
+		atomic.AddUint64(&mutationCount, 1)
+		return false
+	}
+	oneShotClient, err := NewDCPClient(t.Name()+"_oneshot", counterCallback, DCPClientOptions{
+		OneShot:             true,
+		CollectionIDs:       collectionIDs,
+		ProgressLogInterval: progressLogInterval,
+	}, collection)
+	require.NoError(t, err)
+	defer func() {
+		_ = oneShotClient.Close()
+	}()
+
+	expectedCompletion := fmt.Sprintf("%d/%d bounded vbuckets complete (100.0%%)", numVbuckets, numVbuckets)
+	AssertLogContains(t, expectedCompletion, func() {
+		doneChan, startErr := oneShotClient.Start()
+		require.NoError(t, startErr)
+		select {
+		case err := <-doneChan:
+			require.NoError(t, err)
+		case <-time.After(oneShotDCPTimeout):
+			require.Fail(t, "timeout waiting for one-shot feed to complete")
+		}
+	})
+
+	// Processed count includes every mutation delivered to the callback
+	progress := oneShotClient.progress.summary(0, 0)
+	assert.GreaterOrEqual(t, atomic.LoadUint64(&mutationCount), uint64(numDocs))
+	assert.Equal(t, atomic.LoadUint64(&mutationCount), progress.Processed)
+	assert.Equal(t, int(numVbuckets), progress.CompletedVbuckets)
+}
+
+// newKeyFilterTestDCPClient creates a DCPClient with started workers and in-memory metadata, but no connection to a
+// bucket, so that stream events can be sent to it directly.
+func newKeyFilterTestDCPClient(numVbuckets uint16, callback sgbucket.FeedEventCallbackFunc, keyFilter DCPKeyFilterFunc) *DCPClient {
+	dc := &DCPClient{
+		workers:          make([]*DCPWorker, defaultNumWorkers),
+		numVbuckets:      numVbuckets,
+		callback:         callback,
+		terminator:       make(chan bool),
+		doneChannel:      make(chan error, 1),
+		stopped:          make(chan struct{}),
+		metadata:         NewDCPMetadataMem(numVbuckets),
+		checkpointPrefix: DCPCheckpointPrefixWithGroupID(""),
+		progress:         newDCPProgress(numVbuckets),
+		keyFilter:        keyFilter,
+	}
+	dc.startWorkers()
+	return dc
+}
+
+// waitForVbSeqs waits until the client's workers have processed up to the given sequence for each vbucket.
+func waitForVbSeqs(dc *DCPClient, vbSeqs []uint64) {
+	for vbID, seq := range vbSeqs {
+		for atomic.LoadUint64(&dc.progress.vbSeqs[vbID]) < seq {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestExcludeKeyPrefixes(t *testing.T) {
+	filter := ExcludeKeyPrefixes(SyncDocPrefix, "_txn:")
+	assert.True(t, filter([]byte("doc1")))
+	assert.True(t, filter([]byte("_syncdoc")))
+	assert.False(t, filter([]byte(SyncDocPrefix+"seq")))
+	assert.False(t, filter([]byte("_txn:atr-1")))
+}
+
+// TestDCPClientKeyFilter ensures mutations and deletions for filtered keys aren't sent to the callback, but still
+// advance the vbucket's sequence.
+func TestDCPClientKeyFilter(t *testing.T) {
+
+	var callbackKeys []string
+	var callbackLock sync.Mutex
+	callback := func(event sgbucket.FeedEvent) bool {
+		callbackLock.Lock()
+		defer callbackLock.Unlock()
+		callbackKeys = append(callbackKeys, string(event.Key))
+		return true
+	}
+
+	dc := newKeyFilterTestDCPClient(1, callback, ExcludeKeyPrefixes(SyncDocPrefix))
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 1, Key: []byte("doc1"), Value: []byte(`{}`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 2, Key: []byte(SyncDocPrefix + "seq"), Value: []byte(`1`)})
+	dc.Deletion(gocbcore.DcpDeletion{VbID: 0, SeqNo: 3, Key: []byte("doc2")})
+	dc.Deletion(gocbcore.DcpDeletion{VbID: 0, SeqNo: 4, Key: []byte(SyncDocPrefix + "user:bob")})
+	waitForVbSeqs(dc, []uint64{4})
+
+	callbackLock.Lock()
+	defer callbackLock.Unlock()
+	assert.Equal(t, []string{"doc1", "doc2"}, callbackKeys)
+	assert.Equal(t, gocbcore.SeqNo(4), dc.metadata.GetMeta(0).StartSeqNo)
+}
+
+// TestDCPClientFilterStats ensures the received, filtered and processed counts of mutations and deletions are recorded.
+func TestDCPClientFilterStats(t *testing.T) {
+
+	dc := newKeyFilterTestDCPClient(1, func(sgbucket.FeedEvent) bool { return true }, ExcludeKeyPrefixes(SyncDocPrefix))
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+	dc.dbStats = new(expvar.Map).Init()
+
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 1, Key: []byte("doc1"), Value: []byte(`{}`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 2, Key: []byte(SyncDocPrefix + "seq"), Value: []byte(`1`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 3, Key: []byte(SyncDocPrefix + "user:bob"), Value: []byte(`{}`)})
+	dc.Deletion(gocbcore.DcpDeletion{VbID: 0, SeqNo: 4, Key: []byte("doc2")})
+	waitForVbSeqs(dc, []uint64{4})
+
+	expected := map[string]string{
+		dcpMutationReceivedStat:  "3",
+		dcpMutationFilteredStat:  "2",
+		dcpMutationProcessedStat: "1",
+		dcpDeletionReceivedStat:  "1",
+		dcpDeletionProcessedStat: "1",
+	}
+	for stat, value := range expected {
+		require.NotNil(t, dc.dbStats.Get(stat), "Missing stat %s", stat)
+		assert.Equal(t, value, dc.dbStats.Get(stat).String(), "Unexpected value for stat %s", stat)
+	}
+	assert.Nil(t, dc.dbStats.Get(dcpDeletionFilteredStat))
+}
+
+// TestDCPClientCollectionFilter ensures mutations and deletions for collections the client's streams weren't opened
+// for aren't sent to the callback, but still advance the vbucket's sequence.
+func TestDCPClientCollectionFilter(t *testing.T) {
+
+	var callbackKeys []string
+	var callbackLock sync.Mutex
+	callback := func(event sgbucket.FeedEvent) bool {
+		callbackLock.Lock()
+		defer callbackLock.Unlock()
+		callbackKeys = append(callbackKeys, string(event.Key))
+		return true
+	}
+
+	dc := newKeyFilterTestDCPClient(1, callback, nil)
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+	dc.streamCollections = map[uint32]struct{}{8: {}, 9: {}}
+
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 1, CollectionID: 8, Key: []byte("doc1"), Value: []byte(`{}`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 2, CollectionID: 10, Key: []byte("doc2"), Value: []byte(`{}`)})
+	dc.Deletion(gocbcore.DcpDeletion{VbID: 0, SeqNo: 3, CollectionID: 9, Key: []byte("doc3")})
+	dc.Deletion(gocbcore.DcpDeletion{VbID: 0, SeqNo: 4, CollectionID: DefaultCollectionID, Key: []byte("doc4")})
+	waitForVbSeqs(dc, []uint64{4})
+
+	callbackLock.Lock()
+	defer callbackLock.Unlock()
+	assert.Equal(t, []string{"doc1", "doc3"}, callbackKeys)
+	assert.Equal(t, gocbcore.SeqNo(4), dc.metadata.GetMeta(0).StartSeqNo)
+}
+
+// TestDCPClientCaughtUpHandler ensures the caught up handler is invoked once, when the last vbucket has processed up to
+// its high seqno, including vbuckets that are caught up from the start or whose stream ends.
+func TestDCPClientCaughtUpHandler(t *testing.T) {
+
+	var caughtUpCount int32
+	dc := newKeyFilterTestDCPClient(3, func(sgbucket.FeedEvent) bool { return true }, nil)
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+	dc.progress.caughtUpHandler = func() {
+		atomic.AddInt32(&caughtUpCount, 1)
+	}
+
+	// vb 1 is empty, so is already caught up
+	dc.progress.setBounds(dc.GetMetadata(), map[uint16]uint64{0: 2, 1: 0, 2: 5})
+
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 1, Key: []byte("doc1"), Value: []byte(`{}`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 2, Key: []byte("doc2"), Value: []byte(`{}`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 2, SeqNo: 3, Key: []byte("doc3"), Value: []byte(`{}`)})
+	waitForVbSeqs(dc, []uint64{2, 0, 3})
+	assert.Equal(t, int32(0), atomic.LoadInt32(&caughtUpCount))
+
+	// The rest of vb 2's backfill isn't visible to the client, and its sequence is advanced to the high seqno
+	dc.SeqNoAdvanced(gocbcore.DcpSeqNoAdvanced{VbID: 2, SeqNo: 5})
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&caughtUpCount) == 1
+	}, 10*time.Second, time.Millisecond)
+
+	// Live mutations after catching up don't invoke the handler again
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 3, Key: []byte("doc4"), Value: []byte(`{}`)})
+	waitForVbSeqs(dc, []uint64{3, 0, 5})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&caughtUpCount))
+}
+
+// TestDCPClientMutationBatching ensures batched mutations are sent to the worker when the batch is full, at the end
+// of a snapshot, before any other event for the vbucket and by the flusher, and are processed in order.
+func TestDCPClientMutationBatching(t *testing.T) {
+
+	var callbackKeys []string
+	var callbackLock sync.Mutex
+	callback := func(event sgbucket.FeedEvent) bool {
+		callbackLock.Lock()
+		defer callbackLock.Unlock()
+		callbackKeys = append(callbackKeys, string(event.Key))
+		return true
+	}
+
+	dc := newKeyFilterTestDCPClient(1, callback, nil)
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+	dc.mutationBatches = newMutationBatches(1)
+	dc.mutationBatchSize = 3
+
+	mutate := func(seq uint64) {
+		dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: seq, Key: []byte(fmt.Sprintf("doc%d", seq)), Value: []byte(`{}`)})
+	}
+
+	// Snapshot end
+	dc.SnapshotMarker(gocbcore.DcpSnapshotMarker{VbID: 0, StartSeqNo: 1, EndSeqNo: 2})
+	mutate(1)
+	mutate(2)
+	waitForVbSeqs(dc, []uint64{2})
+
+	// Full batch
+	dc.SnapshotMarker(gocbcore.DcpSnapshotMarker{VbID: 0, StartSeqNo: 3, EndSeqNo: 10})
+	mutate(3)
+	mutate(4)
+	mutate(5)
+	waitForVbSeqs(dc, []uint64{5})
+
+	// Held until another event for the vbucket
+	mutate(6)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint64(5), atomic.LoadUint64(&dc.progress.vbSeqs[0]))
+	dc.Deletion(gocbcore.DcpDeletion{VbID: 0, SeqNo: 7, Key: []byte("doc7")})
+	waitForVbSeqs(dc, []uint64{7})
+
+	// Flushed after the flush interval
+	mutate(8)
+	dc.startMutationBatchFlusher(10 * time.Millisecond)
+	waitForVbSeqs(dc, []uint64{8})
+
+	callbackLock.Lock()
+	defer callbackLock.Unlock()
+	assert.Equal(t, []string{"doc1", "doc2", "doc3", "doc4", "doc5", "doc6", "doc7", "doc8"}, callbackKeys)
+	assert.Equal(t, gocbcore.SeqNo(8), dc.metadata.GetMeta(0).StartSeqNo)
+}
+
+// TestDCPClientEndSeqNos ensures a client with end sequences closes, releasing Wait, once every vbucket's stream has
+// ended, including vbuckets that were already at their end sequence and so had no stream opened.
+func TestDCPClientEndSeqNos(t *testing.T) {
+
+	dc := newKeyFilterTestDCPClient(2, func(sgbucket.FeedEvent) bool { return true }, nil)
+	dc.endSeqNos = map[uint16]uint64{0: 2, 1: 0}
+	dc.activeVbuckets = map[uint16]struct{}{0: {}, 1: {}}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- dc.Wait()
+	}()
+
+	// vb 1 has nothing to stream, so ends without a stream being opened
+	require.NoError(t, dc.openStream(1, openRetryCount))
+	assert.False(t, dc.closing.IsTrue())
+
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 1, Key: []byte("doc1"), Value: []byte(`{}`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 2, Key: []byte("doc2"), Value: []byte(`{}`)})
+	dc.End(gocbcore.DcpStreamEnd{VbID: 0}, nil)
+
+	select {
+	case err := <-waitErr:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out waiting for client to stop once all streams ended")
+	}
+	assert.Equal(t, gocbcore.SeqNo(2), dc.metadata.GetMeta(0).StartSeqNo)
+}
+
+// TestDCPClientRollbackHandler ensures the rollback handler is invoked with the vbucket's rolled back start sequence.
+func TestDCPClientRollbackHandler(t *testing.T) {
+
+	dc := newKeyFilterTestDCPClient(2, func(sgbucket.FeedEvent) bool { return true }, nil)
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+
+	type rollbackEvent struct {
+		vbID        uint16
+		rollbackSeq uint64
+	}
+	var rollbacks []rollbackEvent
+	dc.rollbackHandler = func(vbID uint16, rollbackSeq uint64) {
+		// Metadata must already have been rolled back when the handler is invoked
+		assert.Equal(t, gocbcore.SeqNo(rollbackSeq), dc.metadata.GetMeta(vbID).StartSeqNo)
+		rollbacks = append(rollbacks, rollbackEvent{vbID: vbID, rollbackSeq: rollbackSeq})
+	}
+
+	dc.metadata.SetMeta(1, DCPMetadata{VbUUID: 1234, StartSeqNo: 10, SnapStartSeqNo: 10, SnapEndSeqNo: 10})
+	require.NoError(t, dc.rollback(1))
+
+	assert.Equal(t, []rollbackEvent{{vbID: 1, rollbackSeq: 0}}, rollbacks)
+	assert.Equal(t, gocbcore.SeqNo(0), dc.metadata.GetMeta(1).StartSeqNo)
+}
+
+// TestDCPClientGetVbucketSequences ensures the sequences returned by GetVbucketSequences start from each vbucket's
+// start sequence, follow processed mutations, and are reset on rollback.
+func TestDCPClientGetVbucketSequences(t *testing.T) {
+
+	dc := newKeyFilterTestDCPClient(2, func(sgbucket.FeedEvent) bool { return true }, nil)
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+
+	dc.metadata.SetMeta(1, DCPMetadata{VbUUID: 1234, StartSeqNo: 10, SnapStartSeqNo: 10, SnapEndSeqNo: 10})
+	dc.progress.setStartSeqs(dc.GetMetadata())
+	assert.Equal(t, map[uint16]uint64{0: 0, 1: 10}, dc.GetVbucketSequences())
+
+	// Read concurrently with the workers' updates
+	readsDone := make(chan struct{})
+	go func() {
+		defer close(readsDone)
+		for i := 0; i < 100; i++ {
+			seqs := dc.GetVbucketSequences()
+			assert.Len(t, seqs, 2)
+		}
+	}()
+	dc.Mutation(gocbcore.DcpMutation{VbID: 0, SeqNo: 1, Key: []byte("doc1"), Value: []byte(`{}`)})
+	dc.Mutation(gocbcore.DcpMutation{VbID: 1, SeqNo: 11, Key: []byte("doc2"), Value: []byte(`{}`)})
+	dc.Deletion(gocbcore.DcpDeletion{VbID: 0, SeqNo: 3, Key: []byte("doc3")})
+	waitForVbSeqs(dc, []uint64{3, 11})
+	<-readsDone
+	assert.Equal(t, map[uint16]uint64{0: 3, 1: 11}, dc.GetVbucketSequences())
+
+	require.NoError(t, dc.rollback(1))
+	assert.Equal(t, map[uint16]uint64{0: 3, 1: 0}, dc.GetVbucketSequences())
+}
+
+// TestDCPWorkerQueueTimeHistogram ensures the time events spend in a worker's queue is recorded when the worker has stats.
+func TestDCPWorkerQueueTimeHistogram(t *testing.T) {
+
+	SetUpTestLogging(t, LevelDebug, KeyDCP)
+
+	var processed sync.WaitGroup
+	callback := func(event sgbucket.FeedEvent) bool {
+		// Slow callback, so that subsequent events wait in the queue
+		time.Sleep(10 * time.Millisecond)
+		processed.Done()
+		return true
+	}
+
+	dbStats := new(expvar.Map).Init()
+	terminator := make(chan bool)
+	var workersWg sync.WaitGroup
+	worker := NewDCPWorker(0, NewDCPMetadataMem(1), callback, nil, terminator, nil, DCPCheckpointPrefixWithGroupID(""), []uint16{0}, &DCPWorkerOptions{dbStats: dbStats})
+	worker.Start(&workersWg)
+	defer func() {
+		close(terminator)
+		workersWg.Wait()
+	}()
+
+	const numEvents = 5
+	processed.Add(numEvents)
+	for i := 1; i <= numEvents; i++ {
+		worker.Send(mutationEvent{seq: uint64(i), key: []byte(fmt.Sprintf("doc%d", i)), value: []byte(`{}`)})
+	}
+	processed.Wait()
+
+	var queueTimeCount int64
+	dbStats.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, dcpWorkerQueueTimeStat) {
+			queueTimeCount += kv.Value.(*expvar.Int).Value()
+		}
+	})
+	assert.Equal(t, int64(numEvents), queueTimeCount)
+}
+
+// persistNotifyingMetadata is an in-memory metadata store that sends the failover log of vbucket 0 to persisted
+// whenever metadata is persisted.
+type persistNotifyingMetadata struct {
+	*DCPMetadataMem
+	persisted chan []gocbcore.FailoverEntry
+}
+
+func (m *persistNotifyingMetadata) Persist(workerID int, vbIDs []uint16) {
+	m.persisted <- m.GetMeta(0).FailoverEntries
+}
+
+// TestDCPWorkerPersistsFailoverLog verifies that metadata is persisted when a stream is opened with a failover log
+// showing the vbucket has failed over since the metadata was stored.
+func TestDCPWorkerPersistsFailoverLog(t *testing.T) {
+
+	metadata := &persistNotifyingMetadata{
+		DCPMetadataMem: NewDCPMetadataMem(1),
+		persisted:      make(chan []gocbcore.FailoverEntry, 10),
+	}
+	// Metadata restored from a previous run
+	storedFailoverLog := []gocbcore.FailoverEntry{{VbUUID: 1234, SeqNo: 0}}
+	metadata.SetMeta(0, DCPMetadata{VbUUID: 1234, StartSeqNo: 100, EndSeqNo: math.MaxUint64, FailoverEntries: storedFailoverLog})
+
+	dbStats := new(expvar.Map).Init()
+	terminator := make(chan bool)
+	var workersWg sync.WaitGroup
+	worker := NewDCPWorker(0, metadata, nil, nil, terminator, nil, DCPCheckpointPrefixWithGroupID(""), []uint16{0}, &DCPWorkerOptions{dbStats: dbStats})
+	worker.Start(&workersWg)
+	defer func() {
+		close(terminator)
+		workersWg.Wait()
+	}()
+
+	// Reopened with an unchanged failover log, then after a failover at seq 50
+	worker.Send(streamOpenEvent{failoverLogs: storedFailoverLog})
+	failoverLog := []gocbcore.FailoverEntry{{VbUUID: 1234, SeqNo: 0}, {VbUUID: 5678, SeqNo: 50}}
+	worker.Send(streamOpenEvent{failoverLogs: failoverLog})
+
+	select {
+	case persisted := <-metadata.persisted:
+		assert.Equal(t, failoverLog, persisted)
+	case <-time.After(10 * time.Second):
+		require.Fail(t, "Timed out waiting for metadata to be persisted")
+	}
+	assert.Len(t, metadata.persisted, 0)
+	assert.Equal(t, gocbcore.VbUUID(5678), metadata.GetMeta(0).VbUUID)
+	assert.Equal(t, "1", dbStats.Get(dcpFailoverCountStat).String())
+}
+
+// TestDCPClientWorkerPool verifies that vbuckets are distributed across the configured number of workers, and that
+// each worker's queue has the configured size.
+func TestDCPClientWorkerPool(t *testing.T) {
+
+	testCases := []struct {
+		name              string
+		numWorkers        int
+		workerQueueSize   int
+		expectedQueueSize int
+	}{
+		{name: "defaults", numWorkers: defaultNumWorkers, expectedQueueSize: defaultQueueLength},
+		{name: "single worker", numWorkers: 1, workerQueueSize: 100, expectedQueueSize: 100},
+		{name: "worker per vbucket", numWorkers: 64, workerQueueSize: 5, expectedQueueSize: 5},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			const numVbuckets = 64
+			dc := &DCPClient{
+				workers:          make([]*DCPWorker, testCase.numWorkers),
+				numVbuckets:      numVbuckets,
+				terminator:       make(chan bool),
+				metadata:         NewDCPMetadataMem(numVbuckets),
+				checkpointPrefix: DCPCheckpointPrefixWithGroupID(""),
+				workerQueueSize:  testCase.workerQueueSize,
+			}
+			dc.startWorkers()
+			defer func() {
+				close(dc.terminator)
+				dc.workersWg.Wait()
+			}()
+
+			assignedCount := 0
+			for _, worker := range dc.workers {
+				assert.Equal(t, testCase.expectedQueueSize, cap(worker.eventFeed))
+				assert.Len(t, worker.assignedVbs, numVbuckets/testCase.numWorkers)
+				for _, vbNo := range worker.assignedVbs {
+					assert.Equal(t, worker, dc.workerForVbno(vbNo))
+				}
+				assignedCount += len(worker.assignedVbs)
+			}
+			assert.Equal(t, numVbuckets, assignedCount)
+		})
+	}
+}
+
+// TestDCPWorkerOSOSnapshot verifies that a vbucket's sequence isn't advanced while processing an OSO snapshot, and is
+// advanced to the highest sequence in the snapshot at the end of it.
+func TestDCPWorkerOSOSnapshot(t *testing.T) {
+
+	processed := make(chan string, 10)
+	callback := func(event sgbucket.FeedEvent) bool {
+		processed <- string(event.Key)
+		return true
+	}
+
+	metadata := NewDCPMetadataMem(2)
+	terminator := make(chan bool)
+	var workersWg sync.WaitGroup
+	worker := NewDCPWorker(0, metadata, callback, nil, terminator, nil, DCPCheckpointPrefixWithGroupID(""), []uint16{0, 1}, &DCPWorkerOptions{})
+	worker.Start(&workersWg)
+	defer func() {
+		close(terminator)
+		workersWg.Wait()
+	}()
+
+	// Events are processed in order by the worker, so once a marker mutation on vbucket 1 has been processed,
+	// everything sent before it for vbucket 0 has been too.
+	waitForMarker := func(seq uint64) {
+		key := fmt.Sprintf("marker%d", seq)
+		worker.Send(mutationEvent{streamEventCommon: streamEventCommon{vbID: 1}, seq: seq, key: []byte(key), value: []byte(`{}`)})
+		for k := range processed {
+			if k == key {
+				return
+			}
+		}
+	}
+
+	worker.Send(osoSnapshotEvent{streamEventCommon: streamEventCommon{vbID: 0}, start: true})
+	for _, seq := range []uint64{5, 2, 4} {
+		worker.Send(mutationEvent{streamEventCommon: streamEventCommon{vbID: 0}, seq: seq, key: []byte(fmt.Sprintf("doc%d", seq)), value: []byte(`{}`)})
+	}
+	waitForMarker(1)
+	assert.Equal(t, gocbcore.SeqNo(0), metadata.GetMeta(0).StartSeqNo)
+
+	worker.Send(osoSnapshotEvent{streamEventCommon: streamEventCommon{vbID: 0}, start: false})
+	waitForMarker(2)
+	meta := metadata.GetMeta(0)
+	assert.Equal(t, gocbcore.SeqNo(5), meta.StartSeqNo)
+	assert.Equal(t, gocbcore.SeqNo(5), meta.SnapStartSeqNo)
+	assert.Equal(t, gocbcore.SeqNo(5), meta.SnapEndSeqNo)
+}
+
+// snapshotCountingMetadata is an in-memory metadata store that counts the snapshots set, and sends the sequence of
+// vbucket 0 to persisted whenever metadata is persisted.
+type snapshotCountingMetadata struct {
+	*DCPMetadataMem
+	snapshotsSet int
+	persisted    chan gocbcore.SeqNo
+}
+
+func (m *snapshotCountingMetadata) SetSnapshot(e snapshotEvent) {
+	m.snapshotsSet++
+	m.DCPMetadataMem.SetSnapshot(e)
+}
+
+func (m *snapshotCountingMetadata) Persist(workerID int, vbIDs []uint16) {
+	m.persisted <- m.GetMeta(0).StartSeqNo
+}
+
+// TestDCPWorkerCoalescesSnapshots verifies that snapshot markers that don't advance a vbucket's snapshot window, as
+// sent by KV when a stream is reconnected, don't result in further snapshot checkpoint updates.
+func TestDCPWorkerCoalescesSnapshots(t *testing.T) {
+
+	metadata := &snapshotCountingMetadata{
+		DCPMetadataMem: NewDCPMetadataMem(1),
+		persisted:      make(chan gocbcore.SeqNo, 10),
+	}
+	terminator := make(chan bool)
+	var workersWg sync.WaitGroup
+	persistFrequency := time.Duration(0)
+	worker := NewDCPWorker(0, metadata, nil, nil, terminator, nil, DCPCheckpointPrefixWithGroupID(""), []uint16{0}, &DCPWorkerOptions{metaPersistFrequency: &persistFrequency})
+	worker.Start(&workersWg)
+	defer func() {
+		close(terminator)
+		workersWg.Wait()
+	}()
+
+	sendSnapshot := func(startSeq, endSeq uint64) {
+		worker.Send(snapshotEvent{startSeq: startSeq, endSeq: endSeq})
+	}
+	// Metadata is persisted after each mutation, once any pending snapshot has been set
+	sendMutation := func(seq uint64) {
+		worker.Send(mutationEvent{seq: seq, key: []byte(fmt.Sprintf("doc%d", seq)), value: []byte(`{}`)})
+		select {
+		case persistedSeq := <-metadata.persisted:
+			require.Equal(t, gocbcore.SeqNo(seq), persistedSeq)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Timed out waiting for metadata to be persisted", "seq %d", seq)
+		}
+	}
+
+	// Duplicate markers before the first mutation
+	sendSnapshot(1, 10)
+	sendSnapshot(1, 10)
+	sendMutation(5)
+	assert.Equal(t, 1, metadata.snapshotsSet)
+
+	// Markers within the current snapshot after reconnecting
+	sendSnapshot(1, 10)
+	sendSnapshot(5, 10)
+	sendMutation(6)
+	assert.Equal(t, 1, metadata.snapshotsSet)
+	meta := metadata.GetMeta(0)
+	assert.Equal(t, gocbcore.SeqNo(1), meta.SnapStartSeqNo)
+	assert.Equal(t, gocbcore.SeqNo(10), meta.SnapEndSeqNo)
+
+	// A marker advancing the window is applied
+	sendSnapshot(11, 20)
+	sendMutation(11)
+	assert.Equal(t, 2, metadata.snapshotsSet)
+	meta = metadata.GetMeta(0)
+	assert.Equal(t, gocbcore.SeqNo(11), meta.SnapStartSeqNo)
+	assert.Equal(t, gocbcore.SeqNo(20), meta.SnapEndSeqNo)
+	assert.Len(t, metadata.persisted, 0)
+}
+
+// BenchmarkDCPClientKeyFilter compares throughput when every event is sent to the callback with a key filter that drops
+// most events before they reach it.
+func BenchmarkDCPClientKeyFilter(b *testing.B) {
+
+	const numVbuckets = 64
+	value := []byte(`{"foo": "bar", "baz": [1, 2, 3]}`)
+	callback := func(event sgbucket.FeedEvent) bool {
+		// Simulate processing of the document body
+		var body map[string]interface{}
+		_ = JSONUnmarshal(event.Value, &body)
+		return true
+	}
+
+	testCases := []struct {
+		name      string
+		keyFilter DCPKeyFilterFunc
+	}{
+		{name: "NoFilter"},
+		{name: "ExcludeSyncPrefix", keyFilter: ExcludeKeyPrefixes(SyncDocPrefix)},
+	}
+
+	for _, testCase := range testCases {
+		b.Run(testCase.name, func(b *testing.B) {
+			// Nine in ten keys are metadata docs, which the filter drops
+			keys := make([][]byte, 10)
+			for i := range keys {
+				if i == 0 {
+					keys[i] = []byte("doc")
+				} else {
+					keys[i] = []byte(fmt.Sprintf("%sdoc%d", SyncDocPrefix, i))
+				}
+			}
+
+			dc := newKeyFilterTestDCPClient(numVbuckets, callback, testCase.keyFilter)
+			vbSeqs := make([]uint64, numVbuckets)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vbID := uint16(i % numVbuckets)
+				vbSeqs[vbID]++
+				dc.Mutation(gocbcore.DcpMutation{VbID: vbID, SeqNo: vbSeqs[vbID], Key: keys[i%len(keys)], Value: value})
+			}
+			waitForVbSeqs(dc, vbSeqs)
+			b.StopTimer()
+			close(dc.terminator)
+			dc.workersWg.Wait()
+		})
+	}
+}
+
+// TestDCPClientPauseResume verifies that stream events aren't passed to the workers while the client is paused, that
+// no events are lost across a pause and resume, and that closing the client releases a paused stream observer.
+func TestDCPClientPauseResume(t *testing.T) {
+
+	const numVbuckets = 4
+	processed := make(chan string, 100)
+	dc := &DCPClient{
+		workers:          make([]*DCPWorker, 2),
+		numVbuckets:      numVbuckets,
+		terminator:       make(chan bool),
+		metadata:         NewDCPMetadataMem(numVbuckets),
+		checkpointPrefix: DCPCheckpointPrefixWithGroupID(""),
+		callback: func(event sgbucket.FeedEvent) bool {
+			processed <- string(event.Key)
+			return true
+		},
+	}
+	dc.startWorkers()
+
+	mutation := func(seq uint64) gocbcore.DcpMutation {
+		vbID := uint16(seq % numVbuckets)
+		return gocbcore.DcpMutation{VbID: vbID, SeqNo: seq, Key: []byte(fmt.Sprintf("doc%d", seq)), Value: []byte(`{}`)}
+	}
+	requireProcessed := func(expected ...string) {
+		var keys []string
+		for range expected {
+			select {
+			case key := <-processed:
+				keys = append(keys, key)
+			case <-time.After(10 * time.Second):
+				require.FailNow(t, "timed out waiting for events to be processed", "processed: %v", keys)
+			}
+		}
+		require.ElementsMatch(t, expected, keys)
+	}
+
+	dc.Mutation(mutation(1))
+	requireProcessed("doc1")
+
+	dc.Pause()
+	require.True(t, dc.Paused())
+
+	// gocbcore delivers a connection's stream events on a single goroutine, which blocks while the client is paused
+	observerDone := make(chan struct{})
+	go func() {
+		defer close(observerDone)
+		for seq := uint64(2); seq <= 10; seq++ {
+			dc.Mutation(mutation(seq))
+		}
+	}()
+	select {
+	case key := <-processed:
+		require.FailNow(t, "event processed while the client was paused", "key: %s", key)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	dc.Resume()
+	require.False(t, dc.Paused())
+	requireProcessed("doc2", "doc3", "doc4", "doc5", "doc6", "doc7", "doc8", "doc9", "doc10")
+	<-observerDone
+
+	// Closing the client releases a stream observer blocked by a pause
+	dc.Pause()
+	observerDone = make(chan struct{})
+	go func() {
+		defer close(observerDone)
+		dc.Mutation(mutation(11))
+	}()
+	close(dc.terminator)
+	select {
+	case <-observerDone:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out waiting for paused stream observer to be released on close")
+	}
+	dc.workersWg.Wait()
+}
+
+func TestDCPClientOpenStreamSleeper(t *testing.T) {
+	dc := &DCPClient{openStreamMaxBackoff: time.Second}
+	sleeper := dc.openStreamSleeper()
+
+	// First retry is within the jittered initial backoff
+	shouldContinue, sleepMs := sleeper(1)
+	assert.True(t, shouldContinue)
+	assert.GreaterOrEqual(t, sleepMs, int(openStreamInitialBackoff/time.Millisecond)/2)
+	assert.LessOrEqual(t, sleepMs, int(openStreamInitialBackoff/time.Millisecond))
+
+	// Backoff is capped at openStreamMaxBackoff, and retries indefinitely
+	for attempt := 2; attempt < 20; attempt++ {
+		shouldContinue, sleepMs = sleeper(attempt)
+		assert.True(t, shouldContinue)
+		assert.LessOrEqual(t, sleepMs, 1000)
+	}
+	assert.GreaterOrEqual(t, sleepMs, 500)
+}
+
+// TestDCPClientStartMetadata ensures caller-supplied start positions are applied to the metadata streams are opened
+// from, and are only accepted when they match the failover log of the opened stream.
+func TestDCPClientStartMetadata(t *testing.T) {
+
+	dc := newKeyFilterTestDCPClient(4, func(sgbucket.FeedEvent) bool { return true }, nil)
+	defer func() {
+		close(dc.terminator)
+		dc.workersWg.Wait()
+	}()
+
+	require.Error(t, dc.setStartPositions(DCPStartMetadata{4: {VbUUID: 1234, SeqNo: 10}}))
+
+	require.NoError(t, dc.setStartPositions(DCPStartMetadata{
+		0: {VbUUID: 1234, SeqNo: 10},
+		1: {VbUUID: 1234, SeqNo: 60},
+		2: {VbUUID: 9999, SeqNo: 10},
+		3: {VbUUID: 1234, SeqNo: 10},
+	}))
+	meta := dc.metadata.GetMeta(0)
+	assert.Equal(t, gocbcore.VbUUID(1234), meta.VbUUID)
+	assert.Equal(t, gocbcore.SeqNo(10), meta.StartSeqNo)
+	assert.Equal(t, gocbcore.SeqNo(10), meta.SnapStartSeqNo)
+	assert.Equal(t, gocbcore.SeqNo(10), meta.SnapEndSeqNo)
+
+	// vb 1's position is from before a failover at seq 50, so was seen under a different vbUUID
+	failoverLog := []gocbcore.FailoverEntry{{VbUUID: 1234, SeqNo: 0}, {VbUUID: 5678, SeqNo: 50}}
+	assert.NoError(t, dc.verifyStartPosition(0, failoverLog))
+	assert.ErrorIs(t, dc.verifyStartPosition(1, failoverLog), errStartPositionMismatch)
+	assert.ErrorIs(t, dc.verifyStartPosition(2, failoverLog), errStartPositionMismatch)
+
+	// Positions are only verified once, when their stream is first opened
+	assert.NoError(t, dc.verifyStartPosition(1, failoverLog))
+
+	// A rolled back vbucket no longer starts from its position
+	require.NoError(t, dc.rollback(3))
+	assert.NoError(t, dc.verifyStartPosition(3, []gocbcore.FailoverEntry{{VbUUID: 5678, SeqNo: 0}}))
+
+	// The end of a stream closed to backfill from zero doesn't close the client
+	dc.closedStreams[1] = struct{}{}
+	dc.onStreamEnd(endStreamEvent{streamEventCommon: streamEventCommon{vbID: 1}, err: gocbcore.ErrDCPStreamClosed})
+	assert.False(t, dc.closing.IsTrue())
+	assert.False(t, dc.takeClosedStream(1))
+}
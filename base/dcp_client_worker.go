@@ -3,9 +3,11 @@ package base
 import (
 	"bytes"
 	"context"
+	"expvar"
 	"sync"
 	"time"
 
+	"github.com/couchbase/gocbcore/v10"
 	sgbucket "github.com/couchbase/sg-bucket"
 )
 
@@ -19,7 +21,7 @@ import (
 // works this channel and synchronously invokes the mutationCallback for mutations or deletions.
 type DCPWorker struct {
 	ID                    int
-	eventFeed             chan streamEvent
+	eventFeed             chan queuedStreamEvent
 	terminator            chan bool
 	checkpointPrefixBytes []byte
 	mutationCallback      sgbucket.FeedEventCallbackFunc
@@ -27,11 +29,28 @@ type DCPWorker struct {
 	ignoreDeletes         bool
 	metadata              DCPMetadataStore
 	pendingSnapshot       map[uint16]snapshotEvent
+	osoMaxSeqs            map[uint16]uint64 // Highest sequence seen so far for each vbucket in an OSO snapshot
 	lastMetaPersistTime   time.Time
 	metaPersistFrequency  time.Duration
 	assignedVbs           []uint16
+	progress              *dcpProgress
+	dbStats               *expvar.Map
 }
 
+// queuedStreamEvent is a streamEvent in a worker's eventFeed, along with the time it was queued.  queuedAt is only set
+// when the worker has stats to record the time spent in the queue to.
+type queuedStreamEvent struct {
+	streamEvent
+	queuedAt time.Time
+}
+
+// dcpWorkerQueueTimeStat is the prefix of the histogram of time spent by stream events in a worker's queue before being
+// processed.  Distinguishes a slow mutation callback (queue time increases) from a slow feed.
+const dcpWorkerQueueTimeStat = "dcp_worker_queue_time"
+
+// dcpFailoverCountStat counts streams opened with a different vbUUID than the one in the stored metadata
+const dcpFailoverCountStat = "dcp_failover_count"
+
 const defaultQueueLength = 10
 const defaultMetadataPersistFrequency = 1 * time.Minute
 
@@ -39,6 +58,8 @@ type DCPWorkerOptions struct {
 	eventQueueLength     int
 	ignoreDeletes        bool
 	metaPersistFrequency *time.Duration
+	progress             *dcpProgress // Optional, updated as events are processed
+	dbStats              *expvar.Map  // Optional, records a histogram of time spent by events in the queue
 }
 
 func NewDCPWorker(workerID int, metadata DCPMetadataStore, mutationCallback sgbucket.FeedEventCallbackFunc,
@@ -56,7 +77,14 @@ func NewDCPWorker(workerID int, metadata DCPMetadataStore, mutationCallback sgbu
 		metadataPersistFrequency = *options.metaPersistFrequency
 	}
 
-	eventQueue := make(chan streamEvent, queueLength)
+	var progress *dcpProgress
+	var dbStats *expvar.Map
+	if options != nil {
+		progress = options.progress
+		dbStats = options.dbStats
+	}
+
+	eventQueue := make(chan queuedStreamEvent, queueLength)
 
 	return &DCPWorker{
 		ID:                    workerID,
@@ -68,8 +96,11 @@ func NewDCPWorker(workerID int, metadata DCPMetadataStore, mutationCallback sgbu
 		ignoreDeletes:         options != nil && options.ignoreDeletes,
 		metadata:              metadata,
 		pendingSnapshot:       make(map[uint16]snapshotEvent),
+		osoMaxSeqs:            make(map[uint16]uint64),
 		metaPersistFrequency:  metadataPersistFrequency,
 		assignedVbs:           assignedVbs,
+		progress:              progress,
+		dbStats:               dbStats,
 	}
 }
 
@@ -84,8 +115,12 @@ func (w *DCPWorker) Send(event streamEvent) {
 		return
 	default:
 	}
+	queued := queuedStreamEvent{streamEvent: event}
+	if w.dbStats != nil {
+		queued.queuedAt = time.Now()
+	}
 	select {
-	case w.eventFeed <- event:
+	case w.eventFeed <- queued:
 	case <-w.terminator:
 		InfofCtx(context.TODO(), KeyDCP, "Closing DCP worker, DCP Client was closed")
 	}
@@ -97,28 +132,49 @@ func (w *DCPWorker) Start(wg *sync.WaitGroup) {
 		defer wg.Done()
 		for {
 			select {
-			case event := <-w.eventFeed:
+			case queued := <-w.eventFeed:
+				if w.dbStats != nil {
+					WriteHistogram(w.dbStats, queued.queuedAt, dcpWorkerQueueTimeStat)
+				}
+				event := queued.streamEvent
 				vbID := event.VbID()
 				switch e := event.(type) {
 				case streamOpenEvent:
-					w.metadata.SetFailoverEntries(e.vbID, e.failoverLogs)
+					w.setFailoverEntries(vbID, e.failoverLogs)
 				case snapshotEvent:
+					if w.isRedundantSnapshot(e) {
+						TracefCtx(context.TODO(), KeyDCP, "Ignoring redundant snapshot marker (vb:%d) [%d, %d]", vbID, e.startSeq, e.endSeq)
+						break
+					}
 					// Set pending snapshot - don't persist to meta until we receive first sequence in the snapshot,
 					// to avoid attempting to restart with a new snapshot and old sequence value
 					w.pendingSnapshot[vbID] = e
 				case mutationEvent:
-					if w.mutationCallback != nil {
-						w.mutationCallback(e.asFeedEvent())
+					w.processMutation(e)
+				case mutationBatchEvent:
+					for _, mutation := range e.mutations {
+						w.processMutation(mutation)
 					}
-					w.updateSeq(e.key, vbID, e.seq)
 				case deletionEvent:
 					if w.mutationCallback != nil && !w.ignoreDeletes {
 						w.mutationCallback(e.asFeedEvent())
 					}
 					w.updateSeq(e.key, vbID, e.seq)
+					w.mutationProcessed(vbID, e.seq)
 				case seqnoAdvancedEvent:
 					w.updateSeq(nil, vbID, e.seq)
+					if _, inOSO := w.osoMaxSeqs[vbID]; w.progress != nil && !inOSO {
+						w.progress.seqProcessed(vbID, e.seq)
+					}
+				case osoSnapshotEvent:
+					if e.start {
+						w.osoMaxSeqs[vbID] = 0
+					} else {
+						w.endOSOSnapshot(vbID)
+					}
 				case endStreamEvent:
+					// An incomplete OSO snapshot doesn't advance the sequence, and will be resent when the stream is reopened
+					delete(w.osoMaxSeqs, vbID)
 					w.endStreamCallback(e)
 				}
 			case <-w.terminator:
@@ -129,6 +185,32 @@ func (w *DCPWorker) Start(wg *sync.WaitGroup) {
 	}()
 }
 
+// processMutation invokes the callback for a mutation and updates the vbucket's sequence.
+func (w *DCPWorker) processMutation(e mutationEvent) {
+	if w.mutationCallback != nil {
+		w.mutationCallback(e.asFeedEvent())
+	}
+	w.updateSeq(e.key, e.vbID, e.seq)
+	w.mutationProcessed(e.vbID, e.seq)
+}
+
+// isRedundantSnapshot returns true if a snapshot marker doesn't advance the vbucket's snapshot window, because it falls
+// within the pending snapshot or, if there isn't one, the snapshot in the metadata.  KV can send back-to-back markers
+// for overlapping ranges when a stream is reconnected, and coalescing them avoids redundant metadata updates.
+func (w *DCPWorker) isRedundantSnapshot(e snapshotEvent) bool {
+	snapStart, snapEnd := uint64(0), uint64(0)
+	if pending, ok := w.pendingSnapshot[e.vbID]; ok {
+		snapStart, snapEnd = pending.startSeq, pending.endSeq
+	} else {
+		meta := w.metadata.GetMeta(e.vbID)
+		snapStart, snapEnd = uint64(meta.SnapStartSeqNo), uint64(meta.SnapEndSeqNo)
+	}
+	if snapEnd == 0 {
+		return false
+	}
+	return e.startSeq >= snapStart && e.endSeq <= snapEnd
+}
+
 func (w *DCPWorker) checkPendingSnapshot(vbID uint16) {
 	if snapshot, ok := w.pendingSnapshot[vbID]; ok {
 		w.metadata.SetSnapshot(snapshot)
@@ -142,6 +224,15 @@ func (w *DCPWorker) updateSeq(key []byte, vbID uint16, seq uint64) {
 		return
 	}
 
+	// Within an OSO snapshot, lower sequences may still be to come, so the vbucket's sequence can't be updated (and
+	// persisted) until the end of the snapshot
+	if maxSeq, inOSO := w.osoMaxSeqs[vbID]; inOSO {
+		if seq > maxSeq {
+			w.osoMaxSeqs[vbID] = seq
+		}
+		return
+	}
+
 	// TODO: update snapshot and seq in a single atomic update
 	w.checkPendingSnapshot(vbID)
 	w.metadata.UpdateSeq(vbID, seq)
@@ -152,6 +243,60 @@ func (w *DCPWorker) updateSeq(key []byte, vbID uint16, seq uint64) {
 
 }
 
+// setFailoverEntries records the failover log a vbucket's stream was opened with.  If the vbucket has failed over since
+// its failover log was last stored, the metadata is persisted straight away, so that after a restart the stream is
+// reopened with the current vbUUID and the server can tell whether a rollback is needed.
+func (w *DCPWorker) setFailoverEntries(vbID uint16, failoverLog []gocbcore.FailoverEntry) {
+	previousVbUUID := getLatestVbUUID(w.metadata.GetMeta(vbID).FailoverEntries)
+	w.metadata.SetFailoverEntries(vbID, failoverLog)
+	if previousVbUUID == 0 || previousVbUUID == getLatestVbUUID(failoverLog) {
+		return
+	}
+
+	InfofCtx(context.TODO(), KeyDCP, "vbucket %d has failed over since its metadata was stored (vbUUID %d -> %d)", vbID, previousVbUUID, getLatestVbUUID(failoverLog))
+	if w.dbStats != nil {
+		w.dbStats.Add(dcpFailoverCountStat, 1)
+	}
+	w.metadata.Persist(w.ID, w.assignedVbs)
+}
+
+// mutationProcessed updates progress after a mutation or deletion has been processed.  Within an OSO snapshot the
+// vbucket's progress sequence isn't advanced until the end of the snapshot.
+func (w *DCPWorker) mutationProcessed(vbID uint16, seq uint64) {
+	if w.progress == nil {
+		return
+	}
+	if _, inOSO := w.osoMaxSeqs[vbID]; inOSO {
+		w.progress.countProcessed()
+		return
+	}
+	w.progress.mutationProcessed(vbID, seq)
+}
+
+// endOSOSnapshot is called at the end of an OSO snapshot, when every sequence up to the highest one in the snapshot
+// has been processed.  The vbucket's sequence is advanced to it, as a complete snapshot so that the stream can be
+// restarted from there.
+func (w *DCPWorker) endOSOSnapshot(vbID uint16) {
+	maxSeq, inOSO := w.osoMaxSeqs[vbID]
+	if !inOSO {
+		return
+	}
+	delete(w.osoMaxSeqs, vbID)
+	if maxSeq == 0 {
+		return
+	}
+
+	w.pendingSnapshot[vbID] = snapshotEvent{
+		streamEventCommon: streamEventCommon{vbID: vbID},
+		startSeq:          maxSeq,
+		endSeq:            maxSeq,
+	}
+	w.updateSeq(nil, vbID, maxSeq)
+	if w.progress != nil {
+		w.progress.seqProcessed(vbID, maxSeq)
+	}
+}
+
 func (w *DCPWorker) Close() {
 	// cleanup persistence
 }
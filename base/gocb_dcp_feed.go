@@ -4,6 +4,7 @@ import (
 	"context"
 	"expvar"
 	"fmt"
+	"time"
 
 	"github.com/couchbase/gocbcore/v10"
 	sgbucket "github.com/couchbase/sg-bucket"
@@ -39,8 +40,8 @@ func getHighSeqMetadata(collection *Collection) ([]DCPMetadata, error) {
 	return metadata, nil
 }
 
-// StartGocbDCPFeed starts a DCP Feed.
-func StartGocbDCPFeed(collection *Collection, bucketName string, args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc, dbStats *expvar.Map, metadataStoreType DCPMetadataStoreType, groupID string) error {
+// StartGocbDCPFeed starts a DCP Feed.  If progressLogInterval is non-zero, the feed's aggregate progress is logged at that interval.
+func StartGocbDCPFeed(collection *Collection, bucketName string, args sgbucket.FeedArguments, callback sgbucket.FeedEventCallbackFunc, dbStats *expvar.Map, metadataStoreType DCPMetadataStoreType, groupID string, progressLogInterval time.Duration) error {
 	metadata, err := getHighSeqMetadata(collection)
 	if err != nil {
 		return err
@@ -61,12 +62,13 @@ func StartGocbDCPFeed(collection *Collection, bucketName string, args sgbucket.F
 		feedName,
 		callback,
 		DCPClientOptions{
-			MetadataStoreType: metadataStoreType,
-			GroupID:           groupID,
-			InitialMetadata:   metadata,
-			DbStats:           dbStats,
-			CollectionIDs:     collectionIDs,
-			AgentPriority:     gocbcore.DcpAgentPriorityMed,
+			MetadataStoreType:   metadataStoreType,
+			GroupID:             groupID,
+			InitialMetadata:     metadata,
+			DbStats:             dbStats,
+			CollectionIDs:       collectionIDs,
+			AgentPriority:       gocbcore.DcpAgentPriorityMed,
+			ProgressLogInterval: progressLogInterval,
 		},
 		collection)
 	if err != nil {
@@ -315,6 +315,16 @@ type CBLReplicationPushStats struct {
 	//
 	// The propose_change_time is not included in the write_processing_time.
 	ProposeChangeTime *SgwIntStat `json:"propose_change_time"`
+	// The total number of pushed revisions rejected because they conflict with the current revision.
+	RevRejectedConflictCount *SgwIntStat `json:"rev_rejected_conflict_count"`
+	// The total number of pushed revisions rejected by the sync function, or because the user isn't permitted to write them.
+	RevRejectedForbiddenCount *SgwIntStat `json:"rev_rejected_forbidden_count"`
+	// The total number of pushed revisions rejected because the rev message or body was malformed.
+	RevRejectedMalformedCount *SgwIntStat `json:"rev_rejected_malformed_count"`
+	// The total number of pushed revisions rejected for any other reason, including internal errors.
+	RevRejectedOtherCount *SgwIntStat `json:"rev_rejected_other_count"`
+	// The total number of pushed revisions rejected because the database had reached its doc count quota.
+	RevRejectedQuotaCount *SgwIntStat `json:"rev_rejected_quota_count"`
 	// Total time spent processing writes. Measures complete request-to-response time for a write.
 	WriteProcessingTime *SgwIntStat `json:"write_processing_time"`
 }
@@ -940,12 +950,17 @@ func (d *DbStats) initCBLReplicationPushStats() {
 	labelKeys := []string{DatabaseLabelKey}
 	labelVals := []string{d.dbName}
 	d.CBLReplicationPushStats = &CBLReplicationPushStats{
-		AttachmentPushBytes: NewIntStat(SubsystemReplicationPush, "attachment_push_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
-		AttachmentPushCount: NewIntStat(SubsystemReplicationPush, "attachment_push_count", labelKeys, labelVals, prometheus.CounterValue, 0),
-		DocPushCount:        NewIntStat(SubsystemReplicationPush, "doc_push_count", labelKeys, labelVals, prometheus.GaugeValue, 0),
-		ProposeChangeCount:  NewIntStat(SubsystemReplicationPush, "propose_change_count", labelKeys, labelVals, prometheus.CounterValue, 0),
-		ProposeChangeTime:   NewIntStat(SubsystemReplicationPush, "propose_change_time", labelKeys, labelVals, prometheus.CounterValue, 0),
-		WriteProcessingTime: NewIntStat(SubsystemReplicationPush, "write_processing_time", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		AttachmentPushBytes:       NewIntStat(SubsystemReplicationPush, "attachment_push_bytes", labelKeys, labelVals, prometheus.CounterValue, 0),
+		AttachmentPushCount:       NewIntStat(SubsystemReplicationPush, "attachment_push_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		DocPushCount:              NewIntStat(SubsystemReplicationPush, "doc_push_count", labelKeys, labelVals, prometheus.GaugeValue, 0),
+		ProposeChangeCount:        NewIntStat(SubsystemReplicationPush, "propose_change_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		ProposeChangeTime:         NewIntStat(SubsystemReplicationPush, "propose_change_time", labelKeys, labelVals, prometheus.CounterValue, 0),
+		RevRejectedConflictCount:  NewIntStat(SubsystemReplicationPush, "rev_rejected_conflict_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		RevRejectedForbiddenCount: NewIntStat(SubsystemReplicationPush, "rev_rejected_forbidden_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		RevRejectedMalformedCount: NewIntStat(SubsystemReplicationPush, "rev_rejected_malformed_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		RevRejectedOtherCount:     NewIntStat(SubsystemReplicationPush, "rev_rejected_other_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		RevRejectedQuotaCount:     NewIntStat(SubsystemReplicationPush, "rev_rejected_quota_count", labelKeys, labelVals, prometheus.CounterValue, 0),
+		WriteProcessingTime:       NewIntStat(SubsystemReplicationPush, "write_processing_time", labelKeys, labelVals, prometheus.GaugeValue, 0),
 	}
 }
 
@@ -955,6 +970,11 @@ func (d *DbStats) unregisterCBLReplicationPushStats() {
 	prometheus.Unregister(d.CBLReplicationPushStats.DocPushCount)
 	prometheus.Unregister(d.CBLReplicationPushStats.ProposeChangeCount)
 	prometheus.Unregister(d.CBLReplicationPushStats.ProposeChangeTime)
+	prometheus.Unregister(d.CBLReplicationPushStats.RevRejectedConflictCount)
+	prometheus.Unregister(d.CBLReplicationPushStats.RevRejectedForbiddenCount)
+	prometheus.Unregister(d.CBLReplicationPushStats.RevRejectedMalformedCount)
+	prometheus.Unregister(d.CBLReplicationPushStats.RevRejectedOtherCount)
+	prometheus.Unregister(d.CBLReplicationPushStats.RevRejectedQuotaCount)
 	prometheus.Unregister(d.CBLReplicationPushStats.WriteProcessingTime)
 }
 
@@ -23,6 +23,7 @@ import (
 	"hash/crc32"
 	"io"
 	"math"
+	mathrand "math/rand"
 	"net"
 	"net/http"
 	"net/url"
@@ -566,6 +567,19 @@ func CreateIndefiniteMaxDoublingSleeperFunc(initialTimeToSleepMs int, maxSleepPe
 	return sleeper
 }
 
+// CreateJitteredSleeperFunc wraps a RetrySleeper so that each sleep is a random duration between half and all of the
+// wrapped sleeper's time, to avoid concurrent retries against the same resource all waking at once.
+func CreateJitteredSleeperFunc(sleeper RetrySleeper) RetrySleeper {
+	return func(numAttempts int) (bool, int) {
+		shouldContinue, timeToSleepMs := sleeper(numAttempts)
+		if !shouldContinue || timeToSleepMs <= 1 {
+			return shouldContinue, timeToSleepMs
+		}
+		half := timeToSleepMs / 2
+		return true, timeToSleepMs - half + mathrand.Intn(half+1)
+	}
+}
+
 // SortedUint64Slice attaches the methods of sort.Interface to []uint64, sorting in increasing order.
 type SortedUint64Slice []uint64
 
@@ -178,6 +178,24 @@ func TestCreateDoublingSleeperFunc(t *testing.T) {
 
 }
 
+func TestCreateJitteredSleeperFunc(t *testing.T) {
+
+	maxNumAttempts := 5
+	sleeper := CreateJitteredSleeperFunc(CreateDoublingSleeperFunc(maxNumAttempts, 100))
+
+	expectedMaxMs := 100
+	for attempt := 1; attempt <= maxNumAttempts; attempt++ {
+		shouldContinue, timeToSleepMs := sleeper(attempt)
+		assert.True(t, shouldContinue)
+		assert.GreaterOrEqual(t, timeToSleepMs, expectedMaxMs/2)
+		assert.LessOrEqual(t, timeToSleepMs, expectedMaxMs)
+		expectedMaxMs *= 2
+	}
+
+	shouldContinue, _ := sleeper(maxNumAttempts + 1)
+	assert.False(t, shouldContinue)
+}
+
 func TestRetryLoop(t *testing.T) {
 
 	// Make sure that the worker retries if an error is returned and shouldRetry == true
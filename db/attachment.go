@@ -17,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/couchbase/sync_gateway/base"
 )
@@ -84,6 +85,10 @@ func (db *Database) storeAttachments(ctx context.Context, doc *Document, newAtta
 				return nil, err
 			}
 			digest := Sha1DigestKey(attachment)
+			// A sha1 digest sent with the data must match it, so that data corrupted in transit isn't stored
+			if declaredDigest, ok := meta["digest"].(string); ok && strings.HasPrefix(declaredDigest, "sha1-") && declaredDigest != digest {
+				return nil, base.HTTPErrorf(http.StatusBadRequest, "Data of attachment %q doesn't match its digest %s", name, declaredDigest)
+			}
 			key := MakeAttachmentKey(AttVersion2, doc.ID, digest)
 			newAttachmentData[key] = attachment
 
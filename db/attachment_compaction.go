@@ -21,7 +21,7 @@ const (
 	CleanupPhase    = "cleanup"
 )
 
-func attachmentCompactMarkPhase(ctx context.Context, db *Database, compactionID string, terminator *base.SafeTerminator, markedAttachmentCount *base.AtomicInt) (count int64, vbUUIDs []uint64, err error) {
+func attachmentCompactMarkPhase(ctx context.Context, db *Database, compactionID string, terminator *base.SafeTerminator, markedAttachmentCount *base.AtomicInt, phaseProgress *compactionPhaseProgress) (count int64, vbUUIDs []uint64, err error) {
 	base.InfofCtx(ctx, base.KeyAll, "Starting first phase of attachment compaction (mark phase) with compactionID: %q", compactionID)
 	compactionLoggingID := "Compaction Mark: " + compactionID
 
@@ -126,7 +126,7 @@ func attachmentCompactMarkPhase(ctx context.Context, db *Database, compactionID
 		return 0, nil, err
 	}
 
-	clientOptions, err := getCompactionDCPClientOptions(collection, db.Options.GroupID)
+	clientOptions, err := getCompactionDCPClientOptions(collection, db.Options.GroupID, db.Options.DCPProgressLogInterval)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -142,6 +142,7 @@ func attachmentCompactMarkPhase(ctx context.Context, db *Database, compactionID
 		base.WarnfCtx(ctx, "[%s] Failed to create attachment compaction DCP client! %v", compactionLoggingID, err)
 		return 0, nil, err
 	}
+	phaseProgress.setClient(dcpClient)
 
 	doneChan, err := dcpClient.Start()
 	if err != nil {
@@ -285,7 +286,7 @@ func handleAttachments(attachmentKeyMap map[string]string, docKey string, attach
 	}
 }
 
-func attachmentCompactSweepPhase(ctx context.Context, db *Database, compactionID string, vbUUIDs []uint64, dryRun bool, terminator *base.SafeTerminator, purgedAttachmentCount *base.AtomicInt) (int64, error) {
+func attachmentCompactSweepPhase(ctx context.Context, db *Database, compactionID string, vbUUIDs []uint64, dryRun bool, terminator *base.SafeTerminator, purgedAttachmentCount *base.AtomicInt, phaseProgress *compactionPhaseProgress) (int64, error) {
 	base.InfofCtx(ctx, base.KeyAll, "Starting second phase of attachment compaction (sweep phase) with compactionID: %q", compactionID)
 	compactionLoggingID := "Compaction Sweep: " + compactionID
 
@@ -352,7 +353,7 @@ func attachmentCompactSweepPhase(ctx context.Context, db *Database, compactionID
 		return 0, err
 	}
 
-	clientOptions, err := getCompactionDCPClientOptions(collection, db.Options.GroupID)
+	clientOptions, err := getCompactionDCPClientOptions(collection, db.Options.GroupID, db.Options.DCPProgressLogInterval)
 	if err != nil {
 		return 0, err
 	}
@@ -365,6 +366,7 @@ func attachmentCompactSweepPhase(ctx context.Context, db *Database, compactionID
 		base.WarnfCtx(ctx, "[%s] Failed to create attachment compaction DCP client! %v", compactionLoggingID, err)
 		return 0, err
 	}
+	phaseProgress.setClient(dcpClient)
 
 	doneChan, err := dcpClient.Start()
 	if err != nil {
@@ -397,7 +399,7 @@ func attachmentCompactSweepPhase(ctx context.Context, db *Database, compactionID
 	return purgedAttachmentCount.Value(), err
 }
 
-func attachmentCompactCleanupPhase(ctx context.Context, db *Database, compactionID string, vbUUIDs []uint64, terminator *base.SafeTerminator) error {
+func attachmentCompactCleanupPhase(ctx context.Context, db *Database, compactionID string, vbUUIDs []uint64, terminator *base.SafeTerminator, phaseProgress *compactionPhaseProgress) error {
 	base.InfofCtx(ctx, base.KeyAll, "Starting third phase of attachment compaction (cleanup phase) with compactionID: %q", compactionID)
 	compactionLoggingID := "Compaction Cleanup: " + compactionID
 
@@ -486,7 +488,7 @@ func attachmentCompactCleanupPhase(ctx context.Context, db *Database, compaction
 		return err
 	}
 
-	clientOptions, err := getCompactionDCPClientOptions(collection, db.Options.GroupID)
+	clientOptions, err := getCompactionDCPClientOptions(collection, db.Options.GroupID, db.Options.DCPProgressLogInterval)
 	if err != nil {
 		return err
 	}
@@ -500,6 +502,7 @@ func attachmentCompactCleanupPhase(ctx context.Context, db *Database, compaction
 		base.WarnfCtx(ctx, "[%s] Failed to create attachment compaction DCP client! %v", compactionLoggingID, err)
 		return err
 	}
+	phaseProgress.setClient(dcpClient)
 
 	doneChan, err := dcpClient.Start()
 	if err != nil {
@@ -540,7 +543,7 @@ func getCompactionIDSubDocPath(compactionID string) string {
 }
 
 // getCompactionDCPClientOptions returns the default set of DCPClientOptions suitable for attachment compaction
-func getCompactionDCPClientOptions(collection *base.Collection, groupID string) (*base.DCPClientOptions, error) {
+func getCompactionDCPClientOptions(collection *base.Collection, groupID string, progressLogInterval time.Duration) (*base.DCPClientOptions, error) {
 	var collectionIDs []uint32
 	if collection.IsSupported(sgbucket.DataStoreFeatureCollections) {
 		collectionID, err := collection.GetCollectionID()
@@ -551,11 +554,13 @@ func getCompactionDCPClientOptions(collection *base.Collection, groupID string)
 	}
 
 	clientOptions := &base.DCPClientOptions{
-		OneShot:           true,
-		FailOnRollback:    true,
-		MetadataStoreType: base.DCPMetadataStoreCS,
-		GroupID:           groupID,
-		CollectionIDs:     collectionIDs,
+		OneShot:             true,
+		FailOnRollback:      true,
+		MetadataStoreType:   base.DCPMetadataStoreCS,
+		GroupID:             groupID,
+		CollectionIDs:       collectionIDs,
+		ProgressLogInterval: progressLogInterval,
+		TrackProgress:       true,
 	}
 	return clientOptions, nil
 
@@ -48,7 +48,7 @@ func TestAttachmentMark(t *testing.T) {
 	attKeys = append(attKeys, createDocWithInBodyAttachment(t, ctx, "inBodyDoc", []byte(`{}`), "attForInBodyRef", []byte(`{"val": "inBodyAtt"}`), testDb))
 
 	terminator := base.NewSafeTerminator()
-	attachmentsMarked, _, err := attachmentCompactMarkPhase(ctx, testDb, t.Name(), terminator, &base.AtomicInt{})
+	attachmentsMarked, _, err := attachmentCompactMarkPhase(ctx, testDb, t.Name(), terminator, &base.AtomicInt{}, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(13), attachmentsMarked)
 
@@ -105,7 +105,7 @@ func TestAttachmentSweep(t *testing.T) {
 	}
 
 	terminator := base.NewSafeTerminator()
-	purged, err := attachmentCompactSweepPhase(ctx, testDb, t.Name(), nil, false, terminator, &base.AtomicInt{})
+	purged, err := attachmentCompactSweepPhase(ctx, testDb, t.Name(), nil, false, terminator, &base.AtomicInt{}, nil)
 	assert.NoError(t, err)
 
 	assert.Equal(t, int64(11), purged)
@@ -176,7 +176,7 @@ func TestAttachmentCleanup(t *testing.T) {
 	}
 
 	terminator := base.NewSafeTerminator()
-	err := attachmentCompactCleanupPhase(ctx, testDb, t.Name(), nil, terminator)
+	err := attachmentCompactCleanupPhase(ctx, testDb, t.Name(), nil, terminator, nil)
 	assert.NoError(t, err)
 
 	for _, docID := range singleMarkedAttIDs {
@@ -245,11 +245,13 @@ func TestAttachmentMarkAndSweepAndCleanup(t *testing.T) {
 	}
 
 	terminator := base.NewSafeTerminator()
-	attachmentsMarked, vbUUIDS, err := attachmentCompactMarkPhase(ctx, testDb, t.Name(), terminator, &base.AtomicInt{})
+	phaseProgress := &compactionPhaseProgress{}
+	attachmentsMarked, vbUUIDS, err := attachmentCompactMarkPhase(ctx, testDb, t.Name(), terminator, &base.AtomicInt{}, phaseProgress)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(10), attachmentsMarked)
+	assert.Equal(t, float64(100), phaseProgress.percentComplete())
 
-	attachmentsPurged, err := attachmentCompactSweepPhase(ctx, testDb, t.Name(), vbUUIDS, false, terminator, &base.AtomicInt{})
+	attachmentsPurged, err := attachmentCompactSweepPhase(ctx, testDb, t.Name(), vbUUIDS, false, terminator, &base.AtomicInt{}, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(5), attachmentsPurged)
 
@@ -267,7 +269,7 @@ func TestAttachmentMarkAndSweepAndCleanup(t *testing.T) {
 		}
 	}
 
-	err = attachmentCompactCleanupPhase(ctx, testDb, t.Name(), vbUUIDS, terminator)
+	err = attachmentCompactCleanupPhase(ctx, testDb, t.Name(), vbUUIDS, terminator, nil)
 	assert.NoError(t, err)
 
 	for _, attDocKey := range attKeys {
@@ -283,6 +285,20 @@ func TestAttachmentMarkAndSweepAndCleanup(t *testing.T) {
 	}
 }
 
+func TestAttachmentCompactionPercentComplete(t *testing.T) {
+	manager := &AttachmentCompactionManager{}
+	assert.Equal(t, float64(0), manager.percentComplete(BackgroundProcessStateRunning))
+	assert.Equal(t, float64(100), manager.percentComplete(BackgroundProcessStateCompleted))
+
+	// Each phase is weighted equally, with no progress through the phase until its DCP client has been started
+	manager.SetPhase(MarkPhase)
+	assert.Equal(t, float64(0), manager.percentComplete(BackgroundProcessStateRunning))
+	manager.SetPhase(SweepPhase)
+	assert.InDelta(t, 100.0/3, manager.percentComplete(BackgroundProcessStateRunning), 0.001)
+	manager.SetPhase(CleanupPhase)
+	assert.InDelta(t, 200.0/3, manager.percentComplete(BackgroundProcessStateRunning), 0.001)
+}
+
 func TestAttachmentCompactionRunTwice(t *testing.T) {
 	if base.UnitTestUrlIsWalrus() {
 		t.Skip("This test only works against Couchbase Server")
@@ -578,13 +594,13 @@ func TestAttachmentDifferentVBUUIDsBetweenPhases(t *testing.T) {
 
 	// Run mark phase as usual
 	terminator := base.NewSafeTerminator()
-	_, vbUUIDs, err := attachmentCompactMarkPhase(ctx, testDB, t.Name(), terminator, &base.AtomicInt{})
+	_, vbUUIDs, err := attachmentCompactMarkPhase(ctx, testDB, t.Name(), terminator, &base.AtomicInt{}, nil)
 	assert.NoError(t, err)
 
 	// Manually modify a vbUUID and ensure the Sweep phase errors
 	vbUUIDs[0] = 1
 
-	_, err = attachmentCompactSweepPhase(ctx, testDB, t.Name(), vbUUIDs, false, terminator, &base.AtomicInt{})
+	_, err = attachmentCompactSweepPhase(ctx, testDB, t.Name(), vbUUIDs, false, terminator, &base.AtomicInt{}, nil)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "error opening stream for vb 0: VbUUID mismatch when failOnRollback set")
 }
@@ -843,7 +859,7 @@ func TestAttachmentCompactIncorrectStat(t *testing.T) {
 	stat := &base.AtomicInt{}
 	count := int64(0)
 	go func() {
-		attachmentCount, _, err := attachmentCompactMarkPhase(ctx, testDb, "mark", terminator, stat)
+		attachmentCount, _, err := attachmentCompactMarkPhase(ctx, testDb, "mark", terminator, stat, nil)
 		atomic.StoreInt64(&count, attachmentCount)
 		require.NoError(t, err)
 	}()
@@ -885,7 +901,7 @@ func TestAttachmentCompactIncorrectStat(t *testing.T) {
 	count = 0
 	terminator = base.NewSafeTerminator()
 	go func() {
-		attachmentCount, err := attachmentCompactSweepPhase(ctx, testDb, "sweep", nil, false, terminator, stat)
+		attachmentCount, err := attachmentCompactSweepPhase(ctx, testDb, "sweep", nil, false, terminator, stat, nil)
 		atomic.StoreInt64(&count, attachmentCount)
 		require.NoError(t, err)
 	}()
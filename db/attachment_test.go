@@ -748,6 +748,25 @@ func TestStoreAttachments(t *testing.T) {
 	assert.NotEmpty(t, attachment["revpos"])
 	assert.True(t, attachment["stub"].(bool))
 
+	// Simulate error scenario for attachment data that doesn't match the sha1 digest sent with it.
+	revText = `{"key1": "value1", "_attachments": {"att1.txt": {"data": "YXR0MS50eHQ=", "digest": "sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA="}}}`
+	assert.NoError(t, base.JSONUnmarshal([]byte(revText), &revBody))
+	revId, doc, err = db.Put(ctx, "doc6", revBody)
+	assert.Empty(t, revId, "The revId should be empty since the attachment data doesn't match its digest")
+	assert.Empty(t, doc, "The doc should be empty since the attachment data doesn't match its digest")
+	assert.Error(t, err, "It should throw 400 doesn't match its digest error")
+	assert.Contains(t, err.Error(), "400 Data of attachment")
+
+	// Attachment data with a matching sha1 digest is stored.
+	revText = `{"key1": "value1", "_attachments": {"att1.txt": {"data": "YXR0MS50eHQ=", "digest": "sha1-crv3IVNxp3JXbP6bizTHt3GB3O0="}}}`
+	assert.NoError(t, base.JSONUnmarshal([]byte(revText), &revBody))
+	revId, doc, err = db.Put(ctx, "doc7", revBody)
+	assert.NoError(t, err, "Couldn't update document")
+	assert.NotEmpty(t, revId, "Document revision id should be generated")
+	require.NotNil(t, doc)
+	attachment = doc.Attachments["att1.txt"].(map[string]interface{})
+	assert.Equal(t, "sha1-crv3IVNxp3JXbP6bizTHt3GB3O0=", attachment["digest"])
+
 	// Simulate error scenario for attachment without data; stub is not provided; If the data is
 	// empty in attachment, the attachment must be a stub that repeats a parent attachment.
 	revText = `{"key1": "value1", "_attachments": {"att1.txt": {"revpos": 2}}}`
@@ -28,9 +28,39 @@ type AttachmentCompactionManager struct {
 	Phase             string
 	VBUUIDs           []uint64
 	dryRun            bool
+	phaseProgress     compactionPhaseProgress
 	lock              sync.Mutex
 }
 
+// attachmentCompactionPhases are the phases of attachment compaction, in the order they're run.
+var attachmentCompactionPhases = []string{MarkPhase, SweepPhase, CleanupPhase}
+
+// compactionPhaseProgress holds the DCP client for the running attachment compaction phase, so that progress through
+// the phase can be reported while it runs.
+type compactionPhaseProgress struct {
+	client *base.DCPClient
+	lock   sync.Mutex
+}
+
+func (p *compactionPhaseProgress) setClient(client *base.DCPClient) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.client = client
+}
+
+// percentComplete returns the percentage of the running phase's DCP feed that has been processed.
+func (p *compactionPhaseProgress) percentComplete() float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client == nil {
+		return 0
+	}
+	return p.client.Progress().PercentComplete
+}
+
 var _ BackgroundManagerProcessI = &AttachmentCompactionManager{}
 
 func NewAttachmentCompactionManager(bucket base.Bucket) *BackgroundManager {
@@ -117,7 +147,7 @@ func (a *AttachmentCompactionManager) Run(ctx context.Context, options map[strin
 	case "mark", "":
 		a.SetPhase("mark")
 		persistClusterStatus()
-		_, a.VBUUIDs, err = attachmentCompactMarkPhase(ctx, database, a.CompactID, terminator, &a.MarkedAttachments)
+		_, a.VBUUIDs, err = attachmentCompactMarkPhase(ctx, database, a.CompactID, terminator, &a.MarkedAttachments, &a.phaseProgress)
 		if err != nil || terminator.IsClosed() {
 			return err
 		}
@@ -125,7 +155,7 @@ func (a *AttachmentCompactionManager) Run(ctx context.Context, options map[strin
 	case "sweep":
 		a.SetPhase("sweep")
 		persistClusterStatus()
-		_, err := attachmentCompactSweepPhase(ctx, database, a.CompactID, a.VBUUIDs, a.dryRun, terminator, &a.PurgedAttachments)
+		_, err := attachmentCompactSweepPhase(ctx, database, a.CompactID, a.VBUUIDs, a.dryRun, terminator, &a.PurgedAttachments, &a.phaseProgress)
 		if err != nil || terminator.IsClosed() {
 			return err
 		}
@@ -133,7 +163,7 @@ func (a *AttachmentCompactionManager) Run(ctx context.Context, options map[strin
 	case "cleanup":
 		a.SetPhase("cleanup")
 		persistClusterStatus()
-		err := attachmentCompactCleanupPhase(ctx, database, a.CompactID, a.VBUUIDs, terminator)
+		err := attachmentCompactCleanupPhase(ctx, database, a.CompactID, a.VBUUIDs, terminator, &a.phaseProgress)
 		if err != nil || terminator.IsClosed() {
 			return err
 		}
@@ -148,15 +178,30 @@ func (a *AttachmentCompactionManager) SetPhase(phase string) {
 	defer a.lock.Unlock()
 
 	a.Phase = phase
+	a.phaseProgress.setClient(nil)
+}
+
+// percentComplete estimates overall progress, weighting each phase equally.  Must be called with a.lock held.
+func (a *AttachmentCompactionManager) percentComplete(state BackgroundProcessState) float64 {
+	if state == BackgroundProcessStateCompleted {
+		return 100
+	}
+	for i, phase := range attachmentCompactionPhases {
+		if a.Phase == phase {
+			return (float64(i)*100 + a.phaseProgress.percentComplete()) / float64(len(attachmentCompactionPhases))
+		}
+	}
+	return 0
 }
 
 type AttachmentManagerResponse struct {
 	BackgroundManagerStatus
-	MarkedAttachments int64  `json:"marked_attachments"`
-	PurgedAttachments int64  `json:"purged_attachments"`
-	CompactID         string `json:"compact_id"`
-	Phase             string `json:"phase,omitempty"`
-	DryRun            bool   `json:"dry_run,omitempty"`
+	MarkedAttachments int64   `json:"marked_attachments"`
+	PurgedAttachments int64   `json:"purged_attachments"`
+	PercentComplete   float64 `json:"percent_complete"`
+	CompactID         string  `json:"compact_id"`
+	Phase             string  `json:"phase,omitempty"`
+	DryRun            bool    `json:"dry_run,omitempty"`
 }
 
 type AttachmentManagerMeta struct {
@@ -176,6 +221,7 @@ func (a *AttachmentCompactionManager) GetProcessStatus(status BackgroundManagerS
 		BackgroundManagerStatus: status,
 		MarkedAttachments:       a.MarkedAttachments.Value(),
 		PurgedAttachments:       a.PurgedAttachments.Value(),
+		PercentComplete:         a.percentComplete(status.State),
 		CompactID:               a.CompactID,
 		Phase:                   a.Phase,
 		DryRun:                  a.dryRun,
@@ -0,0 +1,112 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"sort"
+	"sync"
+)
+
+// BlipReplicationStatus describes a client's BLIP replication connection to a database.
+type BlipReplicationStatus struct {
+	ContextID        string                           `json:"context_id"`              // BLIP context ID of the connection
+	User             string                           `json:"user,omitempty"`          // Name of the connected user, empty for admin and guest connections
+	ClientType       string                           `json:"client_type,omitempty"`   // Type of client, e.g. cbl2 or sgr2
+	SubChangesActive bool                             `json:"subchanges_active"`       // Whether the client has an active subChanges feed
+	Continuous       bool                             `json:"continuous,omitempty"`    // Whether the latest subChanges feed is continuous
+	Since            string                           `json:"since,omitempty"`         // Since sequence of the latest subChanges feed
+	LastSentSeq      string                           `json:"last_sent_seq,omitempty"` // Sequence of the last change sent to the client
+	BytesSent        int64                            `json:"bytes_sent"`              // Message body bytes sent to the client
+	BytesReceived    int64                            `json:"bytes_received"`          // Message body bytes received from the client
+	Compression      map[string]BlipCompressionStatus `json:"compression"`             // Compression of rev and changes messages, by message type
+}
+
+// activeBlipSyncContexts tracks the BlipSyncContexts of the client replication connections to a database.
+type activeBlipSyncContexts struct {
+	contexts map[*BlipSyncContext]struct{}
+	lock     sync.Mutex
+}
+
+// AddActiveBlipSyncContext registers a client replication connection, so that it's reported by ActiveBlipReplications
+// until it's removed with RemoveActiveBlipSyncContext.
+func (dbc *DatabaseContext) AddActiveBlipSyncContext(bsc *BlipSyncContext) {
+	dbc.activeBlipSyncContexts.lock.Lock()
+	defer dbc.activeBlipSyncContexts.lock.Unlock()
+	if dbc.activeBlipSyncContexts.contexts == nil {
+		dbc.activeBlipSyncContexts.contexts = make(map[*BlipSyncContext]struct{})
+	}
+	dbc.activeBlipSyncContexts.contexts[bsc] = struct{}{}
+}
+
+// RemoveActiveBlipSyncContext removes a client replication connection registered with AddActiveBlipSyncContext.
+func (dbc *DatabaseContext) RemoveActiveBlipSyncContext(bsc *BlipSyncContext) {
+	dbc.activeBlipSyncContexts.lock.Lock()
+	defer dbc.activeBlipSyncContexts.lock.Unlock()
+	delete(dbc.activeBlipSyncContexts.contexts, bsc)
+}
+
+// ActiveBlipReplications returns the status of each active client replication connection, ordered by context ID.
+func (dbc *DatabaseContext) ActiveBlipReplications() []BlipReplicationStatus {
+	dbc.activeBlipSyncContexts.lock.Lock()
+	statuses := make([]BlipReplicationStatus, 0, len(dbc.activeBlipSyncContexts.contexts))
+	for bsc := range dbc.activeBlipSyncContexts.contexts {
+		statuses = append(statuses, bsc.replicationStatus())
+	}
+	dbc.activeBlipSyncContexts.lock.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ContextID < statuses[j].ContextID
+	})
+	return statuses
+}
+
+// replicationStatus returns the current status of the connection.
+func (bsc *BlipSyncContext) replicationStatus() BlipReplicationStatus {
+	bsc.replicationStatusLock.Lock()
+	defer bsc.replicationStatusLock.Unlock()
+
+	status := BlipReplicationStatus{
+		ContextID:        bsc.blipContext.ID,
+		User:             bsc.userName,
+		ClientType:       string(bsc.clientType),
+		SubChangesActive: bsc.activeSubChanges.IsTrue(),
+		Continuous:       bsc.subChangesContinuous,
+		BytesSent:        bsc.bytesSent.Value(),
+		BytesReceived:    bsc.bytesReceived.Value(),
+		Compression: map[string]BlipCompressionStatus{
+			MessageRev:     bsc.revCompressionStats.status(),
+			MessageChanges: bsc.changesCompressionStats.status(),
+		},
+	}
+	if bsc.subChangesSince != nil {
+		status.Since = bsc.subChangesSince.String()
+	}
+	if bsc.lastSentSeq != nil {
+		status.LastSentSeq = bsc.lastSentSeq.String()
+	}
+	return status
+}
+
+// setSubChangesStatus records the parameters of a subChanges feed for the connection's status.
+func (bsc *BlipSyncContext) setSubChangesStatus(since SequenceID, continuous bool) {
+	bsc.replicationStatusLock.Lock()
+	defer bsc.replicationStatusLock.Unlock()
+	bsc.subChangesSince = &since
+	bsc.subChangesContinuous = continuous
+	bsc.lastSentSeq = nil
+}
+
+// setLastSentSeq records the sequence of the last change sent to the client for the connection's status.
+func (bsc *BlipSyncContext) setLastSentSeq(seq SequenceID) {
+	bsc.replicationStatusLock.Lock()
+	defer bsc.replicationStatusLock.Unlock()
+	bsc.lastSentSeq = &seq
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// ChangesAttachment summarises one of a revision's attachments in a changes row, sent when subChanges has
+// includeAttachments set, so that the client can decide which attachments to pull before requesting the revision.
+type ChangesAttachment struct {
+	Digest string `json:"digest"`
+	Length int64  `json:"length"`
+}
+
+// changesAttachments is the attachments field of a changes row, keyed by attachment name.
+type changesAttachments map[string]ChangesAttachment
+
+// newChangesAttachments returns the summary of the given attachments, or nil if there are none.
+func newChangesAttachments(attachments AttachmentsMeta) changesAttachments {
+	if len(attachments) == 0 {
+		return nil
+	}
+	summary := make(changesAttachments, len(attachments))
+	for name, value := range attachments {
+		meta, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		digest, _ := meta["digest"].(string)
+		length, _ := base.ToInt64(meta["length"])
+		summary[name] = ChangesAttachment{Digest: digest, Length: length}
+	}
+	return summary
+}
+
+// addChangesRowAttachments appends a summary of the revision's attachments to a changes row, if it has any.  The
+// deleted field is always included before the attachments, so that they're at a fixed position in the row.  If the
+// revision can't be retrieved the row is returned unchanged, and the client learns about the attachments from the
+// revision as usual.
+func (bh *blipHandler) addChangesRowAttachments(changesDb *Database, changeRow []interface{}, change *ChangeEntry, revID string) []interface{} {
+	if change.Deleted || change.Revoked || change.allRemoved {
+		return changeRow
+	}
+	rev, err := changesDb.GetRev(bh.loggingCtx, change.ID, revID, false, nil)
+	if err != nil {
+		base.DebugfCtx(bh.loggingCtx, base.KeySync, "Unable to get attachments for changes row %s/%s: %v", base.UD(change.ID), revID, err)
+		return changeRow
+	}
+	atts := newChangesAttachments(rev.Attachments)
+	if atts == nil {
+		return changeRow
+	}
+	if len(changeRow) == 3 {
+		if bh.blipContext.ActiveSubprotocol() == BlipCBMobileReplicationV3 {
+			changeRow = append(changeRow, changesDeletedFlag(0))
+		} else {
+			changeRow = append(changeRow, false)
+		}
+	}
+	return append(changeRow, atts)
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+const (
+	ChangesEncodingJSON   = "json"   // Changes are sent as a JSON array of [seq, docID, revID, deleted] arrays
+	ChangesEncodingBinary = "binary" // Changes are sent using the binary changes encoding, see encodeBinaryChanges
+)
+
+var errInvalidBinaryChanges = errors.New("invalid binary changes encoding")
+
+// encodeBinaryChanges encodes a batch of changes rows using the binary changes encoding.  The body is a uvarint count
+// of rows, followed by each row as a uvarint count of fields and then each field as a uvarint length-prefixed string.
+// Fields hold the same values as the JSON encoding, in their string form:
+//
+//	seq:     the sequence's string form (e.g. "12" or "10::12")
+//	docID:   the document ID
+//	revID:   the revision ID
+//	deleted: optional, the deleted flags as a decimal integer ("1"), or "true" for protocol version 2
+//	atts:    optional, JSON summary of the revision's attachments, only sent when requested by subChanges
+//
+// An empty body has no rows, and is sent when the client has caught up.
+func encodeBinaryChanges(changeArray [][]interface{}) []byte {
+	if len(changeArray) == 0 {
+		return []byte{}
+	}
+	body := make([]byte, 0, 64*len(changeArray))
+	body = appendUvarint(body, uint64(len(changeArray)))
+	for _, row := range changeArray {
+		body = appendUvarint(body, uint64(len(row)))
+		for _, field := range row {
+			value := binaryChangesField(field)
+			body = appendUvarint(body, uint64(len(value)))
+			body = append(body, value...)
+		}
+	}
+	return body
+}
+
+// binaryChangesField returns the string form of a changes row field.
+func binaryChangesField(field interface{}) string {
+	switch val := field.(type) {
+	case string:
+		return val
+	case SequenceID:
+		return val.String()
+	case changesDeletedFlag:
+		return strconv.FormatUint(uint64(val), 10)
+	case bool:
+		return strconv.FormatBool(val)
+	case changesAttachments:
+		atts, _ := base.JSONMarshal(val)
+		return string(atts)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// DecodeBinaryChanges decodes a changes message body that uses the binary changes encoding into rows of string fields.
+func DecodeBinaryChanges(body []byte) ([][]string, error) {
+	if len(body) == 0 {
+		return [][]string{}, nil
+	}
+	numRows, body, err := readBinaryChangesUvarint(body)
+	if err != nil {
+		return nil, err
+	}
+	// Each row is at least one byte, so a count larger than the body is invalid
+	if numRows > uint64(len(body)) {
+		return nil, errInvalidBinaryChanges
+	}
+	rows := make([][]string, 0, numRows)
+	for i := uint64(0); i < numRows; i++ {
+		var numFields uint64
+		if numFields, body, err = readBinaryChangesUvarint(body); err != nil {
+			return nil, err
+		}
+		if numFields > uint64(len(body)) {
+			return nil, errInvalidBinaryChanges
+		}
+		row := make([]string, 0, numFields)
+		for j := uint64(0); j < numFields; j++ {
+			var fieldLen uint64
+			if fieldLen, body, err = readBinaryChangesUvarint(body); err != nil {
+				return nil, err
+			}
+			if fieldLen > uint64(len(body)) {
+				return nil, errInvalidBinaryChanges
+			}
+			row = append(row, string(body[:fieldLen]))
+			body = body[fieldLen:]
+		}
+		rows = append(rows, row)
+	}
+	if len(body) > 0 {
+		return nil, errInvalidBinaryChanges
+	}
+	return rows, nil
+}
+
+// splitChangesBatch splits a batch of changes rows into consecutive batches whose encoded bodies are each no larger than
+// maxBytes, so that a large batch isn't held in a single message.  A row that's larger than maxBytes on its own is sent
+// in a batch by itself.  The batch isn't split when maxBytes is zero.
+func splitChangesBatch(changeArray [][]interface{}, maxBytes int, binaryEncoding bool) [][][]interface{} {
+	if maxBytes <= 0 || len(changeArray) == 0 {
+		return [][][]interface{}{changeArray}
+	}
+	var batches [][][]interface{}
+	batchStart := 0
+	batchSize := changesBodyOverhead
+	for i, row := range changeArray {
+		rowSize := encodedChangesRowSize(row, binaryEncoding)
+		if i > batchStart && batchSize+rowSize > maxBytes {
+			batches = append(batches, changeArray[batchStart:i])
+			batchStart = i
+			batchSize = changesBodyOverhead
+		}
+		batchSize += rowSize
+	}
+	return append(batches, changeArray[batchStart:])
+}
+
+// changesBodyOverhead is an upper bound on the bytes in a changes message body outside of its rows: the enclosing
+// brackets of the JSON encoding, or the row count of the binary encoding.
+const changesBodyOverhead = binary.MaxVarintLen64
+
+// encodedChangesRowSize returns the number of bytes a changes row adds to a changes message body.
+func encodedChangesRowSize(row []interface{}, binaryEncoding bool) int {
+	if binaryEncoding {
+		// A single row is encoded with a one byte row count
+		return len(encodeBinaryChanges([][]interface{}{row})) - 1
+	}
+	encodedRow, err := base.JSONMarshal(row)
+	if err != nil {
+		return 0
+	}
+	// Rows are separated by commas
+	return len(encodedRow) + 1
+}
+
+func appendUvarint(body []byte, val uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], val)
+	return append(body, buf[:n]...)
+}
+
+func readBinaryChangesUvarint(body []byte) (uint64, []byte, error) {
+	val, n := binary.Uvarint(body)
+	if n <= 0 {
+		return 0, nil, errInvalidBinaryChanges
+	}
+	return val, body[n:], nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryChangesEncodingRoundTrip(t *testing.T) {
+	changeArray := [][]interface{}{
+		{SequenceID{Seq: 12}, "doc1", "1-abc"},
+		{SequenceID{Seq: 14, LowSeq: 10}, "doc2", "2-def", changesDeletedFlagDeleted | changesDeletedFlagRemoved},
+		{SequenceID{Seq: 15, TriggeredBy: 13}, "", "3-ghi", true},
+		{SequenceID{Seq: 16}, "doc3", "1-jkl", changesDeletedFlag(0), changesAttachments{"a.txt": {Digest: "sha1-abc", Length: 3}}},
+	}
+
+	rows, err := DecodeBinaryChanges(encodeBinaryChanges(changeArray))
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"12", "doc1", "1-abc"},
+		{"10::14", "doc2", "2-def", "5"},
+		{"13:15", "", "3-ghi", "true"},
+		{"16", "doc3", "1-jkl", "0", `{"a.txt":{"digest":"sha1-abc","length":3}}`},
+	}, rows)
+
+	rows, err = DecodeBinaryChanges(encodeBinaryChanges(nil))
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func TestDecodeInvalidBinaryChanges(t *testing.T) {
+	valid := encodeBinaryChanges([][]interface{}{{SequenceID{Seq: 1}, "doc1", "1-abc"}})
+
+	testCases := map[string][]byte{
+		"truncated":      valid[:len(valid)-1],
+		"trailing bytes": append(append([]byte{}, valid...), 0x00),
+		"row count":      {0xff, 0x01},
+		"bad uvarint":    {0x80},
+	}
+	for name, body := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := DecodeBinaryChanges(body)
+			assert.ErrorIs(t, err, errInvalidBinaryChanges)
+		})
+	}
+}
+
+func TestSplitChangesBatch(t *testing.T) {
+	changeArray := make([][]interface{}, 0, 10)
+	for i := 1; i <= 10; i++ {
+		changeArray = append(changeArray, []interface{}{SequenceID{Seq: uint64(i)}, "doc", "1-abc"})
+	}
+
+	// Not split without a limit
+	assert.Equal(t, [][][]interface{}{changeArray}, splitChangesBatch(changeArray, 0, false))
+
+	for _, binaryEncoding := range []bool{false, true} {
+		rowSize := encodedChangesRowSize(changeArray[0], binaryEncoding)
+		maxBytes := changesBodyOverhead + 3*rowSize
+		batches := splitChangesBatch(changeArray, maxBytes, binaryEncoding)
+		require.Len(t, batches, 4, "binaryEncoding=%t", binaryEncoding)
+		var rejoined [][]interface{}
+		for _, batch := range batches {
+			var body []byte
+			if binaryEncoding {
+				body = encodeBinaryChanges(batch)
+			} else {
+				var err error
+				body, err = base.JSONMarshal(batch)
+				require.NoError(t, err)
+			}
+			assert.LessOrEqual(t, len(body), maxBytes, "binaryEncoding=%t", binaryEncoding)
+			rejoined = append(rejoined, batch...)
+		}
+		assert.Equal(t, changeArray, rejoined)
+	}
+
+	// A row larger than the limit is sent on its own
+	batches := splitChangesBatch(changeArray[:2], 1, false)
+	assert.Equal(t, [][][]interface{}{changeArray[:1], changeArray[1:2]}, batches)
+}
+
+func TestNewChangesAttachments(t *testing.T) {
+	assert.Nil(t, newChangesAttachments(nil))
+	assert.Nil(t, newChangesAttachments(AttachmentsMeta{}))
+
+	atts := newChangesAttachments(AttachmentsMeta{
+		"a.txt": map[string]interface{}{"digest": "sha1-abc", "length": 3, "revpos": 1, "stub": true},
+		"b.bin": map[string]interface{}{"digest": "sha1-def", "length": float64(2048), "revpos": 2, "stub": true},
+	})
+	assert.Equal(t, changesAttachments{
+		"a.txt": {Digest: "sha1-abc", Length: 3},
+		"b.bin": {Digest: "sha1-def", Length: 2048},
+	}, atts)
+}
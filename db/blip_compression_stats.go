@@ -0,0 +1,102 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"compress/flate"
+	"sync"
+
+	"github.com/couchbase/go-blip"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// BlipCompressionStatus describes how effective compression has been for one type of message on a connection.
+type BlipCompressionStatus struct {
+	Messages           int64 `json:"messages"`            // Number of messages sent and received
+	CompressedMessages int64 `json:"compressed_messages"` // Number of those messages that were compressed in transit
+	Bytes              int64 `json:"bytes"`               // Message body bytes before compression
+	CompressedBytes    int64 `json:"compressed_bytes"`    // Estimated message body bytes after compression
+}
+
+// blipCompressionStats tracks pre- and post-compression body sizes for one type of message.
+type blipCompressionStats struct {
+	messages           base.AtomicInt
+	compressedMessages base.AtomicInt
+	bytes              base.AtomicInt
+	compressedBytes    base.AtomicInt
+}
+
+func (s *blipCompressionStats) status() BlipCompressionStatus {
+	return BlipCompressionStatus{
+		Messages:           s.messages.Value(),
+		CompressedMessages: s.compressedMessages.Value(),
+		Bytes:              s.bytes.Value(),
+		CompressedBytes:    s.compressedBytes.Value(),
+	}
+}
+
+// recordMessage adds a message body to the stats.  go-blip doesn't expose the size of message bodies on the wire, so
+// the compressed size is estimated by deflating the body at the same compression level.  This is an upper bound, as
+// go-blip's deflate stream is shared by all messages on the connection.
+func (s *blipCompressionStats) recordMessage(body []byte, compressed bool) {
+	s.messages.Add(1)
+	s.bytes.Add(int64(len(body)))
+	if !compressed {
+		s.compressedBytes.Add(int64(len(body)))
+		return
+	}
+	s.compressedMessages.Add(1)
+	s.compressedBytes.Add(deflatedSize(body))
+}
+
+// recordCompressionStats records a sent or received message's body in the connection's compression stats, for the
+// message types that are compressed.
+func (bsc *BlipSyncContext) recordCompressionStats(msg *blip.Message, body []byte) {
+	switch msg.Profile() {
+	case MessageRev:
+		bsc.revCompressionStats.recordMessage(body, msg.Compressed())
+	case MessageChanges:
+		bsc.changesCompressionStats.recordMessage(body, msg.Compressed())
+	}
+}
+
+// byteCounter is an io.Writer that discards its input, counting the number of bytes written.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+var deflaterPool sync.Pool
+
+// deflatedSize returns the size of body once deflated at go-blip's compression level.
+func deflatedSize(body []byte) int64 {
+	var size byteCounter
+	z, ok := deflaterPool.Get().(*flate.Writer)
+	if ok {
+		z.Reset(&size)
+	} else {
+		var err error
+		if z, err = flate.NewWriter(&size, blip.CompressionLevel); err != nil {
+			return int64(len(body))
+		}
+	}
+	defer deflaterPool.Put(z)
+
+	if _, err := z.Write(body); err != nil {
+		return int64(len(body))
+	}
+	if err := z.Close(); err != nil {
+		return int64(len(body))
+	}
+	return int64(size)
+}
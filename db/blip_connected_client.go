@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -59,13 +60,52 @@ func (bh *blipHandler) handleGetRev(rq *blip.Message) error {
 	}
 
 	response := rq.Response()
-	response.SetCompressed(true)
 	response.Properties[GetRevRevId] = rev.RevID
 	response.SetBody(bodyBytes)
+	bh.setCompressed(response, true)
 	bh.replicationStats.HandleGetRevCount.Add(1)
 	return nil
 }
 
+//////// GETREVS:
+
+// Handles a Connected-Client "getRevs" request, whose body is a JSON array of [docID, revID] pairs.  Each revision is
+// sent to the client as a "rev" message, or a "norev" message if it's missing or can't be sent, in the order requested.
+// The request is responded to once all of the revisions have been sent.
+func (bh *blipHandler) handleGetRevs(rq *blip.Message) error {
+	var docRevs [][]string
+	if err := rq.ReadJSONBody(&docRevs); err != nil {
+		return base.HTTPErrorf(http.StatusBadRequest, "Invalid %s body: %v", MessageGetRevs, err)
+	}
+	for _, docRev := range docRevs {
+		if len(docRev) != 2 || docRev[0] == "" || docRev[1] == "" {
+			return base.HTTPErrorf(http.StatusBadRequest, "%s entries must be [docID, revID] pairs", MessageGetRevs)
+		}
+	}
+
+	bh.logEndpointEntry(rq.Profile(), fmt.Sprintf("revs: %d", len(docRevs)))
+
+	for _, docRev := range docRevs {
+		docID, revID := docRev[0], docRev[1]
+		err := bh.sendRevision(rq.Sender, docID, revID, SequenceID{}, map[string]bool{}, 0, bh.collection)
+		if err == ErrClosedBLIPSender {
+			return err
+		} else if err != nil {
+			// Unwrap the error so that the norev reports its status, rather than a generic server error
+			var httpErr *base.HTTPError
+			if errors.As(err, &httpErr) {
+				err = httpErr
+			}
+			base.InfofCtx(bh.loggingCtx, base.KeySync, "Unable to send rev %s/%s for %s: %v", base.UD(docID), revID, MessageGetRevs, err)
+			if err := bh.sendNoRev(rq.Sender, docID, revID, bh.collectionIdx, SequenceID{}, err); err != nil {
+				return err
+			}
+		}
+		bh.replicationStats.HandleGetRevCount.Add(1)
+	}
+	return nil
+}
+
 //////// PUTREV:
 
 // Handles a Connected-Client "putRev" request.
@@ -98,8 +138,8 @@ func (bh *blipHandler) handleFunction(rq *blip.Message) error {
 
 		// Write the result to the response:
 		response := rq.Response()
-		response.SetCompressed(true)
 		_ = response.SetJSONBody(results)
+		bh.setCompressed(response, true)
 		return nil
 	})
 }
@@ -137,8 +177,8 @@ func (bh *blipHandler) handleQuery(rq *blip.Message) error {
 			return err
 		}
 		response := rq.Response()
-		response.SetCompressed(true)
 		response.SetJSONBodyAsBytes(out.Bytes())
+		bh.setCompressed(response, true)
 		return nil
 	})
 }
@@ -173,8 +213,8 @@ func (bh *blipHandler) handleGraphQL(rq *blip.Message) error {
 			return err
 		}
 		response := rq.Response()
-		response.SetCompressed(true)
 		_ = response.SetJSONBody(result)
+		bh.setCompressed(response, true)
 		return nil
 	})
 }
@@ -12,13 +12,16 @@ package db
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -41,9 +44,17 @@ var handlersByProfile = map[string]blipHandlerFunc{
 	MessageProveAttachment: userBlipHandler(collectionBlipHandler((*blipHandler).handleProveAttachment)),
 	MessageProposeChanges:  collectionBlipHandler((*blipHandler).handleProposeChanges),
 	MessageGetRev:          userBlipHandler(collectionBlipHandler((*blipHandler).handleGetRev)),
+	MessageGetRevs:         userBlipHandler(collectionBlipHandler((*blipHandler).handleGetRevs)),
 	MessagePutRev:          userBlipHandler(collectionBlipHandler((*blipHandler).handlePutRev)),
 
-	MessageGetCollections: userBlipHandler((*blipHandler).handleGetCollections),
+	MessageBulkDelCheckpoint:     collectionBlipHandler((*blipHandler).handleBulkDelCheckpoint),
+	MessageGetActiveReplications: (*blipHandler).handleGetActiveReplications,
+	MessageGetDocChannels:        userBlipHandler(collectionBlipHandler((*blipHandler).handleGetDocChannels)),
+	MessageGetRevTree:            collectionBlipHandler((*blipHandler).handleGetRevTree),
+	MessageGetServerSequence:     userBlipHandler(collectionBlipHandler((*blipHandler).handleGetServerSequence)),
+
+	MessageGetCollections:  userBlipHandler((*blipHandler).handleGetCollections),
+	MessageListCollections: userBlipHandler((*blipHandler).handleListCollections),
 }
 
 var kConnectedClientHandlersByProfile = map[string]blipHandlerFunc{
@@ -55,6 +66,9 @@ var kConnectedClientHandlersByProfile = map[string]blipHandlerFunc{
 // maxInFlightChangesBatches is the maximum number of in-flight changes batches a client is allowed to send without being throttled.
 const maxInFlightChangesBatches = 2
 
+// unsubChangesTimeout is how long unsubChanges waits for an active changes feed to stop before giving up.
+const unsubChangesTimeout = 30 * time.Second
+
 type blipHandler struct {
 	*BlipSyncContext
 	db            *Database // Handler-specific copy of the BlipSyncContext's blipContextDb
@@ -183,7 +197,10 @@ func (bh *blipHandler) handleGetCheckpoint(rq *blip.Message) error {
 	if value == nil {
 		return base.HTTPErrorf(http.StatusNotFound, http.StatusText(http.StatusNotFound))
 	}
-	response.Properties[GetCheckpointResponseRev] = value[BodyRev].(string)
+	// The rev is used by the client as a CAS token when it next sets the checkpoint
+	if rev, ok := value[BodyRev].(string); ok {
+		response.Properties[GetCheckpointResponseRev] = rev
+	}
 	delete(value, BodyRev)
 	delete(value, BodyId)
 	// TODO: Marshaling here when we could use raw bytes all the way from the bucket
@@ -191,7 +208,9 @@ func (bh *blipHandler) handleGetCheckpoint(rq *blip.Message) error {
 	return nil
 }
 
-// Received a "setCheckpoint" request
+// Received a "setCheckpoint" request.  The rev property must match the rev of the stored checkpoint, if there is one,
+// otherwise the request is rejected with a 409 so that clients sharing a client ID can't overwrite each other's
+// checkpoints.
 func (bh *blipHandler) handleSetCheckpoint(rq *blip.Message) error {
 
 	checkpointMessage := SetCheckpointMessage{rq}
@@ -215,6 +234,174 @@ func (bh *blipHandler) handleSetCheckpoint(rq *blip.Message) error {
 	return nil
 }
 
+// Received a "getActiveReplications" request.  Responds with the status of each client replication connection to the
+// database, as a JSON array of BlipReplicationStatus.
+func (bh *blipHandler) handleGetActiveReplications(rq *blip.Message) error {
+
+	if bh.db.User() != nil {
+		return base.HTTPErrorf(http.StatusForbidden, "%s is only permitted for admin connections", MessageGetActiveReplications)
+	}
+	bh.logEndpointEntry(rq.Profile(), "")
+
+	response := rq.Response()
+	if response == nil {
+		return nil
+	}
+	return response.SetJSONBody(bh.db.DatabaseContext.ActiveBlipReplications())
+}
+
+// Received a "bulkDelCheckpoint" request.  Removes the checkpoints for the given clients and/or all clients with IDs
+// matching a prefix, forcing those clients to replicate from zero on their next connection.
+func (bh *blipHandler) handleBulkDelCheckpoint(rq *blip.Message) error {
+
+	if bh.db.User() != nil {
+		return base.HTTPErrorf(http.StatusForbidden, "%s is only permitted for admin connections", MessageBulkDelCheckpoint)
+	}
+
+	var requestBody BulkDelCheckpointRequestBody
+	if err := rq.ReadJSONBody(&requestBody); err != nil {
+		return base.HTTPErrorf(http.StatusBadRequest, "Unable to parse %s request body: %v", MessageBulkDelCheckpoint, err)
+	}
+	bh.logEndpointEntry(rq.Profile(), requestBody.String())
+
+	if len(requestBody.Clients) == 0 && requestBody.Prefix == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "%s requires clients or prefix", MessageBulkDelCheckpoint)
+	}
+
+	checkpointIDs := make(map[string]struct{}, len(requestBody.Clients))
+	for _, client := range requestBody.Clients {
+		checkpointIDs[CheckpointDocIDPrefix+client] = struct{}{}
+	}
+
+	if requestBody.Prefix != "" {
+		results, err := bh.collection.QueryLocalCheckpoints(bh.loggingCtx, requestBody.Prefix)
+		if err != nil {
+			return err
+		}
+		var row QueryIdRow
+		for results.Next(&row) {
+			checkpointIDs[strings.TrimPrefix(row.Id, RealSpecialDocID(DocTypeLocal, ""))] = struct{}{}
+		}
+		if err := results.Close(); err != nil {
+			return err
+		}
+	}
+
+	deleted := 0
+	for checkpointID := range checkpointIDs {
+		checkpoint, err := bh.collection.GetSpecial(DocTypeLocal, checkpointID)
+		if base.IsDocNotFoundError(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		revID, _ := checkpoint[BodyRev].(string)
+		err = bh.collection.DeleteSpecial(DocTypeLocal, checkpointID, revID)
+		if base.IsDocNotFoundError(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		deleted++
+	}
+	base.InfofCtx(bh.loggingCtx, base.KeySyncMsg, "Deleted %d checkpoints for %s", deleted, base.UD(requestBody.String()))
+
+	response := rq.Response()
+	if response == nil {
+		return nil
+	}
+	return response.SetJSONBody(BulkDelCheckpointResponseBody{Deleted: deleted})
+}
+
+// Received a "getDocChannels" request.  Admin connections are sent all of the channels the document is currently in,
+// users only the channels they have access to.
+func (bh *blipHandler) handleGetDocChannels(rq *blip.Message) error {
+
+	docID := rq.Properties[GetDocChannelsID]
+	bh.logEndpointEntry(rq.Profile(), fmt.Sprintf("docID: %s", base.UD(docID)))
+	if docID == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "%s requires %s", MessageGetDocChannels, GetDocChannelsID)
+	}
+
+	syncData, err := bh.collection.GetDocSyncData(bh.loggingCtx, docID)
+	if err != nil {
+		return err
+	}
+
+	user := bh.db.User()
+	docChannels := make([]string, 0, len(syncData.Channels))
+	for channel, removal := range syncData.Channels {
+		if removal != nil {
+			// The document has been removed from this channel
+			continue
+		}
+		if user != nil && !user.CanSeeChannel(channel) {
+			continue
+		}
+		docChannels = append(docChannels, channel)
+	}
+
+	// Don't reveal a document to a user that can't see any of its channels
+	if user != nil && len(docChannels) == 0 {
+		return base.HTTPErrorf(http.StatusForbidden, "forbidden")
+	}
+	sort.Strings(docChannels)
+
+	response := rq.Response()
+	if response == nil {
+		return nil
+	}
+	return response.SetJSONBody(GetDocChannelsResponseBody{Channels: docChannels})
+}
+
+// Received a "getRevTree" request.  Responds with the document's full revision tree, in the same form as the history
+// returned by the _raw REST endpoint, for debugging conflicts.
+func (bh *blipHandler) handleGetRevTree(rq *blip.Message) error {
+
+	if bh.db.User() != nil {
+		return base.HTTPErrorf(http.StatusForbidden, "%s is only permitted for admin connections", MessageGetRevTree)
+	}
+
+	docID := rq.Properties[GetRevTreeID]
+	bh.logEndpointEntry(rq.Profile(), fmt.Sprintf("docID: %s", base.UD(docID)))
+	if docID == "" {
+		return base.HTTPErrorf(http.StatusBadRequest, "%s requires %s", MessageGetRevTree, GetRevTreeID)
+	}
+
+	syncData, err := bh.collection.GetDocSyncData(bh.loggingCtx, docID)
+	if err != nil {
+		return err
+	}
+
+	response := rq.Response()
+	if response == nil {
+		return nil
+	}
+	return response.SetJSONBody(GetRevTreeResponseBody{CurrentRev: syncData.CurrentRev, History: syncData.History})
+}
+
+// Received a "getServerSequence" request.  Lets clients determine how far behind they are without subscribing to
+// changes.  For admin connections the user sequence is the database's sequence.
+func (bh *blipHandler) handleGetServerSequence(rq *blip.Message) error {
+
+	bh.logEndpointEntry(rq.Profile(), "")
+
+	sequence, err := bh.collection.LastSequence()
+	if err != nil {
+		return err
+	}
+	userSequence, err := bh.collection.LastSequenceForUser(bh.loggingCtx)
+	if err != nil {
+		return err
+	}
+
+	response := rq.Response()
+	if response == nil {
+		return nil
+	}
+	return response.SetJSONBody(GetServerSequenceResponseBody{Sequence: sequence, UserSequence: userSequence})
+}
+
 // ////// CHANGES
 
 // Received a "subChanges" subscription request
@@ -246,6 +433,22 @@ func (bh *blipHandler) handleSubChanges(rq *blip.Message) error {
 		return base.HTTPErrorf(http.StatusBadRequest, "DocIDs filter not supported for continuous subChanges")
 	}
 
+	// Changes for multiple collections may be requested in the body, rather than for a single collection via the
+	// collection property
+	var collectionIdxs []int
+	if collectionNames := subChangesParams.bodyCollections(); len(collectionNames) > 0 {
+		if bh.collectionIdx == nil {
+			collectionIdxs, err = bh.subChangesCollections(collectionNames)
+		} else {
+			err = base.HTTPErrorf(http.StatusBadRequest, "Collections in the %s body can't be combined with the %s property", MessageSubChanges, BlipCollection)
+		}
+		if err != nil {
+			// No subscription is started, so allow the client to retry with a different set of collections
+			bh.activeSubChanges.Set(false)
+			return err
+		}
+	}
+
 	bh.logEndpointEntry(rq.Profile(), subChangesParams.String())
 
 	var channels base.Set
@@ -259,19 +462,60 @@ func (bh *blipHandler) handleSubChanges(rq *blip.Message) error {
 			return base.HTTPErrorf(http.StatusBadRequest, "Empty channel list")
 
 		}
+
+		// Channels requested in the body must all be visible to the user
+		if user := bh.db.User(); user != nil && len(subChangesParams.bodyChannels()) > 0 {
+			if err := user.AuthorizeAllChannels(channels); err != nil {
+				// No subscription is started, so allow the client to retry with a different set of channels
+				bh.activeSubChanges.Set(false)
+				return err
+			}
+		}
 	} else if filter != "" {
 		return base.HTTPErrorf(http.StatusBadRequest, "Unknown filter; try sync_gateway/bychannel")
 	}
 
+	revFields, err := subChangesParams.fields()
+	if err != nil {
+		bh.activeSubChanges.Set(false)
+		return base.HTTPErrorf(http.StatusBadRequest, "Invalid %s property: %v", SubChangesFields, err)
+	}
+	bh.setRevFields(revFields)
+
 	clientType := clientTypeCBL2
 	if rq.Properties["client_sgr2"] == trueProperty {
 		clientType = clientTypeSGR2
 	}
 
 	continuous := subChangesParams.continuous()
+	batchSize := subChangesParams.batchSize()
+	binaryEncoding := subChangesParams.binaryEncoding()
+
+	// Let the client know what batch size and encoding are actually being used, as the requested size may have been
+	// clamped, and the requested encoding may not be supported
+	if response := rq.Response(); response != nil {
+		response.Properties[SubChangesResponseBatch] = strconv.Itoa(batchSize)
+		if binaryEncoding {
+			response.Properties[SubChangesResponseEncoding] = ChangesEncodingBinary
+		} else {
+			response.Properties[SubChangesResponseEncoding] = ChangesEncodingJSON
+		}
+	}
+
+	bh.setSubChangesStatus(subChangesParams.Since(), continuous)
+
+	// Continuous connections may sit idle for long periods, so check they're still alive
+	if continuous {
+		bh.startKeepalive(rq.Sender)
+	}
 
 	// Start asynchronous changes goroutine
+	subChangesDone := make(chan struct{})
+	bh.subChangesDone = subChangesDone
 	go func() {
+		// Deferred first so that the feed's stats and state have been released by the time unsubChanges is notified
+		defer close(subChangesDone)
+
 		// Pull replication stats by type
 		if continuous {
 			bh.replicationStats.SubChangesContinuousActive.Add(1)
@@ -289,29 +533,49 @@ func (bh *blipHandler) handleSubChanges(rq *blip.Message) error {
 		}()
 		// sendChanges runs until blip context closes, or fails due to error
 		startTime := time.Now()
-		_ = bh.sendChanges(rq.Sender, &sendChangesOptions{
-			docIDs:            subChangesParams.docIDs(),
-			since:             subChangesParams.Since(),
-			continuous:        continuous,
-			activeOnly:        subChangesParams.activeOnly(),
-			batchSize:         subChangesParams.batchSize(),
-			channels:          channels,
-			revocations:       subChangesParams.revocations(),
-			clientType:        clientType,
-			ignoreNoConflicts: clientType == clientTypeSGR2, // force this side to accept a "changes" message, even in no conflicts mode for SGR2.
-		})
+		opts := &sendChangesOptions{
+			docIDs:             subChangesParams.docIDs(),
+			since:              subChangesParams.Since(),
+			continuous:         continuous,
+			activeOnly:         subChangesParams.activeOnly(),
+			batchSize:          batchSize,
+			batchTimeout:       subChangesParams.batchTimeout(),
+			channels:           channels,
+			revocations:        subChangesParams.revocations(),
+			clientType:         clientType,
+			ignoreNoConflicts:  clientType == clientTypeSGR2, // force this side to accept a "changes" message, even in no conflicts mode for SGR2.
+			binaryEncoding:     binaryEncoding,
+			creationsOnly:      subChangesParams.creationsOnly(),
+			winningRevOnly:     subChangesParams.winningRevOnly(),
+			includeAttachments: subChangesParams.includeAttachments(),
+		}
+		if len(collectionIdxs) > 0 {
+			bh.sendCollectionsChanges(rq.Sender, collectionIdxs, opts)
+		} else {
+			_ = bh.sendChanges(rq.Sender, opts)
+		}
 		base.DebugfCtx(bh.loggingCtx, base.KeySyncMsg, "#%d: Type:%s   --> Time:%v", bh.serialNumber, rq.Profile(), time.Since(startTime))
 	}()
 
 	return nil
 }
 
+// handleUnsubChanges stops the changes feed started by subChanges, if there is one.  The response is sent once the feed
+// has stopped and released its resources, so that the client can subscribe again straight away.
 func (bh *blipHandler) handleUnsubChanges(rq *blip.Message) error {
 	bh.changesCtxLock.Lock()
 	defer bh.changesCtxLock.Unlock()
 
 	bh.changesCtxCancel()
-	return nil
+	if bh.subChangesDone == nil {
+		return nil
+	}
+	select {
+	case <-bh.subChangesDone:
+		return nil
+	case <-time.After(unsubChangesTimeout):
+		return base.HTTPErrorf(http.StatusServiceUnavailable, "Timed out waiting for the changes feed to stop")
+	}
 }
 
 type clientType uint8
@@ -322,15 +586,20 @@ const (
 )
 
 type sendChangesOptions struct {
-	docIDs            []string
-	since             SequenceID
-	continuous        bool
-	activeOnly        bool
-	batchSize         int
-	channels          base.Set
-	clientType        clientType
-	revocations       bool
-	ignoreNoConflicts bool
+	docIDs             []string
+	since              SequenceID
+	continuous         bool
+	activeOnly         bool
+	batchSize          int
+	batchTimeout       time.Duration // If non-zero, a partial batch is sent once it's been pending for this long
+	channels           base.Set
+	clientType         clientType
+	revocations        bool
+	ignoreNoConflicts  bool
+	binaryEncoding     bool // Send changes using the binary changes encoding
+	creationsOnly      bool // Only send changes for the first revision of new documents
+	winningRevOnly     bool // Only send changes that change a document's winning revision
+	includeAttachments bool // Include a summary of each revision's attachments in its changes row
 }
 
 type changesDeletedFlag uint
@@ -373,27 +642,88 @@ func (bh *blipHandler) sendChanges(sender *blip.Sender, opts *sendChangesOptions
 		channelSet = base.SetOf(channels.AllChannelWildcard)
 	}
 
+	// When only winning revision changes are wanted, track the revision last sent for each document, so that
+	// entries for writes to a losing branch (which leave the winning revision unchanged) can be suppressed.
+	var sentWinningRevs map[string]string
+	if opts.winningRevOnly {
+		sentWinningRevs = make(map[string]string)
+	}
+
 	caughtUp := false
+	// pendingLock is held while handling changes from the feed and while flushing on batch timeout, so that pending
+	// changes are only ever sent by one of them at a time.
+	var pendingLock sync.Mutex
+	var flushTimer *time.Timer
+	var flushErr error
 	pendingChanges := make([][]interface{}, 0, opts.batchSize)
 	sendPendingChangesAt := func(minChanges int) error {
 		if len(pendingChanges) >= minChanges {
-			if err := bh.sendBatchOfChanges(sender, pendingChanges, opts.ignoreNoConflicts); err != nil {
-				return err
+			if flushTimer != nil {
+				flushTimer.Stop()
+				flushTimer = nil
+			}
+			// Very large batches are sent as several messages, each acknowledged separately
+			for _, batch := range splitChangesBatch(pendingChanges, bh.db.Options.MaxChangesMessageSize, opts.binaryEncoding) {
+				if err := bh.sendBatchOfChanges(sender, batch, opts.ignoreNoConflicts, opts.binaryEncoding); err != nil {
+					return err
+				}
 			}
 			pendingChanges = make([][]interface{}, 0, opts.batchSize)
 		}
 		return nil
 	}
+	// startFlushTimer ensures a partial batch is sent within the batch timeout, rather than waiting for the batch to
+	// fill.  Requires pendingLock to be held.
+	startFlushTimer := func() {
+		if opts.batchTimeout <= 0 || flushTimer != nil || len(pendingChanges) == 0 {
+			return
+		}
+		var timer *time.Timer
+		timer = time.AfterFunc(opts.batchTimeout, func() {
+			pendingLock.Lock()
+			defer pendingLock.Unlock()
+			// Ignore the timer if the batch it was started for has already been sent
+			if flushTimer != timer || flushErr != nil {
+				return
+			}
+			flushTimer = nil
+			if err := sendPendingChangesAt(1); err != nil {
+				base.DebugfCtx(bh.loggingCtx, base.KeySync, "Error sending changes on batch timeout: %v", err)
+				flushErr = err
+			}
+		})
+		flushTimer = timer
+	}
 
 	// Create a distinct database instance for changes, to avoid races between reloadUser invocation in changes.go
 	// and BlipSyncContext user access.
 	changesDb := bh.copyContextDatabase()
 	_, forceClose := generateBlipSyncChanges(bh.loggingCtx, changesDb, channelSet, options, opts.docIDs, func(changes []*ChangeEntry) error {
+		pendingLock.Lock()
+		defer pendingLock.Unlock()
+		if flushErr != nil {
+			return flushErr
+		}
+
 		base.DebugfCtx(bh.loggingCtx, base.KeySync, "    Sending %d changes", len(changes))
 		for _, change := range changes {
 			if !strings.HasPrefix(change.ID, "_") {
 				for _, item := range change.Changes {
+					if opts.creationsOnly && !isCreationChange(change, item["rev"]) {
+						continue
+					}
+
+					if sentWinningRevs != nil {
+						if change.hidden && sentWinningRevs[change.ID] == item["rev"] {
+							continue
+						}
+						sentWinningRevs[change.ID] = item["rev"]
+					}
+
 					changeRow := bh.buildChangesRow(change, item["rev"])
+					if opts.includeAttachments {
+						changeRow = bh.addChangesRowAttachments(changesDb, changeRow, change, item["rev"])
+					}
 
 					// If change is a removal and we're running with protocol V3 and change change is not a tombstone
 					// fall into 3.0 removal handling
@@ -437,14 +767,22 @@ func (bh *blipHandler) sendChanges(sender *blip.Sender, opts *sendChangesOptions
 			if !caughtUp {
 				caughtUp = true
 				// Signal to client that it's caught up
-				if err := bh.sendBatchOfChanges(sender, nil, opts.ignoreNoConflicts); err != nil {
+				if err := bh.sendBatchOfChanges(sender, nil, opts.ignoreNoConflicts, opts.binaryEncoding); err != nil {
 					return err
 				}
 			}
 		}
+		startFlushTimer()
 		return nil
 	})
 
+	pendingLock.Lock()
+	if flushTimer != nil {
+		flushTimer.Stop()
+		flushTimer = nil
+	}
+	pendingLock.Unlock()
+
 	// On forceClose, send notify to trigger immediate exit from change waiter
 	if forceClose {
 		user := ""
@@ -457,6 +795,17 @@ func (bh *blipHandler) sendChanges(sender *blip.Sender, opts *sendChangesOptions
 	return !forceClose
 }
 
+// isCreationChange returns true if the change is for the first revision of a new document, i.e. a non-deleted,
+// non-removed generation 1 revision.  Documents that have been updated since they were created no longer have a
+// creation in the changes feed, and so aren't sent.
+func isCreationChange(change *ChangeEntry, revID string) bool {
+	if change.Deleted || change.Revoked || change.allRemoved {
+		return false
+	}
+	generation, _ := ParseRevID(revID)
+	return generation == 1
+}
+
 func (bh *blipHandler) buildChangesRow(change *ChangeEntry, revID string) []interface{} {
 	var changeRow []interface{}
 
@@ -487,7 +836,7 @@ func (bh *blipHandler) buildChangesRow(change *ChangeEntry, revID string) []inte
 	return changeRow
 }
 
-func (bh *blipHandler) sendBatchOfChanges(sender *blip.Sender, changeArray [][]interface{}, ignoreNoConflicts bool) error {
+func (bh *blipHandler) sendBatchOfChanges(sender *blip.Sender, changeArray [][]interface{}, ignoreNoConflicts bool, binaryEncoding bool) error {
 	outrq := blip.NewRequest()
 	outrq.SetProfile("changes")
 	if ignoreNoConflicts {
@@ -496,9 +845,14 @@ func (bh *blipHandler) sendBatchOfChanges(sender *blip.Sender, changeArray [][]i
 	if bh.collectionIdx != nil {
 		outrq.Properties[BlipCollection] = strconv.Itoa(*bh.collectionIdx)
 	}
-	err := outrq.SetJSONBody(changeArray)
-	if err != nil {
-		base.InfofCtx(bh.loggingCtx, base.KeyAll, "Error setting changes: %v", err)
+	if binaryEncoding {
+		outrq.Properties[ChangesMessageEncoding] = ChangesEncodingBinary
+		outrq.SetBody(encodeBinaryChanges(changeArray))
+	} else {
+		err := outrq.SetJSONBody(changeArray)
+		if err != nil {
+			base.InfofCtx(bh.loggingCtx, base.KeyAll, "Error setting changes: %v", err)
+		}
 	}
 
 	if len(changeArray) > 0 {
@@ -544,6 +898,7 @@ func (bh *blipHandler) sendBatchOfChanges(sender *blip.Sender, changeArray [][]i
 	if len(changeArray) > 0 {
 		sequence := changeArray[0][0].(SequenceID)
 		base.InfofCtx(bh.loggingCtx, base.KeySync, "Sent %d changes to client, from seq %s", len(changeArray), sequence.String())
+		bh.setLastSentSeq(changeArray[len(changeArray)-1][0].(SequenceID))
 	} else {
 		base.InfofCtx(bh.loggingCtx, base.KeySync, "Sent all changes to client")
 	}
@@ -666,8 +1021,9 @@ func (bh *blipHandler) handleChanges(rq *blip.Message) error {
 		response.Properties[ChangesResponseDeltas] = trueProperty
 		bh.replicationStats.HandleChangesDeltaRequestedCount.Add(int64(nRequested))
 	}
-	response.SetCompressed(true)
+	response.Properties[ChangesResponseMaxHistory] = bh.maxHistoryProperty()
 	response.SetBody(output.Bytes())
+	bh.setCompressed(response, true)
 
 	if bh.sgr2PullAddExpectedSeqsCallback != nil {
 		bh.sgr2PullAddExpectedSeqsCallback(expectedSeqs)
@@ -698,6 +1054,17 @@ func (bh *blipHandler) handleProposeChanges(rq *blip.Message) error {
 		includeConflictRev = val == trueProperty
 	}
 
+	forceIndexes, err := parseProposeChangesForce(rq.Properties[ProposeChangesForce])
+	if err != nil {
+		return err
+	}
+
+	// A status map only includes the non-zero statuses, keyed by the index of the proposed change
+	var statusMap map[string]interface{}
+	if rq.Properties[ProposeChangesStatusMap] == trueProperty {
+		statusMap = make(map[string]interface{})
+	}
+
 	var changeList [][]interface{}
 	if err := rq.ReadJSONBody(&changeList); err != nil {
 		return err
@@ -725,7 +1092,24 @@ func (bh *blipHandler) handleProposeChanges(rq *blip.Message) error {
 			parentRevID = change[2].(string)
 		}
 		status, currentRev := bh.collection.CheckProposedRev(bh.loggingCtx, docID, revID, parentRevID)
-		if status != 0 {
+		if _, force := forceIndexes[i]; force && status == ProposedRev_Conflict {
+			// Only admin connections may overwrite the server's revision.  The forced rev is written as the winner
+			// of a conflict resolution when it's received.
+			if bh.db.User() != nil {
+				status = ProposedRev_Forbidden
+			} else {
+				base.InfofCtx(bh.loggingCtx, base.KeySync, "Accepting forced proposed rev %s for doc %s, conflicting with current rev %s", revID, base.UD(docID), currentRev)
+				bh.addForcedRev(IDAndRev{DocID: docID, RevID: revID})
+				status = ProposedRev_OK
+			}
+		}
+		if status != 0 && statusMap != nil {
+			if includeConflictRev && status == ProposedRev_Conflict {
+				statusMap[strconv.Itoa(i)] = IncludeConflictRevEntry{Status: status, Rev: currentRev}
+			} else {
+				statusMap[strconv.Itoa(i)] = status
+			}
+		} else if status != 0 {
 			// Skip writing trailing zeroes; but if we write a number afterwards we have to catch up
 			if nWritten > 0 {
 				output.Write([]byte(","))
@@ -754,11 +1138,43 @@ func (bh *blipHandler) handleProposeChanges(rq *blip.Message) error {
 		base.DebugfCtx(bh.loggingCtx, base.KeyAll, "Setting deltas=true property on proposeChanges response")
 		response.Properties[ChangesResponseDeltas] = trueProperty
 	}
-	response.SetCompressed(true)
-	response.SetBody(output.Bytes())
+	response.Properties[ProposeChangesResponseMaxHistory] = bh.maxHistoryProperty()
+	body := output.Bytes()
+	if statusMap != nil {
+		if body, err = base.JSONMarshal(statusMap); err != nil {
+			return err
+		}
+		response.Properties[ProposeChangesResponseStatusMap] = trueProperty
+	}
+	response.SetBody(body)
+	bh.setCompressed(response, true)
 	return nil
 }
 
+// maxHistoryProperty returns the maxHistory to send in changes and proposeChanges responses.  History beyond the
+// database's revs_limit would be pruned on arrival, so there's no need for peers to send it.  Peers still stop at the
+// first ancestor the server already has, so a truncated history that doesn't reach a known ancestor is treated as a
+// conflict by PutExistingRev.
+func (bh *blipHandler) maxHistoryProperty() string {
+	return strconv.FormatUint(uint64(bh.db.RevsLimit), 10)
+}
+
+// parseProposeChangesForce parses the comma-separated list of change indexes in a proposeChanges force property.
+func parseProposeChangesForce(val string) (map[int]struct{}, error) {
+	if val == "" {
+		return nil, nil
+	}
+	indexes := make(map[int]struct{})
+	for _, indexStr := range strings.Split(val, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(indexStr))
+		if err != nil || index < 0 {
+			return nil, base.HTTPErrorf(http.StatusBadRequest, "Invalid %s value %q: must be comma-separated change indexes", ProposeChangesForce, val)
+		}
+		indexes[index] = struct{}{}
+	}
+	return indexes, nil
+}
+
 // ////// DOCUMENTS:
 
 func (bsc *BlipSyncContext) sendRevAsDelta(sender *blip.Sender, docID, revID string, deltaSrcRevID string, seq SequenceID, knownRevs map[string]bool, maxHistory int, handleChangesResponseDb *Database) error {
@@ -771,7 +1187,7 @@ func (bsc *BlipSyncContext) sendRevAsDelta(sender *blip.Sender, docID, revID str
 
 	revDelta, redactedRev, err := handleChangesResponseDb.GetDelta(bsc.loggingCtx, docID, deltaSrcRevID, revID)
 	if err == ErrForbidden { // nolint: gocritic // can't convert if/else if to switch since base.IsFleeceDeltaError is not switchable
-		return err
+		return bsc.sendNoRev(sender, docID, revID, collectionIdx, seq, err)
 	} else if base.IsFleeceDeltaError(err) {
 		// Something went wrong in the diffing library. We want to know about this!
 		base.WarnfCtx(bsc.loggingCtx, "Falling back to full body replication. Error generating delta from %s to %s for key %s - err: %v", deltaSrcRevID, revID, base.UD(docID), err)
@@ -840,6 +1256,61 @@ type processRevStats struct {
 	docsPurgedCount *base.SgwIntStat
 }
 
+// validateRevDocID rejects pushed docIDs that use the reserved Sync Gateway metadata prefix, or that don't match the
+// database's configured doc_id_pattern.  The pattern only restricts the creation of new documents by clients pushing
+// to this node - revisions of existing documents (including tombstones) and revisions pulled by an active
+// sg-replicate replication are allowed.
+func (bh *blipHandler) validateRevDocID(docID string) error {
+	if strings.HasPrefix(docID, base.SyncDocPrefix) {
+		return base.HTTPErrorf(http.StatusBadRequest, "Invalid docID %q: the %s prefix is reserved", base.UD(docID), base.SyncDocPrefix)
+	}
+
+	pattern := bh.db.Options.DocIDPattern
+	if pattern == nil || pattern.MatchString(docID) {
+		return nil
+	}
+
+	// sgr2PullProcessedSeqCallback is only set for the active side of an sg-replicate pull replication
+	if bh.sgr2PullProcessedSeqCallback != nil {
+		return nil
+	}
+
+	_, err := bh.collection.GetDocSyncData(bh.loggingCtx, docID)
+	if err == nil {
+		return nil
+	} else if !base.IsDocNotFoundError(err) {
+		return err
+	}
+	return base.HTTPErrorf(http.StatusForbidden, "Invalid docID %q: does not match doc_id_pattern", base.UD(docID))
+}
+
+// revRejectedStat returns the rejected rev stat for the category of the given processRev error.
+func revRejectedStat(pushStats *base.CBLReplicationPushStats, err error) *base.SgwIntStat {
+	status, _ := base.ErrorAsHTTPStatus(err)
+	switch status {
+	case http.StatusBadRequest:
+		return pushStats.RevRejectedMalformedCount
+	case http.StatusForbidden:
+		return pushStats.RevRejectedForbiddenCount
+	case http.StatusConflict:
+		return pushStats.RevRejectedConflictCount
+	case http.StatusInsufficientStorage:
+		return pushStats.RevRejectedQuotaCount
+	default:
+		return pushStats.RevRejectedOtherCount
+	}
+}
+
+// checkRevBodySize returns a 413 error if a pushed revision's body is larger than the database's maximum document size.
+func (bh *blipHandler) checkRevBodySize(docID string, size int) error {
+	maxSize := bh.db.Options.MaxDocumentSize
+	if maxSize == 0 || size <= maxSize {
+		return nil
+	}
+	base.InfofCtx(bh.loggingCtx, base.KeySyncMsg, "Rejecting rev for doc %q: body of %d bytes exceeds the maximum document size of %d bytes", base.UD(docID), size, maxSize)
+	return base.HTTPErrorf(http.StatusRequestEntityTooLarge, "Document body of %d bytes exceeds the maximum document size of %d bytes", size, maxSize)
+}
+
 // Processes a "rev" request, i.e. client is pushing a revision body
 // stats must always be provided, along with all the fields filled with valid pointers
 func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err error) {
@@ -850,6 +1321,7 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 			stats.count.Add(1)
 		} else {
 			stats.errorCount.Add(1)
+			revRejectedStat(bh.db.DbStats.CBLReplicationPush(), err).Add(1)
 		}
 	}()
 
@@ -867,6 +1339,14 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 		return base.HTTPErrorf(http.StatusForbidden, "Replication context is read-only, docID: %s, revID:%s", docID, revID)
 	}
 
+	if err := bh.validateRevDocID(docID); err != nil {
+		return err
+	}
+
+	if err := bh.collection.checkDocCountQuota(bh.loggingCtx, docID); err != nil {
+		return err
+	}
+
 	base.DebugfCtx(bh.loggingCtx, base.KeySyncMsg, "#%d: Type:%s %s", bh.serialNumber, rq.Profile(), revMessage.String())
 
 	bodyBytes, err := rq.Body()
@@ -874,6 +1354,11 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 		return err
 	}
 
+	// Reject oversized bodies before parsing them
+	if err := bh.checkRevBodySize(docID, len(bodyBytes)); err != nil {
+		return err
+	}
+
 	base.TracefCtx(bh.loggingCtx, base.KeySyncMsg, "#%d: Properties:%v  Body:%s", bh.serialNumber, base.UD(revMessage.Properties), base.UD(string(bodyBytes)))
 
 	stats.bytes.Add(int64(len(bodyBytes)))
@@ -903,6 +1388,7 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 	newDoc.UpdateBodyBytes(bodyBytes)
 
 	injectedAttachmentsForDelta := false
+	injectedClientMetaForDelta := false
 	if deltaSrcRevID, isDelta := revMessage.DeltaSrc(); isDelta {
 		if !bh.sgCanUseDeltas {
 			return base.HTTPErrorf(http.StatusBadRequest, "Deltas are disabled for this peer")
@@ -939,6 +1425,12 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 			injectedAttachmentsForDelta = true
 		}
 
+		// Stamp _meta so a delta that doesn't modify it leaves it intact.  Copied, as patching mutates nested maps.
+		if len(deltaSrcRev.ClientMeta) > 0 {
+			deltaSrcBody[BodyClientMeta] = map[string]interface{}(deltaSrcRev.ClientMeta.DeepCopy())
+			injectedClientMetaForDelta = true
+		}
+
 		deltaSrcMap := map[string]interface{}(deltaSrcBody)
 		err = base.Patch(&deltaSrcMap, newDoc.Body())
 		// err should only ever be a FleeceDeltaError here - but to be defensive, handle other errors too (e.g. somehow reaching this code in a CE build)
@@ -951,6 +1443,17 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 		newDoc.UpdateBody(deltaSrcMap)
 		base.TracefCtx(bh.loggingCtx, base.KeySync, "docID: %s - body after patching: %v", base.UD(docID), base.UD(deltaSrcMap))
 		stats.deltaRecvCount.Add(1)
+
+		// A small delta can still produce an oversized body
+		if bh.db.Options.MaxDocumentSize > 0 {
+			patchedBytes, err := newDoc.BodyBytes()
+			if err != nil {
+				return err
+			}
+			if err := bh.checkRevBodySize(docID, len(patchedBytes)); err != nil {
+				return err
+			}
+		}
 	}
 
 	err = validateBlipBody(bodyBytes, newDoc)
@@ -969,6 +1472,21 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 		newDoc.UpdateBody(body)
 	}
 
+	// Pull out client metadata - this is stored alongside the sync metadata, so is neither part of the stored body nor
+	// visible to the sync function.
+	if injectedClientMetaForDelta || bytes.Contains(bodyBytes, []byte(`"`+BodyClientMeta+`"`)) {
+		body := newDoc.Body()
+		if rawMeta, ok := body[BodyClientMeta]; ok {
+			clientMeta, ok := rawMeta.(map[string]interface{})
+			if !ok {
+				return base.HTTPErrorf(http.StatusBadRequest, "%s must be a JSON object", BodyClientMeta)
+			}
+			newDoc.DocClientMeta = clientMeta
+			delete(body, BodyClientMeta)
+			newDoc.UpdateBody(body)
+		}
+	}
+
 	newDoc.Deleted = revMessage.Deleted()
 
 	// noconflicts flag from LiteCore
@@ -1092,6 +1610,9 @@ func (bh *blipHandler) processRev(rq *blip.Message, stats *processRevStats) (err
 	forceAllowConflictingTombstone := newDoc.Deleted && (bh.conflictResolver != nil || bh.clientType == BLIPClientTypeSGR2)
 	if bh.conflictResolver != nil {
 		_, _, err = bh.collection.PutExistingRevWithConflictResolution(bh.loggingCtx, newDoc, history, true, bh.conflictResolver, forceAllowConflictingTombstone, rawBucketDoc)
+	} else if bh.takeForcedRev(IDAndRev{DocID: docID, RevID: revID}) {
+		// Rev was proposed with force - resolve any conflict in favour of the incoming rev
+		_, _, err = bh.collection.PutExistingRevWithConflictResolution(bh.loggingCtx, newDoc, history, true, NewConflictResolver(RemoteWinsConflictResolver, nil), forceAllowConflictingTombstone, rawBucketDoc)
 	} else {
 		_, _, err = bh.collection.PutExistingRev(bh.loggingCtx, newDoc, history, revNoConflicts, forceAllowConflictingTombstone, rawBucketDoc)
 	}
@@ -1195,8 +1716,23 @@ func (bh *blipHandler) handleGetAttachment(rq *blip.Message) error {
 	}
 	base.DebugfCtx(bh.loggingCtx, base.KeySync, "Sending attachment with digest=%q (%.2f KB)", digest, float64(len(attachment))/float64(1024))
 	response := rq.Response()
-	response.SetBody(attachment)
-	response.SetCompressed(rq.Properties[BlipCompress] == trueProperty)
+	if getAttachmentParams.encoding() == AttachmentEncodingGzip {
+		// The body is already compressed, so isn't compressed again by BLIP
+		var gzipped bytes.Buffer
+		gz := gzip.NewWriter(&gzipped)
+		if _, err := gz.Write(attachment); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		response.SetBody(gzipped.Bytes())
+		response.Properties[GetAttachmentResponseEncoding] = AttachmentEncodingGzip
+	} else {
+		// Unknown encodings fall back to sending the attachment as is
+		response.SetBody(attachment)
+		bh.setCompressed(response, rq.Properties[BlipCompress] == trueProperty)
+	}
 	bh.replicationStats.HandleGetAttachment.Add(1)
 	bh.replicationStats.HandleGetAttachmentBytes.Add(int64(len(attachment)))
 
@@ -1205,8 +1741,18 @@ func (bh *blipHandler) handleGetAttachment(rq *blip.Message) error {
 
 var errNoBlipHandler = fmt.Errorf("404 - No handler for BLIP request")
 
-// sendGetAttachment requests the full attachment from the peer.
+// sendGetAttachment requests the full attachment from the peer.  BLIP message bodies are held in memory in full, so
+// attachments larger than can be stored are rejected based on their metadata, before their body is requested.
 func (bh *blipHandler) sendGetAttachment(sender *blip.Sender, docID string, name string, digest string, meta map[string]interface{}) ([]byte, error) {
+	lNum, metaLengthOK := meta["length"]
+	metaLength, ok := base.ToInt64(lNum)
+	if !ok {
+		return nil, fmt.Errorf("invalid attachment length found in meta")
+	}
+	if metaLength > maxAttachmentSizeBytes {
+		return nil, base.HTTPErrorf(http.StatusRequestEntityTooLarge, "Attachment too large")
+	}
+
 	base.DebugfCtx(bh.loggingCtx, base.KeySync, "    Asking for attachment %q for doc %s (digest %s)", base.UD(name), base.UD(docID), digest)
 	outrq := blip.NewRequest()
 	outrq.SetProfile(MessageGetAttachment)
@@ -1233,12 +1779,6 @@ func (bh *blipHandler) sendGetAttachment(sender *blip.Sender, docID string, name
 		return nil, err
 	}
 
-	lNum, metaLengthOK := meta["length"]
-	metaLength, ok := base.ToInt64(lNum)
-	if !ok {
-		return nil, fmt.Errorf("invalid attachment length found in meta")
-	}
-
 	// Verify that the attachment we received matches the metadata stored in the document
 	if !metaLengthOK || len(respBody) != int(metaLength) || Sha1DigestKey(respBody) != digest {
 		return nil, base.HTTPErrorf(http.StatusBadRequest, "Incorrect data sent for attachment with digest: %s", digest)
@@ -3,7 +3,9 @@ package db
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/couchbase/go-blip"
 	"github.com/couchbase/sync_gateway/base"
@@ -91,14 +93,100 @@ func (bh *blipHandler) handleGetCollections(rq *blip.Message) error {
 	return response.SetJSONBody(checkpoints)
 }
 
+// Received a "listCollections" request.  Lets clients discover which collections they can pass to getCollections,
+// rather than having to be configured with them.  Responds with the collections sorted by name, which is empty when
+// the database only has the default collection.
+func (bh *blipHandler) handleListCollections(rq *blip.Message) error {
+	bh.logEndpointEntry(rq.Profile(), "")
+
+	collections := make([]ListCollectionsResponseEntry, 0)
+	for scopeName, scope := range bh.db.Scopes {
+		for collectionName, collection := range scope.Collections {
+			collectionDB := &Database{DatabaseContext: collection.CollectionCtx, user: bh.blipContextDb.User()}
+			sequence, err := collectionDB.LastSequenceForUser(bh.loggingCtx)
+			if err != nil {
+				return err
+			}
+			collections = append(collections, ListCollectionsResponseEntry{
+				Collection: scopeName + base.ScopeCollectionSeparator + collectionName,
+				Sequence:   sequence,
+			})
+		}
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Collection < collections[j].Collection
+	})
+
+	response := rq.Response()
+	if response == nil {
+		return nil
+	}
+	return response.SetJSONBody(collections)
+}
+
 func (bsc *BlipSyncContext) getCollectionIndexForDB(db *Database) (int, bool) {
 	if bsc.collectionMapping == nil {
 		return 0, false
 	}
 	for i, iDB := range bsc.collectionMapping {
+		// Collections passed to getCollections that don't exist on the database are mapped to nil
+		if iDB == nil {
+			continue
+		}
 		if iDB.BucketSpec.Scope == db.BucketSpec.Scope && iDB.BucketSpec.Collection == db.BucketSpec.Collection {
 			return i, true
 		}
 	}
 	return 0, false
 }
+
+// subChangesCollections returns the index in the getCollections mapping of each of the [scope.]collection names
+// requested in a subChanges body.  Each collection must exist on the database, and must have been passed to
+// getCollections so that the client can identify the collection that changes are sent for.
+func (bh *blipHandler) subChangesCollections(collectionNames []string) ([]int, error) {
+	if len(bh.collectionMapping) == 0 {
+		return nil, base.HTTPErrorf(http.StatusBadRequest, "Passing collections requires calling %s first", MessageGetCollections)
+	}
+
+	collectionIdxs := make([]int, 0, len(collectionNames))
+	for _, scopeAndCollection := range collectionNames {
+		scope, collectionName, err := parseScopeAndCollection(scopeAndCollection)
+		if err != nil {
+			return nil, base.HTTPErrorf(http.StatusBadRequest, "Invalid specification for collection: %s", err)
+		}
+		collection, ok := bh.db.Scopes[*scope].Collections[*collectionName]
+		if !ok {
+			return nil, base.HTTPErrorf(http.StatusNotFound, "Collection %s not found", base.MD(scopeAndCollection))
+		}
+
+		collectionIdx, ok := bh.getCollectionIndexForDB(&Database{DatabaseContext: collection.CollectionCtx})
+		if !ok {
+			return nil, base.HTTPErrorf(http.StatusBadRequest, "Collection %s wasn't passed to %s", base.MD(scopeAndCollection), MessageGetCollections)
+		}
+		for _, idx := range collectionIdxs {
+			if idx == collectionIdx {
+				return nil, base.HTTPErrorf(http.StatusBadRequest, "Collection %s was requested more than once", base.MD(scopeAndCollection))
+			}
+		}
+		collectionIdxs = append(collectionIdxs, collectionIdx)
+	}
+	return collectionIdxs, nil
+}
+
+// sendCollectionsChanges sends changes for each of the given collections concurrently, until all of the feeds have
+// completed.  Each collection's changes messages have its collection property set, so the client can tell which
+// collection the interleaved changes are for.
+func (bh *blipHandler) sendCollectionsChanges(sender *blip.Sender, collectionIdxs []int, opts *sendChangesOptions) {
+	var wg sync.WaitGroup
+	for _, collectionIdx := range collectionIdxs {
+		collectionHandler := *bh
+		collectionHandler.collection = bh.collectionMapping[collectionIdx]
+		collectionHandler.collectionIdx = base.IntPtr(collectionIdx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = collectionHandler.sendChanges(sender, opts)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/go-blip"
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// DefaultBlipKeepaliveTimeout is how long a client has to respond to a keepalive request before its connection is
+// closed, when BlipKeepaliveInterval is set without BlipKeepaliveTimeout.
+const DefaultBlipKeepaliveTimeout = 30 * time.Second
+
+// markReceived records that a message has been received from the client, so the connection isn't idle.
+func (bsc *BlipSyncContext) markReceived() {
+	atomic.StoreInt64(&bsc.lastReceivedNano, time.Now().UnixNano())
+}
+
+// idleSince returns the time the last message was received from the client.
+func (bsc *BlipSyncContext) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&bsc.lastReceivedNano))
+}
+
+// startKeepalive starts sending keepalive requests on the connection, if enabled for the database, whenever nothing
+// has been received from the client for the keepalive interval.  Intermediaries may silently drop idle connections,
+// so a connection that doesn't respond to a keepalive within the keepalive timeout is closed, rather than being left
+// half-open.  Only started once per connection.
+func (bsc *BlipSyncContext) startKeepalive(sender *blip.Sender) {
+	interval := bsc.blipContextDb.Options.BlipKeepaliveInterval
+	if interval <= 0 {
+		return
+	}
+	timeout := bsc.blipContextDb.Options.BlipKeepaliveTimeout
+	if timeout <= 0 {
+		timeout = DefaultBlipKeepaliveTimeout
+	}
+	bsc.keepaliveOnce.Do(func() {
+		go bsc.keepalive(sender, interval, timeout)
+	})
+}
+
+func (bsc *BlipSyncContext) keepalive(sender *blip.Sender, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-bsc.terminator:
+			return
+		}
+		if time.Since(bsc.idleSince()) < interval {
+			continue
+		}
+		if err := bsc.sendKeepalive(sender, timeout); err != nil {
+			base.InfofCtx(bsc.loggingCtx, base.KeySync, "Closing idle BLIP connection: %v", err)
+			bsc.replicationStats.NumKeepaliveTimeouts.Add(1)
+			sender.Close()
+			return
+		}
+	}
+}
+
+// sendKeepalive sends a keepalive request and waits for the client to respond.  Clients aren't required to handle
+// keepalive requests, as the error response to an unknown profile is enough to show the connection is alive.
+func (bsc *BlipSyncContext) sendKeepalive(sender *blip.Sender, timeout time.Duration) error {
+	rq := blip.NewRequest()
+	rq.SetProfile(MessageKeepalive)
+	if !sender.Send(rq) {
+		return ErrClosedBLIPSender
+	}
+
+	// Response blocks until the client responds, or the connection is closed
+	responded := make(chan struct{})
+	go func() {
+		rq.Response()
+		close(responded)
+	}()
+
+	select {
+	case <-responded:
+		bsc.markReceived()
+		return nil
+	case <-bsc.terminator:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("no response to keepalive within %v", timeout)
+	}
+}
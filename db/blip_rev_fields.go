@@ -0,0 +1,84 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseRevFields parses the value of a subChanges fields property, a comma-separated list of dot-separated JSON paths,
+// into the path segments for each field.
+func parseRevFields(fields string) ([][]string, error) {
+	if fields == "" {
+		return nil, nil
+	}
+	var paths [][]string
+	for _, field := range strings.Split(fields, ",") {
+		path := strings.Split(strings.TrimSpace(field), ".")
+		for _, segment := range path {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid field %q", field)
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// projectBodyFields returns a new body containing only the values at the given paths.  Paths that don't exist in the
+// body, or that traverse a non-object value, are omitted.  The given body isn't modified.
+func projectBodyFields(body Body, paths [][]string) Body {
+	projected := make(Body, len(paths))
+	for _, path := range paths {
+		projectPath(body, projected, path)
+	}
+	return projected
+}
+
+// projectPath copies the value at path from src to dst, creating intermediate objects in dst as needed.
+func projectPath(src, dst map[string]interface{}, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+	srcChild, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	dstChild, ok := dst[path[0]].(map[string]interface{})
+	if !ok {
+		dstChild = make(map[string]interface{})
+	}
+	projectPath(srcChild, dstChild, path[1:])
+	if len(dstChild) > 0 {
+		dst[path[0]] = dstChild
+	}
+}
+
+// setRevFields sets the fields that revisions sent to the client are projected to.  No projection is done when paths
+// is empty.
+func (bsc *BlipSyncContext) setRevFields(paths [][]string) {
+	bsc.revFieldsLock.Lock()
+	defer bsc.revFieldsLock.Unlock()
+	bsc.revFields = paths
+}
+
+// getRevFields returns the fields that revisions sent to the client are projected to, if any.
+func (bsc *BlipSyncContext) getRevFields() [][]string {
+	bsc.revFieldsLock.RLock()
+	defer bsc.revFieldsLock.RUnlock()
+	return bsc.revFields
+}
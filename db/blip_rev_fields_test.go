@@ -0,0 +1,64 @@
+/*
+Copyright 2023-Present Couchbase, Inc.
+
+Use of this software is governed by the Business Source License included in
+the file licenses/BSL-Couchbase.txt.  As of the Change Date specified in that
+file, in accordance with the Business Source License, use of this software will
+be governed by the Apache License, Version 2.0, included in the file
+licenses/APL2.txt.
+*/
+
+package db
+
+import (
+	"testing"
+
+	"github.com/couchbase/sync_gateway/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRevFields(t *testing.T) {
+	paths, err := parseRevFields("")
+	require.NoError(t, err)
+	assert.Nil(t, paths)
+
+	paths, err = parseRevFields("name, address.city")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"name"}, {"address", "city"}}, paths)
+
+	for _, invalid := range []string{",", "name,", "address..city", ".name"} {
+		_, err = parseRevFields(invalid)
+		assert.Error(t, err, "expected error for %q", invalid)
+	}
+}
+
+func TestProjectBodyFields(t *testing.T) {
+	var body Body
+	require.NoError(t, body.Unmarshal([]byte(`{"name": "alice", "age": 42, "address": {"city": "Leeds", "street": "Briggate", "geo": {"lat": 53.8, "lon": -1.5}}, "tags": ["a", "b"]}`)))
+
+	tests := []struct {
+		name     string
+		fields   string
+		expected string
+	}{
+		{name: "top level", fields: "name,tags", expected: `{"name": "alice", "tags": ["a", "b"]}`},
+		{name: "nested", fields: "address.city,address.geo.lat", expected: `{"address": {"city": "Leeds", "geo": {"lat": 53.8}}}`},
+		{name: "whole object", fields: "address.geo", expected: `{"address": {"geo": {"lat": 53.8, "lon": -1.5}}}`},
+		{name: "missing", fields: "email,address.postcode", expected: `{}`},
+		{name: "traverses non-object", fields: "name.first,tags.0", expected: `{}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			paths, err := parseRevFields(test.fields)
+			require.NoError(t, err)
+			projected, err := base.JSONMarshal(projectBodyFields(body, paths))
+			require.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(projected))
+		})
+	}
+
+	// The source body must not be modified
+	assert.Len(t, body, 4)
+	assert.Len(t, body["address"], 3)
+}
@@ -16,7 +16,9 @@ import (
 	"errors"
 	"fmt"
 	"runtime/debug"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,7 +29,10 @@ import (
 const (
 	// Blip default vals
 	BlipDefaultBatchSize = uint64(200)
-	BlipMinimumBatchSize = uint64(10) // Not in the replication spec - is this required?
+	BlipMinimumBatchSize = uint64(10)   // Not in the replication spec - is this required?
+	BlipMaximumBatchSize = uint64(1000) // Upper bound on client-requested batch size, to bound the size of a single changes message
+
+	BlipMaximumBatchTimeoutMs = uint64(60000) // Upper bound on client-requested changes batch timeout
 )
 
 var ErrClosedBLIPSender = errors.New("use of closed BLIP sender")
@@ -86,6 +91,8 @@ type BlipSyncContext struct {
 	dbUserLock                       sync.RWMutex    // Must be held when refreshing the db user
 	allowedAttachments               map[string]AllowedAttachment
 	allowedAttachmentsLock           sync.Mutex
+	forcedRevs                       map[IDAndRev]struct{} // Revisions accepted via a forced proposeChanges entry, to be written as conflict resolution winners
+	forcedRevsLock                   sync.Mutex
 	handlerSerialNumber              uint64                                    // Each handler within a context gets a unique serial number for logging
 	terminatorOnce                   sync.Once                                 // Used to ensure the terminator channel below is only ever closed once.
 	terminator                       chan bool                                 // Closed during BlipSyncContext.close(). Ensures termination of async goroutines.
@@ -105,9 +112,22 @@ type BlipSyncContext struct {
 	purgeOnRemoval                   bool                                      // Purges the document when we pull a _removed:true revision.
 	conflictResolver                 *ConflictResolver                         // Conflict resolver for active replications
 	changesCtxLock                   sync.Mutex
-	changesCtx                       context.Context    // Used for the unsub changes Blip message to check if the subChanges feed should stop
-	changesCtxCancel                 context.CancelFunc // Cancel function for changesCtx to cancel subChanges being sent
-	changesPendingResponseCount      int64              // Number of changes messages pending changesResponse
+	changesCtx                       context.Context      // Used for the unsub changes Blip message to check if the subChanges feed should stop
+	changesCtxCancel                 context.CancelFunc   // Cancel function for changesCtx to cancel subChanges being sent
+	subChangesDone                   chan struct{}        // Closed when the changes feed started by the latest subChanges has stopped
+	changesPendingResponseCount      int64                // Number of changes messages pending changesResponse
+	replicationStatusLock            sync.Mutex           // Synchronization for subChangesSince, subChangesContinuous and lastSentSeq
+	subChangesSince                  *SequenceID          // Since sequence of the latest subChanges, reported by replicationStatus
+	subChangesContinuous             bool                 // Whether the latest subChanges was continuous, reported by replicationStatus
+	lastSentSeq                      *SequenceID          // Sequence of the last change sent to the client, reported by replicationStatus
+	bytesSent                        base.AtomicInt       // Message body bytes sent to the client, reported by replicationStatus
+	bytesReceived                    base.AtomicInt       // Message body bytes received from the client, reported by replicationStatus
+	revCompressionStats              blipCompressionStats // Compression of rev messages sent and received, reported by replicationStatus
+	changesCompressionStats          blipCompressionStats // Compression of changes messages sent and received, reported by replicationStatus
+	revFields                        [][]string           // JSON paths that revision bodies sent to the client are projected to, set by subChanges.  Access via getRevFields()
+	revFieldsLock                    sync.RWMutex         // Synchronization for revFields
+	lastReceivedNano                 int64                // Time the last message was received from the client, in Unix nanoseconds.  Atomic access via markReceived and idleSince
+	keepaliveOnce                    sync.Once            // Ensures keepalive requests are only started once for the connection
 	// TODO: For review, whether sendRevAllConflicts needs to be per sendChanges invocation
 	sendRevNoConflicts bool                      // Whether to set noconflicts=true when sending revisions
 	clientType         BLIPSyncContextClientType // Can perform client-specific replication behaviour based on this field
@@ -174,16 +194,38 @@ func (bsc *BlipSyncContext) register(profile string, handlerFn func(*blipHandler
 			serialNumber:    bsc.incrementSerialNumber(),
 		}
 
+		bsc.markReceived()
+
 		// Trace log the full message body and properties
 		if base.LogTraceEnabled(base.KeySyncMsg) {
 			rqBody, _ := rq.Body()
 			base.TracefCtx(bsc.loggingCtx, base.KeySyncMsg, "Recv Req %s: Body: '%s' Properties: %v", rq, base.UD(rqBody), base.UD(rq.Properties))
 		}
+		if rqBody, err := rq.Body(); err == nil {
+			bsc.bytesReceived.Add(int64(len(rqBody)))
+			bsc.recordCompressionStats(rq, rqBody)
+		}
+		defer func() {
+			if response := rq.Response(); response != nil {
+				if respBody, err := response.Body(); err == nil {
+					bsc.bytesSent.Add(int64(len(respBody)))
+				}
+			}
+		}()
 
 		if err := handlerFn(&handler, rq); err != nil {
 			status, msg := base.ErrorAsHTTPStatus(err)
 			if response := rq.Response(); response != nil {
 				response.SetError("HTTP", status, msg)
+				if profile == MessageRev {
+					// Rev errors have a JSON body, so that clients can tell why the revision was rejected
+					if body, marshalErr := base.JSONMarshal(newRevErrorResponseBody(err)); marshalErr != nil {
+						base.WarnfCtx(bsc.loggingCtx, "Unable to marshal rev error response body: %v", marshalErr)
+					} else {
+						response.SetBody(body)
+						response.Properties["Content-Type"] = "application/json"
+					}
+				}
 			}
 			base.InfofCtx(bsc.loggingCtx, base.KeySyncMsg, "#%d: Type:%s   --> %d %s Time:%v", handler.serialNumber, profile, status, msg, time.Since(startTime))
 		} else if profile != "subChanges" {
@@ -265,6 +307,7 @@ func (bsc *BlipSyncContext) handleChangesResponse(sender *blip.Sender, response
 		base.DebugfCtx(bsc.loggingCtx, base.KeyAll, "Empty response to 'changes' message: %s", response)
 	}
 	changesResponseReceived := time.Now()
+	bsc.markReceived()
 
 	bsc.replicationStats.HandleChangesResponseCount.Add(1)
 	bsc.replicationStats.HandleChangesResponseTime.Add(time.Since(requestSent).Nanoseconds())
@@ -281,6 +324,9 @@ func (bsc *BlipSyncContext) handleChangesResponse(sender *blip.Sender, response
 		base.TracefCtx(bsc.loggingCtx, base.KeySync, "Client didn't specify 'deltas' property in 'changes' response. useDeltas: %v", bsc.useDeltas)
 	}
 
+	// Deltas can't be applied to projected revision bodies
+	sendSparseRevs := len(bsc.getRevFields()) > 0
+
 	// Maps docID --> a map containing true for revIDs known to the client
 	knownRevsByDoc := make(map[string]map[string]bool, len(answer))
 
@@ -305,7 +351,7 @@ func (bsc *BlipSyncContext) handleChangesResponse(sender *blip.Sender, response
 			}
 
 			// The first element of the knownRevsArray returned from CBL is the parent revision to use as deltaSrc
-			if bsc.useDeltas && len(knownRevsArray) > 0 {
+			if bsc.useDeltas && !sendSparseRevs && len(knownRevsArray) > 0 {
 				if revID, ok := knownRevsArray[0].(string); ok {
 					deltaSrcRevID = revID
 				}
@@ -470,6 +516,25 @@ func (bsc *BlipSyncContext) allowedAttachment(digest string) AllowedAttachment {
 	return bsc.allowedAttachments[digest]
 }
 
+// addForcedRev records that the given revision was proposed with force, and should win any conflict when it's received.
+func (bsc *BlipSyncContext) addForcedRev(idAndRev IDAndRev) {
+	bsc.forcedRevsLock.Lock()
+	defer bsc.forcedRevsLock.Unlock()
+	if bsc.forcedRevs == nil {
+		bsc.forcedRevs = make(map[IDAndRev]struct{})
+	}
+	bsc.forcedRevs[idAndRev] = struct{}{}
+}
+
+// takeForcedRev returns whether the given revision was proposed with force, and removes it from the set of forced revisions.
+func (bsc *BlipSyncContext) takeForcedRev(idAndRev IDAndRev) bool {
+	bsc.forcedRevsLock.Lock()
+	defer bsc.forcedRevsLock.Unlock()
+	_, ok := bsc.forcedRevs[idAndRev]
+	delete(bsc.forcedRevs, idAndRev)
+	return ok
+}
+
 // setUseDeltas will set useDeltas on the BlipSyncContext as long as both sides of the connection have it enabled.
 func (bsc *BlipSyncContext) setUseDeltas(clientCanUseDeltas bool) {
 	if bsc.useDeltas && bsc.sgCanUseDeltas && clientCanUseDeltas {
@@ -517,9 +582,26 @@ func (bsc *BlipSyncContext) sendDelta(sender *blip.Sender, docID string, collect
 		properties, seq, resendFullRevisionFunc)
 }
 
+// setCompressed marks an outgoing message as compressed if compress is true and its body is at least the database's
+// BLIP compression threshold, as compressing smaller messages costs more than it saves.  Must be called after the
+// message body has been set.
+func (bsc *BlipSyncContext) setCompressed(msg *blip.Message, compress bool) {
+	if compress {
+		body, _ := msg.Body()
+		compress = len(body) >= bsc.blipContextDb.Options.BlipCompressionThreshold
+	}
+	msg.SetCompressed(compress)
+}
+
 // sendBLIPMessage is a simple wrapper around all sent BLIP messages
 func (bsc *BlipSyncContext) sendBLIPMessage(sender *blip.Sender, msg *blip.Message) bool {
 	ok := sender.Send(msg)
+	if ok {
+		if body, err := msg.Body(); err == nil {
+			bsc.bytesSent.Add(int64(len(body)))
+			bsc.recordCompressionStats(msg, body)
+		}
+	}
 	if base.LogTraceEnabled(base.KeySyncMsg) {
 		rqBody, _ := msg.Body()
 		base.TracefCtx(bsc.loggingCtx, base.KeySyncMsg, "Sent Req %s: Body: '%s' Properties: %v", msg, base.UD(rqBody), base.UD(msg.Properties))
@@ -527,6 +609,17 @@ func (bsc *BlipSyncContext) sendBLIPMessage(sender *blip.Sender, msg *blip.Messa
 	return ok
 }
 
+// isRevUnavailableError returns true if an error getting a revision means that it can't be served, for example because
+// it's been purged or pruned or the user can't access it, rather than that getting it failed.  The peer is sent a norev
+// for an unavailable revision so that it stops waiting for it.
+func isRevUnavailableError(err error) bool {
+	if base.IsDocNotFoundError(err) {
+		return true
+	}
+	var httpErr *base.HTTPError
+	return errors.As(err, &httpErr) && httpErr.Status >= 400 && httpErr.Status < 500
+}
+
 func (bsc *BlipSyncContext) sendNoRev(sender *blip.Sender, docID, revID string, collectionIdx *int, seq SequenceID, err error) error {
 	base.DebugfCtx(bsc.loggingCtx, base.KeySync, "Sending norev %q %s due to unavailable revision: %v", base.UD(docID), revID, err)
 
@@ -566,7 +659,7 @@ func (bsc *BlipSyncContext) sendRevision(sender *blip.Sender, docID, revID strin
 	}
 
 	rev, err := handleChangesResponseDb.GetRev(bsc.loggingCtx, docID, revID, true, nil)
-	if base.IsDocNotFoundError(err) {
+	if isRevUnavailableError(err) {
 		return bsc.sendNoRev(sender, docID, revID, collectionIdx, seq, err)
 	} else if err != nil {
 		return fmt.Errorf("failed to GetRev for doc %s with rev %s: %w", base.UD(docID).Redact(), base.MD(revID).Redact(), err)
@@ -574,12 +667,20 @@ func (bsc *BlipSyncContext) sendRevision(sender *blip.Sender, docID, revID strin
 
 	base.TracefCtx(bsc.loggingCtx, base.KeySync, "sendRevision, rev attachments for %s/%s are %v", base.UD(docID), revID, base.UD(rev.Attachments))
 	attachmentStorageMeta := ToAttachmentStorageMeta(rev.Attachments)
+	revFields := bsc.getRevFields()
 	var bodyBytes []byte
-	if base.IsEnterpriseEdition() {
-		// Still need to stamp _attachments into BLIP messages
+	if base.IsEnterpriseEdition() && len(revFields) == 0 {
+		// Still need to stamp _attachments and _meta into BLIP messages
+		var kvPairs []base.KVPair
 		if len(rev.Attachments) > 0 {
 			DeleteAttachmentVersion(rev.Attachments)
-			bodyBytes, err = base.InjectJSONProperties(rev.BodyBytes, base.KVPair{Key: BodyAttachments, Val: rev.Attachments})
+			kvPairs = append(kvPairs, base.KVPair{Key: BodyAttachments, Val: rev.Attachments})
+		}
+		if len(rev.ClientMeta) > 0 {
+			kvPairs = append(kvPairs, base.KVPair{Key: BodyClientMeta, Val: rev.ClientMeta})
+		}
+		if len(kvPairs) > 0 {
+			bodyBytes, err = base.InjectJSONProperties(rev.BodyBytes, kvPairs...)
 			if err != nil {
 				return err
 			}
@@ -592,11 +693,19 @@ func (bsc *BlipSyncContext) sendRevision(sender *blip.Sender, docID, revID strin
 			return bsc.sendNoRev(sender, docID, revID, collectionIdx, seq, err)
 		}
 
-		// Still need to stamp _attachments into BLIP messages
+		// Only send the fields requested by the client.  _attachments and _meta are still sent in full, below.
+		if len(revFields) > 0 {
+			body = projectBodyFields(body, revFields)
+		}
+
+		// Still need to stamp _attachments and _meta into BLIP messages
 		if len(rev.Attachments) > 0 {
 			DeleteAttachmentVersion(rev.Attachments)
 			body[BodyAttachments] = rev.Attachments
 		}
+		if len(rev.ClientMeta) > 0 {
+			body[BodyClientMeta] = rev.ClientMeta
+		}
 
 		bodyBytes, err = base.JSONMarshalCanonical(body)
 		if err != nil {
@@ -606,6 +715,12 @@ func (bsc *BlipSyncContext) sendRevision(sender *blip.Sender, docID, revID strin
 
 	history := toHistory(rev.History, knownRevs, maxHistory)
 	properties := blipRevMessageProperties(history, rev.Deleted, seq)
+	if len(revFields) > 0 {
+		// The client can't determine the revision's channels from a projected body
+		channels := rev.Channels.ToArray()
+		sort.Strings(channels)
+		properties[RevMessageChannels] = strings.Join(channels, ",")
+	}
 	if base.LogDebugEnabled(base.KeySync) {
 		base.DebugfCtx(bsc.loggingCtx, base.KeySync, "Sending rev %q %s based on %d known, digests: %v", base.UD(docID), revID, len(knownRevs), digests(attachmentStorageMeta))
 	}
@@ -11,6 +11,7 @@ licenses/APL2.txt.
 package db
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/couchbase/sync_gateway/base"
@@ -101,3 +102,26 @@ func BenchmarkBlipSyncContextSetUseDeltas(b *testing.B) {
 		})
 	}
 }
+
+func TestBlipCompressionStats(t *testing.T) {
+	var stats blipCompressionStats
+
+	compressible := []byte(`{"value": "` + strings.Repeat("a", 1000) + `"}`)
+	stats.recordMessage(compressible, true)
+	status := stats.status()
+	assert.Equal(t, int64(1), status.Messages)
+	assert.Equal(t, int64(1), status.CompressedMessages)
+	assert.Equal(t, int64(len(compressible)), status.Bytes)
+	assert.Greater(t, status.CompressedBytes, int64(0))
+	assert.Less(t, status.CompressedBytes, status.Bytes)
+
+	// Uncompressed messages count at their full size
+	uncompressed := []byte(`{"value": 1}`)
+	stats.recordMessage(uncompressed, false)
+	previousCompressedBytes := status.CompressedBytes
+	status = stats.status()
+	assert.Equal(t, int64(2), status.Messages)
+	assert.Equal(t, int64(1), status.CompressedMessages)
+	assert.Equal(t, int64(len(compressible)+len(uncompressed)), status.Bytes)
+	assert.Equal(t, previousCompressedBytes+int64(len(uncompressed)), status.CompressedBytes)
+}
@@ -13,10 +13,12 @@ package db
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/couchbase/go-blip"
 	"github.com/couchbase/sync_gateway/base"
@@ -35,8 +37,17 @@ const (
 	MessageProposeChanges  = "proposeChanges"
 	MessageProveAttachment = "proveAttachment"
 	MessageGetCollections  = "getCollections"
+	MessageKeepalive       = "keepalive" // Sent by Sync Gateway on idle connections.  Clients needn't handle it
+
+	MessageBulkDelCheckpoint     = "bulkDelCheckpoint"     // Admin only
+	MessageGetActiveReplications = "getActiveReplications" // Admin only
+	MessageGetRevTree            = "getRevTree"            // Admin only, returns a document's full revision tree
+	MessageGetDocChannels        = "getDocChannels"        // Returns the channels a document is in, filtered to those visible to non-admin users
+	MessageGetServerSequence     = "getServerSequence"     // Returns the database's latest sequence, and the latest sequence visible to the user
+	MessageListCollections       = "listCollections"       // Returns the collections the user can replicate, and the latest sequence visible to the user in each
 
 	MessageGetRev       = "getRev"       // Connected Client API
+	MessageGetRevs      = "getRevs"      // Connected Client API
 	MessagePutRev       = "putRev"       // Connected Client API
 	MessageUnsubChanges = "unsubChanges" // Connected Client API
 	MessageQuery        = "query"        // Connected Client API
@@ -62,13 +73,23 @@ const (
 	GetCheckpointClient      = "client"
 
 	// subChanges message properties
-	SubChangesActiveOnly  = "activeOnly"
-	SubChangesFilter      = "filter"
-	SubChangesChannels    = "channels"
-	SubChangesSince       = "since"
-	SubChangesContinuous  = "continuous"
-	SubChangesBatch       = "batch"
-	SubChangesRevocations = "revocations"
+	SubChangesActiveOnly         = "activeOnly"
+	SubChangesFilter             = "filter"
+	SubChangesChannels           = "channels"
+	SubChangesSince              = "since"
+	SubChangesContinuous         = "continuous"
+	SubChangesBatch              = "batch"
+	SubChangesRevocations        = "revocations"
+	SubChangesEncoding           = "encoding"           // Requested encoding of changes message bodies, one of ChangesEncodingJSON or ChangesEncodingBinary
+	SubChangesCreationsOnly      = "creationsOnly"      // If true, only the first revision of new documents is sent
+	SubChangesWinningRevOnly     = "winningRevOnly"     // If true, changes are only sent when a document's winning revision changes
+	SubChangesFields             = "fields"             // Comma-separated JSON paths.  If set, revision bodies are projected to these fields before being sent
+	SubChangesIncludeAttachments = "includeAttachments" // If true, changes rows include the name, digest and length of each of the revision's attachments
+	SubChangesBatchTimeout       = "batchTimeout"       // Milliseconds.  If set, a partial batch of changes is sent once it's been pending for this long
+
+	// subChanges response properties
+	SubChangesResponseBatch    = "batch"    // Effective batch size, after the requested size has been clamped to the allowed range
+	SubChangesResponseEncoding = "encoding" // Effective encoding of changes message bodies, ChangesEncodingJSON if the requested encoding isn't supported
 
 	// rev message properties
 	RevMessageID          = "id"
@@ -78,6 +99,7 @@ const (
 	RevMessageHistory     = "history"
 	RevMessageNoConflicts = "noconflicts"
 	RevMessageDeltaSrc    = "deltaSrc"
+	RevMessageChannels    = "channels" // Comma-separated channels of the revision, only sent for revisions projected to subChanges fields
 
 	// norev message properties
 	NorevMessageId       = "id"
@@ -92,22 +114,40 @@ const (
 	GetRevRevId     = "rev"
 	GetRevIfNotRev  = "ifNotRev"
 
+	// getDocChannels message properties
+	GetDocChannelsID = "docID"
+
+	// getRevTree message properties
+	GetRevTreeID = "docID"
+
 	// changes message properties
 	ChangesMessageIgnoreNoConflicts = "ignoreNoConflicts"
+	ChangesMessageEncoding          = "encoding" // Set to ChangesEncodingBinary when the body uses the binary changes encoding
 
 	// changes response properties
-	ChangesResponseMaxHistory = "maxHistory"
+	ChangesResponseMaxHistory = "maxHistory" // Max number of ancestors the peer should include in the history of revs it sends
 	ChangesResponseDeltas     = "deltas"
 
 	// proposeChanges message properties
 	ProposeChangesConflictsIncludeRev = "conflictIncludesRev"
+	ProposeChangesForce               = "force"     // Comma-separated indexes of proposed changes that should overwrite a conflicting server revision
+	ProposeChangesStatusMap           = "statusMap" // If true, the response body is an object of the non-zero statuses keyed by index, rather than an array
 
 	// proposeChanges response message properties
-	ProposeChangesResponseDeltas = "deltas"
+	ProposeChangesResponseDeltas     = "deltas"
+	ProposeChangesResponseMaxHistory = "maxHistory" // Max number of ancestors the peer should include in the history of revs it sends
+	ProposeChangesResponseStatusMap  = "statusMap"  // Set to true when the response body is a status map, for peers that don't support it
 
 	// getAttachment message properties
-	GetAttachmentID     = "docID"
-	GetAttachmentDigest = "digest"
+	GetAttachmentID       = "docID"
+	GetAttachmentDigest   = "digest"
+	GetAttachmentEncoding = "encoding" // Optional.  When "gzip", the attachment is returned gzip-compressed
+
+	// getAttachment response message properties
+	GetAttachmentResponseEncoding = "encoding" // Set to "gzip" when the attachment body is gzip-compressed
+
+	// Attachment encodings
+	AttachmentEncodingGzip = "gzip"
 
 	// proveAttachment
 	ProveAttachmentDigest = "digest"
@@ -145,13 +185,17 @@ type LatestSequenceFunc func() (SequenceID, error)
 
 // SubChangesParams is a helper for handling BLIP subChanges requests.  Supports Stringer() interface to log aspects of the request.
 type SubChangesParams struct {
-	rq      *blip.Message // The underlying BLIP message
-	_since  SequenceID    // Since value on the incoming request
-	_docIDs []string      // Document ID filter specified on the incoming request
+	rq           *blip.Message // The underlying BLIP message
+	_since       SequenceID    // Since value on the incoming request
+	_docIDs      []string      // Document ID filter specified on the incoming request
+	_channels    []string      // Channel filter specified in the body of the incoming request
+	_collections []string      // [scope.]collection names specified in the body of the incoming request
 }
 
 type SubChangesBody struct {
-	DocIDs []string `json:"docIDs"`
+	DocIDs      []string `json:"docIDs"`
+	Channels    []string `json:"channels,omitempty"`    // Channels for the sync_gateway/bychannel filter, as an alternative to the channels property
+	Collections []string `json:"collections,omitempty"` // [scope.]collection names to send changes for, as an alternative to the collection property
 }
 
 // Create a new subChanges helper
@@ -177,12 +221,14 @@ func NewSubChangesParams(logCtx context.Context, rq *blip.Message, zeroSeq Seque
 	params._since = sinceSequenceId
 
 	// rq.BodyReader() returns an EOF for a non-existent body, so using rq.Body() here
-	docIDs, err := readDocIDsFromRequest(rq)
+	body, err := readSubChangesBody(rq)
 	if err != nil {
-		base.InfofCtx(logCtx, base.KeySync, "%s: Error reading doc IDs on subChanges request: %s", rq, err)
+		base.InfofCtx(logCtx, base.KeySync, "%s: Error reading body of subChanges request: %s", rq, err)
 		return params, err
 	}
-	params._docIDs = docIDs
+	params._docIDs = body.DocIDs
+	params._channels = body.Channels
+	params._collections = body.Collections
 
 	return params, nil
 }
@@ -195,28 +241,43 @@ func (s *SubChangesParams) docIDs() []string {
 	return s._docIDs
 }
 
-func readDocIDsFromRequest(rq *blip.Message) (docIDs []string, err error) {
+// bodyChannels returns the channel filter specified in the body of the request, if any.
+func (s *SubChangesParams) bodyChannels() []string {
+	return s._channels
+}
+
+// bodyCollections returns the [scope.]collection names specified in the body of the request, if any.
+func (s *SubChangesParams) bodyCollections() []string {
+	return s._collections
+}
+
+func readSubChangesBody(rq *blip.Message) (body SubChangesBody, err error) {
 	// Get Body from request.  Not using BodyReader(), to avoid EOF on empty body
 	rawBody, err := rq.Body()
 	if err != nil {
-		return nil, err
+		return body, err
 	}
 
-	// If there's a non-empty body, unmarshal to get the docIDs
+	// If there's a non-empty body, unmarshal to get the docIDs and channels
 	if len(rawBody) > 0 {
-		var body SubChangesBody
 		unmarshalErr := base.JSONUnmarshal(rawBody, &body)
 		if unmarshalErr != nil {
-			return nil, err
+			return SubChangesBody{}, err
 		}
-		docIDs = body.DocIDs
 	}
-	return docIDs, err
+	return body, err
 
 }
 
 func (s *SubChangesParams) batchSize() int {
-	return int(base.GetRestrictedIntFromString(s.rq.Properties["batch"], BlipDefaultBatchSize, BlipMinimumBatchSize, math.MaxUint64, true))
+	return int(base.GetRestrictedIntFromString(s.rq.Properties["batch"], BlipDefaultBatchSize, BlipMinimumBatchSize, BlipMaximumBatchSize, true))
+}
+
+// batchTimeout returns how long a partial batch of changes may be held before it's sent, or zero if partial batches
+// aren't sent on a timer.
+func (s *SubChangesParams) batchTimeout() time.Duration {
+	timeoutMs := base.GetRestrictedIntFromString(s.rq.Properties[SubChangesBatchTimeout], 0, 0, BlipMaximumBatchTimeoutMs, true)
+	return time.Duration(timeoutMs) * time.Millisecond
 }
 
 func (s *SubChangesParams) continuous() bool {
@@ -231,6 +292,31 @@ func (s *SubChangesParams) revocations() bool {
 	return s.rq.Properties[SubChangesRevocations] == trueProperty
 }
 
+// creationsOnly returns true if the client only wants changes for the first revision of new documents.
+func (s *SubChangesParams) creationsOnly() bool {
+	return s.rq.Properties[SubChangesCreationsOnly] == trueProperty
+}
+
+// winningRevOnly returns true if the client only wants changes that change a document's winning revision.
+func (s *SubChangesParams) winningRevOnly() bool {
+	return s.rq.Properties[SubChangesWinningRevOnly] == trueProperty
+}
+
+// includeAttachments returns true if the client wants a summary of each revision's attachments in its changes row.
+func (s *SubChangesParams) includeAttachments() bool {
+	return s.rq.Properties[SubChangesIncludeAttachments] == trueProperty
+}
+
+// fields returns the JSON paths the client wants revision bodies projected to, if any.
+func (s *SubChangesParams) fields() ([][]string, error) {
+	return parseRevFields(s.rq.Properties[SubChangesFields])
+}
+
+// binaryEncoding returns true if the client has requested the binary changes encoding.
+func (s *SubChangesParams) binaryEncoding() bool {
+	return s.rq.Properties[SubChangesEncoding] == ChangesEncodingBinary
+}
+
 func (s *SubChangesParams) activeOnly() bool {
 	return (s.rq.Properties[SubChangesActiveOnly] == trueProperty)
 }
@@ -244,7 +330,12 @@ func (s *SubChangesParams) channels() (channels string, found bool) {
 	return channels, found
 }
 
+// channelsExpandedSet returns the channels to filter by.  Channels specified in the request body take precedence over
+// the comma-separated channels property.
 func (s *SubChangesParams) channelsExpandedSet() (resultChannels base.Set, err error) {
+	if len(s._channels) > 0 {
+		return channels.SetFromArray(s._channels, channels.ExpandStar)
+	}
 	channelsParam, found := s.rq.Properties[SubChangesChannels]
 	if !found {
 		return nil, fmt.Errorf("Missing 'channels' filter parameter")
@@ -269,6 +360,14 @@ func (s *SubChangesParams) String() string {
 		buffer.WriteString(fmt.Sprintf("ActiveOnly:%v ", activeOnly))
 	}
 
+	if s.creationsOnly() {
+		buffer.WriteString("CreationsOnly:true ")
+	}
+
+	if s.winningRevOnly() {
+		buffer.WriteString("WinningRevOnly:true ")
+	}
+
 	filter := s.filter()
 	if len(filter) > 0 {
 		buffer.WriteString(fmt.Sprintf("Filter:%v ", filter))
@@ -276,6 +375,13 @@ func (s *SubChangesParams) String() string {
 		if found {
 			buffer.WriteString(fmt.Sprintf("Channels:%v ", channels))
 		}
+		if len(s.bodyChannels()) > 0 {
+			buffer.WriteString(fmt.Sprintf("BodyChannels:%v ", s.bodyChannels()))
+		}
+	}
+
+	if len(s.bodyCollections()) > 0 {
+		buffer.WriteString(fmt.Sprintf("Collections:%v ", s.bodyCollections()))
 	}
 
 	batchSize := s.batchSize()
@@ -283,6 +389,10 @@ func (s *SubChangesParams) String() string {
 		buffer.WriteString(fmt.Sprintf("BatchSize:%v ", s.batchSize()))
 	}
 
+	if batchTimeout := s.batchTimeout(); batchTimeout > 0 {
+		buffer.WriteString(fmt.Sprintf("BatchTimeout:%v ", batchTimeout))
+	}
+
 	if len(s.docIDs()) > 0 {
 		buffer.WriteString(fmt.Sprintf("DocIDs:%v ", s.docIDs()))
 	}
@@ -523,8 +633,16 @@ func (g *getAttachmentParams) docID() string {
 	return g.rq.Properties[GetAttachmentID]
 }
 
+func (g *getAttachmentParams) encoding() string {
+	return g.rq.Properties[GetAttachmentEncoding]
+}
+
 func (g *getAttachmentParams) String() string {
-	return fmt.Sprintf("Digest:%v, DocID: %v ", g.digest(), base.UD(g.docID()))
+	buffer := bytes.NewBufferString(fmt.Sprintf("Digest:%v, DocID: %v ", g.digest(), base.UD(g.docID())))
+	if encoding := g.encoding(); encoding != "" {
+		buffer.WriteString(fmt.Sprintf("Encoding:%v ", encoding))
+	}
+	return buffer.String()
 }
 
 type IncludeConflictRevEntry struct {
@@ -542,6 +660,67 @@ func (b *GetCollectionsRequestBody) String() string {
 	return fmt.Sprintf("Collections: %v, CheckpointIds: %v", b.Collections, b.CheckpointIDs)
 }
 
+// BulkDelCheckpointRequestBody identifies the checkpoints to be removed by a bulkDelCheckpoint request, either as an
+// explicit list of client IDs, a client ID prefix, or both.
+type BulkDelCheckpointRequestBody struct {
+	Clients []string `json:"clients,omitempty"`
+	Prefix  string   `json:"prefix,omitempty"`
+}
+
+func (b *BulkDelCheckpointRequestBody) String() string {
+	return fmt.Sprintf("Clients: %v, Prefix: %q", b.Clients, b.Prefix)
+}
+
+// BulkDelCheckpointResponseBody is the body of a bulkDelCheckpoint response
+type BulkDelCheckpointResponseBody struct {
+	Deleted int `json:"deleted"`
+}
+
+// RevErrorResponseBody is the body of an error response to a rev message
+type RevErrorResponseBody struct {
+	Error         string `json:"error"`                     // HTTP status text of the Error-Code, e.g. "Forbidden"
+	Reason        string `json:"reason"`                    // Why the revision wasn't saved
+	SyncFnMessage string `json:"sync_fn_message,omitempty"` // Message the sync function rejected the revision with
+}
+
+// newRevErrorResponseBody returns the body of an error response to a rev message that failed with err
+func newRevErrorResponseBody(err error) RevErrorResponseBody {
+	status, msg := base.ErrorAsHTTPStatus(err)
+	body := RevErrorResponseBody{
+		Error:  http.StatusText(status),
+		Reason: msg,
+	}
+	var rejection *SyncFnRejectionError
+	if errors.As(err, &rejection) {
+		body.Reason = "rejected by sync function"
+		_, body.SyncFnMessage = base.ErrorAsHTTPStatus(rejection.Err)
+	}
+	return body
+}
+
+// GetDocChannelsResponseBody is the body of a getDocChannels response
+type GetDocChannelsResponseBody struct {
+	Channels []string `json:"channels"`
+}
+
+// GetRevTreeResponseBody is the body of a getRevTree response
+type GetRevTreeResponseBody struct {
+	CurrentRev string  `json:"rev"`     // The document's current revision
+	History    RevTree `json:"history"` // Every revision in the tree, including non-winning branches and tombstones
+}
+
+// GetServerSequenceResponseBody is the body of a getServerSequence response
+type GetServerSequenceResponseBody struct {
+	Sequence     uint64 `json:"sequence"`      // The database's latest sequence
+	UserSequence uint64 `json:"user_sequence"` // The sequence of the latest change visible to the user
+}
+
+// ListCollectionsResponseEntry describes one of the collections in a listCollections response
+type ListCollectionsResponseEntry struct {
+	Collection string `json:"collection"` // scope.collection name, as passed to getCollections
+	Sequence   uint64 `json:"sequence"`   // The sequence of the latest change in the collection visible to the user
+}
+
 // NewGetCollectionsMessage constructs a message request from a clientID provided by API, and keyspaces that match collections
 func NewGetCollectionsMessage(body GetCollectionsRequestBody) (*blip.Message, error) {
 	msg := blip.NewRequest()
@@ -61,6 +61,7 @@ type BlipSyncStats struct {
 	NumConnectAttempts               *base.SgwIntStat
 	NumReconnectsAborted             *base.SgwIntStat
 	NumHandlersPanicked              *base.SgwIntStat
+	NumKeepaliveTimeouts             *base.SgwIntStat // Connections closed for not responding to a keepalive
 }
 
 func NewBlipSyncStats() *BlipSyncStats {
@@ -109,6 +110,7 @@ func NewBlipSyncStats() *BlipSyncStats {
 		NumConnectAttempts:               &base.SgwIntStat{},
 		NumReconnectsAborted:             &base.SgwIntStat{},
 		NumHandlersPanicked:              &base.SgwIntStat{},
+		NumKeepaliveTimeouts:             &base.SgwIntStat{},
 	}
 }
 
@@ -33,6 +33,7 @@ const (
 	DefaultCachePendingSeqMaxWait = 5 * time.Second  // Max time we'll wait for a pending sequence before sending to missed queue
 	DefaultSkippedSeqMaxWait      = 60 * time.Minute // Max time we'll wait for an entry in the missing before purging
 	QueryTombstoneBatch           = 250              // Max number of tombstones checked per query during Compact
+	MinChangesPollIntervalMs      = 100              // Minimum configurable interval between polls for sequences not received from the feed
 )
 
 var SkippedSeqCleanViewBatch = 50 // Max number of sequences checked per query during CleanSkippedSequence.  Var to support testing
@@ -199,6 +200,14 @@ func (c *changeCache) Init(logCtx context.Context, dbcontext *DatabaseContext, n
 	}
 	c.backgroundTasks = append(c.backgroundTasks, bgt)
 
+	if pollInterval := dbcontext.Options.ChangesPollInterval; pollInterval > 0 {
+		bgt, err = NewBackgroundTask("PollForUnseenSequences", c.context.Name, c.PollForUnseenSequences, pollInterval, c.terminator)
+		if err != nil {
+			return err
+		}
+		c.backgroundTasks = append(c.backgroundTasks, bgt)
+	}
+
 	// Lock the cache -- not usable until .Start() called.  This fixes the DCP startup race condition documented in SG #3558.
 	c.lock.Lock()
 	return nil
@@ -395,6 +404,61 @@ func (c *changeCache) CleanSkippedSequenceQueue(ctx context.Context) error {
 	return nil
 }
 
+// Fallback for mutations that haven't arrived on the feed, invoked every ChangesPollInterval when set.
+// Compares the _sync:seq counter against the next sequence expected by the cache, and queries for any allocated
+// sequences the cache hasn't yet received.  Found entries are processed as if they'd arrived on the feed, which
+// notifies continuous changes feeds waiting on the affected channels.  At most SkippedSeqCleanViewBatch sequences
+// are queried per invocation.
+func (c *changeCache) PollForUnseenSequences(ctx context.Context) error {
+
+	lastSequence, err := c.context.sequences.getSequence()
+	if err != nil {
+		return err
+	}
+
+	c.lock.RLock()
+	unseenSequences := make([]uint64, 0)
+	for seq := c.nextSequence; seq <= lastSequence && len(unseenSequences) < SkippedSeqCleanViewBatch; seq++ {
+		if _, ok := c.receivedSeqs[seq]; !ok {
+			unseenSequences = append(unseenSequences, seq)
+		}
+	}
+	c.lock.RUnlock()
+
+	if len(unseenSequences) == 0 {
+		return nil
+	}
+
+	entries, err := c.context.getChangesForSequences(ctx, unseenSequences)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	base.DebugfCtx(ctx, base.KeyCache, "Poll found %d of %d sequences not yet received from the feed for database %s", len(entries), len(unseenSequences), base.MD(c.context.Name))
+
+	changedChannelsCombined := base.Set{}
+	for _, entry := range entries {
+		// As with skipped sequences, the query only returns the * channel, so the doc's channels need to be populated
+		doc, err := c.context.GetDocument(ctx, entry.DocID, DocUnmarshalNoHistory)
+		if err != nil {
+			base.InfofCtx(ctx, base.KeyCache, "Unable to retrieve doc %q found by poll for sequence %d - will wait for the feed: %v", base.UD(entry.DocID), entry.Sequence, err)
+			continue
+		}
+		entry.Channels = doc.Channels
+
+		changedChannels := c.processEntry(entry)
+		changedChannelsCombined = changedChannelsCombined.Update(changedChannels)
+	}
+
+	if c.notifyChange != nil && len(changedChannelsCombined) > 0 {
+		c.notifyChange(changedChannelsCombined)
+	}
+	return nil
+}
+
 // ////// ADDING CHANGES:
 
 // Note that DocChanged may be executed concurrently for multiple events (in the DCP case, DCP events
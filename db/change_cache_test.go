@@ -1325,6 +1325,57 @@ func TestSkippedViewRetrieval(t *testing.T) {
 
 }
 
+// Test that a mutation that never arrives on the feed is delivered to a continuous changes feed by polling, when
+// ChangesPollInterval is set.
+func TestPollForUnseenSequences(t *testing.T) {
+
+	if !base.UnitTestUrlIsWalrus() {
+		t.Skip("Skip test with LeakyBucket dependency test when running in integration")
+	}
+
+	base.SetUpTestLogging(t, base.LevelDebug, base.KeyCache, base.KeyChanges)
+
+	// Use leaky bucket to have the feed 'lose' the mutation for doc-missed
+	leakyConfig := base.LeakyBucketConfig{
+		TapFeedMissingDocs: []string{"doc-missed"},
+	}
+	pollInterval := 100 * time.Millisecond
+	ctx := base.TestCtx(t)
+	dbcOptions := DatabaseContextOptions{
+		ChangesPollInterval: pollInterval,
+	}
+	AddOptionsFromEnvironmentVariables(&dbcOptions)
+	testBucket := base.GetTestBucket(t)
+	dbCtx, err := NewDatabaseContext(ctx, "db", base.NewLeakyBucket(testBucket, leakyConfig), false, dbcOptions)
+	require.NoError(t, err)
+	db, err := CreateDatabase(dbCtx)
+	require.NoError(t, err)
+	defer db.Close(ctx)
+	db.ChannelMapper = channels.NewDefaultChannelMapper()
+
+	var options ChangesOptions
+	changesCtx, changesCtxCancel := context.WithCancel(context.Background())
+	defer changesCtxCancel()
+	options.ChangesCtx = changesCtx
+	options.Continuous = true
+	options.Wait = true
+	feed, err := db.MultiChangesFeed(ctx, base.SetOf("ABC"), options)
+	require.NoError(t, err)
+
+	_, _, err = db.Put(ctx, "doc-missed", Body{"channels": "ABC"})
+	require.NoError(t, err)
+
+	// The feed never notifies for doc-missed, so only the poll can deliver it
+	deadline := time.Now().Add(10 * pollInterval)
+	for {
+		entry, err := readNextFromFeed(feed, time.Until(deadline))
+		require.NoError(t, err, "doc-missed wasn't delivered within %v of poll interval %v", 10*pollInterval, pollInterval)
+		if entry != nil && entry.ID == "doc-missed" {
+			break
+		}
+	}
+}
+
 // Test that housekeeping goroutines get terminated when change cache is stopped
 func TestStopChangeCache(t *testing.T) {
 
@@ -53,6 +53,7 @@ type ChangeEntry struct {
 	Err          error           `json:"err,omitempty"` // Used to notify feed consumer of errors
 	allRemoved   bool            // Flag to track whether an entry is a removal in all channels visible to the user.
 	branched     bool
+	hidden       bool         // Flag to track whether the entry is for a non-winning revision (hidden by a conflict)
 	backfill     backfillFlag // Flag used to identify non-client entries used for backfill synchronization (di only)
 	principalDoc bool         // Used to indicate _user/_role docs
 	Revoked      bool         `json:"revoked,omitempty"`
@@ -466,6 +467,7 @@ func makeChangeEntry(logEntry *LogEntry, seqID SequenceID, channelName string) C
 		Deleted:      (logEntry.Flags & channels.Deleted) != 0,
 		Changes:      []ChangeRev{{"rev": logEntry.RevID}},
 		branched:     (logEntry.Flags & channels.Branched) != 0,
+		hidden:       (logEntry.Flags & channels.Hidden) != 0,
 		principalDoc: logEntry.IsPrincipal,
 	}
 
@@ -1080,6 +1082,39 @@ func (db *Database) GetChangeLog(channelName string, afterSeq uint64) (entries [
 	return db.changeCache.getChannelCache().GetCachedChanges(channelName)
 }
 
+// LastSequenceForUser returns the sequence of the most recent change visible to the database's user.  That's the
+// highest of the latest change in each of the user's channels, the sequence each channel was granted at, and the
+// sequence of the user itself.  Returns the database's last sequence when there's no user, or the user has access to
+// all channels.
+func (db *Database) LastSequenceForUser(ctx context.Context) (uint64, error) {
+	lastSeq, err := db.LastSequence()
+	if err != nil || db.user == nil {
+		return lastSeq, err
+	}
+
+	userChannels := db.user.InheritedChannels()
+	if userChannels.Contains(channels.UserStarChannel) {
+		return lastSeq, nil
+	}
+
+	userSeq := db.user.Sequence()
+	for channelName, grant := range userChannels {
+		if grant.Sequence > userSeq {
+			userSeq = grant.Sequence
+		}
+		// Only changes after the highest sequence found so far are of interest
+		options := ChangesOptions{Since: SequenceID{Seq: userSeq}, LoggingCtx: ctx, ChangesCtx: ctx}
+		changes, err := db.changeCache.GetChanges(channelName, options)
+		if err != nil {
+			return 0, err
+		}
+		if len(changes) > 0 && changes[len(changes)-1].Sequence > userSeq {
+			userSeq = changes[len(changes)-1].Sequence
+		}
+	}
+	return userSeq, nil
+}
+
 // WaitForSequenceNotSkipped blocks until the given sequence has been received or skipped by the change cache.
 func (dbc *DatabaseContext) WaitForSequence(ctx context.Context, sequence uint64) (err error) {
 	base.DebugfCtx(ctx, base.KeyChanges, "Waiting for sequence: %d", sequence)
@@ -34,6 +34,10 @@ const (
 var ErrForbidden = base.HTTPErrorf(403, "forbidden")
 
 var ErrMissing = base.HTTPErrorf(404, "missing")
+
+// ErrImportConflict is returned when a pushed revision conflicts with a revision created by importing a concurrent
+// update made outside of Sync Gateway, as opposed to a revision written through Sync Gateway.
+var ErrImportConflict = base.HTTPErrorf(http.StatusConflict, "Document revision conflicts with an imported update")
 var ErrDeleted = base.HTTPErrorf(404, "deleted")
 
 // ////// READING DOCUMENTS:
@@ -416,6 +420,14 @@ func (db *Database) GetDelta(ctx context.Context, docID, fromRevID, toRevID stri
 			toBodyCopy[BodyAttachments] = map[string]interface{}(toRevision.Attachments)
 		}
 
+		// Likewise for _meta, so the client applying the delta ends up with the target revision's _meta
+		if len(fromRevision.ClientMeta) > 0 {
+			fromBodyCopy[BodyClientMeta] = map[string]interface{}(fromRevision.ClientMeta)
+		}
+		if len(toRevision.ClientMeta) > 0 {
+			toBodyCopy[BodyClientMeta] = map[string]interface{}(toRevision.ClientMeta)
+		}
+
 		deltaBytes, err := base.Diff(fromBodyCopy, toBodyCopy)
 		if err != nil {
 			return nil, nil, err
@@ -500,18 +512,19 @@ func (db *Database) authorizeDoc(doc *Document, revid string) error {
 
 // Gets a revision of a document. If it's obsolete it will be loaded from the database if possible.
 // inline "_attachments" properties in the body will be extracted and returned separately if present (pre-2.5 metadata, or backup revisions)
-func (db *DatabaseContext) getRevision(ctx context.Context, doc *Document, revid string) (bodyBytes []byte, body Body, attachments AttachmentsMeta, err error) {
+// inline "_meta" properties stamped into non-winning or backup revision bodies are also extracted and returned separately.
+func (db *DatabaseContext) getRevision(ctx context.Context, doc *Document, revid string) (bodyBytes []byte, body Body, attachments AttachmentsMeta, clientMeta Body, err error) {
 	bodyBytes = doc.getRevisionBodyJSON(ctx, revid, db.RevisionBodyLoader)
 
 	// No inline body, so look for separate doc:
 	if bodyBytes == nil {
 		if !doc.History.contains(revid) {
-			return nil, nil, nil, ErrMissing
+			return nil, nil, nil, nil, ErrMissing
 		}
 
 		bodyBytes, err = db.getOldRevisionJSON(ctx, doc.ID, revid)
 		if err != nil || bodyBytes == nil {
-			return nil, nil, nil, err
+			return nil, nil, nil, nil, err
 		}
 	}
 
@@ -519,11 +532,12 @@ func (db *DatabaseContext) getRevision(ctx context.Context, doc *Document, revid
 	if doc.CurrentRev == revid {
 		body = doc._body
 		attachments = doc.Attachments
+		clientMeta = doc.ClientMeta
 	}
 
 	// handle backup revision inline attachments, or pre-2.5 meta
 	if inlineAtts, cleanBodyBytes, cleanBody, err := extractInlineAttachments(bodyBytes); err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	} else if len(inlineAtts) > 0 {
 		// we found some inline attachments, so merge them with attachments, and update the bodies
 		attachments = mergeAttachments(inlineAtts, attachments)
@@ -531,7 +545,16 @@ func (db *DatabaseContext) getRevision(ctx context.Context, doc *Document, revid
 		body = cleanBody
 	}
 
-	return bodyBytes, body, attachments, nil
+	// handle non-winning or backup revision inline _meta
+	if inlineClientMeta, cleanBodyBytes, cleanBody, err := extractInlineClientMeta(bodyBytes); err != nil {
+		return nil, nil, nil, nil, err
+	} else if inlineClientMeta != nil {
+		clientMeta = inlineClientMeta
+		bodyBytes = cleanBodyBytes
+		body = cleanBody
+	}
+
+	return bodyBytes, body, attachments, clientMeta, nil
 }
 
 // mergeAttachments copies the docAttachments map, and merges pre25Attachments into it.
@@ -616,6 +639,32 @@ func extractInlineAttachments(bodyBytes []byte) (attachments AttachmentsMeta, cl
 	return attsMap, bodyBytes, body, nil
 }
 
+// extractInlineClientMeta moves any "_meta" stamped into a non-winning or backup revision body, along with a "cleaned" version of bodyBytes and body.
+func extractInlineClientMeta(bodyBytes []byte) (clientMeta Body, cleanBodyBytes []byte, cleanBody Body, err error) {
+	if !bytes.Contains(bodyBytes, []byte(`"`+BodyClientMeta+`"`)) {
+		return nil, bodyBytes, nil, nil
+	}
+
+	var body Body
+	if err = body.Unmarshal(bodyBytes); err != nil {
+		return nil, nil, nil, err
+	}
+
+	metaMap, ok := body[BodyClientMeta].(map[string]interface{})
+	if !ok {
+		// no _meta object found (in a top-level property)
+		return nil, bodyBytes, body, nil
+	}
+
+	delete(body, BodyClientMeta)
+	bodyBytes, err = base.JSONMarshal(body)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return metaMap, bodyBytes, body, nil
+}
+
 // Gets the body of a revision's nearest ancestor, as raw JSON (without _id or _rev.)
 // If no ancestor has any JSON, returns nil but no error.
 func (db *Database) getAncestorJSON(ctx context.Context, doc *Document, revid string) ([]byte, error) {
@@ -656,7 +705,7 @@ func (db *Database) get1xRevFromDoc(ctx context.Context, doc *Document, revid st
 				return nil, false, ErrDeleted
 			}
 		}
-		if bodyBytes, _, attachments, err = db.getRevision(ctx, doc, revid); err != nil {
+		if bodyBytes, _, attachments, _, err = db.getRevision(ctx, doc, revid); err != nil {
 			return nil, false, err
 		}
 	}
@@ -693,7 +742,7 @@ func (db *Database) get1xRevFromDoc(ctx context.Context, doc *Document, revid st
 // Returns the body and rev ID of the asked-for revision or the most recent available ancestor.
 func (db *Database) getAvailableRev(ctx context.Context, doc *Document, revid string) ([]byte, string, AttachmentsMeta, error) {
 	for ; revid != ""; revid = doc.History[revid].Parent {
-		if bodyBytes, _, attachments, _ := db.getRevision(ctx, doc, revid); bodyBytes != nil {
+		if bodyBytes, _, attachments, _, _ := db.getRevision(ctx, doc, revid); bodyBytes != nil {
 			return bodyBytes, revid, attachments, nil
 		}
 	}
@@ -753,7 +802,7 @@ func (db *Database) backupAncestorRevs(ctx context.Context, doc *Document, newDo
 	for {
 		if ancestorRevId = doc.History.getParent(ancestorRevId); ancestorRevId == "" {
 			// No ancestors with JSON found.  Check if we need to back up current rev for delta sync, then return
-			db.backupRevisionJSON(ctx, doc.ID, newDoc.RevID, "", newBodyBytes, nil, doc.Attachments)
+			db.backupRevisionJSON(ctx, doc.ID, newDoc.RevID, "", newBodyBytes, nil, doc.Attachments, newDoc.DocClientMeta)
 			return
 		} else if json = doc.getRevisionBodyJSON(ctx, ancestorRevId, db.RevisionBodyLoader); json != nil {
 			break
@@ -761,7 +810,7 @@ func (db *Database) backupAncestorRevs(ctx context.Context, doc *Document, newDo
 	}
 
 	// Back up the revision JSON as a separate doc in the bucket:
-	db.backupRevisionJSON(ctx, doc.ID, newDoc.RevID, ancestorRevId, newBodyBytes, json, doc.Attachments)
+	db.backupRevisionJSON(ctx, doc.ID, newDoc.RevID, ancestorRevId, newBodyBytes, json, doc.Attachments, newDoc.DocClientMeta)
 
 	// Nil out the ancestor rev's body in the document struct:
 	if ancestorRevId == doc.CurrentRev {
@@ -963,6 +1012,9 @@ func (db *Database) PutExistingRevWithConflictResolution(ctx context.Context, ne
 	}
 
 	allowImport := db.UseXattrs()
+	// Set once the existing doc has been imported for this write, which persists over retries of the update callback
+	// as the import itself triggers a retry.
+	importedForWrite := false
 	doc, _, err = db.updateAndReturnDoc(ctx, newDoc.ID, allowImport, newDoc.DocExpiry, nil, existingDoc, func(doc *Document) (resultDoc *Document, resultAttachmentData AttachmentData, createNewRevIDSkipped bool, updatedExpiry *uint32, resultErr error) {
 		// (Be careful: this block can be invoked multiple times if there are races!)
 
@@ -983,6 +1035,7 @@ func (db *Database) PutExistingRevWithConflictResolution(ctx context.Context, ne
 			if err != nil {
 				return nil, nil, false, nil, err
 			}
+			importedForWrite = true
 		}
 
 		// Find the point where this doc's history branches from the current rev:
@@ -1008,6 +1061,10 @@ func (db *Database) PutExistingRevWithConflictResolution(ctx context.Context, ne
 
 		if !allowConflictingTombstone && db.IsIllegalConflict(ctx, doc, parent, newDoc.Deleted, noConflicts, docHistory) {
 			if conflictResolver == nil {
+				if importedForWrite {
+					base.InfofCtx(ctx, base.KeyCRUD, "Rev %s for doc %s conflicts with an update imported during the write", newRev, base.UD(newDoc.ID))
+					return nil, nil, false, nil, ErrImportConflict
+				}
 				return nil, nil, false, nil, base.HTTPErrorf(http.StatusConflict, "Document revision conflict")
 			}
 			_, updatedHistory, err := db.resolveConflict(ctx, doc, newDoc, docHistory, conflictResolver)
@@ -1408,7 +1465,12 @@ func (db *Database) storeOldBodyInRevTreeAndUpdateCurrent(ctx context.Context, d
 			kvPairs = append(kvPairs, base.KVPair{Key: BodyDeleted, Val: true})
 		}
 
-		// Stamp _attachments and _deleted into rev tree bodies
+		// SyncData.ClientMeta belongs to the previous winner, so move it into the old body along with it
+		if len(doc.SyncData.ClientMeta) > 0 {
+			kvPairs = append(kvPairs, base.KVPair{Key: BodyClientMeta, Val: doc.SyncData.ClientMeta})
+		}
+
+		// Stamp _attachments, _deleted and _meta into rev tree bodies
 		oldBodyJson, marshalErr = base.InjectJSONProperties(oldBodyJson, kvPairs...)
 		if marshalErr != nil {
 			base.WarnfCtx(ctx, "Unable to marshal document body properties for storage in rev tree: %v", marshalErr)
@@ -1416,17 +1478,31 @@ func (db *Database) storeOldBodyInRevTreeAndUpdateCurrent(ctx context.Context, d
 		doc.setNonWinningRevisionBody(prevCurrentRev, oldBodyJson, db.AllowExternalRevBodyStorage(), oldDocHasAttachments)
 	}
 	// Store the new revision body into the doc:
-	doc.setRevisionBody(newRevID, newDoc, db.AllowExternalRevBodyStorage(), newDocHasAttachments)
+	if doc.CurrentRev != newRevID && len(newDoc.DocClientMeta) > 0 {
+		// A non-winning revision's _meta is kept in its rev tree body, as SyncData.ClientMeta belongs to the winner
+		bodyBytes, err := newDoc.BodyBytes()
+		if err == nil {
+			bodyBytes, err = base.InjectJSONProperties(bodyBytes, base.KVPair{Key: BodyClientMeta, Val: newDoc.DocClientMeta})
+		}
+		if err != nil {
+			base.WarnfCtx(ctx, "Unable to marshal document body properties for storage in rev tree: %v", err)
+		}
+		doc.setNonWinningRevisionBody(newRevID, bodyBytes, db.AllowExternalRevBodyStorage(), newDocHasAttachments)
+	} else {
+		doc.setRevisionBody(newRevID, newDoc, db.AllowExternalRevBodyStorage(), newDocHasAttachments)
+	}
 	doc.SyncData.Attachments = newDoc.DocAttachments
 
 	if doc.CurrentRev == newRevID {
 		doc.NewestRev = ""
 		doc.setFlag(channels.Hidden, false)
+		doc.SyncData.ClientMeta = newDoc.DocClientMeta
 	} else {
 		doc.NewestRev = newRevID
 		doc.setFlag(channels.Hidden, true)
 		if doc.CurrentRev != prevCurrentRev {
 			doc.promoteNonWinningRevisionBody(doc.CurrentRev, db.RevisionBodyLoader)
+			doc.promoteNonWinningClientMeta()
 		}
 	}
 }
@@ -1914,6 +1990,10 @@ func (db *Database) updateAndReturnDoc(ctx context.Context, docid string, allowI
 		return nil, "", err
 	}
 
+	if prevCurrentRev == "" {
+		db.incrDocCount(ctx, base.DocCountCreatedKey)
+	}
+
 	db.DbStats.Database().NumDocWrites.Add(1)
 	db.DbStats.Database().DocWritesBytes.Add(int64(docBytes))
 	db.DbStats.Database().DocWritesXattrBytes.Add(int64(xattrBytes))
@@ -1943,6 +2023,7 @@ func (db *Database) updateAndReturnDoc(ctx context.Context, docid string, allowI
 			Channels:         revChannels,
 			Attachments:      doc.Attachments,
 			Expiry:           doc.Expiry,
+			ClientMeta:       doc.ClientMeta,
 			Deleted:          doc.History[newRevID].Deleted,
 			_shallowCopyBody: storedDoc.Body(),
 		}
@@ -2029,7 +2110,7 @@ func getAttachmentIDsForLeafRevisions(ctx context.Context, db *Database, doc *Do
 	})
 
 	for _, leafRevision := range documentLeafRevisions {
-		_, _, attachmentMeta, err := db.getRevision(ctx, doc, leafRevision)
+		_, _, attachmentMeta, _, err := db.getRevision(ctx, doc, leafRevision)
 		if err != nil {
 			return nil, err
 		}
@@ -2187,10 +2268,15 @@ func (db *Database) Purge(ctx context.Context, key string) error {
 	}
 
 	if db.UseXattrs() {
-		return db.Bucket.DeleteWithXattr(key, base.SyncXattrName)
+		err = db.Bucket.DeleteWithXattr(key, base.SyncXattrName)
 	} else {
-		return db.Bucket.Delete(key)
+		err = db.Bucket.Delete(key)
 	}
+	if err != nil {
+		return err
+	}
+	db.incrDocCount(ctx, base.DocCountPurgedKey)
+	return nil
 }
 
 // ////// CHANNELS:
@@ -2242,6 +2328,7 @@ func (db *Database) getChannelsAndAccess(ctx context.Context, doc *Document, bod
 				if isAccessError(err) {
 					db.DbStats.Security().NumAccessErrors.Add(1)
 				}
+				err = &SyncFnRejectionError{Err: err}
 			} else if !validateAccessMap(access) || !validateRoleAccessMap(roles) {
 				err = base.HTTPErrorf(500, "Error in JS sync function")
 			}
@@ -2312,6 +2399,25 @@ func isAccessError(err error) bool {
 	return base.ContainsString(base.SyncFnAccessErrors, err.Error())
 }
 
+// SyncFnRejectionError is returned when the sync function rejects a revision, either by throwing or by failing one of
+// the require* checks.  Err is the HTTP error the revision was rejected with.
+type SyncFnRejectionError struct {
+	Err error
+}
+
+func (e *SyncFnRejectionError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause allows base.ErrorAsHTTPStatus to map the rejection to its HTTP status
+func (e *SyncFnRejectionError) Cause() error {
+	return e.Err
+}
+
+func (e *SyncFnRejectionError) Unwrap() error {
+	return e.Err
+}
+
 // Recomputes the set of channels a User/Role has been granted access to by sync() functions.
 // This is part of the ChannelComputer interface defined by the Authenticator.
 func (context *DatabaseContext) ComputeChannelsForPrincipal(ctx context.Context, princ auth.Principal) (channels.TimedSet, error) {
@@ -2453,10 +2559,11 @@ func (db *Database) RevDiff(ctx context.Context, docid string, revids []string)
 type ProposedRevStatus int
 
 const (
-	ProposedRev_OK       ProposedRevStatus = 0   // Rev can be added without conflict
-	ProposedRev_Exists   ProposedRevStatus = 304 // Rev already exists locally
-	ProposedRev_Conflict ProposedRevStatus = 409 // Rev would cause conflict
-	ProposedRev_Error    ProposedRevStatus = 500 // Error occurred reading local doc
+	ProposedRev_OK        ProposedRevStatus = 0   // Rev can be added without conflict
+	ProposedRev_Exists    ProposedRevStatus = 304 // Rev already exists locally
+	ProposedRev_Conflict  ProposedRevStatus = 409 // Rev would cause conflict
+	ProposedRev_Forbidden ProposedRevStatus = 403 // Rev would cause conflict, and the client isn't permitted to force it
+	ProposedRev_Error     ProposedRevStatus = 500 // Error occurred reading local doc
 )
 
 // Given a docID/revID to be pushed by a client, check whether it can be added _without conflict_.
@@ -2473,6 +2580,10 @@ func (db *Database) CheckProposedRev(ctx context.Context, docid string, revid st
 	} else if doc.CurrentRev == revid {
 		// Proposed rev already exists here:
 		return ProposedRev_Exists, ""
+	} else if doc.History.contains(revid) {
+		// Proposed rev is an ancestor of the current revision, or another branch, that already exists here.  The
+		// client is behind rather than in conflict, so there's no need for it to send the rev:
+		return ProposedRev_Exists, ""
 	} else if doc.CurrentRev == parentRevID {
 		// Proposed rev's parent is my current revision; OK to add:
 		return ProposedRev_OK, ""
@@ -123,6 +123,7 @@ type DatabaseContext struct {
 	userFunctions                UserFunctions            // client-callable JavaScript functions
 	graphQL                      *GraphQL                 // GraphQL query evaluator
 	Scopes                       map[string]Scope         // A map keyed by scope name containing a set of scopes/collections. Nil if running with only _default._default
+	activeBlipSyncContexts       activeBlipSyncContexts   // Client replication connections, reported by ActiveBlipReplications
 }
 
 type Scope struct {
@@ -164,8 +165,18 @@ type DatabaseContextOptions struct {
 	ClientPartitionWindow         time.Duration
 	BcryptCost                    int
 	GroupID                       string
-	JavascriptTimeout             time.Duration // Max time the JS functions run for (ie. sync fn, import filter)
-	Serverless                    bool          // If running in serverless mode
+	JavascriptTimeout             time.Duration  // Max time the JS functions run for (ie. sync fn, import filter)
+	Serverless                    bool           // If running in serverless mode
+	ChangesPollInterval           time.Duration  // If non-zero, the change cache polls for sequences not received from the feed at this interval
+	DocIDPattern                  *regexp.Regexp // If set, docIDs of revs pushed by clients must match this pattern
+	DCPProgressLogInterval        time.Duration  // If non-zero, DCP feeds for import and attachment compaction log aggregate progress at this interval
+	DocCountQuota                 uint64         // If non-zero, new docs pushed by clients are rejected once the database holds this many docs
+	BlipCompressionThreshold      int            // BLIP messages with bodies smaller than this many bytes are sent uncompressed
+	TombstoneTTL                  time.Duration  // If non-zero, tombstones older than this are purged by tombstone compaction, instead of using the server's metadata purge interval
+	MaxDocumentSize               int            // If non-zero, revs pushed by clients with bodies larger than this many bytes are rejected
+	MaxChangesMessageSize         int            // If non-zero, batches of changes are split into changes messages with bodies of at most this many bytes
+	BlipKeepaliveInterval         time.Duration  // If non-zero, keepalive requests are sent on continuous replications that have been idle for this long
+	BlipKeepaliveTimeout          time.Duration  // Connections that don't respond to a keepalive request within this long are closed.  Defaults to DefaultBlipKeepaliveTimeout
 	Scopes                        ScopesOptions
 	skipRegisterImportPIndex      bool // if set, skips the global gocb PIndex registration
 }
@@ -576,7 +587,9 @@ func NewDatabaseContext(ctx context.Context, dbName string, bucket base.Bucket,
 		// Set the purge interval for tombstone compaction
 		dbContext.PurgeInterval = DefaultPurgeInterval
 		cbStore, ok := base.AsCouchbaseStore(bucket)
-		if ok {
+		if options.TombstoneTTL > 0 {
+			dbContext.PurgeInterval = options.TombstoneTTL
+		} else if ok {
 			serverPurgeInterval, err := cbStore.MetadataPurgeInterval()
 			if err != nil {
 				base.WarnfCtx(ctx, "Unable to retrieve server's metadata purge interval - will use default value. %s", err)
@@ -1283,8 +1296,8 @@ func (db *Database) Compact(ctx context.Context, skipRunningStateCheck bool, cal
 
 	base.InfofCtx(ctx, base.KeyAll, "Starting compaction of purged tombstones for %s ...", base.MD(db.Name))
 
-	// Update metadata purge interval if not explicitly set to 0 (used in testing)
-	if db.PurgeInterval > 0 {
+	// Update metadata purge interval if not explicitly set to 0 (used in testing), or configured via the tombstone TTL
+	if db.PurgeInterval > 0 && db.Options.TombstoneTTL == 0 {
 		cbStore, ok := base.AsCouchbaseStore(db.Bucket)
 		if ok {
 			serverPurgeInterval, err := cbStore.MetadataPurgeInterval()
@@ -1827,6 +1840,7 @@ func initDatabaseStats(dbName string, autoImport bool, options DatabaseContextOp
 			QueryTypeResync,
 			QueryTypeAllDocs,
 			QueryTypeUsers,
+			QueryTypeLocalCheckpoints,
 		}
 	}
 	for name, _ := range options.UserQueries {
@@ -0,0 +1,73 @@
+//  Copyright 2023-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package db
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/couchbase/sync_gateway/base"
+)
+
+// ErrDocCountQuotaExceeded is returned when a client pushes a new document to a database that has reached its doc count quota.
+var ErrDocCountQuotaExceeded = base.HTTPErrorf(http.StatusInsufficientStorage, "Document count quota exceeded")
+
+// The number of documents is tracked as a pair of counters for documents created and purged, so that both can be
+// maintained with atomic increments.  Documents written while no quota is configured aren't counted.
+
+// docCount returns the number of documents counted towards the doc count quota.
+func (db *Database) docCount() (uint64, error) {
+	created, err := base.GetCounter(db.Bucket, base.DocCountCreatedKey)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := base.GetCounter(db.Bucket, base.DocCountPurgedKey)
+	if err != nil {
+		return 0, err
+	}
+	if purged > created {
+		return 0, nil
+	}
+	return created - purged, nil
+}
+
+// incrDocCount increments the given doc count counter when a doc count quota is configured.  Failures are logged
+// rather than failing the write, as the document has already been persisted.
+func (db *Database) incrDocCount(ctx context.Context, key string) {
+	if db.Options.DocCountQuota == 0 {
+		return
+	}
+	if _, err := db.Bucket.Incr(key, 1, 1, 0); err != nil {
+		base.WarnfCtx(ctx, "Unable to update doc count quota counter %s: %v", key, err)
+	}
+}
+
+// checkDocCountQuota returns ErrDocCountQuotaExceeded if the database has reached its doc count quota and docID
+// doesn't already exist.  Updates to existing documents are always permitted.
+func (db *Database) checkDocCountQuota(ctx context.Context, docID string) error {
+	quota := db.Options.DocCountQuota
+	if quota == 0 {
+		return nil
+	}
+	count, err := db.docCount()
+	if err != nil {
+		return err
+	}
+	if count < quota {
+		return nil
+	}
+	_, err = db.GetDocSyncData(ctx, docID)
+	if err == nil {
+		return nil
+	} else if !base.IsDocNotFoundError(err) {
+		return err
+	}
+	base.InfofCtx(ctx, base.KeyCRUD, "Rejecting new doc %s: doc count quota of %d reached", base.UD(docID), quota)
+	return ErrDocCountQuotaExceeded
+}
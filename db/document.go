@@ -78,6 +78,7 @@ type SyncData struct {
 	Crc32cUserXattr   string              `json:"user_xattr_value_crc32c,omitempty"` // String representation of crc32c hash of user xattr
 	TombstonedAt      int64               `json:"tombstoned_at,omitempty"`           // Time the document was tombstoned.  Used for view compaction
 	Attachments       AttachmentsMeta     `json:"attachments,omitempty"`
+	ClientMeta        Body                `json:"client_meta,omitempty"` // Client-supplied _meta of the current revision, returned on pull but not indexed or seen by the sync function
 	ChannelSet        []ChannelSetEntry   `json:"channel_set"`
 	ChannelSetHistory []ChannelSetEntry   `json:"channel_set_history"`
 
@@ -174,6 +175,7 @@ type Document struct {
 	DocExpiry      uint32
 	RevID          string
 	DocAttachments AttachmentsMeta
+	DocClientMeta  Body
 	inlineSyncData bool
 }
 
@@ -727,6 +729,21 @@ func (doc *Document) promoteNonWinningRevisionBody(revid string, loader RevLoade
 	doc.removeRevisionBody(revid)
 }
 
+// promoteNonWinningClientMeta moves any _meta stamped into a newly promoted revision body into SyncData.ClientMeta.
+// Must be called after promoteNonWinningRevisionBody.
+func (doc *Document) promoteNonWinningClientMeta() {
+	doc.SyncData.ClientMeta = nil
+	if !doc.HasBody() {
+		return
+	}
+	body := doc.Body()
+	if clientMeta, ok := body[BodyClientMeta].(map[string]interface{}); ok {
+		doc.SyncData.ClientMeta = clientMeta
+		delete(body, BodyClientMeta)
+		doc.UpdateBody(body)
+	}
+}
+
 func (doc *Document) pruneRevisions(maxDepth uint32, keepRev string) int {
 	numPruned, prunedTombstoneBodyKeys := doc.History.pruneRevisions(maxDepth, keepRev)
 	for revID, bodyKey := range prunedTombstoneBodyKeys {
@@ -320,6 +320,9 @@ func (db *Database) importDoc(ctx context.Context, docid string, body Body, expi
 			newDoc.DocAttachments = doc.SyncData.Attachments
 		}
 
+		// Client metadata isn't part of the imported body, so carry it over from the existing sync metadata
+		newDoc.DocClientMeta = doc.SyncData.ClientMeta
+
 		return newDoc, nil, !shouldGenerateNewRev, updatedExpiry, nil
 	})
 
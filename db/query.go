@@ -15,6 +15,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"net/http"
 	"strings"
 	"time"
 
@@ -41,6 +42,7 @@ const (
 	QueryTypeResync              = "resync"
 	QueryTypeAllDocs             = "allDocs"
 	QueryTypeUsers               = "users"
+	QueryTypeLocalCheckpoints    = "localCheckpoints"
 	QueryTypeUserPrefix          = "userquery:" // Prefix applied to named user queries from config file
 )
 
@@ -235,6 +237,23 @@ var QuerySessions = SGQuery{
 		base.KeyspaceQueryAlias, `\\_sync:session:%`),
 	adhoc: false,
 }
+
+// QueryLocalCheckpoints returns the IDs of the local checkpoint docs with keys matching $keyPrefix, using the syncDocs index
+var QueryLocalCheckpoints = SGQuery{
+	name: QueryTypeLocalCheckpoints,
+	statement: fmt.Sprintf(
+		"SELECT META(%s).id "+
+			"FROM %s AS %s "+
+			"USE INDEX($idx) "+
+			"WHERE META(%s).id LIKE '%s' "+
+			"AND META(%s).id LIKE $%s",
+		base.KeyspaceQueryAlias,
+		base.KeyspaceQueryToken, base.KeyspaceQueryAlias,
+		base.KeyspaceQueryAlias, SyncDocWildcard,
+		base.KeyspaceQueryAlias, QueryParamKeyPrefix),
+	adhoc: false,
+}
+
 var QueryTombstones = SGQuery{
 	name: QueryTypeTombstones,
 	statement: fmt.Sprintf(
@@ -285,6 +304,7 @@ const (
 	QueryParamStartKey    = "startkey"
 	QueryParamEndKey      = "endkey"
 	QueryParamLimit       = "limit"
+	QueryParamKeyPrefix   = "keyPrefix"
 
 	// Variables in the select clause can't be parameterized, require additional handling
 	QuerySelectUserName = "$$selectUserName"
@@ -579,6 +599,23 @@ func (context *DatabaseContext) QuerySessions(ctx context.Context, userName stri
 	return context.N1QLQueryWithStats(ctx, QueryTypeSessions, queryStatement, params, base.RequestPlus, QuerySessions.adhoc)
 }
 
+// QueryLocalCheckpoints returns the IDs of local checkpoint documents for clients whose ID starts with clientIDPrefix.
+// Checkpoints aren't indexed by views, so this is only supported for databases using GSI.
+func (context *DatabaseContext) QueryLocalCheckpoints(ctx context.Context, clientIDPrefix string) (sgbucket.QueryResultIterator, error) {
+
+	if context.Options.UseViews {
+		return nil, base.HTTPErrorf(http.StatusNotImplemented, "Querying checkpoints by prefix is not supported when using views")
+	}
+
+	queryStatement := replaceIndexTokensQuery(QueryLocalCheckpoints.statement, sgIndexes[IndexSyncDocs], context.UseXattrs())
+
+	likeEscaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	params := map[string]interface{}{
+		QueryParamKeyPrefix: likeEscaper.Replace(RealSpecialDocID(DocTypeLocal, CheckpointDocIDPrefix+clientIDPrefix)) + "%",
+	}
+	return context.N1QLQueryWithStats(ctx, QueryTypeLocalCheckpoints, queryStatement, params, base.RequestPlus, QueryLocalCheckpoints.adhoc)
+}
+
 type AllDocsViewQueryRow struct {
 	Key   string
 	Value struct {
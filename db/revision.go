@@ -33,6 +33,7 @@ const (
 	BodyPurged         = "_purged"
 	BodyExpiry         = "_exp"
 	BodyRemoved        = "_removed"
+	BodyClientMeta     = "_meta"  // Client metadata, stored with the sync metadata rather than in the document body
 	BodyInternalPrefix = "_sync_" // New internal properties prefix (CBG-1995)
 )
 
@@ -254,7 +255,7 @@ func (db *DatabaseContext) getOldRevisionJSON(ctx context.Context, docid string,
 //	   - new revision stored (as duplicate), with expiry rev_max_age_seconds
 //	delta=true && shared_bucket_access=false
 //	   - old revision stored, with expiry rev_max_age_seconds
-func (db *Database) backupRevisionJSON(ctx context.Context, docId, newRevId, oldRevId string, newBody []byte, oldBody []byte, newAtts AttachmentsMeta) {
+func (db *Database) backupRevisionJSON(ctx context.Context, docId, newRevId, oldRevId string, newBody []byte, oldBody []byte, newAtts AttachmentsMeta, newClientMeta Body) {
 
 	// Without delta sync, store the old rev for in-flight replication purposes
 	if !db.DeltaSyncEnabled() || db.Options.DeltaSyncOptions.RevMaxAgeSeconds == 0 {
@@ -270,12 +271,16 @@ func (db *Database) backupRevisionJSON(ctx context.Context, docId, newRevId, old
 	if db.UseXattrs() {
 		// Backup the current revision
 		var newBodyWithAtts = newBody
+		var kvPairs []base.KVPair
 		if len(newAtts) > 0 {
+			kvPairs = append(kvPairs, base.KVPair{Key: BodyAttachments, Val: newAtts})
+		}
+		if len(newClientMeta) > 0 {
+			kvPairs = append(kvPairs, base.KVPair{Key: BodyClientMeta, Val: newClientMeta})
+		}
+		if len(kvPairs) > 0 {
 			var err error
-			newBodyWithAtts, err = base.InjectJSONProperties(newBody, base.KVPair{
-				Key: BodyAttachments,
-				Val: newAtts,
-			})
+			newBodyWithAtts, err = base.InjectJSONProperties(newBody, kvPairs...)
 			if err != nil {
 				base.WarnfCtx(ctx, "Unable to marshal new revision body during backupRevisionJSON: doc=%q rev=%q err=%v ", base.UD(docId), newRevId, err)
 				return
@@ -91,6 +91,7 @@ type revCacheValue struct {
 	history     Revisions       // Rev history encoded like a "_revisions" property
 	channels    base.Set        // Set of channels that have access
 	expiry      *time.Time      // Document expiry
+	clientMeta  Body            // Document's client-supplied _meta
 	attachments AttachmentsMeta // Document _attachments property
 	delta       *RevisionDelta  // Available delta *from* this revision
 	deleted     bool            // True if revision is a tombstone
@@ -174,9 +175,9 @@ func (rc *LRURevisionCache) LoadInvalidRevFromBackingStore(ctx context.Context,
 	// If doc has been passed in use this to grab values. Otherwise run revCacheLoader which will grab the Document
 	// first
 	if doc != nil {
-		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.err = revCacheLoaderForDocument(ctx, rc.backingStore, doc, key.RevID)
+		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.clientMeta, value.err = revCacheLoaderForDocument(ctx, rc.backingStore, doc, key.RevID)
 	} else {
-		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.err = revCacheLoader(ctx, rc.backingStore, key, includeBody)
+		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.clientMeta, value.err = revCacheLoader(ctx, rc.backingStore, key, includeBody)
 	}
 
 	if includeDelta {
@@ -357,7 +358,7 @@ func (value *revCacheValue) load(ctx context.Context, backingStore RevisionCache
 		}
 	} else {
 		cacheHit = false
-		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.err = revCacheLoader(ctx, backingStore, value.key, includeBody)
+		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.clientMeta, value.err = revCacheLoader(ctx, backingStore, value.key, includeBody)
 	}
 
 	if includeDelta {
@@ -400,6 +401,7 @@ func (value *revCacheValue) asDocumentRevision(body Body, delta *RevisionDelta)
 		History:     value.history,
 		Channels:    value.channels,
 		Expiry:      value.expiry,
+		ClientMeta:  value.clientMeta,
 		Attachments: value.attachments.ShallowCopy(), // Avoid caller mutating the stored attachments
 		Deleted:     value.deleted,
 		Removed:     value.removed,
@@ -452,7 +454,7 @@ func (value *revCacheValue) loadForDoc(ctx context.Context, backingStore Revisio
 		}
 	} else {
 		cacheHit = false
-		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.err = revCacheLoaderForDocument(ctx, backingStore, doc, value.key.RevID)
+		value.bodyBytes, value.body, value.history, value.channels, value.removed, value.attachments, value.deleted, value.expiry, value.clientMeta, value.err = revCacheLoaderForDocument(ctx, backingStore, doc, value.key.RevID)
 	}
 	if includeBody {
 		docRevBody = value.body
@@ -471,6 +473,7 @@ func (value *revCacheValue) store(docRev DocumentRevision) {
 		value.history = docRev.History
 		value.channels = docRev.Channels
 		value.expiry = docRev.Expiry
+		value.clientMeta = docRev.ClientMeta
 		value.attachments = docRev.Attachments.ShallowCopy() // Don't store attachments the caller might later mutate
 		value.deleted = docRev.Deleted
 		value.err = nil
@@ -57,6 +57,7 @@ const (
 	ConfigErrorInvalidConflictResolutionTypeFmt = "Conflict resolution type is invalid, valid values are %s/%s/%s/%s"
 	ConfigErrorInvalidDirectionFmt              = "Invalid replication direction %q, valid values are %s/%s/%s"
 	ConfigErrorBadChannelsArray                 = "Bad channels array in query_params for sync_gateway/bychannel filter"
+	ConfigErrorBatchSizeTooLargeFmt             = "Replication batch_size must not exceed %d"
 )
 
 // ClusterUpdateFunc is callback signature used when updating the cluster configuration
@@ -172,6 +173,11 @@ func (rc *ReplicationConfig) ValidateReplication(fromConfig bool) (err error) {
 		}
 	}
 
+	// Batch size is bounded by the maximum a passive peer will accept in subChanges, and applied to push in the same way
+	if rc.BatchSize > int(BlipMaximumBatchSize) {
+		return base.HTTPErrorf(http.StatusBadRequest, ConfigErrorBatchSizeTooLargeFmt, BlipMaximumBatchSize)
+	}
+
 	// Checkpoint keys are prefixed with 35 characters:  _sync:local:checkpoint/sgr2cp:pull:
 	// Setting length limit for replication ID to 160 characters to retain some room for future
 	// key-related enhancements
@@ -564,7 +570,8 @@ func (m *sgReplicateManager) NewActiveReplicatorConfig(config *ReplicationCfg) (
 
 	rc.ChangesBatchSize = defaultChangesBatchSize
 	if config.BatchSize > 0 {
-		rc.ChangesBatchSize = uint16(config.BatchSize)
+		// Replications persisted before batch_size was validated may exceed the maximum
+		rc.ChangesBatchSize = uint16(base.Min(config.BatchSize, int(BlipMaximumBatchSize)))
 	}
 
 	// Channel filter processing
@@ -9,8 +9,11 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/couchbase/sync_gateway/base"
 )
@@ -111,3 +114,67 @@ func (db *Database) DeleteSpecial(doctype string, docid string, revid string) er
 func RealSpecialDocID(doctype string, docid string) string {
 	return base.SyncDocPrefix + doctype + ":" + docid
 }
+
+// ClientCheckpoint is a summary of the local checkpoint stored for a replication client.
+type ClientCheckpoint struct {
+	Client       string      `json:"client"`
+	LastSequence interface{} `json:"last_sequence,omitempty"`
+}
+
+// ClientCheckpoints returns a summary of the local checkpoint of every client that has set one, sorted by client ID.
+// Checkpoints are found by querying, so this is only supported for databases using GSI.
+func (db *Database) ClientCheckpoints(ctx context.Context) ([]ClientCheckpoint, error) {
+	results, err := db.QueryLocalCheckpoints(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var clients []string
+	var row QueryIdRow
+	for results.Next(&row) {
+		clients = append(clients, strings.TrimPrefix(row.Id, RealSpecialDocID(DocTypeLocal, CheckpointDocIDPrefix)))
+	}
+	if err := results.Close(); err != nil {
+		return nil, err
+	}
+	sort.Strings(clients)
+
+	checkpoints := make([]ClientCheckpoint, 0, len(clients))
+	for _, client := range clients {
+		// Read directly rather than with GetSpecialBytes, so that listing checkpoints doesn't extend their expiry
+		rawCheckpoint, _, err := db.Bucket.GetRaw(RealSpecialDocID(DocTypeLocal, CheckpointDocIDPrefix+client))
+		if base.IsDocNotFoundError(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var checkpoint Body
+		if err := checkpoint.Unmarshal(rawCheckpoint); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, ClientCheckpoint{
+			Client:       client,
+			LastSequence: checkpointLastSequence(checkpoint),
+		})
+	}
+	return checkpoints, nil
+}
+
+// checkpointLastSequence returns the last sequence recorded in a checkpoint.  Sync Gateway replications record it as
+// last_sequence, Couchbase Lite as the remote sequence.
+func checkpointLastSequence(checkpoint Body) interface{} {
+	if lastSeq, ok := checkpoint[checkpointBodyLastSeq]; ok {
+		return lastSeq
+	}
+	return checkpoint["remote"]
+}
+
+// DeleteClientCheckpoint removes the local checkpoint for a client, so that it replicates from zero on its next
+// connection.
+func (db *Database) DeleteClientCheckpoint(client string) error {
+	checkpoint, err := db.GetSpecial(DocTypeLocal, CheckpointDocIDPrefix+client)
+	if err != nil {
+		return err
+	}
+	revID, _ := checkpoint[BodyRev].(string)
+	return db.DeleteSpecial(DocTypeLocal, CheckpointDocIDPrefix+client, revID)
+}
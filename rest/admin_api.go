@@ -1575,6 +1575,32 @@ func (h *handler) getReplicationsStatus() error {
 	return nil
 }
 
+// getActiveBlipReplications reports the BLIP replication connections from clients to the database.
+func (h *handler) getActiveBlipReplications() error {
+	h.writeJSON(h.db.ActiveBlipReplications())
+	return nil
+}
+
+// getClientCheckpoints reports the client ID and last sequence of each client checkpoint in the collection.
+func (h *handler) getClientCheckpoints() error {
+	checkpoints, err := h.db.ClientCheckpoints(h.ctx())
+	if err != nil {
+		return err
+	}
+	h.writeJSON(checkpoints)
+	return nil
+}
+
+// deleteClientCheckpoint removes a client's checkpoint, e.g. once the client has been decommissioned.
+func (h *handler) deleteClientCheckpoint() error {
+	client := h.PathVar("client")
+	if err := h.db.DeleteClientCheckpoint(client); err != nil {
+		return err
+	}
+	base.InfofCtx(h.ctx(), base.KeyHTTP, "Deleted checkpoint for client %s", base.UD(client))
+	return nil
+}
+
 func (h *handler) getReplicationStatus() error {
 	replicationID := mux.Vars(h.rq)["replicationID"]
 	status, err := h.db.SGReplicateMgr.GetReplicationStatus(replicationID, h.getReplicationStatusOptions())
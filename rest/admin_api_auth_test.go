@@ -422,6 +422,26 @@ func TestAdminAuth(t *testing.T) {
 	}
 }
 
+// TestMakeUserWithMetadata ensures fields set when creating a test user round-trip through the Couchbase Server user API.
+func TestMakeUserWithMetadata(t *testing.T) {
+	if base.UnitTestUrlIsWalrus() {
+		t.Skip("Test requires Couchbase Server")
+	}
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	eps, httpClient, err := rt.ServerContext().ObtainManagementEndpointsAndHTTPClient()
+	require.NoError(t, err)
+
+	MakeUserWithMetadata(t, httpClient, eps[0], "MetadataUser", "password", []string{ReadOnlyAdminRole.RoleName}, map[string]string{"name": "Metadata User"})
+	defer DeleteUser(t, httpClient, eps[0], "MetadataUser")
+
+	user := GetUser(t, httpClient, eps[0], "MetadataUser")
+	assert.Equal(t, "MetadataUser", user["id"])
+	assert.Equal(t, "Metadata User", user["name"])
+}
+
 func TestAdminAuthWithX509(t *testing.T) {
 	serverURL := base.UnitTestUrl()
 	if !base.ServerIsTLS(serverURL) {
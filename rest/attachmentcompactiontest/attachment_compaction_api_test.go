@@ -108,6 +108,7 @@ func TestAttachmentCompactionAPI(t *testing.T) {
 	require.Equal(t, db.BackgroundProcessStateCompleted, response.State)
 	require.Equal(t, int64(20), response.MarkedAttachments)
 	require.Equal(t, int64(5), response.PurgedAttachments)
+	require.Equal(t, float64(100), response.PercentComplete)
 	require.Empty(t, response.LastErrorMessage)
 
 	// Start another run
@@ -3,7 +3,9 @@ package rest
 import (
 	"fmt"
 	"net/http"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/couchbase/go-blip"
 	"github.com/couchbase/sync_gateway/base"
@@ -309,3 +311,171 @@ func TestCollectionsReplication(t *testing.T) {
 	_, ok := btcCollection.WaitForRev("doc1", "1-ca9ad22802b66f662ff171f226211d5c")
 	require.True(t, ok)
 }
+
+// TestBlipSubChangesCollections ensures that changes for the collections listed in a subChanges body are sent with the
+// collection property set, and that the collections are validated before the subscription is started.
+func TestBlipSubChangesCollections(t *testing.T) {
+	base.TestRequiresCollections(t)
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	const (
+		scopeKey              = "fooScope"
+		collectionKey         = "fooCollection"
+		scopeAndCollectionKey = scopeKey + "." + collectionKey
+	)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		GuestEnabled: true,
+		DatabaseConfig: &DatabaseConfig{
+			DbConfig: DbConfig{
+				Scopes: ScopesConfig{
+					scopeKey: ScopeConfig{
+						Collections: map[string]CollectionConfig{
+							collectionKey: {},
+						},
+					},
+				},
+			},
+		},
+		createScopesAndCollections: true,
+	})
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, nil, rt)
+	require.NoError(t, err)
+	defer bt.Close()
+
+	sendSubChanges := func(collections ...string) *blip.Message {
+		subChangesRequest := blip.NewRequest()
+		subChangesRequest.SetProfile(db.MessageSubChanges)
+		require.NoError(t, subChangesRequest.SetJSONBody(db.SubChangesBody{Collections: collections}))
+		require.True(t, bt.sender.Send(subChangesRequest))
+		return subChangesRequest.Response()
+	}
+
+	// Collections can't be identified until getCollections has been called
+	resp := sendSubChanges(scopeAndCollectionKey)
+	assert.Equal(t, "400", resp.Properties[db.BlipErrorCode])
+
+	getCollectionsRequest, err := db.NewGetCollectionsMessage(db.GetCollectionsRequestBody{
+		CheckpointIDs: []string{"checkpoint1"},
+		Collections:   []string{scopeAndCollectionKey},
+	})
+	require.NoError(t, err)
+	require.True(t, bt.sender.Send(getCollectionsRequest))
+	resp = getCollectionsRequest.Response()
+	require.NotContains(t, resp.Properties, db.BlipErrorCode)
+
+	resp = sendSubChanges("barScope.barCollection")
+	assert.Equal(t, "404", resp.Properties[db.BlipErrorCode])
+
+	resp = sendSubChanges(scopeAndCollectionKey, scopeAndCollectionKey)
+	assert.Equal(t, "400", resp.Properties[db.BlipErrorCode])
+
+	for _, docID := range []string{"doc1", "doc2"} {
+		resp := rt.SendAdminRequest(http.MethodPut, "/db."+scopeAndCollectionKey+"/"+docID, "{}")
+		RequireStatus(t, resp, http.StatusCreated)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	var changesLock sync.Mutex
+	var changedDocIDs []string
+	var changesCollections []string
+	caughtUp := make(chan struct{})
+	bt.blipContext.HandlerForProfile[db.MessageChanges] = func(request *blip.Message) {
+		var changes [][]interface{}
+		body, err := request.Body()
+		require.NoError(t, err)
+		require.NoError(t, base.JSONUnmarshal(body, &changes))
+
+		changesLock.Lock()
+		defer changesLock.Unlock()
+		changesCollections = append(changesCollections, request.Properties[db.BlipCollection])
+		if len(changes) == 0 {
+			close(caughtUp)
+			return
+		}
+		for _, change := range changes {
+			changedDocIDs = append(changedDocIDs, change[1].(string))
+		}
+		// Don't request any of the revisions
+		if !request.NoReply() {
+			request.Response().SetBody([]byte("[]"))
+		}
+	}
+
+	resp = sendSubChanges(scopeAndCollectionKey)
+	require.NotContains(t, resp.Properties, db.BlipErrorCode)
+
+	select {
+	case <-caughtUp:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for caught up changes message")
+	}
+
+	changesLock.Lock()
+	defer changesLock.Unlock()
+	assert.ElementsMatch(t, []string{"doc1", "doc2"}, changedDocIDs)
+	for _, collection := range changesCollections {
+		assert.Equal(t, "0", collection)
+	}
+}
+
+// TestBlipListCollections ensures listCollections returns the database's collections, sorted by name, with the
+// sequence of the latest change in each one that's visible to the user.
+func TestBlipListCollections(t *testing.T) {
+	base.TestRequiresCollections(t)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{
+			DbConfig: DbConfig{
+				Scopes: ScopesConfig{
+					"fooScope": ScopeConfig{
+						Collections: map[string]CollectionConfig{
+							"fooCollection": {},
+							"barCollection": {},
+						},
+					},
+				},
+			},
+		},
+		createScopesAndCollections: true,
+	})
+	defer rt.Close()
+
+	resp := rt.SendAdminRequest(http.MethodPut, "/db/_user/alice", `{"password": "letmein", "admin_channels": ["A"]}`)
+	RequireStatus(t, resp, http.StatusCreated)
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "alice",
+		connectingPassword: "letmein",
+	}, rt)
+	require.NoError(t, err)
+	defer bt.Close()
+
+	// Only the first doc is visible to the user
+	resp = rt.SendAdminRequest(http.MethodPut, "/db.fooScope.fooCollection/doc1", `{"channels": ["A"]}`)
+	RequireStatus(t, resp, http.StatusCreated)
+	resp = rt.SendAdminRequest(http.MethodPut, "/db.fooScope.fooCollection/doc2", `{"channels": ["B"]}`)
+	RequireStatus(t, resp, http.StatusCreated)
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	resp = rt.SendAdminRequest(http.MethodGet, "/db.fooScope.fooCollection/_raw/doc1", "")
+	RequireStatus(t, resp, http.StatusOK)
+	var rawDoc1 RawResponse
+	require.NoError(t, base.JSONUnmarshal(resp.BodyBytes(), &rawDoc1))
+
+	listCollectionsRequest := blip.NewRequest()
+	listCollectionsRequest.SetProfile(db.MessageListCollections)
+	require.True(t, bt.sender.Send(listCollectionsRequest))
+	listCollectionsResponse := listCollectionsRequest.Response()
+	require.NotContains(t, listCollectionsResponse.Properties, db.BlipErrorCode)
+
+	var collections []db.ListCollectionsResponseEntry
+	require.NoError(t, listCollectionsResponse.ReadJSONBody(&collections))
+	require.Len(t, collections, 2)
+	assert.Equal(t, "fooScope.barCollection", collections[0].Collection)
+	assert.Equal(t, "fooScope.fooCollection", collections[1].Collection)
+	assert.Equal(t, rawDoc1.Sync.Sequence, collections[1].Sequence)
+}
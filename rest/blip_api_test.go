@@ -29,7 +29,9 @@ import (
 	"github.com/couchbase/gocb/v2"
 	"github.com/couchbase/gocbcore/v10/memd"
 	sgbucket "github.com/couchbase/sg-bucket"
+	"github.com/couchbase/sync_gateway/auth"
 	"github.com/couchbase/sync_gateway/base"
+	"github.com/couchbase/sync_gateway/channels"
 	"github.com/couchbase/sync_gateway/db"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -274,6 +276,145 @@ func TestContinuousChangesSubscription(t *testing.T) {
 
 }
 
+// TestBlipTesterMeasureChangeLatency ensures the latency between pushing a rev and receiving its change on a continuous
+// changes feed on another connection can be measured.
+func TestBlipTesterMeasureChangeLatency(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg, base.KeyChanges)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	pusher, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "pusher",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer pusher.Close()
+
+	subscriber, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "subscriber",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer subscriber.Close()
+
+	changes := make(chan *blip.Message, 10)
+	subscriber.SubscribeToChanges(true, changes)
+
+	for i := 0; i < 3; i++ {
+		latency, err := pusher.MeasureChangeLatency(fmt.Sprintf("doc%d", i), "1-abc", []byte(`{"channels": ["ABC"]}`), changes, 10*time.Second)
+		require.NoError(t, err)
+		assert.Greater(t, int64(latency), int64(0))
+		t.Logf("Change latency for doc%d: %v", i, latency)
+	}
+
+	// Pushing a rev the server already has doesn't produce a change, so this times out
+	_, err = pusher.MeasureChangeLatency("doc0", "1-abc", []byte(`{"channels": ["ABC"]}`), changes, 500*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Timed out")
+}
+
+// TestConcurrentRevPushes pushes revs from several clients, each sending from several goroutines at once, and ensures
+// all of the pushed docs are sent as changes.
+func TestConcurrentRevPushes(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	const numClients = 3
+	var wg sync.WaitGroup
+	var allDocIDsLock sync.Mutex
+	var allDocIDs []string
+	for i := 0; i < numClients; i++ {
+		bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+			connectingUsername:          fmt.Sprintf("user%d", i),
+			connectingPassword:          "1234",
+			connectingUserChannelGrants: []string{"*"},
+		}, rt)
+		require.NoError(t, err, "Unexpected error creating BlipTester")
+		defer bt.Close()
+
+		wg.Add(1)
+		go func(clientIdx int) {
+			defer wg.Done()
+			docIDs, errs := bt.SendRevsConcurrently(fmt.Sprintf("client%d-", clientIdx), 5, 10, []byte(`{"channels": ["ABC"]}`))
+			assert.Empty(t, errs)
+			allDocIDsLock.Lock()
+			allDocIDs = append(allDocIDs, docIDs...)
+			allDocIDsLock.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	require.Len(t, allDocIDs, numClients*5*10)
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "reader",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+	bt.RequireDocsInChanges(allDocIDs, 30*time.Second)
+}
+
+// Grant a user access to a channel with existing docs, so that the docs are sent with compound (triggeredBy:seq)
+// sequences, then resume a continuous subChanges from a compound sequence partway through the backfill and validate
+// only the remaining docs are sent.
+func TestContinuousChangesSubscriptionCompoundSince(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg, base.KeyChanges)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	docIDs := []string{"doc1", "doc2", "doc3"}
+	for _, docID := range docIDs {
+		response := rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"channels": ["ABC"]}`)
+		RequireStatus(t, response, http.StatusCreated)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	response := rt.SendAdminRequest(http.MethodPut, "/db/_user/user1", `{"admin_channels": ["ABC"]}`)
+	RequireStatus(t, response, http.StatusOK)
+
+	changes := bt.WaitForNumChanges(len(docIDs))
+	require.Len(t, changes, len(docIDs))
+	resumeSince, ok := changes[0][0].(string)
+	require.True(t, ok, "Expected compound sequence for backfilled change, got %v", changes[0][0])
+	require.Contains(t, resumeSince, ":")
+
+	changesMessages := make(chan *blip.Message, 10)
+	subChangesResponse := bt.subscribeToChanges(true, blip.Properties{db.SubChangesSince: resumeSince}, changesMessages)
+	require.Equal(t, "", subChangesResponse.Properties[db.BlipErrorCode])
+
+	var resumedChanges [][]interface{}
+	for len(resumedChanges) < len(changes)-1 {
+		select {
+		case changesMessage := <-changesMessages:
+			body, err := changesMessage.Body()
+			require.NoError(t, err)
+			var changesBatch [][]interface{}
+			require.NoError(t, base.JSONUnmarshal(body, &changesBatch))
+			resumedChanges = append(resumedChanges, changesBatch...)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Timed out waiting for changes", "received %v", resumedChanges)
+		}
+	}
+	assert.Equal(t, changes[1:], resumedChanges)
+}
+
 // Make several updates
 // Start subChanges w/ continuous=false, batchsize=20
 // Validate we get the expected updates and changes ends
@@ -432,6 +573,234 @@ func TestBlipOneShotChangesSubscription(t *testing.T) {
 	assert.False(t, nonIntegerSequenceReceived, "Unexpected non-integer sequence seen.")
 }
 
+// Test that a subChanges request for an oversized batch is clamped, and that the effective batch size is reported in
+// the response and used for the changes messages.
+func TestBlipSubChangesEffectiveBatchSize(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTester(t)
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	numDocs := int(db.BlipMaximumBatchSize) + 5
+	docs := make([]string, 0, numDocs)
+	for i := 0; i < numDocs; i++ {
+		docs = append(docs, fmt.Sprintf(`{"_id": "doc%d", "key": "val"}`, i))
+	}
+	response := bt.restTester.SendAdminRequest(http.MethodPost, "/db/_bulk_docs", `{"docs": [`+strings.Join(docs, ",")+`]}`)
+	RequireStatus(t, response, http.StatusCreated)
+	require.NoError(t, bt.restTester.WaitForPendingChanges())
+
+	receivedChangesWg := sync.WaitGroup{}
+	receivedChangesWg.Add(numDocs)
+	var batchSizesLock sync.Mutex
+	var batchSizes []int
+	bt.blipContext.HandlerForProfile[db.MessageChanges] = func(request *blip.Message) {
+		body, err := request.Body()
+		assert.NoError(t, err)
+		if string(body) != "null" {
+			var changes [][]interface{}
+			assert.NoError(t, base.JSONUnmarshal(body, &changes))
+			batchSizesLock.Lock()
+			batchSizes = append(batchSizes, len(changes))
+			batchSizesLock.Unlock()
+			receivedChangesWg.Add(-len(changes))
+		}
+		if !request.NoReply() {
+			request.Response().SetBody([]byte("[]"))
+		}
+	}
+
+	subChangesRequest := blip.NewRequest()
+	subChangesRequest.SetProfile(db.MessageSubChanges)
+	subChangesRequest.Properties[db.SubChangesContinuous] = "false"
+	subChangesRequest.Properties[db.SubChangesBatch] = "1000000"
+	require.True(t, bt.sender.Send(subChangesRequest))
+	subChangesResponse := subChangesRequest.Response()
+	assert.Equal(t, strconv.FormatUint(db.BlipMaximumBatchSize, 10), subChangesResponse.Properties[db.SubChangesResponseBatch])
+
+	require.NoError(t, WaitWithTimeout(&receivedChangesWg, time.Second*30), "Timed out waiting for all changes")
+
+	batchSizesLock.Lock()
+	defer batchSizesLock.Unlock()
+	require.Len(t, batchSizes, 2)
+	assert.Equal(t, int(db.BlipMaximumBatchSize), batchSizes[0])
+	assert.Equal(t, 5, batchSizes[1])
+}
+
+// Test that a batch of changes whose body would exceed max_changes_message_bytes is sent as several changes messages,
+// each within the limit, and that all of the changes are still received.
+func TestBlipSubChangesMaxMessageSize(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	const maxMessageBytes = 200
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			MaxChangesMessageBytes: base.Uint32Ptr(maxMessageBytes),
+		}},
+	})
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	const numDocs = 20
+	for i := 0; i < numDocs; i++ {
+		rt.PutDoc(fmt.Sprintf("doc%d", i), `{"key": "val"}`)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	receivedChangesWg := sync.WaitGroup{}
+	receivedChangesWg.Add(numDocs)
+	var bodySizesLock sync.Mutex
+	var bodySizes []int
+	bt.blipContext.HandlerForProfile[db.MessageChanges] = func(request *blip.Message) {
+		body, err := request.Body()
+		assert.NoError(t, err)
+		if string(body) != "null" {
+			var changes [][]interface{}
+			assert.NoError(t, base.JSONUnmarshal(body, &changes))
+			bodySizesLock.Lock()
+			bodySizes = append(bodySizes, len(body))
+			bodySizesLock.Unlock()
+			receivedChangesWg.Add(-len(changes))
+		}
+		if !request.NoReply() {
+			request.Response().SetBody([]byte("[]"))
+		}
+	}
+
+	subChangesRequest := blip.NewRequest()
+	subChangesRequest.SetProfile(db.MessageSubChanges)
+	subChangesRequest.Properties[db.SubChangesContinuous] = "false"
+	require.True(t, bt.sender.Send(subChangesRequest))
+
+	require.NoError(t, WaitWithTimeout(&receivedChangesWg, time.Second*30), "Timed out waiting for all changes")
+
+	bodySizesLock.Lock()
+	defer bodySizesLock.Unlock()
+	assert.Greater(t, len(bodySizes), 1, "Expected the batch to be split into several messages")
+	for _, bodySize := range bodySizes {
+		assert.LessOrEqual(t, bodySize, maxMessageBytes)
+	}
+}
+
+// Test that a continuous subChanges with a batch timeout still sends each change as it arrives, in batches no larger
+// than the requested batch size.
+func TestBlipSubChangesBatchTimeout(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTester(t)
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	receivedDocIDs := make(chan string, 100)
+	bt.blipContext.HandlerForProfile[db.MessageChanges] = func(request *blip.Message) {
+		body, err := request.Body()
+		assert.NoError(t, err)
+		if string(body) != "null" {
+			var changes [][]interface{}
+			assert.NoError(t, base.JSONUnmarshal(body, &changes))
+			assert.LessOrEqual(t, len(changes), 10)
+			for _, change := range changes {
+				receivedDocIDs <- change[1].(string)
+			}
+		}
+		if !request.NoReply() {
+			request.Response().SetBody([]byte("[]"))
+		}
+	}
+
+	subChangesRequest := blip.NewRequest()
+	subChangesRequest.SetProfile(db.MessageSubChanges)
+	subChangesRequest.Properties[db.SubChangesContinuous] = "true"
+	subChangesRequest.Properties[db.SubChangesBatch] = "10"
+	subChangesRequest.Properties[db.SubChangesBatchTimeout] = "50"
+	require.True(t, bt.sender.Send(subChangesRequest))
+	subChangesResponse := subChangesRequest.Response()
+	assert.Equal(t, "10", subChangesResponse.Properties[db.SubChangesResponseBatch])
+
+	// Trickle in fewer docs than fill a batch, and expect each to be received without the batch filling
+	for i := 0; i < 3; i++ {
+		docID := fmt.Sprintf("doc%d", i)
+		response := bt.restTester.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"key": "val"}`)
+		RequireStatus(t, response, http.StatusCreated)
+		select {
+		case receivedDocID := <-receivedDocIDs:
+			assert.Equal(t, docID, receivedDocID)
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Timed out waiting for change", "docID: %s", docID)
+		}
+	}
+}
+
+// Test that keepalive requests are sent on an idle continuous replication, and that the connection is closed once the
+// client stops responding to them.
+func TestBlipKeepalive(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			BlipKeepaliveIntervalSecs: base.Uint32Ptr(1),
+			BlipKeepaliveTimeoutSecs:  base.Uint32Ptr(1),
+		}},
+	})
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	keepalives := make(chan struct{}, 10)
+	var stopResponding base.AtomicBool
+	unblockKeepalives := make(chan struct{})
+	defer close(unblockKeepalives)
+	bt.blipContext.HandlerForProfile[db.MessageKeepalive] = func(request *blip.Message) {
+		select {
+		case keepalives <- struct{}{}:
+		default:
+		}
+		if stopResponding.IsTrue() {
+			<-unblockKeepalives
+		}
+	}
+	bt.blipContext.HandlerForProfile[db.MessageChanges] = func(request *blip.Message) {
+		if !request.NoReply() {
+			request.Response().SetBody([]byte("[]"))
+		}
+	}
+
+	activeReplications := rt.GetDatabase().DbStats.Database().NumReplicationsActive
+	numActive := activeReplications.Value()
+
+	subChangesRequest := blip.NewRequest()
+	subChangesRequest.SetProfile(db.MessageSubChanges)
+	subChangesRequest.Properties[db.SubChangesContinuous] = "true"
+	require.True(t, bt.sender.Send(subChangesRequest))
+	require.NotNil(t, subChangesRequest.Response())
+
+	// The connection stays open while the client responds to keepalives
+	for i := 0; i < 2; i++ {
+		select {
+		case <-keepalives:
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Timed out waiting for keepalive")
+		}
+	}
+	assert.Equal(t, numActive, activeReplications.Value())
+
+	// and is closed once it stops responding
+	stopResponding.Set(true)
+	require.NoError(t, rt.WaitForCondition(func() bool {
+		return activeReplications.Value() == numActive-1
+	}))
+}
+
 // Test subChanges w/ docID filter
 func TestBlipSubChangesDocIDFilter(t *testing.T) {
 
@@ -595,6 +964,75 @@ func TestBlipSubChangesDocIDFilter(t *testing.T) {
 	assert.False(t, nonIntegerSequenceReceived, "Unexpected non-integer sequence seen.")
 }
 
+// Subscribe to changes filtered by channels specified in the subChanges body, and ensure only docs in those channels
+// are sent, and that requesting a channel the user doesn't have access to is rejected.
+func TestBlipSubChangesBodyChannelFilter(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"ABC", "NBC"},
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	for docID, docChannels := range map[string]string{"abcDoc": `["ABC"]`, "nbcDoc": `["NBC"]`, "bothDoc": `["ABC", "NBC"]`} {
+		sent, _, _, err := bt.SendRev(docID, "1-abc", []byte(`{"channels": `+docChannels+`}`), blip.Properties{})
+		require.True(t, sent)
+		require.NoError(t, err)
+	}
+	require.NoError(t, bt.restTester.WaitForPendingChanges())
+
+	changesMessages := make(chan *blip.Message, 10)
+	bt.blipContext.HandlerForProfile["changes"] = func(request *blip.Message) {
+		changesMessages <- request
+		if !request.NoReply() {
+			request.Response().SetBody([]byte("[]"))
+		}
+	}
+	defer delete(bt.blipContext.HandlerForProfile, "changes")
+
+	subChanges := func(channelFilter []string) *blip.Message {
+		subChangesRequest := blip.NewRequest()
+		subChangesRequest.SetProfile(db.MessageSubChanges)
+		subChangesRequest.Properties[db.SubChangesContinuous] = "false"
+		subChangesRequest.Properties[db.SubChangesFilter] = base.ByChannelFilter
+		require.NoError(t, subChangesRequest.SetJSONBody(db.SubChangesBody{Channels: channelFilter}))
+		require.True(t, bt.sender.Send(subChangesRequest))
+		return subChangesRequest.Response()
+	}
+
+	// The user doesn't have access to CNN
+	subChangesResponse := subChanges([]string{"ABC", "CNN"})
+	assert.Equal(t, "403", subChangesResponse.Properties[db.BlipErrorCode])
+
+	subChangesResponse = subChanges([]string{"ABC"})
+	require.Equal(t, "", subChangesResponse.Properties[db.BlipErrorCode])
+
+	var docIDs []string
+	for caughtUp := false; !caughtUp; {
+		select {
+		case changesMessage := <-changesMessages:
+			body, err := changesMessage.Body()
+			require.NoError(t, err)
+			if string(body) == "null" {
+				caughtUp = true
+				continue
+			}
+			var changesBatch [][]interface{}
+			require.NoError(t, base.JSONUnmarshal(body, &changesBatch))
+			for _, change := range changesBatch {
+				docIDs = append(docIDs, change[1].(string))
+			}
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Timed out waiting for changes", "received %v", docIDs)
+		}
+	}
+	assert.ElementsMatch(t, []string{"abcDoc", "bothDoc"}, docIDs)
+}
+
 // Push proposed changes and ensure that the server accepts them
 //
 // 1. Start sync gateway in no-conflicts mode
@@ -640,6 +1078,57 @@ func TestProposedChangesNoConflictsMode(t *testing.T) {
 
 }
 
+// Test that changes responses tell the client how much rev history to send, and that pushed revs with truncated
+// history are still connected to the known ancestor, or rejected as a conflict when they don't reach one.
+func TestBlipPushRevMaxHistory(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		noConflictsMode: true,
+		GuestEnabled:    true,
+	})
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	proposeChangesRequest := blip.NewRequest()
+	proposeChangesRequest.SetProfile(db.MessageProposeChanges)
+	proposeChangesRequest.SetBody([]byte(`[["doc1", "1-abc"]]`))
+	require.True(t, bt.sender.Send(proposeChangesRequest))
+	proposeChangesResponse := proposeChangesRequest.Response()
+	require.Equal(t, "", proposeChangesResponse.Properties[db.BlipErrorCode])
+	revsLimit := bt.restTester.GetDatabase().RevsLimit
+	assert.Equal(t, strconv.FormatUint(uint64(revsLimit), 10), proposeChangesResponse.Properties[db.ProposeChangesResponseMaxHistory])
+
+	_, _, _, err = bt.SendRev("doc1", "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+	require.NoError(t, err)
+	_, _, _, err = bt.SendRevWithHistory("doc1", "2-abc", []string{"1-abc"}, []byte(`{"key": "val"}`), blip.Properties{})
+	require.NoError(t, err)
+
+	// A truncated history that reaches the current rev is added to it
+	bt.maxHistory = 2
+	_, req, _, err := bt.SendRevWithHistory("doc1", "4-abc", []string{"3-abc", "2-abc", "1-abc"}, []byte(`{"key": "val"}`), blip.Properties{})
+	require.NoError(t, err)
+	assert.Equal(t, "3-abc,2-abc", req.Properties[db.RevMessageHistory])
+
+	doc, err := bt.restTester.GetDatabase().GetDocument(base.TestCtx(t), "doc1", db.DocUnmarshalSync)
+	require.NoError(t, err)
+	assert.Equal(t, "4-abc", doc.CurrentRev)
+	assert.Equal(t, "3-abc", doc.History["4-abc"].Parent)
+	assert.Equal(t, "2-abc", doc.History["3-abc"].Parent)
+
+	// A truncated history that doesn't reach a known ancestor can't be connected to the current rev, so is a conflict
+	_, req, _, err = bt.SendRevWithHistory("doc1", "7-abc", []string{"6-abc", "5-abc", "4-abc"}, []byte(`{"key": "val"}`), blip.Properties{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "409")
+	assert.Equal(t, "6-abc,5-abc", req.Properties[db.RevMessageHistory])
+
+	doc, err = bt.restTester.GetDatabase().GetDocument(base.TestCtx(t), "doc1", db.DocUnmarshalSync)
+	require.NoError(t, err)
+	assert.Equal(t, "4-abc", doc.CurrentRev)
+	assert.NotContains(t, doc.History, "7-abc")
+}
+
 // Validate SG sends conflicting rev when requested
 func TestProposedChangesIncludeConflictingRev(t *testing.T) {
 
@@ -717,6 +1206,12 @@ func TestProposedChangesIncludeConflictingRev(t *testing.T) {
 			parentRevID:   matchingUpdateRev1,
 			expectedValue: float64(db.ProposedRev_Exists),
 		},
+		proposeChangesCase{
+			key:           "matchingUpdate",
+			revID:         matchingUpdateRev1,
+			parentRevID:   "",
+			expectedValue: float64(db.ProposedRev_Exists),
+		},
 	}
 
 	proposeChangesRequest := blip.NewRequest()
@@ -757,45 +1252,242 @@ func TestProposedChangesIncludeConflictingRev(t *testing.T) {
 
 }
 
-// Connect to public port with authentication
-func TestPublicPortAuthentication(t *testing.T) {
+// TestProposedChangesStatusMap ensures that when a status map is requested, the proposeChanges response is an object of
+// only the non-zero statuses keyed by index.
+func TestProposedChangesStatusMap(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	// Create bliptester that is connected as user1, with access to the user1 channel
-	btUser1, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		noConflictsMode: true,
+		GuestEnabled:    true,
 	})
 	require.NoError(t, err, "Error creating BlipTester")
-	defer btUser1.Close()
+	defer bt.Close()
 
-	// Send the user1 doc
-	_, _, _, err = btUser1.SendRev(
-		"foo",
-		"1-abc",
-		[]byte(`{"key": "val", "channels": ["user1"]}`),
-		blip.Properties{},
-	)
-	require.NoError(t, err, "Error sending revision")
+	conflictingRev := bt.restTester.PutDoc("conflicting", `{"version":1}`).Rev
+	matchingRev := bt.restTester.PutDoc("matching", `{"version":1}`).Rev
 
-	// Create bliptester that is connected as user2, with access to the * channel
-	btUser2, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
-		connectingUsername:          "user2",
-		connectingPassword:          "1234",
-		connectingUserChannelGrants: []string{"*"}, // user2 has access to all channels
-	}, btUser1.restTester) // re-use rest tester, otherwise it will create a new underlying bucket in walrus case
-	require.NoError(t, err, "Error creating BlipTester")
-	defer btUser2.Close()
+	proposeChanges := func(body string, includeConflictRev bool) (*blip.Message, map[string]interface{}) {
+		proposeChangesRequest := blip.NewRequest()
+		proposeChangesRequest.SetProfile(db.MessageProposeChanges)
+		proposeChangesRequest.Properties[db.ProposeChangesStatusMap] = "true"
+		if includeConflictRev {
+			proposeChangesRequest.Properties[db.ProposeChangesConflictsIncludeRev] = "true"
+		}
+		proposeChangesRequest.SetBody([]byte(body))
+		require.True(t, bt.sender.Send(proposeChangesRequest))
+		proposeChangesResponse := proposeChangesRequest.Response()
+		require.Equal(t, "", proposeChangesResponse.Properties[db.BlipErrorCode])
+		var statuses map[string]interface{}
+		require.NoError(t, proposeChangesResponse.ReadJSONBody(&statuses))
+		return proposeChangesResponse, statuses
+	}
 
-	// Send the user2 doc, which is in a "random" channel, but it should be accessible due to * channel access
-	_, _, _, err = btUser2.SendRev(
-		"foo2",
-		"1-abcd",
-		[]byte(`{"key": "val", "channels": ["NBC"]}`),
-		blip.Properties{},
-	)
-	require.NoError(t, err, "Error sending revision")
+	changes := fmt.Sprintf(`[["new1", "1-abc"], ["conflicting", "1-abc"], ["new2", "1-abc"], ["matching", "%s"], ["new3", "1-abc"]]`, matchingRev)
+	response, statuses := proposeChanges(changes, false)
+	assert.Equal(t, "true", response.Properties[db.ProposeChangesResponseStatusMap])
+	assert.Equal(t, map[string]interface{}{
+		"1": json.Number("409"),
+		"3": json.Number("304"),
+	}, statuses)
+
+	_, statuses = proposeChanges(changes, true)
+	assert.Equal(t, map[string]interface{}{
+		"1": map[string]interface{}{"status": json.Number("409"), "rev": conflictingRev},
+		"3": json.Number("304"),
+	}, statuses)
+
+	// When every change is accepted the status map is empty
+	_, statuses = proposeChanges(`[["new1", "1-abc"], ["new2", "1-abc"]]`, false)
+	assert.Empty(t, statuses)
+}
+
+// Validate that without conflictIncludesRev, a proposed change that conflicts with the server's current rev gets a
+// status-only conflict entry, and the conflicting rev isn't written when pushed.
+func TestProposedChangesConflictStatusOnly(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		noConflictsMode: true,
+		GuestEnabled:    true,
+	})
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	rt := bt.restTester
+	rev1 := rt.PutDoc("doc1", `{"version":1}`).Rev
+	rev2 := rt.UpdateDoc("doc1", rev1, `{"version":2}`).Rev
+
+	proposeChangesRequest := blip.NewRequest()
+	proposeChangesRequest.SetProfile(db.MessageProposeChanges)
+	proposeChangesBody, err := base.JSONMarshal([][]interface{}{
+		{"doc1", "2-abc", rev1}, // sibling of the current rev
+		{"doc1", rev1},          // ancestor of the current rev
+		{"doc1", "3-abc", rev2}, // child of the current rev
+	})
+	require.NoError(t, err)
+	proposeChangesRequest.SetBody(proposeChangesBody)
+	require.True(t, bt.sender.Send(proposeChangesRequest))
+	body, err := proposeChangesRequest.Response().Body()
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf("[%d,%d]", db.ProposedRev_Conflict, db.ProposedRev_Exists), string(body))
+
+	_, _, _, err = bt.SendRevWithHistory("doc1", "2-abc", []string{rev1}, []byte(`{"version":"conflict"}`), blip.Properties{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "409")
+	assert.Equal(t, rev2, rt.GetDoc("doc1")["_rev"])
+}
+
+// Validate that a conflicting proposed change sent with force is accepted and becomes the winner for admin connections,
+// and is rejected as forbidden for user connections.
+func TestProposedChangesForce(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	adminBt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Error creating admin BlipTester")
+	defer adminBt.Close()
+
+	userBt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Error creating user BlipTester")
+	defer userBt.Close()
+
+	resp := rt.PutDoc("forceDoc", `{"version":1}`)
+	rev1 := resp.Rev
+	resp = rt.UpdateDoc("forceDoc", rev1, `{"version":2}`)
+	serverRev2 := resp.Rev
+
+	// Sends a single proposed change for forceDoc 2-abc, returning the response entries
+	proposeChange := func(bt *BlipTester, force bool) []interface{} {
+		proposeChangesRequest := blip.NewRequest()
+		proposeChangesRequest.SetProfile(db.MessageProposeChanges)
+		if force {
+			proposeChangesRequest.Properties[db.ProposeChangesForce] = "0"
+		}
+		proposeChangesRequest.SetBody([]byte(`[["forceDoc", "2-abc", "` + rev1 + `"]]`))
+		require.True(t, bt.sender.Send(proposeChangesRequest))
+		body, err := proposeChangesRequest.Response().Body()
+		require.NoError(t, err)
+		var changeList []interface{}
+		require.NoError(t, base.JSONUnmarshal(body, &changeList))
+		return changeList
+	}
+
+	// Without force, the conflicting change is rejected
+	assert.Equal(t, []interface{}{float64(db.ProposedRev_Conflict)}, proposeChange(adminBt, false))
+
+	// Users aren't authorized to force
+	assert.Equal(t, []interface{}{float64(db.ProposedRev_Forbidden)}, proposeChange(userBt, true))
+
+	// Admin connections can force, and the forced rev wins once it's pushed
+	assert.Equal(t, []interface{}{}, proposeChange(adminBt, true))
+	_, _, _, err = adminBt.SendRevWithHistory("forceDoc", "2-abc", []string{rev1}, []byte(`{"version":"forced"}`), blip.Properties{})
+	require.NoError(t, err)
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/forceDoc", "")
+	RequireStatus(t, response, http.StatusOK)
+	var body db.Body
+	require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, "2-abc", body[db.BodyRev])
+	assert.Equal(t, "forced", body["version"])
+
+	// The server's previous revision has been tombstoned by the resolution
+	response = rt.SendAdminRequest(http.MethodGet, "/db/forceDoc?open_revs=all&revs=true", "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.NotContains(t, response.Body.String(), `"_rev":"`+serverRev2+`"`)
+}
+
+// TestBlipCompressionThreshold ensures responses smaller than the configured compression threshold are sent
+// uncompressed, while larger responses are compressed.
+func TestBlipCompressionThreshold(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		GuestEnabled: true,
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			BlipCompressionThresholdBytes: base.Uint32Ptr(100),
+		}},
+	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, nil, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// Sends a changes message for numChanges unknown docs, returning the response
+	sendChanges := func(numChanges int) *blip.Message {
+		changeList := make([][]interface{}, 0, numChanges)
+		for i := 0; i < numChanges; i++ {
+			changeList = append(changeList, []interface{}{i + 1, fmt.Sprintf("compressionDoc%d", i), "1-abc"})
+		}
+		changesRequest := blip.NewRequest()
+		changesRequest.SetProfile(db.MessageChanges)
+		changesRequest.Properties[db.ChangesMessageIgnoreNoConflicts] = "true"
+		require.NoError(t, changesRequest.SetJSONBody(changeList))
+		require.True(t, bt.sender.Send(changesRequest))
+		response := changesRequest.Response()
+		body, err := response.Body()
+		require.NoError(t, err)
+		var changesResponse []interface{}
+		require.NoError(t, base.JSONUnmarshal(body, &changesResponse))
+		require.Len(t, changesResponse, numChanges)
+		return response
+	}
+
+	// Response body "[[]]" is below the threshold
+	assert.False(t, sendChanges(1).Compressed())
+
+	// Response body "[[],[],...]" is above the threshold
+	assert.True(t, sendChanges(100).Compressed())
+}
+
+// Connect to public port with authentication
+func TestPublicPortAuthentication(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	// Create bliptester that is connected as user1, with access to the user1 channel
+	btUser1, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	})
+	require.NoError(t, err, "Error creating BlipTester")
+	defer btUser1.Close()
+
+	// Send the user1 doc
+	_, _, _, err = btUser1.SendRev(
+		"foo",
+		"1-abc",
+		[]byte(`{"key": "val", "channels": ["user1"]}`),
+		blip.Properties{},
+	)
+	require.NoError(t, err, "Error sending revision")
+
+	// Create bliptester that is connected as user2, with access to the * channel
+	btUser2, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user2",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"}, // user2 has access to all channels
+	}, btUser1.restTester) // re-use rest tester, otherwise it will create a new underlying bucket in walrus case
+	require.NoError(t, err, "Error creating BlipTester")
+	defer btUser2.Close()
+
+	// Send the user2 doc, which is in a "random" channel, but it should be accessible due to * channel access
+	_, _, _, err = btUser2.SendRev(
+		"foo2",
+		"1-abcd",
+		[]byte(`{"key": "val", "channels": ["NBC"]}`),
+		blip.Properties{},
+	)
+	require.NoError(t, err, "Error sending revision")
 
 	// Assert that user1 received a single expected change
 	changesChannelUser1 := btUser1.WaitForNumChanges(1)
@@ -814,6 +1506,49 @@ func TestPublicPortAuthentication(t *testing.T) {
 
 }
 
+// TestPublicPortSessionCookieAuthentication connects to the public port with a session cookie instead of basic auth, and
+// ensures that connecting with an invalid or deleted session is rejected.
+func TestPublicPortSessionCookieAuthentication(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeyAuth, base.KeySync)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+		useSessionCookie:   true,
+	}, rt)
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+	sessionCookie := bt.spec.sessionCookie
+	require.NotEmpty(t, sessionCookie)
+
+	// The connection is authenticated as user1
+	sent, _, resp, err := bt.SendRev("foo", "1-abc", []byte(`{"key": "val", "channels": ["user1"]}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Properties["Error-Code"])
+	changes := bt.WaitForNumChanges(1)
+	require.Len(t, changes, 1)
+	AssertChangeEquals(t, changes[0], ExpectedChange{docId: "foo", revId: "1-abc", sequence: "*", deleted: base.BoolPtr(false)})
+
+	// An invalid session is rejected
+	_, err = NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		sessionCookie: auth.DefaultCookieName + "=FakeSession",
+	}, rt)
+	assert.Error(t, err)
+
+	// A deleted session is rejected
+	response := rt.SendRequestWithHeaders(http.MethodDelete, "/db/_session", "", map[string]string{"Cookie": sessionCookie})
+	RequireStatus(t, response, http.StatusOK)
+	_, err = NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		sessionCookie: sessionCookie,
+	}, rt)
+	assert.Error(t, err)
+}
+
 // Connect to public port with authentication, and validate user update during a replication
 func TestPublicPortAuthenticationUserUpdate(t *testing.T) {
 
@@ -1158,8 +1893,9 @@ func TestBlipSendAndGetRev(t *testing.T) {
 	rt := NewRestTester(t, nil)
 	defer rt.Close()
 	btSpec := BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"user1"},
 	}
 	bt, err := NewBlipTesterFromSpecWithRT(t, &btSpec, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
@@ -1178,6 +1914,9 @@ func TestBlipSendAndGetRev(t *testing.T) {
 	assert.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &responseBody), "Error unmarshalling GET doc response")
 	_, ok := responseBody[db.BodyDeleted]
 	assert.False(t, ok)
+	require.NoError(t, rt.WaitForPendingChanges())
+	bt.GetChanges()
+	bt.AssertChangeDeleted("sendAndGetRev", false)
 
 	// Tombstone the document
 	history := []string{"1-abc"}
@@ -1194,390 +1933,1709 @@ func TestBlipSendAndGetRev(t *testing.T) {
 	deletedValue, deletedOK := responseBody[db.BodyDeleted].(bool)
 	assert.True(t, deletedOK)
 	assert.True(t, deletedValue)
+	require.NoError(t, rt.WaitForPendingChanges())
+	bt.GetChanges()
+	bt.AssertChangeDeleted("sendAndGetRev", true)
 }
 
-// Test send and retrieval of a doc with a large numeric value.  Ensure proper large number handling.
-//
-//	Validate deleted handling (includes check for https://github.com/couchbase/sync_gateway/issues/3341)
-func TestBlipSendAndGetLargeNumberRev(t *testing.T) {
-	base.LongRunningTest(t)
+// Test that getServerSequence returns the database's latest sequence, along with the latest sequence in the channels
+// visible to the user.
+func TestBlipGetServerSequence(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
 	rt := NewRestTester(t, nil)
 	defer rt.Close()
-	btSpec := BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
-	}
-	bt, err := NewBlipTesterFromSpecWithRT(t, &btSpec, rt)
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"A"},
+	}, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
+	adminBt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Unexpected error creating admin BlipTester")
+	defer adminBt.Close()
 
-	// Send non-deleted rev
-	sent, _, resp, err := bt.SendRev("largeNumberRev", "1-abc", []byte(`{"key": "val", "largeNumber":9223372036854775807, "channels": ["user1"]}`), blip.Properties{})
-	assert.True(t, sent)
-	assert.NoError(t, err)
-	assert.Equal(t, "", resp.Properties["Error-Code"])
+	rt.PutDoc("docA", `{"channels": ["A"]}`)
+	require.NoError(t, rt.WaitForPendingChanges())
+	docASeq, err := rt.GetDatabase().LastSequence()
+	require.NoError(t, err)
 
-	// Get non-deleted rev
-	response := bt.restTester.SendAdminRequest("GET", "/db/largeNumberRev?rev=1-abc", "")
-	RequireStatus(t, response, 200) // Check the raw bytes, because unmarshalling the response would be another opportunity for the number to get modified
-	responseString := string(response.Body.Bytes())
-	if !strings.Contains(responseString, `9223372036854775807`) {
-		t.Errorf("Response does not contain the expected number format.  Response: %s", responseString)
-	}
-}
+	rt.PutDoc("docB", `{"channels": ["B"]}`)
+	require.NoError(t, rt.WaitForPendingChanges())
+	docBSeq, err := rt.GetDatabase().LastSequence()
+	require.NoError(t, err)
 
-func AssertChangeEquals(t *testing.T, change []interface{}, expectedChange ExpectedChange) {
-	if err := expectedChange.Equals(change); err != nil {
-		t.Errorf("Change %+v does not equal expected change: %+v.  Error: %v", change, expectedChange, err)
-	}
-}
+	// The user can't see docB, so is only behind as far as docA
+	sequences, err := bt.GetServerSequence()
+	require.NoError(t, err)
+	assert.Equal(t, docBSeq, sequences.Sequence)
+	assert.Equal(t, docASeq, sequences.UserSequence)
 
-// Test adding / retrieving attachments
-func TestAttachments(t *testing.T) {
-	// TODO: Write tests to cover scenario
-	t.Skip("not tested")
-}
+	sequences, err = adminBt.GetServerSequence()
+	require.NoError(t, err)
+	assert.Equal(t, docBSeq, sequences.Sequence)
+	assert.Equal(t, docBSeq, sequences.UserSequence)
 
-// Make sure it's not possible to have two outstanding subChanges w/ continuous=true.
-// Expected behavior is that the first continous change subscription should get discarded in favor of 2nd.
-func TestConcurrentChangesSubscriptions(t *testing.T) {
-	// TODO: Write tests to cover scenario
-	t.Skip("not tested")
-}
+	// Granting access to B makes docB visible to the user, at the sequence it was granted
+	resp := rt.SendAdminRequest(http.MethodPut, "/db/_user/user1", `{"admin_channels": ["A", "B"]}`)
+	RequireStatus(t, resp, http.StatusOK)
+	require.NoError(t, rt.WaitForPendingChanges())
+	grantSeq, err := rt.GetDatabase().LastSequence()
+	require.NoError(t, err)
 
-// Create a continous changes subscription that has docs in multiple channels, and make sure
-// all docs are received
-func TestMultiChannelContinousChangesSubscription(t *testing.T) {
-	// TODO: Write tests to cover scenario
-	t.Skip("not tested")
+	sequences, err = bt.GetServerSequence()
+	require.NoError(t, err)
+	assert.Equal(t, grantSeq, sequences.Sequence)
+	assert.Equal(t, grantSeq, sequences.UserSequence)
 }
 
-// Test setting and getting checkpoints
-func TestBlipSetCheckpoint(t *testing.T) {
+// Test that a getRevs request sends a rev or norev message for each requested revision, in the order requested, and
+// applies the same access checks as revisions sent in response to changes.
+func TestBlipGetRevs(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
 	rt := NewRestTester(t, nil)
 	defer rt.Close()
-	btSpec := BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
-	}
-	bt, err := NewBlipTesterFromSpecWithRT(t, &btSpec, rt)
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"user1"},
+	}, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	// Create new checkpoint
-	checkpointBody := []byte(`{"client_seq":"1000"}`)
-	sent, _, resp, err := bt.SetCheckpoint("testclient", "", checkpointBody)
-	assert.True(t, sent)
-	assert.NoError(t, err)
-	assert.Equal(t, "", resp.Properties["Error-Code"])
-
-	checkpointRev := resp.Rev()
-	assert.Equal(t, "0-1", checkpointRev)
-
-	// Validate checkpoint existence in bucket (local file name "/" needs to be URL encoded as %252F)
-	response := rt.SendAdminRequest("GET", "/db/_local/checkpoint%252Ftestclient", "")
-	RequireStatus(t, response, 200)
-	var responseBody map[string]interface{}
-	err = base.JSONUnmarshal(response.Body.Bytes(), &responseBody)
-	assert.Equal(t, "1000", responseBody["client_seq"])
+	rev1 := rt.PutDoc("getRevs1", `{"key": "val1", "channels": ["user1"]}`).Rev
+	rev2 := rt.PutDoc("getRevs2", `{"key": "val2", "channels": ["user1"]}`).Rev
+	rev4 := rt.PutDoc("getRevs4", `{"key": "val4", "channels": ["other"]}`).Rev
+	rev5 := rt.PutDoc("getRevs5", `{"key": "val5", "channels": ["user1"]}`).Rev
+
+	revs, err := bt.GetRevs([][]string{
+		{"getRevs1", rev1},
+		{"getRevs2", rev2},
+		{"getRevsMissing", "1-abc"},
+		{"getRevs4", rev4},
+		{"getRevs5", rev5},
+	})
+	require.NoError(t, err)
+	require.Len(t, revs, 5)
 
-	// Attempt to update the checkpoint with previous rev
-	checkpointBody = []byte(`{"client_seq":"1005"}`)
-	sent, _, resp, err = bt.SetCheckpoint("testclient", checkpointRev, checkpointBody)
-	assert.True(t, sent)
-	assert.NoError(t, err)
-	assert.Equal(t, "", resp.Properties["Error-Code"])
-	checkpointRev = resp.Rev()
-	assert.Equal(t, "0-2", checkpointRev)
-}
+	for i, expected := range []struct {
+		docID, revID, key string
+	}{
+		{"getRevs1", rev1, "val1"},
+		{"getRevs2", rev2, "val2"},
+		{"getRevsMissing", "1-abc", ""},
+		{"getRevs4", rev4, ""},
+		{"getRevs5", rev5, "val5"},
+	} {
+		rev := revs[i]
+		assert.Equal(t, expected.docID, rev.Properties[db.RevMessageID])
+		assert.Equal(t, expected.revID, rev.Properties[db.RevMessageRev])
+		switch expected.docID {
+		case "getRevsMissing":
+			assert.Equal(t, db.MessageNoRev, rev.Profile())
+			assert.Equal(t, "404", rev.Properties[db.NorevMessageError])
+		case "getRevs4":
+			// The user can't see the revision's channel, so is sent a removal
+			assert.Equal(t, db.MessageRev, rev.Profile())
+			var body db.Body
+			require.NoError(t, rev.ReadJSONBody(&body))
+			assert.Equal(t, true, body[db.BodyRemoved])
+			assert.NotContains(t, body, "key")
+		default:
+			assert.Equal(t, db.MessageRev, rev.Profile())
+			var body db.Body
+			require.NoError(t, rev.ReadJSONBody(&body))
+			assert.Equal(t, expected.key, body["key"])
+		}
+	}
 
-// Test no-conflicts mode replication (proposeChanges endpoint)
-func TestNoConflictsModeReplication(t *testing.T) {
-	// TODO: Write tests to cover scenario
-	t.Skip("not tested")
+	// A malformed body is rejected without sending any revisions
+	_, err = bt.GetRevs([][]string{{"getRevs1"}})
+	assert.ErrorContains(t, err, "400")
 }
 
-// Reproduce issue where ReloadUser was not being called, and so it was
-// using a stale channel access grant for the user.
-// Reproduces https://github.com/couchbase/sync_gateway/issues/2717
-func TestReloadUser(t *testing.T) {
+// Test that a _meta property pushed with a rev is stored alongside the sync metadata rather than in the body, isn't
+// used for channel assignment, and is returned when the rev is pulled.
+func TestBlipRevClientMeta(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	syncFn := `
-		function(doc) {
-			if (doc._id == "access1") {
-				// if its an access grant doc, grant access
-				access(doc.accessUser, doc.accessChannel);
-			} else {
-                // otherwise if its a normal access doc, require access then add to channels
-				requireAccess("PBS");
-				channel(doc.channels);
-			}
-		}
-    `
-
-	// Setup
-	rtConfig := RestTesterConfig{SyncFn: syncFn}
-	rt := NewRestTester(t, &rtConfig)
+	rt := NewRestTester(t, nil)
 	defer rt.Close()
-	ctx := rt.Context()
 	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"user1"},
 	}, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	// Set up a ChangeWaiter for this test, to block until the user change notification happens
-	dbc := rt.GetDatabase()
-	user1, err := dbc.Authenticator(ctx).GetUser("user1")
+	sent, _, resp, err := bt.SendRev("clientMeta", "1-abc", []byte(`{"key": "val", "channels": ["user1"], "_meta": {"deviceId": "device1", "channels": ["secret"]}}`), blip.Properties{})
+	require.True(t, sent)
 	require.NoError(t, err)
+	require.Equal(t, "", resp.Properties["Error-Code"])
 
-	userDb, err := db.GetDatabase(dbc, user1)
+	// _meta should be held in sync metadata, and not be seen by the sync function
+	doc, err := rt.GetDatabase().GetDocument(base.TestCtx(t), "clientMeta", db.DocUnmarshalAll)
 	require.NoError(t, err)
+	assert.Equal(t, "device1", doc.ClientMeta["deviceId"])
+	_, ok := doc.Body()[db.BodyClientMeta]
+	assert.False(t, ok)
+	assert.Contains(t, doc.Channels, "user1")
+	assert.NotContains(t, doc.Channels, "secret")
 
-	userWaiter := userDb.NewUserWaiter()
+	response := rt.SendAdminRequest(http.MethodGet, "/db/clientMeta", "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.NotContains(t, response.Body.String(), db.BodyClientMeta)
 
-	// Put document that triggers access grant for user to channel PBS
-	response := rt.SendAdminRequest("PUT", "/db/access1", `{"accessUser":"user1", "accessChannel":["PBS"]}`)
-	RequireStatus(t, response, 201)
+	// _meta should round-trip on pull
+	pulledDoc, err := bt.GetDocAtRev("clientMeta", "1-abc")
+	require.NoError(t, err)
+	clientMeta, ok := pulledDoc[db.BodyClientMeta].(map[string]interface{})
+	require.True(t, ok, "Expected _meta in pulled rev, got %v", pulledDoc)
+	assert.Equal(t, "device1", clientMeta["deviceId"])
 
-	// Wait for notification
-	require.True(t, db.WaitForUserWaiterChange(userWaiter))
+	// _meta must be an object
+	sent, _, resp, err = bt.SendRevWithHistory("clientMeta", "2-abc", []string{"1-abc"}, []byte(`{"key": "val", "_meta": "device1"}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "400", resp.Properties["Error-Code"])
+}
 
-	// Add a doc in the PBS channel
-	_, _, addRevResponse, err := bt.SendRev(
-		"foo",
-		"1-abc",
-		[]byte(`{"key": "val", "channels": ["PBS"]}`),
-		blip.Properties{},
-	)
-	assert.NoError(t, err)
+// TestBlipSendDeltaRev verifies that a delta pushed with BlipTester.SendDeltaRev is applied to the full body.
+func TestBlipSendDeltaRev(t *testing.T) {
 
-	// Make assertions on response to make sure the change was accepted
-	addRevResponseBody, err := addRevResponse.Body()
-	assert.NoError(t, err, "Unexpected error")
-	errorCode, hasErrorCode := addRevResponse.Properties["Error-Code"]
-	assert.False(t, hasErrorCode)
-	if hasErrorCode {
-		t.Fatalf("Unexpected error sending revision.  Error code: %v.  Response body: %s", errorCode, addRevResponseBody)
+	if !base.IsEnterpriseEdition() {
+		t.Skip("Delta sync only supported in EE")
 	}
 
-}
-
-// Grant a user access to a channel via the Sync Function and a doc change, and make sure
-// it shows up in the user's changes feed
-func TestAccessGrantViaSyncFunction(t *testing.T) {
-
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	// Setup
-	rtConfig := RestTesterConfig{
-		SyncFn: `function(doc) {channel(doc.channels); access(doc.accessUser, doc.accessChannel);}`,
-	}
-	rt := NewRestTester(t, &rtConfig)
+	sgUseDeltas := true
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			DeltaSync: &DeltaSyncConfig{
+				Enabled: &sgUseDeltas,
+			},
+		}},
+	})
 	defer rt.Close()
 	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
 	}, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	// Add a doc in the PBS channel
-	_, _, _, _ = bt.SendRev(
-		"foo",
-		"1-abc",
-		[]byte(`{"key": "val", "channels": ["PBS"]}`),
-		blip.Properties{},
-	)
-
-	// Put document that triggers access grant for user to channel PBS
-	response := rt.SendAdminRequest("PUT", "/db/access1", `{"accessUser":"user1", "accessChannel":["PBS"]}`)
-	RequireStatus(t, response, 201)
+	sent, _, _, err := bt.SendRev("doc1", "1-abc", []byte(`{"greetings": [{"hello": "world!"}], "count": 1}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
 
-	// Add another doc in the PBS channel
-	_, _, _, _ = bt.SendRev(
-		"foo2",
-		"1-abc",
-		[]byte(`{"key": "val", "channels": ["PBS"]}`),
-		blip.Properties{},
-	)
+	// Replace count and append to greetings
+	sent, _, _, err = bt.SendDeltaRev("doc1", "1-abc", "2-abc", []byte(`{"count": 2, "greetings": {"1-": [{"hi": "alice"}]}}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
 
-	// Make sure we can see it by getting changes
-	changes := bt.WaitForNumChanges(2)
-	log.Printf("changes: %+v", changes)
-	assert.Equal(t, 2, len(changes))
+	bt.RequireRevBody("doc1", "2-abc", []byte(`{"greetings": [{"hello": "world!"}, {"hi": "alice"}], "count": 2}`))
 
+	// A delta against a revision that isn't known is rejected
+	sent, _, resp, err := bt.SendDeltaRev("doc1", "1-def", "2-def", []byte(`{"count": 3}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "422", resp.Properties[db.BlipErrorCode])
 }
 
-// Grant a user access to a channel via the REST Admin API, and make sure
-// it shows up in the user's changes feed
-func TestAccessGrantViaAdminApi(t *testing.T) {
+// TestBlipRevClientMetaDeltaSync ensures _meta survives deltas in both directions - a pushed delta that doesn't modify
+// _meta must preserve it, and deltas sent on pull must carry changes to it.
+func TestBlipRevClientMetaDeltaSync(t *testing.T) {
+
+	if !base.IsEnterpriseEdition() {
+		t.Skip("Delta sync only supported in EE")
+	}
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	// Create blip tester
-	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+	sgUseDeltas := true
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			DeltaSync: &DeltaSyncConfig{
+				Enabled: &sgUseDeltas,
+			},
+		}},
+		GuestEnabled: true,
 	})
-	require.NoError(t, err, "Unexpected error creating BlipTester")
-	defer bt.Close()
+	defer rt.Close()
 
-	// Add a doc in the PBS channel
-	_, _, _, _ = bt.SendRev(
-		"foo",
-		"1-abc",
-		[]byte(`{"key": "val", "channels": ["PBS"]}`),
-		blip.Properties{},
-	)
+	client1, err := NewBlipTesterClientOptsWithRT(t, rt, nil)
+	require.NoError(t, err)
+	defer client1.Close()
+	client1.ClientDeltas = true
 
-	// Update the user doc to grant access to PBS
-	response := bt.restTester.SendAdminRequest("PUT", "/db/_user/user1", `{"admin_channels":["user1", "PBS"]}`)
-	RequireStatus(t, response, 200)
+	client2, err := NewBlipTesterClientOptsWithRT(t, rt, nil)
+	require.NoError(t, err)
+	defer client2.Close()
+	client2.ClientDeltas = true
+	require.NoError(t, client2.StartPull())
 
-	// Add another doc in the PBS channel
-	_, _, _, _ = bt.SendRev(
-		"foo2",
-		"1-abc",
-		[]byte(`{"key": "val", "channels": ["PBS"]}`),
-		blip.Properties{},
-	)
+	// Push a delta that leaves _meta unchanged
+	_, err = client1.PushRev("doc1", "", []byte(`{"greeting": "hi", "_meta": {"deviceId": "device1"}}`))
+	require.NoError(t, err)
+	_, err = client1.PushRev("doc1", "1-abc", []byte(`{"greeting": "hello", "_meta": {"deviceId": "device1"}}`))
+	require.NoError(t, err)
 
-	// Make sure we can see both docs in the changes
-	changes := bt.WaitForNumChanges(2)
-	assert.Equal(t, 2, len(changes))
+	msg, ok := client1.pushReplication.WaitForMessage(4)
+	require.True(t, ok)
+	assert.Equal(t, "1-abc", msg.Properties[db.RevMessageDeltaSrc])
+	msgBody, err := msg.Body()
+	require.NoError(t, err)
+	assert.NotContains(t, string(msgBody), db.BodyClientMeta)
+
+	doc, err := rt.GetDatabase().GetDocument(base.TestCtx(t), "doc1", db.DocUnmarshalAll)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", doc.Body()["greeting"])
+	assert.Equal(t, "device1", doc.ClientMeta["deviceId"])
 
+	// Update _meta from the other client, and ensure the delta pulled by the first client carries it
+	data, ok := client2.WaitForRev("doc1", "2-abc")
+	require.True(t, ok)
+	var pulledBody db.Body
+	require.NoError(t, pulledBody.Unmarshal(data))
+	assert.Equal(t, map[string]interface{}{"deviceId": "device1"}, pulledBody[db.BodyClientMeta])
+
+	_, err = client2.PushRev("doc1", "2-abc", []byte(`{"greeting": "hello", "_meta": {"deviceId": "device2"}}`))
+	require.NoError(t, err)
+
+	require.NoError(t, client1.StartPull())
+	data, ok = client1.WaitForRev("doc1", "3-abc")
+	require.True(t, ok)
+	revMsg, ok := client1.WaitForBlipRevMessage("doc1", "3-abc")
+	require.True(t, ok)
+	assert.Equal(t, "2-abc", revMsg.Properties[db.RevMessageDeltaSrc])
+	pulledBody = nil
+	require.NoError(t, pulledBody.Unmarshal(data))
+	assert.Equal(t, map[string]interface{}{"deviceId": "device2"}, pulledBody[db.BodyClientMeta])
 }
 
-func TestCheckpoint(t *testing.T) {
+// TestBlipRevClientMetaConflict ensures a pushed revision that doesn't win a conflict doesn't replace the winner's
+// _meta, and that pulling each branch returns its own _meta.
+func TestBlipRevClientMetaConflict(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	// Create blip tester
-	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			AllowConflicts: base.BoolPtr(true),
+		}},
 	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"user1"},
+	}, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	client := "testClient"
-
-	// Get the checkpoint -- expect to be missing at this point
-	request := blip.NewRequest()
-	request.SetCompressed(true)
-	request.SetProfile("getCheckpoint")
-	request.Properties["client"] = client
-	sent := bt.sender.Send(request)
-	if !sent {
-		panic(fmt.Sprintf("Failed to get checkpoint for client: %v", client))
-	}
-	checkpointResponse := request.Response()
-
-	// Expect to get no checkpoint
-	errorcode, ok := checkpointResponse.Properties["Error-Code"]
-	assert.True(t, ok)
-	assert.Equal(t, "404", errorcode)
+	sent, _, _, err := bt.SendRev("conflictMeta", "1-abc", []byte(`{"channels": ["user1"], "_meta": {"branch": "root"}}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	sent, _, _, err = bt.SendRevWithHistory("conflictMeta", "2-bbb", []string{"1-abc"}, []byte(`{"channels": ["user1"], "_meta": {"branch": "winner"}}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	// 2-aaa loses the revid comparison against 2-bbb
+	sent, _, _, err = bt.SendRevWithHistory("conflictMeta", "2-aaa", []string{"1-abc"}, []byte(`{"channels": ["user1"], "_meta": {"branch": "loser"}}`), blip.Properties{"noconflicts": "false"})
+	require.True(t, sent)
+	require.NoError(t, err)
 
-	// Set a checkpoint
-	requestSetCheckpoint := blip.NewRequest()
-	requestSetCheckpoint.SetCompressed(true)
-	requestSetCheckpoint.SetProfile("setCheckpoint")
-	requestSetCheckpoint.Properties["client"] = client
-	checkpointBody := db.Body{"Key": "Value"}
-	assert.NoError(t, requestSetCheckpoint.SetJSONBody(checkpointBody))
-	// requestSetCheckpoint.Properties["rev"] = "rev1"
-	sent = bt.sender.Send(requestSetCheckpoint)
-	if !sent {
-		panic(fmt.Sprintf("Failed to set checkpoint for client: %v", client))
-	}
-	checkpointResponse = requestSetCheckpoint.Response()
-	body, err := checkpointResponse.Body()
-	assert.NoError(t, err, "Unexpected error")
-	log.Printf("responseSetCheckpoint body: %s", body)
+	doc, err := rt.GetDatabase().GetDocument(base.TestCtx(t), "conflictMeta", db.DocUnmarshalAll)
+	require.NoError(t, err)
+	require.Equal(t, "2-bbb", doc.CurrentRev)
+	assert.Equal(t, "winner", doc.ClientMeta["branch"])
 
-	// Get the checkpoint and make sure it has the expected value
-	requestGetCheckpoint2 := blip.NewRequest()
-	requestGetCheckpoint2.SetCompressed(true)
-	requestGetCheckpoint2.SetProfile("getCheckpoint")
-	requestGetCheckpoint2.Properties["client"] = client
-	sent = bt.sender.Send(requestGetCheckpoint2)
-	if !sent {
-		panic(fmt.Sprintf("Failed to get checkpoint for client: %v", client))
+	for revID, expectedBranch := range map[string]string{"2-bbb": "winner", "2-aaa": "loser"} {
+		rev, err := rt.GetDatabase().GetRevisionCacheForTest().Get(base.TestCtx(t), "conflictMeta", revID, db.RevCacheOmitBody, db.RevCacheOmitDelta)
+		require.NoError(t, err)
+		assert.Equal(t, expectedBranch, rev.ClientMeta["branch"], "unexpected _meta for rev %s", revID)
+		assert.NotContains(t, string(rev.BodyBytes), db.BodyClientMeta)
 	}
-	checkpointResponse = requestGetCheckpoint2.Response()
-	body, err = checkpointResponse.Body()
-	assert.NoError(t, err, "Unexpected error")
-	log.Printf("body: %s", body)
-	assert.True(t, strings.Contains(string(body), "Key"))
-	assert.True(t, strings.Contains(string(body), "Value"))
 
+	// A REST read of the losing branch mustn't expose _meta in the body
+	response := rt.SendAdminRequest(http.MethodGet, "/db/conflictMeta?rev=2-aaa", "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.NotContains(t, response.Body.String(), db.BodyClientMeta)
 }
 
-// Test Attachment replication behavior described here: https://github.com/couchbase/couchbase-lite-core/wiki/Replication-Protocol
-// - Put attachment via blip
-// - Verifies that getAttachment won't return attachment "out of context" of a rev request
-// - Get attachment via REST and verifies it returns the correct content
-func TestPutAttachmentViaBlipGetViaRest(t *testing.T) {
+// TestBlipRevDocIDPattern ensures pushed revs are rejected when their docID doesn't match the configured doc_id_pattern,
+// or uses the reserved _sync: prefix.
+func TestBlipRevDocIDPattern(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	// Create blip tester
-	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			DocIDPattern: `^(order|invoice)::[0-9]+$`,
+		}},
 	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"user1"},
+	}, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	attachmentBody := "attach"
-	digest := db.Sha1DigestKey([]byte(attachmentBody))
-
-	input := SendRevWithAttachmentInput{
-		docId:            "doc",
-		revId:            "1-rev1",
-		attachmentName:   "myAttachment",
-		attachmentLength: len(attachmentBody),
-		attachmentBody:   attachmentBody,
-		attachmentDigest: digest,
+	testCases := []struct {
+		docID         string
+		expectedError string
+	}{
+		{docID: "order::1"},
+		{docID: "invoice::22"},
+		{docID: "customer::1", expectedError: "403"},
+		{docID: "order::abc", expectedError: "403"},
+		{docID: base.SyncDocPrefix + "order::1", expectedError: "400"},
 	}
-	bt.SendRevWithAttachment(input)
-
-	// Try to fetch the attachment directly via getAttachment, expected to fail w/ 403 error for security reasons
-	// since it's not in the context of responding to a "rev" request from the peer.
-	getAttachmentRequest := blip.NewRequest()
-	getAttachmentRequest.SetProfile(db.MessageGetAttachment)
-	getAttachmentRequest.Properties[db.GetAttachmentDigest] = input.attachmentDigest
-	getAttachmentRequest.Properties[db.GetAttachmentID] = input.docId
-	sent := bt.sender.Send(getAttachmentRequest)
-	if !sent {
-		panic(fmt.Sprintf("Failed to send request for doc: %v", input.docId))
+	for _, tc := range testCases {
+		t.Run(tc.docID, func(t *testing.T) {
+			sent, _, resp, err := bt.SendRev(tc.docID, "1-abc", []byte(`{"channels": ["user1"]}`), blip.Properties{})
+			require.True(t, sent)
+			assert.Equal(t, tc.expectedError, resp.Properties["Error-Code"])
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+				response := rt.SendAdminRequest(http.MethodGet, "/db/"+tc.docID, "")
+				RequireStatus(t, response, http.StatusOK)
+			} else {
+				require.Error(t, err)
+			}
+		})
 	}
-	getAttachmentResponse := getAttachmentRequest.Response()
-	errorCode, hasErrorCode := getAttachmentResponse.Properties["Error-Code"]
-	assert.Equal(t, "403", errorCode) // "Attachment's doc not being synced"
+
+	// Documents that already exist, e.g. written before the pattern was configured, can still be updated and deleted
+	response := rt.SendAdminRequest(http.MethodPut, "/db/legacy::1", `{"channels": ["user1"]}`)
+	RequireStatus(t, response, http.StatusCreated)
+	legacyRev1 := RespRevID(t, response)
+
+	sent, _, resp, err := bt.SendRevWithHistory("legacy::1", "2-abc", []string{legacyRev1}, []byte(`{"channels": ["user1"]}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	sent, _, resp, err = bt.SendRevWithHistory("legacy::1", "3-abc", []string{"2-abc", legacyRev1}, []byte(`{}`), blip.Properties{db.RevMessageDeleted: "1"})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	response = rt.SendAdminRequest(http.MethodGet, "/db/legacy::1?rev=3-abc", "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.Contains(t, response.Body.String(), `"_deleted":true`)
+}
+
+// TestBlipRevMaxDocumentSize ensures revs with bodies over the database's maximum document size are rejected with 413,
+// including deltas that produce an oversized body once applied.
+func TestBlipRevMaxDocumentSize(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	sgUseDeltas := base.IsEnterpriseEdition()
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			MaxDocumentSizeBytes: base.Uint32Ptr(200),
+			DeltaSync: &DeltaSyncConfig{
+				Enabled: &sgUseDeltas,
+			},
+		}},
+	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	_, _, _, err = bt.SendRev("doc1", "1-abc", []byte(`{"channels": ["ABC"]}`), blip.Properties{})
+	require.NoError(t, err)
+
+	padding := strings.Repeat("x", 180)
+	errorBody := bt.RequireRevRejected("doc2", "1-abc", []byte(`{"channels": ["ABC"], "padding": "`+padding+padding+`"}`), http.StatusRequestEntityTooLarge)
+	assert.Contains(t, errorBody.Reason, "exceeds the maximum document size of 200 bytes")
+	response := rt.SendAdminRequest(http.MethodGet, "/db/doc2", "")
+	RequireStatus(t, response, http.StatusNotFound)
+
+	if !sgUseDeltas {
+		return
+	}
+
+	// The delta is under the limit, but the body it produces isn't
+	sent, _, resp, err := bt.SendDeltaRev("doc1", "1-abc", "2-abc", []byte(`{"padding": "`+padding+`"}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "413", resp.Properties[db.BlipErrorCode])
+	response = rt.SendAdminRequest(http.MethodGet, "/db/doc1", "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.NotContains(t, response.Body.String(), "padding")
+}
+
+// TestBlipRevDocCountQuota ensures new docs pushed once the database has reached its doc count quota are rejected with
+// 507, while existing docs can still be updated and read.
+func TestBlipRevDocCountQuota(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			DocCountQuota: base.Uint64Ptr(2),
+		}},
+	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"user1"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	for _, docID := range []string{"doc1", "doc2"} {
+		sent, _, resp, err := bt.SendRev(docID, "1-abc", []byte(`{"channels": ["user1"]}`), blip.Properties{})
+		require.True(t, sent)
+		require.NoError(t, err)
+		assert.Equal(t, "", resp.Properties["Error-Code"])
+	}
+
+	// Quota reached, new docs are rejected
+	sent, _, resp, err := bt.SendRev("doc3", "1-abc", []byte(`{"channels": ["user1"]}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "507", resp.Properties["Error-Code"])
+	response := rt.SendAdminRequest(http.MethodGet, "/db/doc3", "")
+	RequireStatus(t, response, http.StatusNotFound)
+
+	// Existing docs can still be updated and read
+	sent, _, resp, err = bt.SendRevWithHistory("doc1", "2-abc", []string{"1-abc"}, []byte(`{"channels": ["user1"], "updated": true}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	response = rt.SendAdminRequest(http.MethodGet, "/db/doc1", "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.Contains(t, response.Body.String(), `"updated":true`)
+
+	// Purging a doc frees up quota for a new doc
+	response = rt.SendAdminRequest(http.MethodPost, "/db/_purge", `{"doc2": ["*"]}`)
+	RequireStatus(t, response, http.StatusOK)
+
+	sent, _, resp, err = bt.SendRev("doc3", "1-abc", []byte(`{"channels": ["user1"]}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+}
+
+// TestBlipTombstoneTTL ensures tombstones pushed over BLIP are purged by tombstone compaction once they're older than
+// the configured tombstone TTL, and are then removed from the changes feed.
+func TestBlipTombstoneTTL(t *testing.T) {
+
+	if !base.TestUseXattrs() {
+		t.Skip("Tombstone compaction requires xattrs")
+	}
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg, base.KeyCRUD)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		GuestEnabled: true,
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			TombstoneTTLSecs: base.Uint32Ptr(1),
+		}},
+	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, nil, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	dbc := rt.GetDatabase()
+	require.Equal(t, time.Second, dbc.PurgeInterval)
+
+	pushTombstone := func(docID string) {
+		sent, _, _, err := bt.SendRev(docID, "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+		require.True(t, sent)
+		require.NoError(t, err)
+		sent, _, _, err = bt.SendRevWithHistory(docID, "2-abc", []string{"1-abc"}, []byte(`{}`), blip.Properties{db.RevMessageDeleted: "1"})
+		require.True(t, sent)
+		require.NoError(t, err)
+	}
+
+	pushTombstone("expiredTombstone")
+	// Tombstones are aged in whole seconds, so wait until the tombstone is older than the TTL
+	time.Sleep(2500 * time.Millisecond)
+	pushTombstone("recentTombstone")
+
+	ctx := base.TestCtx(t)
+	database, err := db.GetDatabase(dbc, nil)
+	require.NoError(t, err)
+	purgedCount, err := database.Compact(ctx, false, func(purgedDocCount *int) {}, base.NewSafeTerminator())
+	require.NoError(t, err)
+	assert.Equal(t, 1, purgedCount)
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_raw/expiredTombstone", "")
+	RequireStatus(t, response, http.StatusNotFound)
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_raw/recentTombstone", "")
+	RequireStatus(t, response, http.StatusOK)
+
+	changes, err := rt.WaitForChanges(1, "/db/_changes", "", true)
+	require.NoError(t, err)
+	require.Len(t, changes.Results, 1)
+	assert.Equal(t, "recentTombstone", changes.Results[0].ID)
+}
+
+// Test send and retrieval of a doc with a large numeric value.  Ensure proper large number handling.
+//
+//	Validate deleted handling (includes check for https://github.com/couchbase/sync_gateway/issues/3341)
+func TestBlipSendAndGetLargeNumberRev(t *testing.T) {
+	base.LongRunningTest(t)
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+	btSpec := BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}
+	bt, err := NewBlipTesterFromSpecWithRT(t, &btSpec, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// Send non-deleted rev
+	sent, _, resp, err := bt.SendRev("largeNumberRev", "1-abc", []byte(`{"key": "val", "largeNumber":9223372036854775807, "channels": ["user1"]}`), blip.Properties{})
+	assert.True(t, sent)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	// Get non-deleted rev
+	response := bt.restTester.SendAdminRequest("GET", "/db/largeNumberRev?rev=1-abc", "")
+	RequireStatus(t, response, 200) // Check the raw bytes, because unmarshalling the response would be another opportunity for the number to get modified
+	responseString := string(response.Body.Bytes())
+	if !strings.Contains(responseString, `9223372036854775807`) {
+		t.Errorf("Response does not contain the expected number format.  Response: %s", responseString)
+	}
+}
+
+func AssertChangeEquals(t *testing.T, change []interface{}, expectedChange ExpectedChange) {
+	if err := expectedChange.Equals(change); err != nil {
+		t.Errorf("Change %+v does not equal expected change: %+v.  Error: %v", change, expectedChange, err)
+	}
+}
+
+// Test adding / retrieving attachments
+func TestAttachments(t *testing.T) {
+	// TODO: Write tests to cover scenario
+	t.Skip("not tested")
+}
+
+// Make sure it's not possible to have two outstanding subChanges w/ continuous=true.
+// Expected behavior is that the first continous change subscription should get discarded in favor of 2nd.
+func TestConcurrentChangesSubscriptions(t *testing.T) {
+	// TODO: Write tests to cover scenario
+	t.Skip("not tested")
+}
+
+// TestBlipReconnectResumesFromCheckpoint verifies that after a network interruption, a client can resume pulling from
+// the checkpoint it set before being disconnected.
+func TestBlipReconnectResumesFromCheckpoint(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	for _, docID := range []string{"doc1", "doc2"} {
+		RequireStatus(t, rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"channels": ["ABC"]}`), http.StatusCreated)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+	changes := bt.GetChanges()
+	require.Len(t, changes, 2)
+
+	lastSeq, err := base.JSONMarshal(changes[1][0])
+	require.NoError(t, err)
+	_, _, resp, err := bt.SetCheckpoint("testclient", "", []byte(fmt.Sprintf(`{"last_sequence": %s}`, lastSeq)))
+	require.NoError(t, err)
+	require.Equal(t, "", resp.Properties[db.BlipErrorCode])
+
+	// Changes made while the client is disconnected are pulled after reconnecting, from the checkpoint
+	bt.Disconnect()
+	for _, docID := range []string{"doc3", "doc4"} {
+		RequireStatus(t, rt.SendAdminRequest(http.MethodPut, "/db/"+docID, `{"channels": ["ABC"]}`), http.StatusCreated)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+	require.NoError(t, bt.Reconnect())
+
+	checkpointRev, body, err := bt.GetCheckpoint("testclient")
+	require.NoError(t, err)
+	assert.Equal(t, resp.Rev(), checkpointRev)
+	var checkpoint struct {
+		LastSequence json.Number `json:"last_sequence"`
+	}
+	require.NoError(t, base.JSONUnmarshal(body, &checkpoint))
+	assert.Equal(t, string(lastSeq), checkpoint.LastSequence.String())
+
+	changes = bt.GetChangesWithProperties(blip.Properties{db.SubChangesSince: checkpoint.LastSequence.String()})
+	docIDs := make([]string, 0, len(changes))
+	for _, change := range changes {
+		docIDs = append(docIDs, change[1].(string))
+	}
+	assert.Equal(t, []string{"doc3", "doc4"}, docIDs)
+}
+
+// TestBlipPurgeDocRemovedFromChanges verifies that a doc purged with BlipTester.PurgeDoc no longer appears in changes.
+func TestBlipPurgeDocRemovedFromChanges(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	for _, docID := range []string{"doc1", "doc2"} {
+		sent, _, _, err := bt.SendRev(docID, "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+		require.True(t, sent)
+		require.NoError(t, err)
+	}
+	require.NoError(t, bt.restTester.WaitForPendingChanges())
+	require.Len(t, bt.GetChanges(), 2)
+
+	require.NoError(t, bt.PurgeDoc("doc1"))
+	bt.RequireDocNotInChanges("doc1")
+
+	changes := bt.GetChanges()
+	require.Len(t, changes, 1)
+	assert.Equal(t, "doc2", changes[0][1])
+}
+
+// Create a continous changes subscription that has docs in multiple channels, and make sure
+// all docs are received
+func TestMultiChannelContinousChangesSubscription(t *testing.T) {
+	// TODO: Write tests to cover scenario
+	t.Skip("not tested")
+}
+
+// Make sure changes can be counted by the channels of their revisions
+func TestBlipWaitForNumChangesInChannel(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	})
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	docs := map[string]string{
+		"doc1": `{"channels": ["ABC"]}`,
+		"doc2": `{"channels": ["ABC", "NBC"]}`,
+		"doc3": `{"channels": ["NBC"]}`,
+		"doc4": `{"channels": ["CBS"]}`,
+	}
+	for docID, body := range docs {
+		_, _, _, err = bt.SendRev(docID, "1-abc", []byte(body), blip.Properties{})
+		require.NoError(t, err, "Error sending revision")
+	}
+
+	changes := bt.WaitForNumChangesInChannel("NBC", 2)
+	assert.Equal(t, 2, bt.CountChangesByChannel(changes)["NBC"])
+
+	changes = bt.WaitForNumChanges(len(docs))
+	require.Len(t, changes, len(docs))
+	assert.Equal(t, map[string]int{"ABC": 2, "NBC": 2, "CBS": 1}, bt.CountChangesByChannel(changes))
+
+	for _, change := range changes {
+		if change[1] == "doc2" {
+			assert.Equal(t, base.SetOf("ABC", "NBC"), bt.ChangeChannels(change))
+		}
+	}
+}
+
+// Test that a doc pushed with an expiry is removed from changes once it expires.  Expired docs are tombstoned on import,
+// so this requires xattrs.
+func TestBlipSendRevWithExpiry(t *testing.T) {
+	if base.UnitTestUrlIsWalrus() {
+		t.Skip("Expiry only supported by Couchbase Server")
+	}
+	if !base.TestUseXattrs() {
+		t.Skip("Expired docs are only tombstoned on import, which requires xattrs")
+	}
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg, base.KeyImport)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	})
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	_, _, _, err = bt.SendRevWithExpiry("expiringDoc", "1-abc", []byte(`{"channels": ["ABC"]}`), 2, blip.Properties{})
+	require.NoError(t, err, "Error sending revision")
+	_, _, _, err = bt.SendRev("doc", "1-abc", []byte(`{"channels": ["ABC"]}`), blip.Properties{})
+	require.NoError(t, err, "Error sending revision")
+
+	changes := bt.WaitForNumChanges(2)
+	require.Len(t, changes, 2)
+	for _, change := range changes {
+		assert.False(t, isDeletedChange(change), "Unexpected deleted change: %v", change)
+	}
+
+	rt := bt.restTester
+	resp := rt.SendAdminRequest(http.MethodGet, "/db/expiringDoc?show_exp=true", "")
+	RequireStatus(t, resp, http.StatusOK)
+	var body db.Body
+	require.NoError(t, base.JSONUnmarshal(resp.Body.Bytes(), &body))
+	assert.NotNil(t, body[db.BodyExpiry])
+
+	// Wait for the doc to expire, then trigger on-demand import to tombstone it
+	err = rt.WaitForCondition(func() bool {
+		_, _, err = rt.GetDatabase().Bucket.GetRaw("expiringDoc")
+		return base.IsDocNotFoundError(err)
+	})
+	require.NoError(t, err)
+	resp = rt.SendAdminRequest(http.MethodGet, "/db/expiringDoc", "")
+	RequireStatus(t, resp, http.StatusNotFound)
+
+	bt.WaitForDocExpiredFromChanges("expiringDoc")
+
+	// The doc without an expiry is unaffected
+	for _, change := range bt.GetChanges() {
+		if change[1] == "doc" {
+			assert.False(t, isDeletedChange(change), "Unexpected deleted change: %v", change)
+		}
+	}
+}
+
+// Test setting and getting checkpoints
+func TestBlipSetCheckpoint(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+	btSpec := BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}
+	bt, err := NewBlipTesterFromSpecWithRT(t, &btSpec, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// Create new checkpoint
+	checkpointBody := []byte(`{"client_seq":"1000"}`)
+	sent, _, resp, err := bt.SetCheckpoint("testclient", "", checkpointBody)
+	assert.True(t, sent)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	checkpointRev := resp.Rev()
+	assert.Equal(t, "0-1", checkpointRev)
+
+	// Validate checkpoint existence in bucket (local file name "/" needs to be URL encoded as %252F)
+	response := rt.SendAdminRequest("GET", "/db/_local/checkpoint%252Ftestclient", "")
+	RequireStatus(t, response, 200)
+	var responseBody map[string]interface{}
+	err = base.JSONUnmarshal(response.Body.Bytes(), &responseBody)
+	assert.Equal(t, "1000", responseBody["client_seq"])
+
+	// Attempt to update the checkpoint with previous rev
+	checkpointBody = []byte(`{"client_seq":"1005"}`)
+	sent, _, resp, err = bt.SetCheckpoint("testclient", checkpointRev, checkpointBody)
+	assert.True(t, sent)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+	checkpointRev = resp.Rev()
+	assert.Equal(t, "0-2", checkpointRev)
+
+	// Updates with a stale rev, or without a rev, are rejected so that clients sharing a client ID can't clobber
+	// each other's checkpoint
+	for _, staleRev := range []string{"0-1", ""} {
+		sent, _, resp, err = bt.SetCheckpoint("testclient", staleRev, []byte(`{"client_seq":"1001"}`))
+		assert.True(t, sent)
+		assert.NoError(t, err)
+		assert.Equal(t, "409", resp.Properties["Error-Code"], "Expected conflict for rev %q", staleRev)
+	}
+
+	// getCheckpoint returns the current rev, for a read-modify-write
+	getCheckpointRequest := blip.NewRequest()
+	getCheckpointRequest.SetProfile(db.MessageGetCheckpoint)
+	getCheckpointRequest.Properties[db.BlipClient] = "testclient"
+	require.True(t, bt.sender.Send(getCheckpointRequest))
+	getCheckpointResponse := getCheckpointRequest.Response()
+	require.Equal(t, "", getCheckpointResponse.Properties["Error-Code"])
+	assert.Equal(t, checkpointRev, getCheckpointResponse.Properties[db.GetCheckpointResponseRev])
+	body, err := getCheckpointResponse.Body()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"client_seq":"1005"}`, string(body))
+
+	sent, _, resp, err = bt.SetCheckpoint("testclient", getCheckpointResponse.Properties[db.GetCheckpointResponseRev], []byte(`{"client_seq":"1010"}`))
+	assert.True(t, sent)
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+	assert.Equal(t, "0-3", resp.Rev())
+}
+
+// Test bulk removal of checkpoints over an admin blip connection, by client ID and by client ID prefix.
+func TestBlipBulkDelCheckpoint(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	adminBt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Unexpected error creating admin BlipTester")
+	defer adminBt.Close()
+
+	clients := []string{"fleetA-1", "fleetA-2", "fleetA-3", "fleetB-1"}
+	for _, client := range clients {
+		sent, _, resp, err := bt.SetCheckpoint(client, "", []byte(`{"client_seq":"1000"}`))
+		require.True(t, sent)
+		require.NoError(t, err)
+		require.Equal(t, "", resp.Properties[db.BlipErrorCode])
+	}
+
+	checkpointExists := func(client string) bool {
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_local/checkpoint%252F"+client, "")
+		return response.Code == http.StatusOK
+	}
+
+	// Non-admin connections can't bulk delete
+	_, err = bt.BulkDeleteCheckpoints([]string{"fleetB-1"}, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+	assert.True(t, checkpointExists("fleetB-1"))
+
+	// Explicit client IDs, including one that doesn't exist
+	deleted, err := adminBt.BulkDeleteCheckpoints([]string{"fleetA-1", "unknown"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.False(t, checkpointExists("fleetA-1"))
+
+	// Deleting by prefix requires GSI, and is rejected without deleting anything when using views
+	if base.TestsDisableGSI() {
+		_, err = adminBt.BulkDeleteCheckpoints(nil, "fleetA-")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "501")
+		for _, client := range clients[1:] {
+			assert.True(t, checkpointExists(client), "Expected checkpoint for %s to remain", client)
+		}
+		return
+	}
+
+	deleted, err = adminBt.BulkDeleteCheckpoints(nil, "fleetA-")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	for _, client := range clients[:3] {
+		assert.False(t, checkpointExists(client), "Expected checkpoint for %s to be deleted", client)
+	}
+	assert.True(t, checkpointExists("fleetB-1"))
+}
+
+// Test the admin endpoints for listing client checkpoints and deleting a client's checkpoint.
+func TestAdminClientCheckpoints(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	for _, client := range []string{"clientB", "clientA"} {
+		sent, _, resp, err := bt.SetCheckpoint(client, "", []byte(`{"local": 5, "remote": "10"}`))
+		require.True(t, sent)
+		require.NoError(t, err)
+		require.Equal(t, "", resp.Properties[db.BlipErrorCode])
+	}
+
+	// Deleting a checkpoint that doesn't exist
+	response := rt.SendAdminRequest(http.MethodDelete, "/db/_checkpoint/unknown", "")
+	RequireStatus(t, response, http.StatusNotFound)
+
+	response = rt.SendAdminRequest(http.MethodDelete, "/db/_checkpoint/clientB", "")
+	RequireStatus(t, response, http.StatusOK)
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_local/checkpoint%252FclientB", "")
+	RequireStatus(t, response, http.StatusNotFound)
+
+	// Listing checkpoints requires GSI
+	response = rt.SendAdminRequest(http.MethodGet, "/db/_checkpoints", "")
+	if base.TestsDisableGSI() {
+		RequireStatus(t, response, http.StatusNotImplemented)
+		return
+	}
+	RequireStatus(t, response, http.StatusOK)
+	var checkpoints []db.ClientCheckpoint
+	require.NoError(t, base.JSONUnmarshal(response.BodyBytes(), &checkpoints))
+	assert.Equal(t, []db.ClientCheckpoint{{Client: "clientA", LastSequence: "10"}}, checkpoints)
+}
+
+// Test the _blip_active admin endpoint and getActiveReplications message report client replication connections.
+func TestBlipActiveReplications(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	getActiveReplications := func() []db.BlipReplicationStatus {
+		response := rt.SendAdminRequest(http.MethodGet, "/db/_blip_active", "")
+		RequireStatus(t, response, http.StatusOK)
+		var statuses []db.BlipReplicationStatus
+		require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &statuses))
+		return statuses
+	}
+	assert.Empty(t, getActiveReplications())
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	bt.blipContext.HandlerForProfile[db.MessageChanges] = func(request *blip.Message) {
+		if !request.NoReply() {
+			// Respond that no revs are wanted
+			request.Response().SetBody([]byte("[]"))
+		}
+	}
+
+	RequireStatus(t, rt.SendAdminRequest(http.MethodPut, "/db/doc1", `{"channels": ["user1"]}`), http.StatusCreated)
+	seq, err := rt.SequenceForDoc("doc1")
+	require.NoError(t, err)
+
+	subChangesRequest := blip.NewRequest()
+	subChangesRequest.SetProfile(db.MessageSubChanges)
+	subChangesRequest.Properties[db.SubChangesContinuous] = "true"
+	require.True(t, bt.sender.Send(subChangesRequest))
+	require.Equal(t, "", subChangesRequest.Response().Properties[db.BlipErrorCode])
+
+	var statuses []db.BlipReplicationStatus
+	require.NoError(t, rt.WaitForCondition(func() bool {
+		statuses = getActiveReplications()
+		return len(statuses) == 1 && statuses[0].LastSentSeq != ""
+	}))
+	status := statuses[0]
+	// The server's BLIP context ID is generated independently of the client's
+	assert.NotEmpty(t, status.ContextID)
+	assert.Equal(t, "user1", status.User)
+	assert.Equal(t, string(db.BLIPClientTypeCBL2), status.ClientType)
+	assert.True(t, status.SubChangesActive)
+	assert.True(t, status.Continuous)
+	assert.Equal(t, "0", status.Since)
+	assert.Equal(t, strconv.FormatUint(seq, 10), status.LastSentSeq)
+	assert.Greater(t, status.BytesSent, int64(0))
+	assert.Greater(t, status.BytesReceived, int64(0))
+
+	// The same status is available to admin BLIP connections, which are reported too
+	adminBt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Unexpected error creating admin BlipTester")
+	defer adminBt.Close()
+
+	statuses, err = adminBt.GetActiveReplications()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	users := []string{statuses[0].User, statuses[1].User}
+	assert.ElementsMatch(t, []string{"user1", ""}, users)
+	assert.NotEqual(t, statuses[0].ContextID, statuses[1].ContextID)
+
+	_, err = bt.GetActiveReplications()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+
+	// Connections are no longer reported once closed
+	bt.sender.Close()
+	require.NoError(t, rt.WaitForCondition(func() bool {
+		return len(getActiveReplications()) == 1
+	}))
+}
+
+// Test the compression of rev and changes messages is reported for active replications.
+func TestBlipActiveReplicationsCompression(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// SendRev compresses revs, so a compressible body should compress well
+	body := `{"channels": ["ABC"], "value": "` + strings.Repeat("a", 1000) + `"}`
+	_, _, _, err = bt.SendRev("doc1", "1-abc", []byte(body), blip.Properties{})
+	require.NoError(t, err)
+	bt.WaitForNumChanges(1)
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/_blip_active", "")
+	RequireStatus(t, response, http.StatusOK)
+	var statuses []db.BlipReplicationStatus
+	require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 1)
+
+	revStatus := statuses[0].Compression[db.MessageRev]
+	assert.Equal(t, int64(1), revStatus.Messages)
+	assert.Equal(t, int64(1), revStatus.CompressedMessages)
+	assert.Equal(t, int64(len(body)), revStatus.Bytes)
+	assert.Less(t, revStatus.CompressedBytes, revStatus.Bytes)
+
+	changesStatus := statuses[0].Compression[db.MessageChanges]
+	assert.Greater(t, changesStatus.Messages, int64(0))
+	assert.Greater(t, changesStatus.Bytes, int64(0))
+}
+
+// Test getDocChannels reports all of a document's channels to admin connections, and only the channels the user can
+// see to user connections.
+func TestBlipGetDocChannels(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"ABC", "NBC"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	adminBt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Unexpected error creating admin BlipTester")
+	defer adminBt.Close()
+
+	// The default sync function assigns each doc to the channels in its channels property
+	sent, _, _, err := bt.SendRev("multiChannel", "1-abc", []byte(`{"channels": ["NBC", "CNN", "ABC"]}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	sent, _, _, err = bt.SendRev("cnnOnly", "1-abc", []byte(`{"channels": ["CNN"]}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+
+	docChannels, err := adminBt.GetDocChannels("multiChannel")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ABC", "CNN", "NBC"}, docChannels)
+
+	docChannels, err = bt.GetDocChannels("multiChannel")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ABC", "NBC"}, docChannels)
+
+	docChannels, err = adminBt.GetDocChannels("cnnOnly")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CNN"}, docChannels)
+
+	// The user can't see any of the doc's channels
+	_, err = bt.GetDocChannels("cnnOnly")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+
+	_, err = adminBt.GetDocChannels("missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+// TestBlipTesterWaitForNumChangesWithTimeout ensures waiting for changes that never arrive fails with an error instead of
+// blocking.
+func TestBlipTesterWaitForNumChangesWithTimeout(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	})
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	for _, docID := range []string{"doc1", "doc2"} {
+		_, _, _, err = bt.SendRev(docID, "1-abc", []byte(`{"channels": ["ABC"]}`), blip.Properties{})
+		require.NoError(t, err, "Error sending revision")
+	}
+
+	changes, err := bt.WaitForNumChangesWithTimeout(2, 10*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, changes, 2)
+
+	_, err = bt.WaitForNumChangesWithTimeout(3, 500*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "waiting for 3 changes, last saw 2")
+}
+
+// TestBlipGetRevTree verifies that admin connections can retrieve a document's full revision tree, including
+// conflicting branches and tombstones.
+func TestBlipGetRevTree(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"ABC"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	adminBt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Unexpected error creating admin BlipTester")
+	defer adminBt.Close()
+
+	// Create two branches from 1-abc, and tombstone one of them
+	body := []byte(`{"channels": ["ABC"]}`)
+	_, _, _, err = adminBt.SendRev("doc1", "1-abc", body, blip.Properties{})
+	require.NoError(t, err)
+	_, _, _, err = adminBt.SendRevWithHistory("doc1", "2-abc", []string{"1-abc"}, body, blip.Properties{})
+	require.NoError(t, err)
+	_, _, _, err = adminBt.SendRevWithHistory("doc1", "2-def", []string{"1-abc"}, body, blip.Properties{})
+	require.NoError(t, err)
+	_, _, _, err = adminBt.SendRevWithHistory("doc1", "3-abc", []string{"2-abc", "1-abc"}, []byte(`{}`), blip.Properties{db.RevMessageDeleted: "true"})
+	require.NoError(t, err)
+
+	revTree, err := adminBt.GetRevTree("doc1")
+	require.NoError(t, err)
+	assert.Equal(t, "2-def", revTree.CurrentRev)
+	require.Len(t, revTree.History, 4)
+	for revID, parent := range map[string]string{"1-abc": "", "2-abc": "1-abc", "2-def": "1-abc", "3-abc": "2-abc"} {
+		rev, ok := revTree.History[revID]
+		require.Truef(t, ok, "Rev %s missing from rev tree", revID)
+		assert.Equal(t, parent, rev.Parent)
+		assert.Equalf(t, revID == "3-abc", rev.Deleted, "Unexpected deleted flag for rev %s", revID)
+	}
+	assert.ElementsMatch(t, []string{"2-def", "3-abc"}, revTree.History.GetLeaves())
+
+	// Only admin connections can request rev trees
+	_, err = bt.GetRevTree("doc1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+
+	_, err = adminBt.GetRevTree("missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+
+	_, err = adminBt.GetRevTree("")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+}
+
+// Test no-conflicts mode replication (proposeChanges endpoint)
+func TestNoConflictsModeReplication(t *testing.T) {
+	// TODO: Write tests to cover scenario
+	t.Skip("not tested")
+}
+
+// Reproduce issue where ReloadUser was not being called, and so it was
+// using a stale channel access grant for the user.
+// Reproduces https://github.com/couchbase/sync_gateway/issues/2717
+func TestReloadUser(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	syncFn := `
+		function(doc) {
+			if (doc._id == "access1") {
+				// if its an access grant doc, grant access
+				access(doc.accessUser, doc.accessChannel);
+			} else {
+                // otherwise if its a normal access doc, require access then add to channels
+				requireAccess("PBS");
+				channel(doc.channels);
+			}
+		}
+    `
+
+	// Setup
+	rtConfig := RestTesterConfig{SyncFn: syncFn}
+	rt := NewRestTester(t, &rtConfig)
+	defer rt.Close()
+	ctx := rt.Context()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// Set up a ChangeWaiter for this test, to block until the user change notification happens
+	dbc := rt.GetDatabase()
+	user1, err := dbc.Authenticator(ctx).GetUser("user1")
+	require.NoError(t, err)
+
+	userDb, err := db.GetDatabase(dbc, user1)
+	require.NoError(t, err)
+
+	userWaiter := userDb.NewUserWaiter()
+
+	// Put document that triggers access grant for user to channel PBS
+	response := rt.SendAdminRequest("PUT", "/db/access1", `{"accessUser":"user1", "accessChannel":["PBS"]}`)
+	RequireStatus(t, response, 201)
+
+	// Wait for notification
+	require.True(t, db.WaitForUserWaiterChange(userWaiter))
+
+	// Add a doc in the PBS channel
+	_, _, addRevResponse, err := bt.SendRev(
+		"foo",
+		"1-abc",
+		[]byte(`{"key": "val", "channels": ["PBS"]}`),
+		blip.Properties{},
+	)
+	assert.NoError(t, err)
+
+	// Make assertions on response to make sure the change was accepted
+	addRevResponseBody, err := addRevResponse.Body()
+	assert.NoError(t, err, "Unexpected error")
+	errorCode, hasErrorCode := addRevResponse.Properties["Error-Code"]
+	assert.False(t, hasErrorCode)
+	if hasErrorCode {
+		t.Fatalf("Unexpected error sending revision.  Error code: %v.  Response body: %s", errorCode, addRevResponseBody)
+	}
+
+}
+
+// Grant a user access to a channel via the Sync Function and a doc change, and make sure
+// it shows up in the user's changes feed
+func TestAccessGrantViaSyncFunction(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	// Setup
+	rtConfig := RestTesterConfig{
+		SyncFn: `function(doc) {channel(doc.channels); access(doc.accessUser, doc.accessChannel);}`,
+	}
+	rt := NewRestTester(t, &rtConfig)
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// Add a doc in the PBS channel
+	_, _, _, _ = bt.SendRev(
+		"foo",
+		"1-abc",
+		[]byte(`{"key": "val", "channels": ["PBS"]}`),
+		blip.Properties{},
+	)
+
+	// Put document that triggers access grant for user to channel PBS
+	response := rt.SendAdminRequest("PUT", "/db/access1", `{"accessUser":"user1", "accessChannel":["PBS"]}`)
+	RequireStatus(t, response, 201)
+
+	// Add another doc in the PBS channel
+	_, _, _, _ = bt.SendRev(
+		"foo2",
+		"1-abc",
+		[]byte(`{"key": "val", "channels": ["PBS"]}`),
+		blip.Properties{},
+	)
+
+	// Make sure we can see both docs by getting changes
+	for _, docID := range []string{"foo", "foo2"} {
+		_, err := bt.WaitForChange(docID, "1-abc", 10*time.Second)
+		require.NoError(t, err)
+	}
+
+	// A revision that doesn't exist is never seen
+	_, err = bt.WaitForChange("foo", "2-abc", 100*time.Millisecond)
+	assert.Error(t, err)
+}
+
+// Grant a user access to a channel via the REST Admin API, and make sure
+// it shows up in the user's changes feed
+func TestAccessGrantViaAdminApi(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	// Create blip tester
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// Add a doc in the PBS channel
+	_, _, _, _ = bt.SendRev(
+		"foo",
+		"1-abc",
+		[]byte(`{"key": "val", "channels": ["PBS"]}`),
+		blip.Properties{},
+	)
+
+	// Update the user doc to grant access to PBS
+	response := bt.restTester.SendAdminRequest("PUT", "/db/_user/user1", `{"admin_channels":["user1", "PBS"]}`)
+	RequireStatus(t, response, 200)
+
+	// Add another doc in the PBS channel
+	_, _, _, _ = bt.SendRev(
+		"foo2",
+		"1-abc",
+		[]byte(`{"key": "val", "channels": ["PBS"]}`),
+		blip.Properties{},
+	)
+
+	// Make sure we can see both docs in the changes
+	changes := bt.WaitForNumChanges(2)
+	assert.Equal(t, 2, len(changes))
+
+}
+
+func TestCheckpoint(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	// Create blip tester
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	client := "testClient"
+
+	// Get the checkpoint -- expect to be missing at this point
+	request := blip.NewRequest()
+	request.SetCompressed(true)
+	request.SetProfile("getCheckpoint")
+	request.Properties["client"] = client
+	sent := bt.sender.Send(request)
+	if !sent {
+		panic(fmt.Sprintf("Failed to get checkpoint for client: %v", client))
+	}
+	checkpointResponse := request.Response()
+
+	// Expect to get no checkpoint
+	errorcode, ok := checkpointResponse.Properties["Error-Code"]
+	assert.True(t, ok)
+	assert.Equal(t, "404", errorcode)
+
+	// Set a checkpoint
+	requestSetCheckpoint := blip.NewRequest()
+	requestSetCheckpoint.SetCompressed(true)
+	requestSetCheckpoint.SetProfile("setCheckpoint")
+	requestSetCheckpoint.Properties["client"] = client
+	checkpointBody := db.Body{"Key": "Value"}
+	assert.NoError(t, requestSetCheckpoint.SetJSONBody(checkpointBody))
+	// requestSetCheckpoint.Properties["rev"] = "rev1"
+	sent = bt.sender.Send(requestSetCheckpoint)
+	if !sent {
+		panic(fmt.Sprintf("Failed to set checkpoint for client: %v", client))
+	}
+	checkpointResponse = requestSetCheckpoint.Response()
+	body, err := checkpointResponse.Body()
+	assert.NoError(t, err, "Unexpected error")
+	log.Printf("responseSetCheckpoint body: %s", body)
+
+	// Get the checkpoint and make sure it has the expected value
+	requestGetCheckpoint2 := blip.NewRequest()
+	requestGetCheckpoint2.SetCompressed(true)
+	requestGetCheckpoint2.SetProfile("getCheckpoint")
+	requestGetCheckpoint2.Properties["client"] = client
+	sent = bt.sender.Send(requestGetCheckpoint2)
+	if !sent {
+		panic(fmt.Sprintf("Failed to get checkpoint for client: %v", client))
+	}
+	checkpointResponse = requestGetCheckpoint2.Response()
+	body, err = checkpointResponse.Body()
+	assert.NoError(t, err, "Unexpected error")
+	log.Printf("body: %s", body)
+	assert.True(t, strings.Contains(string(body), "Key"))
+	assert.True(t, strings.Contains(string(body), "Value"))
+
+}
+
+// Test Attachment replication behavior described here: https://github.com/couchbase/couchbase-lite-core/wiki/Replication-Protocol
+// - Put attachment via blip
+// - Verifies that getAttachment won't return attachment "out of context" of a rev request
+// - Get attachment via REST and verifies it returns the correct content
+func TestPutAttachmentViaBlipGetViaRest(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	// Create blip tester
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	attachmentBody := "attach"
+	digest := db.Sha1DigestKey([]byte(attachmentBody))
+
+	input := SendRevWithAttachmentInput{
+		docId:            "doc",
+		revId:            "1-rev1",
+		attachmentName:   "myAttachment",
+		attachmentLength: len(attachmentBody),
+		attachmentBody:   attachmentBody,
+		attachmentDigest: digest,
+	}
+	bt.SendRevWithAttachment(input)
+
+	// Try to fetch the attachment directly via getAttachment, expected to fail w/ 403 error for security reasons
+	// since it's not in the context of responding to a "rev" request from the peer.
+	getAttachmentRequest := blip.NewRequest()
+	getAttachmentRequest.SetProfile(db.MessageGetAttachment)
+	getAttachmentRequest.Properties[db.GetAttachmentDigest] = input.attachmentDigest
+	getAttachmentRequest.Properties[db.GetAttachmentID] = input.docId
+	sent := bt.sender.Send(getAttachmentRequest)
+	if !sent {
+		panic(fmt.Sprintf("Failed to send request for doc: %v", input.docId))
+	}
+	getAttachmentResponse := getAttachmentRequest.Response()
+	errorCode, hasErrorCode := getAttachmentResponse.Properties["Error-Code"]
+	assert.Equal(t, "403", errorCode) // "Attachment's doc not being synced"
 	assert.True(t, hasErrorCode)
 
-	// Get the attachment via REST api and make sure it matches the attachment pushed earlier
-	response := bt.restTester.SendAdminRequest("GET", fmt.Sprintf("/db/%s/%s", input.docId, input.attachmentName), ``)
-	assert.Equal(t, input.attachmentBody, response.Body.String())
+	// Get the attachment via REST api and make sure it matches the attachment pushed earlier
+	response := bt.restTester.SendAdminRequest("GET", fmt.Sprintf("/db/%s/%s", input.docId, input.attachmentName), ``)
+	assert.Equal(t, input.attachmentBody, response.Body.String())
+
+}
+
+// Push a larger attachment via BLIP and fetch byte ranges of it via the REST API
+func TestPutAttachmentViaBlipGetRangeViaRest(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	attachmentBody := strings.Repeat("0123456789", 10000)
+	input := SendRevWithAttachmentInput{
+		docId:            "rangeDoc",
+		revId:            "1-rev1",
+		attachmentName:   "myAttachment",
+		attachmentLength: len(attachmentBody),
+		attachmentBody:   attachmentBody,
+		attachmentDigest: db.Sha1DigestKey([]byte(attachmentBody)),
+	}
+	bt.SendRevWithAttachment(input)
+
+	attachmentPath := fmt.Sprintf("/db/%s/%s", input.docId, input.attachmentName)
+	contentLength := len(attachmentBody)
+
+	// Full fetch advertises range support
+	response := bt.restTester.SendAdminRequest(http.MethodGet, attachmentPath, "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.Equal(t, attachmentBody, response.Body.String())
+	assert.Equal(t, "bytes", response.Header().Get("Accept-Ranges"))
+
+	testCases := []struct {
+		name          string
+		rangeHeader   string
+		expectedStart int
+		expectedEnd   int // inclusive
+	}{
+		{"middle", "bytes=50005-50014", 50005, 50014},
+		{"open ended", "bytes=99990-", 99990, contentLength - 1},
+		{"suffix", "bytes=-25", contentLength - 25, contentLength - 1},
+		{"past end", "bytes=99995-200000", 99995, contentLength - 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response := bt.restTester.SendAdminRequestWithHeaders(http.MethodGet, attachmentPath, "", map[string]string{"Range": tc.rangeHeader})
+			RequireStatus(t, response, http.StatusPartialContent)
+			assert.Equal(t, attachmentBody[tc.expectedStart:tc.expectedEnd+1], response.Body.String())
+			assert.Equal(t, strconv.Itoa(tc.expectedEnd-tc.expectedStart+1), response.Header().Get("Content-Length"))
+			assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", tc.expectedStart, tc.expectedEnd, contentLength), response.Header().Get("Content-Range"))
+		})
+	}
+
+	// A range starting beyond the end of the attachment can't be satisfied
+	response = bt.restTester.SendAdminRequestWithHeaders(http.MethodGet, attachmentPath, "", map[string]string{"Range": "bytes=200000-"})
+	RequireStatus(t, response, http.StatusRequestedRangeNotSatisfiable)
+}
+
+func TestPutAttachmentViaBlipGetViaBlip(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	testCases := []struct {
+		name     string
+		encoding string
+	}{
+		{name: "raw"},
+		{name: "gzip", encoding: db.AttachmentEncodingGzip},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+
+			// Create blip tester
+			bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+				connectingUsername:          "user1",
+				connectingPassword:          "1234",
+				connectingUserChannelGrants: []string{"*"}, // All channels
+			})
+			require.NoError(t, err, "Unexpected error creating BlipTester")
+			defer bt.Close()
+			bt.getAttachmentEncoding = testCase.encoding
+
+			attachmentBody := strings.Repeat(`{"attach": true}`, 100)
+			digest := db.Sha1DigestKey([]byte(attachmentBody))
+
+			// Send revision with attachment
+			input := SendRevWithAttachmentInput{
+				docId:            "doc",
+				revId:            "1-rev1",
+				attachmentName:   "myAttachment",
+				attachmentLength: len(attachmentBody),
+				attachmentBody:   attachmentBody,
+				attachmentDigest: digest,
+			}
+			sent, _, _ := bt.SendRevWithAttachment(input)
+			assert.True(t, sent)
+
+			// Get all docs and attachment via subChanges request.  Gzipped attachments are decompressed by PullDocs.
+			allDocs, ok := bt.WaitForNumDocsViaChanges(1)
+			require.True(t, ok)
+
+			// make assertions on allDocs -- make sure attachment is present w/ expected body
+			require.Len(t, allDocs, 1)
+			retrievedDoc := allDocs[input.docId]
+
+			// doc assertions
+			assert.Equal(t, input.docId, retrievedDoc.ID())
+			assert.Equal(t, input.revId, retrievedDoc.RevID())
+
+			// attachment assertions
+			attachments, err := retrievedDoc.GetAttachments()
+			assert.True(t, err == nil)
+			assert.Equal(t, 1, len(attachments))
+			retrievedAttachment := attachments[input.attachmentName]
+			require.NotNil(t, retrievedAttachment)
+			assert.Equal(t, input.attachmentBody, string(retrievedAttachment.Data))
+			assert.Equal(t, len(attachmentBody), retrievedAttachment.Length)
+			assert.Equal(t, retrievedAttachment.Digest, input.attachmentDigest)
+		})
+	}
+}
+
+// Test pushing revs with multiple attachments, where only the attachments the server doesn't already have are requested,
+// and pulling them back.
+func TestPutMultipleAttachmentsViaBlipGetViaBlip(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"}, // All channels
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	sent, _, res := bt.SendRevWithAttachment(SendRevWithAttachmentInput{
+		docId:   "doc",
+		revId:   "1-rev1",
+		history: []string{},
+		attachments: []SendRevAttachment{
+			{name: "unchanged", body: `{"attachment": "unchanged"}`},
+			{name: "updated", body: `{"attachment": "updated", "version": 1}`, contentType: "text/plain"},
+		},
+	})
+	require.True(t, sent)
+	require.Equal(t, "", res.Properties[db.BlipErrorCode])
+
+	// The unchanged attachment is a stub from rev 1, so is only requested if the server asks for it, which fails the test
+	sent, _, res = bt.SendRevWithAttachment(SendRevWithAttachmentInput{
+		docId:   "doc",
+		revId:   "2-rev2",
+		history: []string{"1-rev1"},
+		attachments: []SendRevAttachment{
+			{name: "unchanged", body: `{"attachment": "unchanged"}`, existing: true},
+			{name: "updated", body: `{"attachment": "updated", "version": 2}`, contentType: "text/plain", revpos: 2},
+			{name: "added", body: `{"attachment": "added"}`, revpos: 2},
+		},
+	})
+	require.True(t, sent)
+	require.Equal(t, "", res.Properties[db.BlipErrorCode])
+
+	allDocs, ok := bt.WaitForNumDocsViaChanges(1)
+	require.True(t, ok)
+	retrievedDoc := allDocs["doc"]
+	assert.Equal(t, "2-rev2", retrievedDoc.RevID())
 
+	attachments, err := retrievedDoc.GetAttachments()
+	require.NoError(t, err)
+	expectedBodies := map[string]string{
+		"unchanged": `{"attachment": "unchanged"}`,
+		"updated":   `{"attachment": "updated", "version": 2}`,
+		"added":     `{"attachment": "added"}`,
+	}
+	require.Len(t, attachments, len(expectedBodies))
+	for name, expectedBody := range expectedBodies {
+		attachment := attachments[name]
+		require.NotNil(t, attachment, "Missing attachment %q", name)
+		assert.Equal(t, expectedBody, string(attachment.Data), "Unexpected body for attachment %q", name)
+		assert.Equal(t, db.Sha1DigestKey([]byte(expectedBody)), attachment.Digest)
+	}
+	assert.Equal(t, "text/plain", attachments["updated"].ContentType)
+	assert.Equal(t, 1, attachments["unchanged"].Revpos)
+	assert.Equal(t, 2, attachments["added"].Revpos)
 }
 
-func TestPutAttachmentViaBlipGetViaBlip(t *testing.T) {
+// Test that pushing a rev whose attachment is unchanged from its parent doesn't re-upload the attachment.
+func TestPutUnchangedAttachmentNotReuploaded(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	// Create blip tester
 	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
 		connectingUsername:          "user1",
 		connectingPassword:          "1234",
@@ -1586,43 +3644,30 @@ func TestPutAttachmentViaBlipGetViaBlip(t *testing.T) {
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	attachmentBody := "attach"
-	digest := db.Sha1DigestKey([]byte(attachmentBody))
+	attachment := SendRevAttachment{name: "myAttachment", body: `{"attachment": "unchanged"}`}
+	digest := db.Sha1DigestKey([]byte(attachment.body))
+	sent, _, res := bt.SendRevWithAttachment(SendRevWithAttachmentInput{
+		docId:       "doc",
+		revId:       "1-rev1",
+		history:     []string{},
+		attachments: []SendRevAttachment{attachment},
+	})
+	require.True(t, sent)
+	require.Equal(t, "", res.Properties[db.BlipErrorCode])
+	assert.Equal(t, 1, bt.GetAttachmentRequestCount(digest))
 
-	// Send revision with attachment
-	input := SendRevWithAttachmentInput{
-		docId:            "doc",
-		revId:            "1-rev1",
-		attachmentName:   "myAttachment",
-		attachmentLength: len(attachmentBody),
-		attachmentBody:   attachmentBody,
-		attachmentDigest: digest,
-	}
-	sent, _, _ := bt.SendRevWithAttachment(input)
-	assert.True(t, sent)
+	bt.RequireAttachmentNotReuploaded("doc", "2-rev2", []string{"1-rev1"}, attachment)
+	bt.RequireAttachmentNotReuploaded("doc", "3-rev3", []string{"2-rev2", "1-rev1"}, attachment)
+	assert.Equal(t, 1, bt.GetAttachmentRequestCount(digest))
 
-	// Get all docs and attachment via subChanges request
 	allDocs, ok := bt.WaitForNumDocsViaChanges(1)
 	require.True(t, ok)
-
-	// make assertions on allDocs -- make sure attachment is present w/ expected body
-	require.Len(t, allDocs, 1)
-	retrievedDoc := allDocs[input.docId]
-
-	// doc assertions
-	assert.Equal(t, input.docId, retrievedDoc.ID())
-	assert.Equal(t, input.revId, retrievedDoc.RevID())
-
-	// attachment assertions
+	retrievedDoc := allDocs["doc"]
+	assert.Equal(t, "3-rev3", retrievedDoc.RevID())
 	attachments, err := retrievedDoc.GetAttachments()
-	assert.True(t, err == nil)
-	assert.Equal(t, 1, len(attachments))
-	retrievedAttachment := attachments[input.attachmentName]
-	require.NotNil(t, retrievedAttachment)
-	assert.Equal(t, input.attachmentBody, string(retrievedAttachment.Data))
-	assert.Equal(t, len(attachmentBody), retrievedAttachment.Length)
-	assert.Equal(t, retrievedAttachment.Digest, input.attachmentDigest)
-
+	require.NoError(t, err)
+	require.NotNil(t, attachments["myAttachment"])
+	assert.Equal(t, attachment.body, string(attachments["myAttachment"].Data))
 }
 
 // Reproduces the issue seen in https://github.com/couchbase/couchbase-lite-core/issues/790
@@ -1661,7 +3706,425 @@ func TestPutInvalidAttachment(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	// Create blip tester
+	// Create blip tester
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"}, // All channels
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			digest := db.Sha1DigestKey([]byte(test.correctAttachmentBody))
+
+			// Send revision with attachment
+			input := SendRevWithAttachmentInput{
+				docId:            test.name,
+				revId:            "1-rev1",
+				attachmentName:   "myAttachment",
+				attachmentLength: len(test.correctAttachmentBody),
+				attachmentBody:   test.invalidAttachmentBody,
+				attachmentDigest: digest,
+			}
+			sent, _, resp := bt.SendRevWithAttachment(input)
+			assert.True(t, sent)
+
+			// Make sure we get the expected response back
+			assert.Equal(t, test.expectedType, resp.Type())
+			if test.expectedErrorCode != "" {
+				assert.Equal(t, test.expectedErrorCode, resp.Properties["Error-Code"])
+			}
+
+			respBody, err := resp.Body()
+			assert.NoError(t, err)
+			t.Logf("resp.Body: %v", string(respBody))
+		})
+	}
+}
+
+// Put a revision that is rejected by the sync function and assert that Sync Gateway
+// returns an error code
+func TestPutInvalidRevSyncFnReject(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	syncFn := `
+		function(doc) {
+			requireAccess("PBS");
+			channel(doc.channels);
+		}
+    `
+
+	// Setup
+	rtConfig := RestTesterConfig{SyncFn: syncFn}
+	rt := NewRestTester(t, &rtConfig)
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	// Add a doc that will be rejected by sync function, since user
+	// does not have access to the CNN channel
+	revRequest := blip.NewRequest()
+	revRequest.SetCompressed(false)
+	revRequest.SetProfile("rev")
+	revRequest.Properties["id"] = "foo"
+	revRequest.Properties["rev"] = "1-aaa"
+	revRequest.Properties["deleted"] = "false"
+	revRequest.SetBody([]byte(`{"key": "val", "channels": ["CNN"]}`))
+	sent := bt.sender.Send(revRequest)
+	assert.True(t, sent)
+
+	revResponse := revRequest.Response()
+
+	// Since doc is rejected by sync function, expect a 403 error
+	errorCode, hasErrorCode := revResponse.Properties["Error-Code"]
+	assert.True(t, hasErrorCode)
+	assert.Equal(t, "403", errorCode)
+
+	// The body says why the doc was rejected
+	var errorBody db.RevErrorResponseBody
+	require.NoError(t, revResponse.ReadJSONBody(&errorBody))
+	assert.Equal(t, db.RevErrorResponseBody{
+		Error:         "Forbidden",
+		Reason:        "rejected by sync function",
+		SyncFnMessage: base.SyncFnErrorMissingChannelAccess,
+	}, errorBody)
+
+	// Make sure that a one-off GetChanges() returns no documents
+	changes := bt.GetChanges()
+	assert.Equal(t, 0, len(changes))
+
+}
+
+// TestBlipRevErrorBodySyncFnThrow verifies that the message thrown by the sync function is returned in the body of the
+// rev error response.
+func TestBlipRevErrorBodySyncFnThrow(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	syncFn := `
+		function(doc) {
+			if (doc.invalid) {
+				throw({forbidden: "invalid docs aren't allowed"});
+			}
+			channel(doc.channels);
+		}
+    `
+	rt := NewRestTester(t, &RestTesterConfig{SyncFn: syncFn})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	revRequest := blip.NewRequest()
+	revRequest.SetProfile(db.MessageRev)
+	revRequest.Properties[db.RevMessageID] = "doc1"
+	revRequest.Properties[db.RevMessageRev] = "1-abc"
+	revRequest.SetBody([]byte(`{"invalid": true}`))
+	require.True(t, bt.sender.Send(revRequest))
+
+	revResponse := revRequest.Response()
+	assert.Equal(t, "403", revResponse.Properties[db.BlipErrorCode])
+	var errorBody db.RevErrorResponseBody
+	require.NoError(t, revResponse.ReadJSONBody(&errorBody))
+	assert.Equal(t, db.RevErrorResponseBody{
+		Error:         "Forbidden",
+		Reason:        "rejected by sync function",
+		SyncFnMessage: "invalid docs aren't allowed",
+	}, errorBody)
+
+	// A valid doc is accepted
+	sent, _, resp, err := bt.SendRev("doc2", "1-abc", []byte(`{"channels": ["ABC"]}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Properties[db.BlipErrorCode])
+}
+
+// TestBlipRevSyncFnThrowErrorCodes verifies that each type of error the sync function can throw is mapped to the
+// expected rev error response.
+func TestBlipRevSyncFnThrowErrorCodes(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+		syncFn:                      syncFnThrowFromDoc,
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	tests := []struct {
+		name           string
+		thrown         string
+		expectedStatus int
+		expectedBody   db.RevErrorResponseBody
+	}{
+		{
+			name:           "forbidden",
+			thrown:         `{"forbidden": "not allowed"}`,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   db.RevErrorResponseBody{Error: "Forbidden", Reason: "rejected by sync function", SyncFnMessage: "not allowed"},
+		},
+		{
+			name:           "unauthorized",
+			thrown:         `{"unauthorized": "who are you"}`,
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   db.RevErrorResponseBody{Error: "Unauthorized", Reason: "rejected by sync function", SyncFnMessage: "who are you"},
+		},
+		{
+			name:           "other object",
+			thrown:         `{"invalid": "bad doc"}`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   db.RevErrorResponseBody{Error: "Internal Server Error", Reason: "Exception in JS sync function"},
+		},
+		{
+			name:           "string",
+			thrown:         `"bad doc"`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   db.RevErrorResponseBody{Error: "Internal Server Error", Reason: "Exception in JS sync function"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := []byte(`{"channels": ["ABC"], "throw": ` + test.thrown + `}`)
+			errorBody := bt.RequireRevRejected("doc-"+test.name, "1-abc", body, test.expectedStatus)
+			assert.Equal(t, test.expectedBody, errorBody)
+		})
+	}
+
+	// A revision that doesn't throw is accepted
+	_, _, _, err = bt.SendRev("doc-valid", "1-abc", []byte(`{"channels": ["ABC"]}`), blip.Properties{})
+	require.NoError(t, err)
+}
+
+// TestBlipBinaryChangesEncoding ensures changes requested with the binary changes encoding match the JSON encoded changes.
+func TestBlipBinaryChangesEncoding(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"}, // All channels
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	for i := 0; i < 5; i++ {
+		sent, _, _, err := bt.SendRev(fmt.Sprintf("binaryChanges%d", i), "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+		require.True(t, sent)
+		require.NoError(t, err)
+	}
+	sent, _, _, err := bt.SendRevWithHistory("binaryChanges0", "2-abc", []string{"1-abc"}, []byte(`{}`), blip.Properties{db.RevMessageDeleted: "1"})
+	require.True(t, sent)
+	require.NoError(t, err)
+
+	jsonChanges := bt.GetChanges()
+	require.Len(t, jsonChanges, 5)
+
+	binaryChanges := bt.GetBinaryChanges()
+	require.Len(t, binaryChanges, len(jsonChanges))
+
+	// Binary fields are the string form of the corresponding JSON values
+	for i, jsonChange := range jsonChanges {
+		expected := make([]string, 0, len(jsonChange))
+		for _, field := range jsonChange {
+			switch val := field.(type) {
+			case float64:
+				expected = append(expected, strconv.FormatFloat(val, 'f', -1, 64))
+			case bool:
+				expected = append(expected, strconv.FormatBool(val))
+			default:
+				expected = append(expected, fmt.Sprint(val))
+			}
+		}
+		assert.Equal(t, expected, binaryChanges[i])
+	}
+}
+
+// TestPutRevSyncFnOldDoc ensures the sync function is passed the previous revision's body as oldDoc for revs pushed
+// over BLIP, and null for new docs, in the same way as for REST updates.
+func TestPutRevSyncFnOldDoc(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	syncFn := `
+		function(doc, oldDoc) {
+			if (doc.created && oldDoc !== null) {
+				throw({forbidden: "oldDoc should be null for new docs"});
+			}
+			if (oldDoc !== null && doc.owner !== oldDoc.owner) {
+				throw({forbidden: "owner can't be changed"});
+			}
+			channel(doc.channels);
+		}
+    `
+
+	rt := NewRestTester(t, &RestTesterConfig{SyncFn: syncFn})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	sent, _, resp, err := bt.SendRev("ownedDoc", "1-abc", []byte(`{"owner": "alice", "created": true}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	// Update that preserves the owner is allowed
+	sent, _, resp, err = bt.SendRevWithHistory("ownedDoc", "2-abc", []string{"1-abc"}, []byte(`{"owner": "alice", "value": 2}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Properties["Error-Code"])
+
+	// Update that changes the owner is rejected
+	sent, _, resp, err = bt.SendRevWithHistory("ownedDoc", "3-abc", []string{"2-abc", "1-abc"}, []byte(`{"owner": "bob", "value": 3}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "403", resp.Properties["Error-Code"])
+
+	response := rt.SendAdminRequest(http.MethodGet, "/db/ownedDoc", "")
+	RequireStatus(t, response, http.StatusOK)
+	var body db.Body
+	require.NoError(t, base.JSONUnmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, "2-abc", body[db.BodyRev])
+
+	// REST updates see the same oldDoc
+	response = rt.SendAdminRequest(http.MethodPut, "/db/ownedDoc?rev=2-abc", `{"owner": "bob"}`)
+	RequireStatus(t, response, http.StatusForbidden)
+	response = rt.SendAdminRequest(http.MethodPut, "/db/ownedDoc?rev=2-abc", `{"owner": "alice", "value": 3}`)
+	RequireStatus(t, response, http.StatusCreated)
+}
+
+// TestBlipSubChangesCreationsOnly ensures only the first revision of new docs is sent when subscribing to changes in
+// creations only mode, skipping updated and deleted docs.
+func TestBlipSubChangesCreationsOnly(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"}, // All channels
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	sendRev := func(docID, revID string, history []string, properties blip.Properties) {
+		sent, _, _, err := bt.SendRevWithHistory(docID, revID, history, []byte(`{"key": "val"}`), properties)
+		require.True(t, sent)
+		require.NoError(t, err)
+	}
+	sendRev("created1", "1-abc", nil, blip.Properties{})
+	sendRev("created2", "1-abc", nil, blip.Properties{})
+	sendRev("updated", "1-abc", nil, blip.Properties{})
+	sendRev("updated", "2-abc", []string{"1-abc"}, blip.Properties{})
+	sendRev("deleted", "1-abc", nil, blip.Properties{})
+	sendRev("deleted", "2-abc", []string{"1-abc"}, blip.Properties{db.RevMessageDeleted: "1"})
+
+	allChanges := bt.GetChanges()
+	require.Len(t, allChanges, 4)
+
+	changes := bt.GetChangesWithProperties(blip.Properties{db.SubChangesCreationsOnly: "true"})
+	require.Len(t, changes, 2)
+	for i, docID := range []string{"created1", "created2"} {
+		assert.Equal(t, docID, changes[i][1])
+		assert.Equal(t, "1-abc", changes[i][2])
+	}
+}
+
+// TestBlipSubChangesWinningRevOnly ensures a continuous subChanges with winningRevOnly only sends a change when a
+// document's winning revision changes, and not for writes to a losing branch.
+func TestBlipSubChangesWinningRevOnly(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			AllowConflicts: base.BoolPtr(true),
+		}},
+	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"}, // All channels
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	changesMessages := make(chan *blip.Message, 10)
+	subChangesResponse := bt.subscribeToChanges(true, blip.Properties{db.SubChangesWinningRevOnly: "true"}, changesMessages)
+	require.Equal(t, "", subChangesResponse.Properties["Error-Code"])
+
+	// requireNextChange waits for the next non-empty changes batch, and checks it contains only the given revision
+	requireNextChange := func(docID, revID string) {
+		for {
+			select {
+			case changesMessage := <-changesMessages:
+				body, err := changesMessage.Body()
+				require.NoError(t, err)
+				var changesBatch [][]interface{}
+				require.NoError(t, base.JSONUnmarshal(body, &changesBatch))
+				if len(changesBatch) == 0 {
+					continue
+				}
+				require.Len(t, changesBatch, 1)
+				assert.Equal(t, docID, changesBatch[0][1])
+				assert.Equal(t, revID, changesBatch[0][2])
+				return
+			case <-time.After(10 * time.Second):
+				require.FailNowf(t, "Timed out waiting for change", "doc %s rev %s", docID, revID)
+			}
+		}
+	}
+
+	sendRev := func(docID, revID string, history []string, properties blip.Properties) {
+		sent, _, _, err := bt.SendRevWithHistory(docID, revID, history, []byte(`{"key": "val"}`), properties)
+		require.True(t, sent)
+		require.NoError(t, err)
+	}
+
+	sendRev("doc1", "1-abc", nil, blip.Properties{})
+	requireNextChange("doc1", "1-abc")
+	sendRev("doc1", "2-bbb", []string{"1-abc"}, blip.Properties{})
+	requireNextChange("doc1", "2-bbb")
+
+	// 2-aaa loses the revid comparison against 2-bbb, so the winning revision is unchanged and no change is sent
+	sendRev("doc1", "2-aaa", []string{"1-abc"}, blip.Properties{"noconflicts": "false"})
+
+	// Resolve the conflict by extending the 2-aaa branch, which becomes the winner, and tombstoning the 2-bbb branch
+	sendRev("doc1", "3-aaa", []string{"2-aaa", "1-abc"}, blip.Properties{"noconflicts": "false"})
+	requireNextChange("doc1", "3-aaa")
+	sendRev("doc1", "3-bbb", []string{"2-bbb", "1-abc"}, blip.Properties{"noconflicts": "false", db.RevMessageDeleted: "1"})
+
+	// The tombstone leaves 3-aaa as the winner, so the next change sent is for a different document
+	sendRev("doc2", "1-abc", nil, blip.Properties{})
+	requireNextChange("doc2", "1-abc")
+}
+
+// TestBlipSubChangesFields ensures revisions sent for a subChanges with fields only contain the requested fields, but
+// still have complete metadata.
+func TestBlipSubChangesFields(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
 	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
 		connectingUsername:          "user1",
 		connectingPassword:          "1234",
@@ -1670,82 +4133,125 @@ func TestPutInvalidAttachment(t *testing.T) {
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			digest := db.Sha1DigestKey([]byte(test.correctAttachmentBody))
+	sent, _, _, err := bt.SendRev("doc1", "1-abc", []byte(`{"channels": ["ABC", "DEF"], "name": "alice", "address": {"city": "Leeds", "street": "Briggate"}, "notes": "not wanted"}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	sent, _, _, err = bt.SendRev("doc2", "1-abc", []byte(`{}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	sent, _, _, err = bt.SendRevWithHistory("doc2", "2-abc", []string{"1-abc"}, []byte(`{}`), blip.Properties{db.RevMessageDeleted: "1"})
+	require.True(t, sent)
+	require.NoError(t, err)
 
-			// Send revision with attachment
-			input := SendRevWithAttachmentInput{
-				docId:            test.name,
-				revId:            "1-rev1",
-				attachmentName:   "myAttachment",
-				attachmentLength: len(test.correctAttachmentBody),
-				attachmentBody:   test.invalidAttachmentBody,
-				attachmentDigest: digest,
-			}
-			sent, _, resp := bt.SendRevWithAttachment(input)
-			assert.True(t, sent)
+	// Request all revisions in every changes batch
+	bt.blipContext.HandlerForProfile[db.MessageChanges] = func(request *blip.Message) {
+		if request.NoReply() {
+			return
+		}
+		body, err := request.Body()
+		require.NoError(t, err)
+		var changesBatch [][]interface{}
+		require.NoError(t, base.JSONUnmarshal(body, &changesBatch))
+		knownRevs := make([][]string, len(changesBatch))
+		for i := range knownRevs {
+			knownRevs[i] = []string{}
+		}
+		responseBody, err := base.JSONMarshal(knownRevs)
+		require.NoError(t, err)
+		request.Response().SetBody(responseBody)
+	}
+	revs := make(chan *blip.Message, 10)
+	bt.blipContext.HandlerForProfile[db.MessageRev] = func(request *blip.Message) {
+		revs <- request
+		if !request.NoReply() {
+			request.Response().SetBody([]byte{})
+		}
+	}
 
-			// Make sure we get the expected response back
-			assert.Equal(t, test.expectedType, resp.Type())
-			if test.expectedErrorCode != "" {
-				assert.Equal(t, test.expectedErrorCode, resp.Properties["Error-Code"])
-			}
+	// An invalid field is rejected
+	subChangesRequest := blip.NewRequest()
+	subChangesRequest.SetProfile(db.MessageSubChanges)
+	subChangesRequest.Properties[db.SubChangesContinuous] = "false"
+	subChangesRequest.Properties[db.SubChangesFields] = "name,"
+	require.True(t, bt.sender.Send(subChangesRequest))
+	require.Equal(t, "400", subChangesRequest.Response().Properties["Error-Code"])
 
-			respBody, err := resp.Body()
-			assert.NoError(t, err)
-			t.Logf("resp.Body: %v", string(respBody))
-		})
+	subChangesRequest = blip.NewRequest()
+	subChangesRequest.SetProfile(db.MessageSubChanges)
+	subChangesRequest.Properties[db.SubChangesContinuous] = "false"
+	subChangesRequest.Properties[db.SubChangesFields] = "name,address.city"
+	require.True(t, bt.sender.Send(subChangesRequest))
+	require.Equal(t, "", subChangesRequest.Response().Properties["Error-Code"])
+
+	revsByDoc := make(map[string]*blip.Message)
+	for len(revsByDoc) < 2 {
+		select {
+		case rev := <-revs:
+			revsByDoc[rev.Properties[db.RevMessageID]] = rev
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "Timed out waiting for revs")
+		}
 	}
+
+	rev := revsByDoc["doc1"]
+	require.NotNil(t, rev)
+	assert.Equal(t, "1-abc", rev.Properties[db.RevMessageRev])
+	assert.Equal(t, "ABC,DEF", rev.Properties[db.RevMessageChannels])
+	body, err := rev.Body()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "alice", "address": {"city": "Leeds"}}`, string(body))
+
+	rev = revsByDoc["doc2"]
+	require.NotNil(t, rev)
+	assert.Equal(t, "2-abc", rev.Properties[db.RevMessageRev])
+	assert.Equal(t, "1", rev.Properties[db.RevMessageDeleted])
+
 }
 
-// Put a revision that is rejected by the sync function and assert that Sync Gateway
-// returns an error code
-func TestPutInvalidRevSyncFnReject(t *testing.T) {
+// TestBlipSubChangesIncludeAttachments ensures changes rows include a summary of the revision's attachments when
+// requested by subChanges, and only then.
+func TestBlipSubChangesIncludeAttachments(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
 
-	syncFn := `
-		function(doc) {
-			requireAccess("PBS");
-			channel(doc.channels);
-		}
-    `
-
-	// Setup
-	rtConfig := RestTesterConfig{SyncFn: syncFn}
-	rt := NewRestTester(t, &rtConfig)
+	rt := NewRestTester(t, nil)
 	defer rt.Close()
 	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
-		connectingUsername: "user1",
-		connectingPassword: "1234",
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"}, // All channels
 	}, rt)
 	require.NoError(t, err, "Unexpected error creating BlipTester")
 	defer bt.Close()
 
-	// Add a doc that will be rejected by sync function, since user
-	// does not have access to the CNN channel
-	revRequest := blip.NewRequest()
-	revRequest.SetCompressed(false)
-	revRequest.SetProfile("rev")
-	revRequest.Properties["id"] = "foo"
-	revRequest.Properties["rev"] = "1-aaa"
-	revRequest.Properties["deleted"] = "false"
-	revRequest.SetBody([]byte(`{"key": "val", "channels": ["CNN"]}`))
-	sent := bt.sender.Send(revRequest)
-	assert.True(t, sent)
-
-	revResponse := revRequest.Response()
-
-	// Since doc is rejected by sync function, expect a 403 error
-	errorCode, hasErrorCode := revResponse.Properties["Error-Code"]
-	assert.True(t, hasErrorCode)
-	assert.Equal(t, "403", errorCode)
+	attachmentData := base64.StdEncoding.EncodeToString([]byte("attachmentA"))
+	sent, _, _, err := bt.SendRev("withAtt", "1-abc", []byte(`{"key": "val", "_attachments": {"a.txt": {"data": "`+attachmentData+`"}}}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	sent, _, _, err = bt.SendRev("withoutAtt", "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	require.NoError(t, rt.WaitForPendingChanges())
 
-	// Make sure that a one-off GetChanges() returns no documents
 	changes := bt.GetChanges()
-	assert.Equal(t, 0, len(changes))
+	require.Len(t, changes, 2)
+	for _, change := range changes {
+		assert.Len(t, change, 3)
+	}
 
+	changes = bt.GetChangesWithProperties(blip.Properties{db.SubChangesIncludeAttachments: "true"})
+	require.Len(t, changes, 2)
+	changesByDoc := make(map[string][]interface{}, len(changes))
+	for _, change := range changes {
+		changesByDoc[change[1].(string)] = change
+	}
+	assert.Len(t, changesByDoc["withoutAtt"], 3)
+	withAtt := changesByDoc["withAtt"]
+	require.Len(t, withAtt, 5)
+	assert.False(t, isDeletedChange(withAtt))
+	assert.Equal(t, map[string]interface{}{
+		"a.txt": map[string]interface{}{"digest": "sha1-wzp8ZyykdEuZ9GuqmxQ7XDrY7Co=", "length": float64(11)},
+	}, withAtt[4])
 }
 
 func TestPutInvalidRevMalformedBody(t *testing.T) {
@@ -1816,6 +4322,84 @@ func TestPutRevNoConflictsMode(t *testing.T) {
 
 }
 
+// TestPutRevRejectedStats ensures rejected revs are counted by rejection category.
+func TestPutRevRejectedStats(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, &RestTesterConfig{
+		SyncFn: `function(doc) {
+			if (doc.reject) {
+				throw({forbidden: "rejected"});
+			}
+			channel(doc.channels);
+		}`,
+		EnableNoConflictsMode: true,
+		DatabaseConfig: &DatabaseConfig{DbConfig: DbConfig{
+			DocCountQuota: base.Uint64Ptr(2),
+		}},
+	})
+	defer rt.Close()
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{
+		noConflictsMode:             true,
+		connectingUsername:          "user1",
+		connectingPassword:          "1234",
+		connectingUserChannelGrants: []string{"*"},
+	}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	pushStats := rt.GetDatabase().DbStats.CBLReplicationPush()
+	assertRejectedCounts := func(forbidden, conflict, malformed, quota int64) {
+		assert.Equal(t, forbidden, pushStats.RevRejectedForbiddenCount.Value())
+		assert.Equal(t, conflict, pushStats.RevRejectedConflictCount.Value())
+		assert.Equal(t, malformed, pushStats.RevRejectedMalformedCount.Value())
+		assert.Equal(t, quota, pushStats.RevRejectedQuotaCount.Value())
+		assert.Equal(t, int64(0), pushStats.RevRejectedOtherCount.Value())
+	}
+
+	sent, _, resp, err := bt.SendRev("doc1", "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	assertRejectedCounts(0, 0, 0, 0)
+
+	// Rejected by the sync function
+	sent, _, resp, err = bt.SendRev("rejected", "1-abc", []byte(`{"reject": true}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "403", resp.Properties["Error-Code"])
+	assertRejectedCounts(1, 0, 0, 0)
+
+	// Conflicts with the current revision
+	sent, _, resp, err = bt.SendRev("doc1", "1-def", []byte(`{"key": "val"}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "409", resp.Properties["Error-Code"])
+	assertRejectedCounts(1, 1, 0, 0)
+
+	// Malformed body
+	sent, _, resp, err = bt.SendRev("malformed", "1-abc", []byte(`{"key": "val", "channels": [" MALFORMED JSON DOC`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "400", resp.Properties["Error-Code"])
+	assertRejectedCounts(1, 1, 1, 0)
+
+	// Doc count quota reached
+	sent, _, _, err = bt.SendRev("doc2", "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+	require.True(t, sent)
+	require.NoError(t, err)
+	sent, _, resp, err = bt.SendRev("doc3", "1-abc", []byte(`{"key": "val"}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "507", resp.Properties["Error-Code"])
+	assertRejectedCounts(1, 1, 1, 1)
+
+	// Counters are exposed in the admin stats
+	response := rt.SendAdminRequest(http.MethodGet, "/_expvar", "")
+	RequireStatus(t, response, http.StatusOK)
+	assert.Contains(t, response.Body.String(), `"rev_rejected_quota_count":1`)
+}
+
 func TestPutRevConflictsMode(t *testing.T) {
 
 	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
@@ -1846,6 +4430,37 @@ func TestPutRevConflictsMode(t *testing.T) {
 
 }
 
+// TestBlipTesterCreateConflict ensures CreateConflict leaves the doc with two conflicting leaf revisions that share
+// a common ancestor.
+func TestBlipTesterCreateConflict(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg)
+
+	bt, err := NewBlipTesterFromSpec(t, BlipTesterSpec{
+		noConflictsMode:    false,
+		connectingUsername: "user1",
+		connectingPassword: "1234",
+	})
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	revIDA, revIDB := bt.CreateConflict("foo", []byte(`{"branch": "a", "channels": ["user1"]}`), []byte(`{"branch": "b", "channels": ["user1"]}`))
+	assert.NotEqual(t, revIDA, revIDB)
+	bt.RequireConflict("foo", revIDA, revIDB)
+
+	doc, err := bt.restTester.GetDatabase().GetDocument(base.TestCtx(t), "foo", db.DocUnmarshalAll)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{revIDA, revIDB}, doc.History.GetLeaves())
+	assert.Equal(t, doc.History[revIDA].Parent, doc.History[revIDB].Parent)
+
+	// A doc with a single branch isn't in conflict
+	_, _, _, err = bt.SendRev("bar", "1-abc", []byte(`{"channels": ["user1"]}`), blip.Properties{})
+	require.NoError(t, err)
+	doc, err = bt.restTester.GetDatabase().GetDocument(base.TestCtx(t), "bar", db.DocUnmarshalAll)
+	require.NoError(t, err)
+	assert.Zero(t, doc.Flags&channels.Conflict)
+}
+
 // Repro attempt for SG #3281
 //
 // - Set up a user w/ access to channel A
@@ -1907,6 +4522,10 @@ func TestGetRemovedDoc(t *testing.T) {
 	assert.NoError(t, err, "Unexpected Error")
 	assert.False(t, resultDoc.IsRemoved())
 
+	revHistory, err := bt.GetRevHistory("foo", "2-bcd")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1-abc"}, revHistory)
+
 	// Add rev-3, remove from channel user1 and put into channel another_channel
 	history = []string{"2-bcd", "1-abc"}
 	sent, _, resp, err = bt.SendRevWithHistory("foo", "3-cde", history, []byte(`{"key": "val", "channels": ["another_channel"]}`), blip.Properties{"noconflicts": "true"})
@@ -1936,6 +4555,11 @@ func TestGetRemovedDoc(t *testing.T) {
 	assert.NoError(t, err, "Unexpected Error")
 	assert.True(t, resultDoc.IsRemoved())
 
+	// The removal is still sent with the revision's ancestry
+	revHistory, err = bt2.GetRevHistory("foo", "3-cde")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2-bcd", "1-abc"}, revHistory)
+
 	// Try to get rev 3 via REST API, and assert that _removed == true
 	headers := map[string]string{}
 	headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(btSpec.connectingUsername+":"+btSpec.connectingPassword))
@@ -2001,6 +4625,43 @@ func TestMissingNoRev(t *testing.T) {
 
 }
 
+// TestPurgedRevNoRev ensures that a client pulling a revision that's been purged since it was added to the changes feed
+// is sent a norev for it, with the reason it can't be served, rather than being left waiting for a rev.
+func TestPurgedRevNoRev(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{GuestEnabled: true})
+	defer rt.Close()
+
+	btc, err := NewBlipTesterClientOptsWithRT(t, rt, nil)
+	require.NoError(t, err)
+	defer btc.Close()
+
+	resp := rt.SendAdminRequest(http.MethodPut, "/db/purged", `{"foo": "bar"}`)
+	RequireStatus(t, resp, http.StatusCreated)
+	revID := RespRevID(t, resp)
+	resp = rt.SendAdminRequest(http.MethodPut, "/db/kept", `{"foo": "bar"}`)
+	RequireStatus(t, resp, http.StatusCreated)
+	keptRevID := RespRevID(t, resp)
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	// Purging the doc leaves its entry in the channel cache, so the client is still sent the change
+	resp = rt.SendAdminRequest(http.MethodPost, "/db/_purge", `{"purged": ["*"]}`)
+	RequireStatus(t, resp, http.StatusOK)
+	rt.GetDatabase().FlushRevisionCacheForTest()
+
+	require.NoError(t, btc.StartOneshotPull())
+
+	_, ok := btc.WaitForRev("kept", keptRevID)
+	require.True(t, ok)
+
+	noRev := btc.WaitForNoRev("purged")
+	assert.Equal(t, revID, noRev.Properties[db.NorevMessageRev])
+	assert.Equal(t, "404", noRev.Properties[db.NorevMessageError])
+	assert.NotEmpty(t, noRev.Properties[db.NorevMessageReason])
+
+	_, found := btc.GetRev("purged", revID)
+	assert.False(t, found)
+}
+
 // TestBlipDeltaSyncPull tests that a simple pull replication uses deltas in EE,
 // and checks that full body replication still happens in CE.
 func TestBlipDeltaSyncPull(t *testing.T) {
@@ -2881,6 +5542,43 @@ func TestActiveOnlyContinuous(t *testing.T) {
 	assert.Equal(t, `{}`, string(rev))
 }
 
+// TestActiveOnlyOneShot ensures tombstones are excluded from a one-shot pull with activeOnly, and are included without it.
+func TestActiveOnlyOneShot(t *testing.T) {
+
+	base.SetUpTestLogging(t, base.LevelInfo, base.KeyHTTP, base.KeySync, base.KeySyncMsg, base.KeyChanges)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	rt.PutDoc("doc1", `{"test": true}`)
+	for _, docID := range []string{"doc2", "doc3"} {
+		putResponse := rt.PutDoc(docID, `{"test": true}`)
+		rt.DeleteDoc(docID, putResponse.Rev)
+	}
+	require.NoError(t, rt.WaitForPendingChanges())
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, &BlipTesterSpec{useAdminPort: true}, rt)
+	require.NoError(t, err, "Unexpected error creating BlipTester")
+	defer bt.Close()
+
+	changedDocIDs := func(changes [][]interface{}) []string {
+		docIDs := make([]string, 0, len(changes))
+		for _, change := range changes {
+			docIDs = append(docIDs, change[1].(string))
+		}
+		return docIDs
+	}
+
+	changes := bt.GetChangesWithProperties(blip.Properties{db.SubChangesActiveOnly: "true"})
+	assert.Equal(t, []string{"doc1"}, changedDocIDs(changes))
+
+	changes = bt.GetChangesWithProperties(blip.Properties{db.SubChangesActiveOnly: "false"})
+	assert.ElementsMatch(t, []string{"doc1", "doc2", "doc3"}, changedDocIDs(changes))
+	assert.False(t, bt.changesDeleted["doc1"])
+	assert.True(t, bt.changesDeleted["doc2"])
+	assert.True(t, bt.changesDeleted["doc3"])
+}
+
 // Test that exercises Sync Gateway's norev handler
 func TestBlipNorev(t *testing.T) {
 
@@ -3539,6 +6237,52 @@ func TestRevocationMessage(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// TestContinuousChangesRevocation grants and then revokes channel access part way through a continuous pull, and
+// ensures the client is sent a revocation for the doc it lost access to, and a removal for a doc moved out of its
+// channels, rather than changes for those docs silently stopping.
+func TestContinuousChangesRevocation(t *testing.T) {
+	defer db.SuspendSequenceBatching()()
+	base.SetUpTestLogging(t, base.LevelDebug, base.KeyChanges, base.KeySync, base.KeySyncMsg)
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	resp := rt.SendAdminRequest(http.MethodPut, "/db/_user/user", `{"admin_channels": ["B"], "password": "test"}`)
+	RequireStatus(t, resp, http.StatusCreated)
+
+	btc, err := NewBlipTesterClientOptsWithRT(t, rt, &BlipTesterClientOpts{
+		Username:        "user",
+		Channels:        []string{"*"},
+		SendRevocations: true,
+	})
+	require.NoError(t, err)
+	defer btc.Close()
+
+	require.NoError(t, btc.StartPull())
+
+	docARevID := rt.CreateDocReturnRev(t, "docA", "", map[string]interface{}{"channels": []string{"A"}})
+	docBRevID := rt.CreateDocReturnRev(t, "docB", "", map[string]interface{}{"channels": []string{"B"}})
+	_, ok := btc.WaitForRev("docB", docBRevID)
+	require.True(t, ok)
+
+	// Grant channel A mid-feed, docA is backfilled
+	resp = rt.SendAdminRequest(http.MethodPut, "/db/_user/user", `{"admin_channels": ["A", "B"]}`)
+	RequireStatus(t, resp, http.StatusOK)
+	_, ok = btc.WaitForRev("docA", docARevID)
+	require.True(t, ok)
+
+	// Revoke channel A, docA is revoked
+	resp = rt.SendAdminRequest(http.MethodPut, "/db/_user/user", `{"admin_channels": ["B"]}`)
+	RequireStatus(t, resp, http.StatusOK)
+	assert.Equal(t, 2, btc.WaitForRemoval("docA"))
+	_, removed := btc.DefaultCollection().GetRemoval("docB")
+	assert.False(t, removed)
+
+	// Move docB out of the user's channels, docB is removed
+	_ = rt.CreateDocReturnRev(t, "docB", docBRevID, map[string]interface{}{"channels": []string{"C"}})
+	assert.Equal(t, 4, btc.WaitForRemoval("docB"))
+}
+
 func TestRevocationNoRev(t *testing.T) {
 	defer db.SuspendSequenceBatching()()
 
@@ -4242,6 +6986,32 @@ func TestProveAttachmentNotFound(t *testing.T) {
 	assert.EqualValues(t, attachmentData, resp.BodyBytes())
 }
 
+// TestBlipPushAttachmentTooLarge ensures an attachment that's too large to store is rejected based on its metadata,
+// without its body being requested from the client.
+func TestBlipPushAttachmentTooLarge(t *testing.T) {
+	rt := NewRestTester(t, &RestTesterConfig{
+		GuestEnabled: true,
+	})
+	defer rt.Close()
+
+	bt, err := NewBlipTesterFromSpecWithRT(t, nil, rt)
+	require.NoError(t, err, "Error creating BlipTester")
+	defer bt.Close()
+
+	var getAttachmentCount int32
+	bt.blipContext.HandlerForProfile[db.MessageGetAttachment] = func(msg *blip.Message) {
+		atomic.AddInt32(&getAttachmentCount, 1)
+		msg.Response().SetError("HTTP", http.StatusInternalServerError, "attachment body shouldn't be requested")
+	}
+
+	const attachmentLength = 50 * 1024 * 1024
+	sent, _, resp, err := bt.SendRev("doc1", "1-abc", []byte(fmt.Sprintf(`{"_attachments": {"large": {"digest": "sha1-wzp8ZyykdEuZ9GuqmxQ7XDrY7Co=", "length": %d, "stub": true, "revpos": 1}}}`, attachmentLength)), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "413", resp.Properties["Error-Code"])
+	assert.Equal(t, int32(0), atomic.LoadInt32(&getAttachmentCount))
+}
+
 // CBG-2053: Test that the handleRev stats still increment correctly when going through the processRev function with
 // the stat mapping (processRevStats)
 func TestProcessRevIncrementsStat(t *testing.T) {
@@ -4649,9 +7419,8 @@ func TestUnsubChanges(t *testing.T) {
 	response, err = btc.UnsubPullChanges()
 	assert.NoError(t, err)
 	assert.Empty(t, response)
-	// Wait for unsub changes to stop the sub changes being sent before sending document up
-	activeReplVal, _ := base.WaitForStat(activeReplStat.Value, 0)
-	assert.EqualValues(t, 0, activeReplVal)
+	// unsubChanges doesn't respond until the sub changes feed has stopped
+	assert.EqualValues(t, 0, activeReplStat.Value())
 
 	// Confirm no more changes are being sent
 	resp = rt.UpdateDoc("doc2", "", `{"key":"val1"}`)
@@ -4671,4 +7440,14 @@ func TestUnsubChanges(t *testing.T) {
 	require.NoError(t, err)
 	_, found = btc.WaitForRev("doc2", resp.Rev)
 	assert.True(t, found)
+
+	// Confirm the pull replication can be restarted immediately after unsubscribing from an active feed
+	response, err = btc.UnsubPullChanges()
+	assert.NoError(t, err)
+	assert.Empty(t, response)
+	err = btc.StartPull()
+	require.NoError(t, err)
+	resp = rt.UpdateDoc("doc3", "", `{"key":"val1"}`)
+	_, found = btc.WaitForRev("doc3", resp.Rev)
+	assert.True(t, found)
 }
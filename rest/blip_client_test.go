@@ -59,11 +59,15 @@ type BlipTesterCollectionClient struct {
 
 	docs map[string]map[string]*BodyMessagePair // Client's local store of documents - Map of docID
 	// to rev ID to bytes
-	attachments           map[string][]byte // Client's local store of attachments - Map of digest to bytes
-	lastReplicatedRev     map[string]string // Latest known rev pulled or pushed
-	docsLock              sync.RWMutex      // lock for docs map
-	attachmentsLock       sync.RWMutex      // lock for attachments map
-	lastReplicatedRevLock sync.RWMutex      // lock for lastReplicatedRev map
+	attachments           map[string][]byte        // Client's local store of attachments - Map of digest to bytes
+	lastReplicatedRev     map[string]string        // Latest known rev pulled or pushed
+	removals              map[string]int           // Deleted flags of the last revocation or removal change received - Map of docID
+	noRevs                map[string]*blip.Message // Last norev message received - Map of docID
+	docsLock              sync.RWMutex             // lock for docs map
+	attachmentsLock       sync.RWMutex             // lock for attachments map
+	lastReplicatedRevLock sync.RWMutex             // lock for lastReplicatedRev map
+	removalsLock          sync.RWMutex             // lock for removals map
+	noRevsLock            sync.RWMutex             // lock for noRevs map
 }
 
 type BodyMessagePair struct {
@@ -174,6 +178,9 @@ func (btr *BlipTesterReplicator) initHandlers(btc *BlipTesterClient) {
 						deletedInt = int(castedDeleted)
 					}
 				}
+				if deletedInt&(2|4) != 0 {
+					btcr.storeRemoval(docID, deletedInt)
+				}
 
 				// Build up a list of revisions known to the client for each change
 				// The first element of each revision list must be the parent revision of the change
@@ -425,8 +432,13 @@ func (btr *BlipTesterReplicator) initHandlers(btc *BlipTesterClient) {
 	}
 
 	btr.bt.blipContext.HandlerForProfile[db.MessageNoRev] = func(msg *blip.Message) {
-		// TODO: Support norev messages
 		btr.storeMessage(msg)
+
+		collection, err := btc.getCollectionNameFromMessage(msg)
+		if err != nil {
+			panic(fmt.Sprintf("error occurred getting collection %v", err))
+		}
+		btc.CollectionClients[collection].storeNoRev(msg.Properties[db.NorevMessageId], msg)
 	}
 
 	btr.bt.blipContext.DefaultHandler = func(msg *blip.Message) {
@@ -628,6 +640,8 @@ func (btc *BlipTesterClient) initCollectionReplication(collection string, collec
 		docs:              make(map[string]map[string]*BodyMessagePair),
 		attachments:       make(map[string][]byte),
 		lastReplicatedRev: make(map[string]string),
+		removals:          make(map[string]int),
+		noRevs:            make(map[string]*blip.Message),
 		parent:            btc,
 	}
 
@@ -717,6 +731,14 @@ func (btc *BlipTesterCollectionClient) Close() {
 	btc.lastReplicatedRev = make(map[string]string, 0)
 	btc.lastReplicatedRevLock.Unlock()
 
+	btc.removalsLock.Lock()
+	btc.removals = make(map[string]int, 0)
+	btc.removalsLock.Unlock()
+
+	btc.noRevsLock.Lock()
+	btc.noRevs = make(map[string]*blip.Message, 0)
+	btc.noRevsLock.Unlock()
+
 	btc.attachmentsLock.Lock()
 	btc.attachments = make(map[string][]byte, 0)
 	btc.attachmentsLock.Unlock()
@@ -949,6 +971,71 @@ func (btc *BlipTesterCollectionClient) WaitForRev(docID, revID string) (data []b
 	}
 }
 
+func (btc *BlipTesterCollectionClient) storeRemoval(docID string, deletedFlags int) {
+	btc.removalsLock.Lock()
+	defer btc.removalsLock.Unlock()
+	btc.removals[docID] = deletedFlags
+}
+
+// GetRemoval returns the deleted flags of the last changes row received for the given doc ID that revoked access to
+// it (2) or removed it from all of the user's channels (4), which the client would use to evict the doc.
+func (btc *BlipTesterCollectionClient) GetRemoval(docID string) (deletedFlags int, found bool) {
+	btc.removalsLock.RLock()
+	defer btc.removalsLock.RUnlock()
+	deletedFlags, found = btc.removals[docID]
+	return deletedFlags, found
+}
+
+// WaitForRemoval blocks until a revocation or removal has been received for the given doc ID, and returns its deleted
+// flags.
+func (btc *BlipTesterCollectionClient) WaitForRemoval(docID string) (deletedFlags int) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case <-timeout:
+			btc.parent.rt.TB.Fatalf("BlipTesterClient timed out waiting for removal of doc ID: %v", docID)
+			return 0
+		case <-ticker.C:
+			if deletedFlags, found := btc.GetRemoval(docID); found {
+				return deletedFlags
+			}
+		}
+	}
+}
+
+func (btc *BlipTesterCollectionClient) storeNoRev(docID string, msg *blip.Message) {
+	btc.noRevsLock.Lock()
+	defer btc.noRevsLock.Unlock()
+	btc.noRevs[docID] = msg
+}
+
+// GetNoRev returns the last norev message received for the given doc ID, which the server sends in place of a rev
+// message when a requested revision can't be served.
+func (btc *BlipTesterCollectionClient) GetNoRev(docID string) (msg *blip.Message, found bool) {
+	btc.noRevsLock.RLock()
+	defer btc.noRevsLock.RUnlock()
+	msg, found = btc.noRevs[docID]
+	return msg, found
+}
+
+// WaitForNoRev blocks until a norev message has been received for the given doc ID, and returns it.
+func (btc *BlipTesterCollectionClient) WaitForNoRev(docID string) *blip.Message {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case <-timeout:
+			btc.parent.rt.TB.Fatalf("BlipTesterClient timed out waiting for norev of doc ID: %v", docID)
+			return nil
+		case <-ticker.C:
+			if msg, found := btc.GetNoRev(docID); found {
+				return msg
+			}
+		}
+	}
+}
+
 // GetMessage returns the message stored in the Client under the given serial number
 func (btr *BlipTesterReplicator) GetMessage(serialNumber blip.MessageNumber) (msg *blip.Message, found bool) {
 	btr.messagesLock.RLock()
@@ -1041,6 +1128,14 @@ func (btc *BlipTesterClient) WaitForBlipRevMessage(docID string, revID string) (
 	return btc.DefaultCollection().WaitForBlipRevMessage(docID, revID)
 }
 
+func (btc *BlipTesterClient) WaitForRemoval(docID string) int {
+	return btc.DefaultCollection().WaitForRemoval(docID)
+}
+
+func (btc *BlipTesterClient) WaitForNoRev(docID string) *blip.Message {
+	return btc.DefaultCollection().WaitForNoRev(docID)
+}
+
 func (btc *BlipTesterClient) StartOneshotPull() error {
 	return btc.DefaultCollection().StartOneshotPull()
 }
@@ -49,6 +49,9 @@ func (h *handler) handleBLIPSync() error {
 	ctx := db.NewBlipSyncContext(h.rqCtx, blipContext, h.db, h.formatSerialNumber(), db.BlipSyncStatsForCBL(h.db.DbStats))
 	defer ctx.Close()
 
+	h.db.DatabaseContext.AddActiveBlipSyncContext(ctx)
+	defer h.db.DatabaseContext.RemoveActiveBlipSyncContext(ctx)
+
 	if string(db.BLIPClientTypeSGR2) == h.getQuery(db.BLIPSyncClientTypeQueryParam) {
 		ctx.SetClientType(db.BLIPClientTypeSGR2)
 	} else {
@@ -90,6 +90,45 @@ func TestSubChangesSince(t *testing.T) {
 
 }
 
+// Make sure that the subChangesParams helper parses compound sequences, as sent in changes messages for backfill
+// after a channel grant, both as plain and JSON strings.
+func TestSubChangesSinceCompound(t *testing.T) {
+
+	rt := NewRestTester(t, nil)
+	defer rt.Close()
+
+	testDb := rt.GetDatabase()
+
+	testCases := []struct {
+		since         string
+		expectedSeqID db.SequenceID
+	}{
+		{since: `5:10`, expectedSeqID: db.SequenceID{TriggeredBy: 5, Seq: 10}},
+		{since: `"5:10"`, expectedSeqID: db.SequenceID{TriggeredBy: 5, Seq: 10}},
+		{since: `3::10`, expectedSeqID: db.SequenceID{LowSeq: 3, Seq: 10}},
+		{since: `"3:5:10"`, expectedSeqID: db.SequenceID{LowSeq: 3, TriggeredBy: 5, Seq: 10}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.since, func(t *testing.T) {
+			rq := blip.NewRequest()
+			rq.Properties["since"] = testCase.since
+
+			subChangesParams, err := db.NewSubChangesParams(base.TestCtx(t), rq, db.SequenceID{}, nil, testDb.ParseSequenceID)
+			require.NoError(t, err)
+
+			seqID := subChangesParams.Since()
+			assert.Equal(t, testCase.expectedSeqID, seqID)
+			assert.Equal(t, base.ConvertJSONString(testCase.since), seqID.String())
+		})
+	}
+
+	rq := blip.NewRequest()
+	rq.Properties["since"] = `"1:2:3:4"`
+	_, err := db.NewSubChangesParams(base.TestCtx(t), rq, db.SequenceID{}, nil, testDb.ParseSequenceID)
+	assert.Error(t, err)
+}
+
 // Tests parsing the "future" mode of subChanges.
 func TestSubChangesFuture(t *testing.T) {
 
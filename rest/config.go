@@ -23,6 +23,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
@@ -180,6 +181,16 @@ type DbConfig struct {
 	GraphQL                          *db.GraphQLConfig                `json:"graphql,omitempty"`                              // GraphQL configuration & resolver fns
 	UserFunctions                    db.UserFunctionConfigMap         `json:"functions,omitempty"`                            // Named JS fns for clients to call
 	Suspendable                      *bool                            `json:"suspendable,omitempty"`                          // Allow the database to be suspended
+	ChangesPollIntervalMs            *uint32                          `json:"changes_poll_interval_ms,omitempty"`             // If set, polls for changes not received from the mutation feed at this interval (in ms)
+	DocIDPattern                     string                           `json:"doc_id_pattern,omitempty"`                       // If set, new docs pushed over BLIP must have a docID matching this regular expression
+	DCPProgressLogIntervalSecs       *uint32                          `json:"dcp_progress_log_interval_secs,omitempty"`       // If set, DCP feeds for import and attachment compaction log aggregate progress at this interval (in seconds)
+	DocCountQuota                    *uint64                          `json:"doc_count_quota,omitempty"`                      // If set, new docs pushed over BLIP are rejected with 507 once the database holds this many docs
+	BlipCompressionThresholdBytes    *uint32                          `json:"blip_compression_threshold_bytes,omitempty"`     // BLIP messages with bodies smaller than this are sent uncompressed. Default 0 (compress all compressible messages)
+	TombstoneTTLSecs                 *uint32                          `json:"tombstone_ttl_secs,omitempty"`                   // If set, tombstones older than this are purged by tombstone compaction, instead of using the server's metadata purge interval
+	MaxDocumentSizeBytes             *uint32                          `json:"max_document_size_bytes,omitempty"`              // If set, revs pushed over BLIP with bodies larger than this are rejected with 413
+	MaxChangesMessageBytes           *uint32                          `json:"max_changes_message_bytes,omitempty"`            // If set, batches of changes sent over BLIP are split into changes messages with bodies of at most this size
+	BlipKeepaliveIntervalSecs        *uint32                          `json:"blip_keepalive_interval_secs,omitempty"`         // If set, keepalive requests are sent on continuous replications idle for this long
+	BlipKeepaliveTimeoutSecs         *uint32                          `json:"blip_keepalive_timeout_secs,omitempty"`          // Replication connections that don't respond to a keepalive within this long are closed. Default 30
 }
 
 type ScopesConfig map[string]ScopeConfig
@@ -584,6 +595,16 @@ func (dbConfig *DbConfig) validateVersion(ctx context.Context, isEnterpriseEditi
 			fmt.Sprintf("%g-%g", db.CompactIntervalMinDays, db.CompactIntervalMaxDays)))
 	}
 
+	if dbConfig.ChangesPollIntervalMs != nil && *dbConfig.ChangesPollIntervalMs < db.MinChangesPollIntervalMs {
+		multiError = multiError.Append(fmt.Errorf(minValueErrorMsg, "changes_poll_interval_ms", db.MinChangesPollIntervalMs))
+	}
+
+	if dbConfig.DocIDPattern != "" {
+		if _, err := regexp.Compile(dbConfig.DocIDPattern); err != nil {
+			multiError = multiError.Append(fmt.Errorf("doc_id_pattern is not a valid regular expression: %w", err))
+		}
+	}
+
 	if dbConfig.CacheConfig != nil {
 
 		if dbConfig.CacheConfig.ChannelCacheConfig != nil {
@@ -132,6 +132,15 @@ func TestConfigValidation(t *testing.T) {
 			name:   "Compact Interval just right",
 			config: `{"databases": {"db":{"compact_interval_days": 0.04}}}`,
 		},
+		{
+			name:   "Changes poll interval too low",
+			config: `{"databases": {"db":{"changes_poll_interval_ms": 99}}}`,
+			err:    "minimum value for changes_poll_interval_ms is: 100",
+		},
+		{
+			name:   "Changes poll interval just right",
+			config: `{"databases": {"db":{"changes_poll_interval_ms": 100}}}`,
+		},
 	}
 
 	for _, test := range tests {
@@ -20,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/couchbase/go-blip"
 	"github.com/couchbase/sync_gateway/base"
 	"github.com/couchbase/sync_gateway/db"
 	"github.com/couchbase/sync_gateway/rest"
@@ -2427,3 +2428,54 @@ func TestImportTouch(t *testing.T) {
 	require.NoError(t, err, "Unable to unmarshal raw response")
 	require.Equal(t, initialRev, rawUpdateResponse.Sync.Rev)
 }
+
+// TestBlipPushImportConflict ensures that a rev pushed over BLIP that conflicts with a concurrent SDK update, imported
+// as part of the push, is rejected with an import conflict error rather than the generic conflict error.
+func TestBlipPushImportConflict(t *testing.T) {
+
+	SkipImportTestsIfNotEnabled(t)
+
+	base.SetUpTestLogging(t, base.LevelDebug, base.KeyImport, base.KeyCRUD, base.KeySyncMsg)
+
+	rt := rest.NewRestTester(t, &rest.RestTesterConfig{
+		GuestEnabled: true,
+		DatabaseConfig: &rest.DatabaseConfig{DbConfig: rest.DbConfig{
+			AutoImport: false,
+		}},
+	})
+	defer rt.Close()
+
+	bt, err := rest.NewBlipTesterFromSpecWithRT(t, nil, rt)
+	require.NoError(t, err)
+	defer bt.Close()
+
+	key := "TestBlipPushImportConflict"
+	response := rt.SendAdminRequest(http.MethodPut, "/db/"+key, `{"version": 1}`)
+	rest.RequireStatus(t, response, http.StatusCreated)
+	rev1 := rest.RespRevID(t, response)
+
+	// SDK update that hasn't been imported yet, so is imported when the client's rev is written
+	require.NoError(t, rt.Bucket().Set(key, 0, nil, map[string]interface{}{"version": "sdk"}))
+
+	sent, _, resp, err := bt.SendRevWithHistory(key, "2-abc", []string{rev1}, []byte(`{"version": 2}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "409", resp.Properties["Error-Code"])
+	body, err := resp.Body()
+	require.NoError(t, err)
+	assert.Equal(t, db.ErrImportConflict.Message, string(body))
+
+	// The imported update is the current revision
+	response = rt.SendAdminRequest(http.MethodGet, "/db/"+key, "")
+	rest.RequireStatus(t, response, http.StatusOK)
+	assert.Contains(t, response.Body.String(), `"version":"sdk"`)
+
+	// A subsequent conflicting push doesn't involve an import, so gets the generic conflict error
+	sent, _, resp, err = bt.SendRevWithHistory(key, "2-def", []string{rev1}, []byte(`{"version": 2}`), blip.Properties{})
+	require.True(t, sent)
+	require.Error(t, err)
+	assert.Equal(t, "409", resp.Properties["Error-Code"])
+	body, err = resp.Body()
+	require.NoError(t, err)
+	assert.NotEqual(t, db.ErrImportConflict.Message, string(body))
+}
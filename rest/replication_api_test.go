@@ -1291,6 +1291,17 @@ func TestValidateReplication(t *testing.T) {
 			},
 			expectedErrorMsg: db.ConfigErrorIDTooLong,
 		},
+		{
+			name: "replication config batch size too large",
+			replicationConfig: db.ReplicationConfig{
+				ID:        "replication1",
+				Remote:    "http://remote:4984/db",
+				Direction: "pull",
+				BatchSize: int(db.BlipMaximumBatchSize) + 1,
+			},
+			expectedErrorMsg: fmt.Sprintf(db.ConfigErrorBatchSizeTooLargeFmt, db.BlipMaximumBatchSize),
+			eeOnly:           true,
+		},
 		{
 			name: "custom conflict resolution without func",
 			replicationConfig: db.ReplicationConfig{
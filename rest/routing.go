@@ -161,6 +161,10 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, []Permission{PermUpdateDb}, nil, (*handler).handleCompact)).Methods("POST")
 	keyspace.Handle("/_compact",
 		makeHandler(sc, adminPrivs, []Permission{PermUpdateDb}, nil, (*handler).handleGetCompact)).Methods("GET")
+	keyspace.Handle("/_checkpoints",
+		makeHandler(sc, adminPrivs, []Permission{PermReadReplications}, nil, (*handler).getClientCheckpoints)).Methods("GET", "HEAD")
+	keyspace.Handle("/_checkpoint/{client}",
+		makeHandler(sc, adminPrivs, []Permission{PermWriteReplications}, nil, (*handler).deleteClientCheckpoint)).Methods("DELETE")
 
 	// Database handlers (multi collection):
 	dbr.Handle("/_session",
@@ -214,6 +218,8 @@ func CreateAdminRouter(sc *ServerContext) *mux.Router {
 		makeHandler(sc, adminPrivs, []Permission{PermReadReplications}, nil, (*handler).getReplicationStatus)).Methods("GET", "HEAD")
 	dbr.Handle("/_replicationStatus/{replicationID}",
 		makeHandler(sc, adminPrivs, []Permission{PermWriteReplications}, nil, (*handler).putReplicationStatus)).Methods("PUT")
+	dbr.Handle("/_blip_active",
+		makeHandler(sc, adminPrivs, []Permission{PermReadReplications}, nil, (*handler).getActiveBlipReplications)).Methods("GET", "HEAD")
 	dbr.Handle("/_config",
 		makeOfflineHandler(sc, adminPrivs, []Permission{PermUpdateDb}, nil, (*handler).handleGetDbConfig)).Methods("GET")
 	dbr.Handle("/_config",
@@ -20,6 +20,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -792,6 +793,58 @@ func dbcOptionsFromConfig(ctx context.Context, sc *ServerContext, config *DbConf
 		slowQueryWarningThreshold = time.Duration(*config.SlowQueryWarningThresholdMs) * time.Millisecond
 	}
 
+	var docIDPattern *regexp.Regexp
+	if config.DocIDPattern != "" {
+		var err error
+		docIDPattern, err = regexp.Compile(config.DocIDPattern)
+		if err != nil {
+			return db.DatabaseContextOptions{}, fmt.Errorf("invalid doc_id_pattern: %w", err)
+		}
+	}
+
+	var changesPollInterval time.Duration
+	if config.ChangesPollIntervalMs != nil {
+		changesPollInterval = time.Duration(*config.ChangesPollIntervalMs) * time.Millisecond
+	}
+
+	var dcpProgressLogInterval time.Duration
+	if config.DCPProgressLogIntervalSecs != nil {
+		dcpProgressLogInterval = time.Duration(*config.DCPProgressLogIntervalSecs) * time.Second
+	}
+
+	var docCountQuota uint64
+	if config.DocCountQuota != nil {
+		docCountQuota = *config.DocCountQuota
+	}
+
+	var blipCompressionThreshold int
+	if config.BlipCompressionThresholdBytes != nil {
+		blipCompressionThreshold = int(*config.BlipCompressionThresholdBytes)
+	}
+
+	var tombstoneTTL time.Duration
+	if config.TombstoneTTLSecs != nil {
+		tombstoneTTL = time.Duration(*config.TombstoneTTLSecs) * time.Second
+	}
+
+	var maxDocumentSize int
+	if config.MaxDocumentSizeBytes != nil {
+		maxDocumentSize = int(*config.MaxDocumentSizeBytes)
+	}
+
+	var maxChangesMessageSize int
+	if config.MaxChangesMessageBytes != nil {
+		maxChangesMessageSize = int(*config.MaxChangesMessageBytes)
+	}
+
+	var blipKeepaliveInterval, blipKeepaliveTimeout time.Duration
+	if config.BlipKeepaliveIntervalSecs != nil {
+		blipKeepaliveInterval = time.Duration(*config.BlipKeepaliveIntervalSecs) * time.Second
+	}
+	if config.BlipKeepaliveTimeoutSecs != nil {
+		blipKeepaliveTimeout = time.Duration(*config.BlipKeepaliveTimeoutSecs) * time.Second
+	}
+
 	groupID := ""
 	if sc.Config.Bootstrap.ConfigGroupID != PersistentConfigDefaultGroupID {
 		groupID = sc.Config.Bootstrap.ConfigGroupID
@@ -839,6 +892,16 @@ func dbcOptionsFromConfig(ctx context.Context, sc *ServerContext, config *DbConf
 		GroupID:                   groupID,
 		JavascriptTimeout:         javascriptTimeout,
 		Serverless:                sc.Config.IsServerless(),
+		ChangesPollInterval:       changesPollInterval,
+		DCPProgressLogInterval:    dcpProgressLogInterval,
+		DocCountQuota:             docCountQuota,
+		BlipCompressionThreshold:  blipCompressionThreshold,
+		TombstoneTTL:              tombstoneTTL,
+		MaxDocumentSize:           maxDocumentSize,
+		MaxChangesMessageSize:     maxChangesMessageSize,
+		BlipKeepaliveInterval:     blipKeepaliveInterval,
+		BlipKeepaliveTimeout:      blipKeepaliveTimeout,
+		DocIDPattern:              docIDPattern,
 		// UserQueries:               config.UserQueries,   // behind feature flag (see below)
 		// UserFunctions:             config.UserFunctions, // behind feature flag (see below)
 		// GraphQL:                   config.GraphQL,       // behind feature flag (see below)
@@ -12,16 +12,20 @@ package rest
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -976,8 +980,34 @@ type BlipTesterSpec struct {
 
 	// Supported blipProtocols for the client to use in order of preference
 	blipProtocols []string
+
+	// If set, the blip connection is made over the admin port instead of the public port, so is not associated
+	// with any user.  connectingUsername is ignored when this is set.
+	useAdminPort bool
+
+	// If an underlying RestTester is created, it will use this sync function.  See syncFnThrowFromDoc for a sync
+	// function that rejects revisions in a way chosen by the test.
+	syncFn string
+
+	// If set, a session is created for connectingUsername via POST /db/_session, and the blip connection is
+	// authenticated with the session cookie instead of basic auth.
+	useSessionCookie bool
+
+	// If set, the blip connection is authenticated with this cookie, in name=value form, instead of basic auth or a
+	// new session.  Allows tests to connect with an invalid or deleted session.
+	sessionCookie string
 }
 
+// syncFnThrowFromDoc is a sync function that throws the value of a revision's "throw" property when it's set, so that
+// tests can choose how each revision is rejected.  Throwing {"forbidden": msg} rejects with a 403, {"unauthorized": msg}
+// with a 401, and any other value is an exception in the sync function.
+const syncFnThrowFromDoc = `function(doc) {
+	if (doc.throw) {
+		throw(doc.throw);
+	}
+	channel(doc.channels);
+}`
+
 // State associated with a BlipTester
 // Note that it's not safe to have multiple goroutines access a single BlipTester due to the
 // fact that certain methods register profile handlers on the BlipContext
@@ -1003,10 +1033,30 @@ type BlipTester struct {
 	// Set when we receive a reply to a getCollections request. Used to verify that all messages after that contain a
 	// `collection` property.
 	useCollections *base.AtomicBool
+
+	// If set, attachments are requested in this encoding (e.g. gzip) by PullDocs
+	getAttachmentEncoding string
+
+	// The number of getAttachment requests the server has made for each digest while revs were pushed by
+	// SendRevWithAttachment
+	getAttachmentRequests     map[string]int
+	getAttachmentRequestsLock sync.Mutex
+
+	// If set, SendRevWithHistory sends at most this many ancestors in a rev's history, as clients do when a changes
+	// or proposeChanges response has a maxHistory property
+	maxHistory int
+
+	// The spec and test the BlipTester was created with, used to re-establish the blip connection in Reconnect
+	spec BlipTesterSpec
+	tb   testing.TB
+
+	// The deleted flag of the latest change for each doc received by the most recent GetChanges, used by
+	// AssertChangeDeleted
+	changesDeleted map[string]bool
 }
 
 // Close the bliptester
-func (bt BlipTester) Close() {
+func (bt *BlipTester) Close() {
 	bt.sender.Close()
 	if !bt.avoidRestTesterClose {
 		bt.restTester.Close()
@@ -1047,6 +1097,7 @@ func NewBlipTesterFromSpec(tb testing.TB, spec BlipTesterSpec) (*BlipTester, err
 	rtConfig := RestTesterConfig{
 		EnableNoConflictsMode: spec.noConflictsMode,
 		GuestEnabled:          spec.GuestEnabled,
+		SyncFn:                spec.syncFn,
 	}
 	var rt = NewRestTester(tb, &rtConfig)
 	return createBlipTesterWithSpec(tb, spec, rt)
@@ -1057,10 +1108,15 @@ func createBlipTesterWithSpec(tb testing.TB, spec BlipTesterSpec, rt *RestTester
 	bt := &BlipTester{
 		restTester:     rt,
 		useCollections: base.NewAtomicBool(false),
+		tb:             tb,
 	}
 
 	// Since blip requests all go over the public handler, wrap the public handler with the httptest server
 	publicHandler := bt.restTester.TestPublicHandler()
+	if spec.useAdminPort {
+		publicHandler = bt.restTester.TestAdminHandler()
+		spec.connectingUsername = ""
+	}
 
 	if len(spec.connectingUsername) > 0 {
 
@@ -1095,18 +1151,58 @@ func createBlipTesterWithSpec(tb testing.TB, spec BlipTesterSpec, rt *RestTester
 		)
 	}
 
+	if spec.useSessionCookie && spec.sessionCookie == "" && len(spec.connectingUsername) > 0 {
+		cookie, err := createSessionCookie(bt.restTester, spec.connectingUsername, spec.connectingPassword)
+		if err != nil {
+			return nil, err
+		}
+		spec.sessionCookie = cookie
+	}
+
+	bt.spec = spec
+	if err := bt.connect(publicHandler); err != nil {
+		return nil, err
+	}
+
+	return bt, nil
+}
+
+// createSessionCookie creates a session for the user via POST /db/_session, and returns the session cookie in
+// name=value form.
+func createSessionCookie(rt *RestTester, username, password string) (string, error) {
+	body, err := base.JSONMarshal(map[string]string{"name": username, "password": password})
+	if err != nil {
+		return "", err
+	}
+	resp := rt.SendRequest(http.MethodPost, "/db/_session", string(body))
+	if resp.Code != http.StatusOK {
+		return "", fmt.Errorf("Unexpected status %d creating session for %s: %s", resp.Code, username, resp.Body.String())
+	}
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Value != "" {
+			return cookie.Name + "=" + cookie.Value, nil
+		}
+	}
+	return "", fmt.Errorf("No session cookie in response creating session for %s", username)
+}
+
+// connect establishes the blip connection for the BlipTester's spec, using a new blip context.
+func (bt *BlipTester) connect(handler http.Handler) error {
+	spec := bt.spec
+	tb := bt.tb
+
 	// Create a _temporary_ test server bound to an actual port that is used to make the blip connection.
 	// This is needed because the mock-based approach fails with a "Connection not hijackable" error when
 	// trying to do the websocket upgrade.  Since it's only needed to setup the websocket, it can be closed
 	// as soon as the websocket is established, hence the defer srv.Close() call.
-	srv := httptest.NewServer(publicHandler)
+	srv := httptest.NewServer(handler)
 	defer srv.Close()
 
 	// Construct URL to connect to blipsync target endpoint
 	destUrl := fmt.Sprintf("%s/db/_blipsync", srv.URL)
 	u, err := url.Parse(destUrl)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	u.Scheme = "ws"
 
@@ -1119,7 +1215,7 @@ func createBlipTesterWithSpec(tb testing.TB, spec BlipTesterSpec, rt *RestTester
 	// Make BLIP/Websocket connection
 	bt.blipContext, err = db.NewSGBlipContextWithProtocols(base.TestCtx(tb), "", protocols...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Ensure that errors get correctly surfaced in tests
@@ -1135,19 +1231,59 @@ func createBlipTesterWithSpec(tb testing.TB, spec BlipTesterSpec, rt *RestTester
 		URL: u.String(),
 	}
 
-	if len(spec.connectingUsername) > 0 {
+	if spec.sessionCookie != "" {
+		config.HTTPHeader = http.Header{
+			"Cookie": {spec.sessionCookie},
+		}
+	} else if len(spec.connectingUsername) > 0 {
 		config.HTTPHeader = http.Header{
 			"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(spec.connectingUsername+":"+spec.connectingPassword))},
 		}
 	}
 
 	bt.sender, err = bt.blipContext.DialConfig(&config)
-	if err != nil {
-		return nil, err
+	return err
+}
+
+// Disconnect closes the blip connection, as if the network connection to Sync Gateway had been lost, and waits for
+// Sync Gateway to notice.  Use Reconnect to re-establish the connection.
+func (bt *BlipTester) Disconnect() {
+	user := bt.spec.connectingUsername
+	numConnections := func() (n int) {
+		for _, status := range bt.restTester.GetDatabase().ActiveBlipReplications() {
+			if status.User == user {
+				n++
+			}
+		}
+		return n
 	}
 
-	return bt, nil
+	connectionsBefore := numConnections()
+	bt.sender.Close()
+	require.NoError(bt.tb, bt.restTester.WaitForCondition(func() bool {
+		return numConnections() < connectionsBefore
+	}), "Sync Gateway didn't close the blip connection")
+}
+
+// Reconnect re-establishes the blip connection closed by Disconnect, with a new blip context, as a client would after
+// a network interruption.  State held by Sync Gateway, such as checkpoints, is unaffected.  Profile handlers registered
+// on the previous blip context are carried over.  Collections must be requested again with getCollections.
+func (bt *BlipTester) Reconnect() error {
+	handlers := bt.blipContext.HandlerForProfile
 
+	handler := bt.restTester.TestPublicHandler()
+	if bt.spec.useAdminPort {
+		handler = bt.restTester.TestAdminHandler()
+	}
+	if err := bt.connect(handler); err != nil {
+		return err
+	}
+
+	for profile, profileHandler := range handlers {
+		bt.blipContext.HandlerForProfile[profile] = profileHandler
+	}
+	bt.useCollections.Set(false)
+	return nil
 }
 
 func (bt *BlipTester) SetCheckpoint(client string, checkpointRev string, body []byte) (sent bool, req *db.SetCheckpointMessage, res *db.SetCheckpointResponse, err error) {
@@ -1168,6 +1304,286 @@ func (bt *BlipTester) SetCheckpoint(client string, checkpointRev string, body []
 
 }
 
+// GetCheckpoint sends a getCheckpoint request for the given client, and returns the checkpoint's rev and body.
+func (bt *BlipTester) GetCheckpoint(client string) (checkpointRev string, body []byte, err error) {
+
+	rq := blip.NewRequest()
+	rq.SetProfile(db.MessageGetCheckpoint)
+	rq.Properties[db.BlipClient] = client
+	if !bt.sender.Send(rq) {
+		return "", nil, fmt.Errorf("Failed to send getCheckpoint for client: %v", client)
+	}
+
+	response := rq.Response()
+	body, err = response.Body()
+	if err != nil {
+		return "", nil, err
+	}
+	if errorCode, ok := response.Properties[db.BlipErrorCode]; ok {
+		return "", nil, fmt.Errorf("Unexpected error sending %s: %s %s", db.MessageGetCheckpoint, errorCode, body)
+	}
+	return response.Properties[db.GetCheckpointResponseRev], body, nil
+}
+
+// BulkDeleteCheckpoints sends a bulkDelCheckpoint request for the given client IDs and/or client ID prefix, and returns
+// the number of checkpoints deleted.  Requires a BlipTester connected over the admin port.
+func (bt *BlipTester) BulkDeleteCheckpoints(clients []string, prefix string) (deleted int, err error) {
+
+	rq := blip.NewRequest()
+	rq.SetProfile(db.MessageBulkDelCheckpoint)
+	if err := rq.SetJSONBody(db.BulkDelCheckpointRequestBody{Clients: clients, Prefix: prefix}); err != nil {
+		return 0, err
+	}
+
+	if !bt.sender.Send(rq) {
+		return 0, fmt.Errorf("Failed to send %s request", db.MessageBulkDelCheckpoint)
+	}
+	resp := rq.Response()
+	if errorCode, ok := resp.Properties[db.BlipErrorCode]; ok {
+		body, _ := resp.Body()
+		return 0, fmt.Errorf("Unexpected error sending %s: %s %s", db.MessageBulkDelCheckpoint, errorCode, body)
+	}
+
+	var responseBody db.BulkDelCheckpointResponseBody
+	if err := resp.ReadJSONBody(&responseBody); err != nil {
+		return 0, err
+	}
+	return responseBody.Deleted, nil
+}
+
+// GetActiveReplications sends a getActiveReplications request, and returns the status of each client replication
+// connection to the database.  Requires a BlipTester connected over the admin port.
+func (bt *BlipTester) GetActiveReplications() (statuses []db.BlipReplicationStatus, err error) {
+
+	rq := blip.NewRequest()
+	rq.SetProfile(db.MessageGetActiveReplications)
+
+	if !bt.sender.Send(rq) {
+		return nil, fmt.Errorf("Failed to send %s request", db.MessageGetActiveReplications)
+	}
+	resp := rq.Response()
+	if errorCode, ok := resp.Properties[db.BlipErrorCode]; ok {
+		body, _ := resp.Body()
+		return nil, fmt.Errorf("Unexpected error sending %s: %s %s", db.MessageGetActiveReplications, errorCode, body)
+	}
+
+	if err := resp.ReadJSONBody(&statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// GetDocChannels sends a getDocChannels request for the given document, and returns the channels reported.  Admin
+// connections are sent all of the document's channels, user connections only the channels visible to the user.
+func (bt *BlipTester) GetDocChannels(docID string) (docChannels []string, err error) {
+
+	rq := blip.NewRequest()
+	rq.SetProfile(db.MessageGetDocChannels)
+	rq.Properties[db.GetDocChannelsID] = docID
+
+	if !bt.sender.Send(rq) {
+		return nil, fmt.Errorf("Failed to send %s request", db.MessageGetDocChannels)
+	}
+	resp := rq.Response()
+	if errorCode, ok := resp.Properties[db.BlipErrorCode]; ok {
+		body, _ := resp.Body()
+		return nil, fmt.Errorf("Unexpected error sending %s: %s %s", db.MessageGetDocChannels, errorCode, body)
+	}
+
+	var responseBody db.GetDocChannelsResponseBody
+	if err := resp.ReadJSONBody(&responseBody); err != nil {
+		return nil, err
+	}
+	return responseBody.Channels, nil
+}
+
+// GetRevTree sends a getRevTree request for the given document, and returns the response body.  Only permitted for
+// admin connections.
+func (bt *BlipTester) GetRevTree(docID string) (revTree db.GetRevTreeResponseBody, err error) {
+
+	rq := blip.NewRequest()
+	rq.SetProfile(db.MessageGetRevTree)
+	rq.Properties[db.GetRevTreeID] = docID
+
+	if !bt.sender.Send(rq) {
+		return revTree, fmt.Errorf("Failed to send %s request", db.MessageGetRevTree)
+	}
+	resp := rq.Response()
+	if errorCode, ok := resp.Properties[db.BlipErrorCode]; ok {
+		body, _ := resp.Body()
+		return revTree, fmt.Errorf("Unexpected error sending %s: %s %s", db.MessageGetRevTree, errorCode, body)
+	}
+
+	if err := resp.ReadJSONBody(&revTree); err != nil {
+		return revTree, err
+	}
+	return revTree, nil
+}
+
+// GetServerSequence sends a getServerSequence request and returns the response body.
+func (bt *BlipTester) GetServerSequence() (sequences db.GetServerSequenceResponseBody, err error) {
+
+	rq := blip.NewRequest()
+	rq.SetProfile(db.MessageGetServerSequence)
+
+	if !bt.sender.Send(rq) {
+		return sequences, fmt.Errorf("Failed to send %s request", db.MessageGetServerSequence)
+	}
+	resp := rq.Response()
+	if errorCode, ok := resp.Properties[db.BlipErrorCode]; ok {
+		body, _ := resp.Body()
+		return sequences, fmt.Errorf("Unexpected error sending %s: %s %s", db.MessageGetServerSequence, errorCode, body)
+	}
+
+	err = resp.ReadJSONBody(&sequences)
+	return sequences, err
+}
+
+// PurgeDoc purges the given doc through the admin REST API, as there's no BLIP message for purging.  The purge has
+// completed, including removal of the doc from the change cache, when PurgeDoc returns.
+func (bt *BlipTester) PurgeDoc(docID string) error {
+	resp := bt.restTester.SendAdminRequest(http.MethodPost, "/db/_purge", fmt.Sprintf(`{%q: ["*"]}`, docID))
+	if resp.Code != http.StatusOK {
+		return fmt.Errorf("Unexpected status purging doc %q: %d %s", docID, resp.Code, resp.Body.String())
+	}
+
+	var responseBody struct {
+		Purged map[string][]string `json:"purged"`
+	}
+	if err := base.JSONUnmarshal(resp.Body.Bytes(), &responseBody); err != nil {
+		return err
+	}
+	if _, ok := responseBody.Purged[docID]; !ok {
+		return fmt.Errorf("Doc %q wasn't purged: %s", docID, resp.Body.String())
+	}
+	return nil
+}
+
+// RequireDocNotInChanges fails the test if a one-shot GetChanges() includes a change for the given doc.
+// Warning: this can only be called from a single goroutine, given the fact it registers profile handlers.
+func (bt *BlipTester) RequireDocNotInChanges(docID string) {
+	for _, change := range bt.GetChanges() {
+		require.NotEqualf(bt.restTester.TB, docID, change[1], "Unexpected change for doc %q: %v", docID, change)
+	}
+}
+
+// WaitForDocExpiredFromChanges waits until docID no longer appears in changes as a live document, i.e. it is either
+// absent from changes or its change is a deletion, as expected once an expired document has been processed.
+func (bt *BlipTester) WaitForDocExpiredFromChanges(docID string) {
+
+	retryWorker := func() (shouldRetry bool, err error, value interface{}) {
+		for _, change := range bt.GetChanges() {
+			if change[1] == docID && !isDeletedChange(change) {
+				return true, nil, nil
+			}
+		}
+		return false, nil, nil
+	}
+
+	err, _ := base.RetryLoop(
+		"WaitForDocExpiredFromChanges",
+		retryWorker,
+		base.CreateDoublingSleeperFunc(20, 100),
+	)
+	require.NoErrorf(bt.restTester.TB, err, "Doc %q still present in changes", docID)
+}
+
+// AssertChangeDeleted asserts that docID was in the changes received by the most recent GetChanges, and that the
+// deleted flag of its latest change is the expected value.
+func (bt *BlipTester) AssertChangeDeleted(docID string, expected bool) {
+	deleted, ok := bt.changesDeleted[docID]
+	if assert.Truef(bt.restTester.TB, ok, "Doc %q not found in changes", docID) {
+		assert.Equalf(bt.restTester.TB, expected, deleted, "Unexpected deleted flag in change for doc %q", docID)
+	}
+}
+
+// isDeletedChange returns true if the given change row, as returned by GetChanges, is flagged as a deletion.  The
+// deleted flag is a boolean for clients without deleted flag support, and a set of flags otherwise.
+func isDeletedChange(change []interface{}) bool {
+	if len(change) < 4 {
+		return false
+	}
+	switch deleted := change[3].(type) {
+	case bool:
+		return deleted
+	case float64:
+		return int(deleted)&1 != 0
+	default:
+		return false
+	}
+}
+
+// GetRevs sends a getRevs request for the given [docID, revID] pairs, and returns the rev and norev messages sent in
+// response, in the order they were sent.
+func (bt *BlipTester) GetRevs(docRevs [][]string) (revs []*blip.Message, err error) {
+
+	var revsLock sync.Mutex
+	collectRev := func(request *blip.Message) {
+		revsLock.Lock()
+		defer revsLock.Unlock()
+		revs = append(revs, request)
+	}
+
+	defer func() {
+		// Clean up all profile handlers that are registered as part of this request
+		delete(bt.blipContext.HandlerForProfile, db.MessageRev)
+		delete(bt.blipContext.HandlerForProfile, db.MessageNoRev)
+	}()
+	bt.blipContext.HandlerForProfile[db.MessageRev] = collectRev
+	bt.blipContext.HandlerForProfile[db.MessageNoRev] = collectRev
+
+	rq := blip.NewRequest()
+	rq.SetProfile(db.MessageGetRevs)
+	if err := rq.SetJSONBody(docRevs); err != nil {
+		return nil, err
+	}
+	if !bt.sender.Send(rq) {
+		return nil, fmt.Errorf("Failed to send %s request", db.MessageGetRevs)
+	}
+	resp := rq.Response()
+	if errorCode, ok := resp.Properties[db.BlipErrorCode]; ok {
+		body, _ := resp.Body()
+		return nil, fmt.Errorf("Unexpected error sending %s: %s %s", db.MessageGetRevs, errorCode, body)
+	}
+
+	// Every rev has been sent by the time the response is, but they're dispatched to the handlers concurrently
+	err = bt.restTester.WaitForCondition(func() bool {
+		revsLock.Lock()
+		defer revsLock.Unlock()
+		return len(revs) >= len(docRevs)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Expected %d revs in response to %s: %w", len(docRevs), db.MessageGetRevs, err)
+	}
+
+	revsLock.Lock()
+	defer revsLock.Unlock()
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].SerialNumber() < revs[j].SerialNumber()
+	})
+	return revs, nil
+}
+
+// GetRevHistory requests the given revision and returns the history sent with it, as a descending list of ancestor
+// revIDs.  Returns an error if the revision is sent as a norev.
+func (bt *BlipTester) GetRevHistory(docID, revID string) (history []string, err error) {
+	revs, err := bt.GetRevs([][]string{{docID, revID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) != 1 {
+		return nil, fmt.Errorf("Expected 1 rev for %s/%s, got %d", docID, revID, len(revs))
+	}
+	rev := revs[0]
+	if rev.Profile() == db.MessageNoRev {
+		return nil, fmt.Errorf("Received norev for %s/%s: %s %s", docID, revID, rev.Properties[db.NorevMessageError], rev.Properties[db.NorevMessageReason])
+	}
+	if historyStr := rev.Properties[db.RevMessageHistory]; historyStr != "" {
+		history = strings.Split(historyStr, ",")
+	}
+	return history, nil
+}
+
 // The docHistory should be in the same format as expected by db.PutExistingRevWithBody(), or empty if this is the first revision
 func (bt *BlipTester) SendRevWithHistory(docId, docRev string, revHistory []string, body []byte, properties blip.Properties) (sent bool, req, res *blip.Message, err error) {
 
@@ -1178,6 +1594,9 @@ func (bt *BlipTester) SendRevWithHistory(docId, docRev string, revHistory []stri
 	revRequest.Properties["id"] = docId
 	revRequest.Properties["rev"] = docRev
 	revRequest.Properties["deleted"] = "false"
+	if bt.maxHistory > 0 && len(revHistory) > bt.maxHistory {
+		revHistory = revHistory[:bt.maxHistory]
+	}
 	if len(revHistory) > 0 {
 		revRequest.Properties["history"] = strings.Join(revHistory, ",")
 	}
@@ -1214,6 +1633,155 @@ func (bt *BlipTester) SendRev(docId, docRev string, body []byte, properties blip
 
 }
 
+// SendRevsConcurrently sends numRevs new documents from each of numGoroutines goroutines at once, to reproduce races in
+// the handling of concurrent rev messages.  Document IDs are docIDPrefix followed by the goroutine and rev index, and
+// each document's rev ID is 1-abc.  Returns the IDs of all documents sent, along with any errors from sending them.
+func (bt *BlipTester) SendRevsConcurrently(docIDPrefix string, numGoroutines, numRevs int, body []byte) (docIDs []string, errs []error) {
+	var errsLock sync.Mutex
+	var wg sync.WaitGroup
+	for g := 0; g < numGoroutines; g++ {
+		for i := 0; i < numRevs; i++ {
+			docIDs = append(docIDs, fmt.Sprintf("%s%d-%d", docIDPrefix, g, i))
+		}
+		wg.Add(1)
+		go func(goroutineDocIDs []string) {
+			defer wg.Done()
+			for _, docID := range goroutineDocIDs {
+				if _, _, _, err := bt.SendRev(docID, "1-abc", body, blip.Properties{}); err != nil {
+					errsLock.Lock()
+					errs = append(errs, err)
+					errsLock.Unlock()
+				}
+			}
+		}(docIDs[g*numRevs : (g+1)*numRevs])
+	}
+	wg.Wait()
+	return docIDs, errs
+}
+
+// RequireDocsInChanges requires that changes are seen for all of the given documents within timeout.
+func (bt *BlipTester) RequireDocsInChanges(docIDs []string, timeout time.Duration) {
+	missingDocIDs := func(changes [][]interface{}) []string {
+		seen := make(map[string]struct{}, len(changes))
+		for _, change := range changes {
+			if len(change) >= 2 {
+				if docID, ok := change[1].(string); ok {
+					seen[docID] = struct{}{}
+				}
+			}
+		}
+		var missing []string
+		for _, docID := range docIDs {
+			if _, ok := seen[docID]; !ok {
+				missing = append(missing, docID)
+			}
+		}
+		return missing
+	}
+	_, lastChanges, ok := bt.pollChangesWithTimeout(timeout, func(changes [][]interface{}) bool {
+		return len(missingDocIDs(changes)) == 0
+	})
+	if !ok {
+		missing := missingDocIDs(lastChanges)
+		require.FailNowf(bt.restTester.TB, "Docs missing from changes", "Timed out after %v waiting for %d docs in changes, %d missing, e.g. %q", timeout, len(docIDs), len(missing), missing[0])
+	}
+}
+
+// RequireRevRejected sends a rev for docID, requires that it's rejected with the expected status as the BLIP
+// Error-Code, and returns the body of the error response.
+func (bt *BlipTester) RequireRevRejected(docID, revID string, body []byte, expectedStatus int) (errorBody db.RevErrorResponseBody) {
+	_, _, res, err := bt.SendRev(docID, revID, body, blip.Properties{})
+	require.Errorf(bt.restTester.TB, err, "Expected rev %s/%s to be rejected", docID, revID)
+	require.NotNil(bt.restTester.TB, res)
+	require.Equal(bt.restTester.TB, strconv.Itoa(expectedStatus), res.Properties[db.BlipErrorCode])
+	require.NoError(bt.restTester.TB, res.ReadJSONBody(&errorBody))
+	return errorBody
+}
+
+// CreateConflict pushes a common ancestor revision of docID, followed by two independent child revisions of it with
+// the bodies branchA and branchB, and returns the rev IDs of the two conflicting leaves.  docID must not already exist,
+// the bodies must differ, and the database must allow conflicts.
+func (bt *BlipTester) CreateConflict(docID string, branchA, branchB []byte) (revIDA, revIDB string) {
+
+	tb := bt.restTester.TB
+	ancestorBody := []byte(`{}`)
+	ancestorRevID := db.CreateRevIDWithBytes(1, "", ancestorBody)
+	_, _, _, err := bt.SendRev(docID, ancestorRevID, ancestorBody, blip.Properties{})
+	require.NoError(tb, err)
+
+	revIDA = db.CreateRevIDWithBytes(2, ancestorRevID, branchA)
+	revIDB = db.CreateRevIDWithBytes(2, ancestorRevID, branchB)
+	require.NotEqual(tb, revIDA, revIDB, "Branches of a conflict must have different bodies")
+	_, _, _, err = bt.SendRevWithHistory(docID, revIDA, []string{ancestorRevID}, branchA, blip.Properties{db.RevMessageNoConflicts: "false"})
+	require.NoError(tb, err)
+	_, _, _, err = bt.SendRevWithHistory(docID, revIDB, []string{ancestorRevID}, branchB, blip.Properties{db.RevMessageNoConflicts: "false"})
+	require.NoError(tb, err)
+	return revIDA, revIDB
+}
+
+// RequireConflict fails the test unless the database reports docID as being in conflict, with each of leafRevIDs as
+// one of its non-deleted leaf revisions.
+func (bt *BlipTester) RequireConflict(docID string, leafRevIDs ...string) {
+
+	tb := bt.restTester.TB
+	doc, err := bt.restTester.GetDatabase().GetDocument(base.TestCtx(tb), docID, db.DocUnmarshalAll)
+	require.NoError(tb, err)
+	require.NotZerof(tb, doc.Flags&channels.Conflict, "Expected doc %s to be in conflict", docID)
+
+	var activeLeaves []string
+	for _, revID := range doc.History.GetLeaves() {
+		if !doc.History[revID].Deleted {
+			activeLeaves = append(activeLeaves, revID)
+		}
+	}
+	require.Subset(tb, activeLeaves, leafRevIDs)
+}
+
+// SendRevWithExpiry sends a rev for docId with the given expiry, which is applied to the document as for a REST write -
+// either a TTL in seconds, or a Unix timestamp.  Rev messages carry expiry in the body's _exp property rather than as a
+// message property, so this sets _exp on the given body before sending.
+func (bt *BlipTester) SendRevWithExpiry(docId, docRev string, body []byte, expiry uint32, properties blip.Properties) (sent bool, req, res *blip.Message, err error) {
+
+	var docBody db.Body
+	if err := base.JSONUnmarshal(body, &docBody); err != nil {
+		return false, nil, nil, err
+	}
+	docBody[db.BodyExpiry] = expiry
+	bodyWithExpiry, err := base.JSONMarshal(docBody)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	return bt.SendRev(docId, docRev, bodyWithExpiry, properties)
+}
+
+// SendDeltaRev sends toRevID of docID as a delta against fromRevID, which the server applies to fromRevID's body.
+// Requires delta sync to be enabled for the database.
+func (bt *BlipTester) SendDeltaRev(docID, fromRevID, toRevID string, deltaBody []byte, properties blip.Properties) (sent bool, req, res *blip.Message, err error) {
+
+	deltaProperties := blip.Properties{db.RevMessageDeltaSrc: fromRevID}
+	for k, v := range properties {
+		deltaProperties[k] = v
+	}
+	return bt.SendRevWithHistory(docID, toRevID, []string{fromRevID}, deltaBody, deltaProperties)
+}
+
+// RequireRevBody fails the test if the server's body for the given revision doesn't match expectedBody, e.g. to check
+// that a delta sent with SendDeltaRev was applied to the full body.
+func (bt *BlipTester) RequireRevBody(docID, revID string, expectedBody []byte) {
+
+	tb := bt.restTester.TB
+	resp := bt.restTester.SendAdminRequest(http.MethodGet, fmt.Sprintf("/db/%s?rev=%s", docID, revID), "")
+	RequireStatus(tb, resp, http.StatusOK)
+
+	var body db.Body
+	require.NoError(tb, base.JSONUnmarshal(resp.BodyBytes(), &body))
+	delete(body, db.BodyId)
+	delete(body, db.BodyRev)
+	actualBody, err := base.JSONMarshal(body)
+	require.NoError(tb, err)
+	require.JSONEq(tb, string(expectedBody), string(actualBody))
+}
+
 func getChangesHandler(changesFinishedWg, revsFinishedWg *sync.WaitGroup) func(request *blip.Message) {
 	return func(request *blip.Message) {
 		// Send a response telling the other side we want ALL revisions
@@ -1335,10 +1903,22 @@ type SendRevWithAttachmentInput struct {
 	attachmentLength int
 	attachmentBody   string
 	attachmentDigest string
+	attachments      []SendRevAttachment // Additional attachments to send with the rev
 	history          []string
 	body             []byte
 }
 
+// SendRevAttachment is an attachment sent with a rev by SendRevWithAttachment.  The attachments the server is expected
+// to request must have distinct digests.
+type SendRevAttachment struct {
+	name        string
+	body        string
+	digest      string // Defaults to the digest of body
+	contentType string // Defaults to application/json
+	revpos      int    // Generation of the rev the attachment was added in.  Defaults to 1
+	existing    bool   // Set if the server already has the attachment from an ancestor rev, so won't request it
+}
+
 // Warning: this can only be called from a single goroutine, given the fact it registers profile handlers.
 func (bt *BlipTester) SendRevWithAttachment(input SendRevWithAttachmentInput) (sent bool, req, res *blip.Message) {
 
@@ -1347,13 +1927,43 @@ func (bt *BlipTester) SendRevWithAttachment(input SendRevWithAttachmentInput) (s
 		delete(bt.blipContext.HandlerForProfile, "getAttachment")
 	}()
 
-	// Create a doc with an attachment
-	myAttachment := db.DocAttachment{
-		ContentType: "application/json",
-		Digest:      input.attachmentDigest,
-		Length:      input.attachmentLength,
-		Revpos:      1,
-		Stub:        true,
+	attachments := input.attachments
+	if input.attachmentName != "" {
+		attachments = append([]SendRevAttachment{{
+			name:   input.attachmentName,
+			body:   input.attachmentBody,
+			digest: input.attachmentDigest,
+		}}, attachments...)
+	}
+
+	// Create a doc with the attachments as stubs, expecting the server to request the bodies of the ones it doesn't
+	// already have
+	attachmentMap := make(db.AttachmentMap, len(attachments))
+	requestableBodies := make(map[string]string)
+	for _, attachment := range attachments {
+		docAttachment := db.DocAttachment{
+			ContentType: attachment.contentType,
+			Digest:      attachment.digest,
+			Length:      len(attachment.body),
+			Revpos:      attachment.revpos,
+			Stub:        true,
+		}
+		if docAttachment.ContentType == "" {
+			docAttachment.ContentType = "application/json"
+		}
+		if docAttachment.Digest == "" {
+			docAttachment.Digest = db.Sha1DigestKey([]byte(attachment.body))
+		}
+		if docAttachment.Revpos == 0 {
+			docAttachment.Revpos = 1
+		}
+		if attachment.name == input.attachmentName && input.attachmentLength > 0 {
+			docAttachment.Length = input.attachmentLength
+		}
+		attachmentMap[attachment.name] = &docAttachment
+		if !attachment.existing {
+			requestableBodies[docAttachment.Digest] = attachment.body
+		}
 	}
 
 	doc := NewRestDocument()
@@ -1364,9 +1974,7 @@ func (bt *BlipTester) SendRevWithAttachment(input SendRevWithAttachmentInput) (s
 		}
 	}
 
-	doc.SetAttachments(db.AttachmentMap{
-		input.attachmentName: &myAttachment,
-	})
+	doc.SetAttachments(attachmentMap)
 
 	docBody, err := base.JSONMarshal(doc)
 	if err != nil {
@@ -1376,16 +1984,22 @@ func (bt *BlipTester) SendRevWithAttachment(input SendRevWithAttachmentInput) (s
 	getAttachmentWg := sync.WaitGroup{}
 
 	bt.blipContext.HandlerForProfile["getAttachment"] = func(request *blip.Message) {
-		defer getAttachmentWg.Done()
-		if request.Properties["digest"] != myAttachment.Digest {
-			panic(fmt.Sprintf("Unexpected digest.  Got: %v, expected: %v", request.Properties["digest"], myAttachment.Digest))
+		digest := request.Properties["digest"]
+		bt.recordGetAttachmentRequest(digest)
+		attachmentBody, ok := requestableBodies[digest]
+		if !ok {
+			// Fail the test rather than panic, so that callers can still report which attachment was requested
+			bt.tb.Errorf("Unexpected getAttachment request for digest %s, expected one of: %v", digest, requestableBodies)
+			request.Response().SetError("HTTP", http.StatusNotFound, "unexpected digest")
+			return
 		}
+		defer getAttachmentWg.Done()
 		response := request.Response()
-		response.SetBody([]byte(input.attachmentBody))
+		response.SetBody([]byte(attachmentBody))
 	}
 
-	// Push a rev with an attachment.
-	getAttachmentWg.Add(1)
+	// Push a rev with the attachments.
+	getAttachmentWg.Add(len(requestableBodies))
 	sent, req, res, _ = bt.SendRevWithHistory(
 		input.docId,
 		input.revId,
@@ -1394,13 +2008,52 @@ func (bt *BlipTester) SendRevWithAttachment(input SendRevWithAttachmentInput) (s
 		blip.Properties{},
 	)
 
-	// Expect a callback to the getAttachment endpoint
+	// Expect a callback to the getAttachment endpoint for each attachment the server doesn't have
 	getAttachmentWg.Wait()
 
 	return sent, req, res
 
 }
 
+// recordGetAttachmentRequest counts a getAttachment request made by the server for digest.
+func (bt *BlipTester) recordGetAttachmentRequest(digest string) {
+	bt.getAttachmentRequestsLock.Lock()
+	defer bt.getAttachmentRequestsLock.Unlock()
+	if bt.getAttachmentRequests == nil {
+		bt.getAttachmentRequests = make(map[string]int)
+	}
+	bt.getAttachmentRequests[digest]++
+}
+
+// GetAttachmentRequestCount returns the number of getAttachment requests the server has made for digest while revs
+// were pushed by SendRevWithAttachment.
+func (bt *BlipTester) GetAttachmentRequestCount(digest string) int {
+	bt.getAttachmentRequestsLock.Lock()
+	defer bt.getAttachmentRequestsLock.Unlock()
+	return bt.getAttachmentRequests[digest]
+}
+
+// RequireAttachmentNotReuploaded pushes a rev with a stub for an attachment the server already has from an ancestor
+// rev, and requires the rev to be accepted without the server requesting the attachment's body again.
+func (bt *BlipTester) RequireAttachmentNotReuploaded(docID, revID string, history []string, attachment SendRevAttachment) {
+	digest := attachment.digest
+	if digest == "" {
+		digest = db.Sha1DigestKey([]byte(attachment.body))
+	}
+	requestsBefore := bt.GetAttachmentRequestCount(digest)
+
+	attachment.existing = true
+	sent, _, res := bt.SendRevWithAttachment(SendRevWithAttachmentInput{
+		docId:       docID,
+		revId:       revID,
+		history:     history,
+		attachments: []SendRevAttachment{attachment},
+	})
+	require.True(bt.tb, sent)
+	require.Equal(bt.tb, "", res.Properties[db.BlipErrorCode])
+	assert.Equal(bt.tb, requestsBefore, bt.GetAttachmentRequestCount(digest), "Attachment %q was re-uploaded", attachment.name)
+}
+
 func (bt *BlipTester) WaitForNumChanges(numChangesExpected int) (changes [][]interface{}) {
 
 	retryWorker := func() (shouldRetry bool, err error, value interface{}) {
@@ -1425,18 +2078,150 @@ func (bt *BlipTester) WaitForNumChanges(numChangesExpected int) (changes [][]int
 
 }
 
+// WaitForNumChangesWithTimeout polls changes until at least numChangesExpected changes are seen, like
+// WaitForNumChanges, but returns an error if that hasn't happened within timeout, including when a GetChanges call
+// never completes.
+func (bt *BlipTester) WaitForNumChangesWithTimeout(numChangesExpected int, timeout time.Duration) (changes [][]interface{}, err error) {
+	changes, lastChanges, ok := bt.pollChangesWithTimeout(timeout, func(changes [][]interface{}) bool {
+		return len(changes) >= numChangesExpected
+	})
+	if !ok {
+		return nil, fmt.Errorf("Timed out after %v waiting for %d changes, last saw %d", timeout, numChangesExpected, len(lastChanges))
+	}
+	return changes, nil
+}
+
+// WaitForChange polls changes until one is seen for the given revision of a document, ignoring changes for any other
+// documents or revisions, and returns it.  Returns an error if it hasn't been seen within timeout.
+func (bt *BlipTester) WaitForChange(docID, revID string, timeout time.Duration) (change []interface{}, err error) {
+	findChange := func(changes [][]interface{}) []interface{} {
+		for _, change := range changes {
+			if len(change) >= 3 && change[1] == docID && change[2] == revID {
+				return change
+			}
+		}
+		return nil
+	}
+	changes, lastChanges, ok := bt.pollChangesWithTimeout(timeout, func(changes [][]interface{}) bool {
+		return findChange(changes) != nil
+	})
+	if !ok {
+		return nil, fmt.Errorf("Timed out after %v waiting for change for doc %q rev %s, last saw %d changes", timeout, docID, revID, len(lastChanges))
+	}
+	return findChange(changes), nil
+}
+
+// pollChangesWithTimeout calls GetChanges until done returns true for the changes seen, and returns them.  If that
+// hasn't happened within timeout, including when a GetChanges call never completes, returns false along with the
+// last changes seen.
+func (bt *BlipTester) pollChangesWithTimeout(timeout time.Duration, done func(changes [][]interface{}) bool) (changes, lastChanges [][]interface{}, ok bool) {
+
+	var seenChanges [][]interface{}
+	var seenChangesLock sync.Mutex
+	found := make(chan [][]interface{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		sleeper := base.CreateIndefiniteMaxDoublingSleeperFunc(5, 500)
+		for attempt := 1; ; attempt++ {
+			currentChanges := bt.GetChanges()
+			seenChangesLock.Lock()
+			seenChanges = currentChanges
+			seenChangesLock.Unlock()
+			if done(currentChanges) {
+				found <- currentChanges
+				return
+			}
+			_, sleepMs := sleeper(attempt)
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Duration(sleepMs) * time.Millisecond):
+			}
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case changes = <-found:
+		return changes, nil, true
+	case <-timer.C:
+		seenChangesLock.Lock()
+		defer seenChangesLock.Unlock()
+		return nil, seenChanges, false
+	}
+}
+
+// WaitForNumChangesInChannel waits until at least numChangesExpected of the changes sent to the client are for
+// revisions in the given channel, and returns all of the changes.
+func (bt *BlipTester) WaitForNumChangesInChannel(channel string, numChangesExpected int) (changes [][]interface{}) {
+
+	retryWorker := func() (shouldRetry bool, err error, value interface{}) {
+		currentChanges := bt.GetChanges()
+		if bt.CountChangesByChannel(currentChanges)[channel] >= numChangesExpected {
+			return false, nil, currentChanges
+		}
+
+		// haven't seen numChangesExpected in the channel yet, so wait and retry
+		return true, nil, nil
+	}
+
+	_, rawChanges := base.RetryLoop(
+		"WaitForNumChangesInChannel",
+		retryWorker,
+		base.CreateDoublingSleeperFunc(10, 10),
+	)
+
+	changes, _ = rawChanges.([][]interface{})
+	return changes
+}
+
+// CountChangesByChannel returns the number of changes in each channel, for changes in the form returned by GetChanges.
+// A change counts towards every channel its revision is in.
+func (bt *BlipTester) CountChangesByChannel(changes [][]interface{}) map[string]int {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		for channel := range bt.ChangeChannels(change) {
+			counts[channel]++
+		}
+	}
+	return counts
+}
+
+// ChangeChannels returns the channels of the revision for a change in the form returned by GetChanges, as recorded
+// in the document's rev tree.
+func (bt *BlipTester) ChangeChannels(change []interface{}) base.Set {
+	require.GreaterOrEqual(bt.restTester.TB, len(change), 3, "Unexpected change format: %v", change)
+	docID, _ := change[1].(string)
+	revID, _ := change[2].(string)
+
+	doc, err := bt.restTester.GetDatabase().GetDocument(base.TestCtx(bt.restTester.TB), docID, db.DocUnmarshalSync)
+	require.NoError(bt.restTester.TB, err, "Error getting document %q for change", docID)
+	revInfo, ok := doc.History[revID]
+	require.True(bt.restTester.TB, ok, "Revision %s not found in rev tree for document %q", revID, docID)
+	return revInfo.Channels
+}
+
 // Returns changes in form of [[sequence, docID, revID, deleted], [sequence, docID, revID, deleted]]
 // Warning: this can only be called from a single goroutine, given the fact it registers profile handlers.
 func (bt *BlipTester) GetChanges() (changes [][]interface{}) {
+	return bt.GetChangesWithProperties(nil)
+}
+
+// GetChangesWithProperties returns changes as for GetChanges, sending subChanges with the given additional properties.
+// Warning: this can only be called from a single goroutine, given the fact it registers profile handlers.
+func (bt *BlipTester) GetChangesWithProperties(properties blip.Properties) (changes [][]interface{}) {
 
 	defer func() {
 		// Clean up all profile handlers that are registered as part of this test
-		delete(bt.blipContext.HandlerForProfile, "changes") // a handler for this profile is registered in SubscribeToChanges
+		delete(bt.blipContext.HandlerForProfile, "changes") // a handler for this profile is registered in subscribeToChanges
 	}()
 
 	collectedChanges := [][]interface{}{}
 	chanChanges := make(chan *blip.Message)
-	bt.SubscribeToChanges(false, chanChanges)
+	bt.subscribeToChanges(false, properties, chanChanges)
 
 	for changeMsg := range chanChanges {
 
@@ -1463,6 +2248,60 @@ func (bt *BlipTester) GetChanges() (changes [][]interface{}) {
 
 	}
 
+	bt.changesDeleted = make(map[string]bool, len(collectedChanges))
+	for _, change := range collectedChanges {
+		if docID, ok := change[1].(string); ok {
+			bt.changesDeleted[docID] = isDeletedChange(change)
+		}
+	}
+
+	return collectedChanges
+
+}
+
+// GetBinaryChanges returns changes requested using the binary changes encoding, in the form of
+// [[sequence, docID, revID, deleted], [sequence, docID, revID, deleted]] with each field decoded as a string.
+// Warning: this can only be called from a single goroutine, given the fact it registers profile handlers.
+func (bt *BlipTester) GetBinaryChanges() (changes [][]string) {
+
+	defer func() {
+		// Clean up all profile handlers that are registered as part of this test
+		delete(bt.blipContext.HandlerForProfile, "changes") // a handler for this profile is registered in subscribeToChanges
+	}()
+
+	collectedChanges := [][]string{}
+	chanChanges := make(chan *blip.Message)
+	subChangesResponse := bt.subscribeToChanges(false, blip.Properties{db.SubChangesEncoding: db.ChangesEncodingBinary}, chanChanges)
+	if encoding := subChangesResponse.Properties[db.SubChangesResponseEncoding]; encoding != db.ChangesEncodingBinary {
+		panic(fmt.Sprintf("Binary changes encoding not accepted, subChanges response encoding: %q", encoding))
+	}
+
+	for changeMsg := range chanChanges {
+
+		if encoding := changeMsg.Properties[db.ChangesMessageEncoding]; encoding != db.ChangesEncodingBinary {
+			panic(fmt.Sprintf("Expected binary encoded changes message, got encoding: %q", encoding))
+		}
+
+		body, err := changeMsg.Body()
+		if err != nil {
+			panic(fmt.Sprintf("Error getting request body: %v", err))
+		}
+
+		changesBatch, err := db.DecodeBinaryChanges(body)
+		if err != nil {
+			panic(fmt.Sprintf("Error decoding binary changes. Body: %v.  Error: %v", body, err))
+		}
+
+		if len(changesBatch) == 0 {
+			// the other side indicated that it's done sending changes.
+			close(chanChanges)
+			break
+		}
+
+		collectedChanges = append(collectedChanges, changesBatch...)
+
+	}
+
 	return collectedChanges
 
 }
@@ -1555,6 +2394,9 @@ func (bt *BlipTester) PullDocs() (docs map[string]RestDocument) {
 			if bt.blipContext.ActiveSubprotocol() == db.BlipCBMobileReplicationV3 {
 				getAttachmentRequest.Properties[db.GetAttachmentID] = docId
 			}
+			if bt.getAttachmentEncoding != "" {
+				getAttachmentRequest.Properties[db.GetAttachmentEncoding] = bt.getAttachmentEncoding
+			}
 			sent := bt.sender.Send(getAttachmentRequest)
 			if !sent {
 				panic("Unable to get attachment.")
@@ -1564,6 +2406,17 @@ func (bt *BlipTester) PullDocs() (docs map[string]RestDocument) {
 			if getAttachmentErr != nil {
 				panic(fmt.Sprintf("Unexpected err: %v", err))
 			}
+			if encoding := getAttachmentResponse.Properties[db.GetAttachmentResponseEncoding]; encoding != bt.getAttachmentEncoding {
+				panic(fmt.Sprintf("Expected attachment encoding %q, got %q", bt.getAttachmentEncoding, encoding))
+			} else if encoding == db.AttachmentEncodingGzip {
+				gz, err := gzip.NewReader(bytes.NewReader(getAttachmentBody))
+				if err != nil {
+					panic(fmt.Sprintf("Unexpected err reading gzipped attachment: %v", err))
+				}
+				if getAttachmentBody, err = io.ReadAll(gz); err != nil {
+					panic(fmt.Sprintf("Unexpected err reading gzipped attachment: %v", err))
+				}
+			}
 			log.Printf("getAttachmentBody: %s", getAttachmentBody)
 			attachment.Data = getAttachmentBody
 		}
@@ -1603,7 +2456,51 @@ func (bt *BlipTester) PullDocs() (docs map[string]RestDocument) {
 
 }
 
+// MeasureChangeLatency pushes a revision with SendRev, and returns the time from just before it's sent until its change
+// is received on changes.  changes must be fed by a continuous changes subscription (see SubscribeToChanges), usually
+// on another BlipTester connected to the same database, and must not be read elsewhere while this is waiting.  Changes
+// for other revisions received in the meantime are discarded.  Returns an error if the change isn't received within
+// timeout.
+func (bt *BlipTester) MeasureChangeLatency(docID, revID string, body []byte, changes <-chan *blip.Message, timeout time.Duration) (latency time.Duration, err error) {
+
+	startTime := time.Now()
+	if _, _, _, err := bt.SendRev(docID, revID, body, blip.Properties{}); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case changesMsg := <-changes:
+			changesBody, err := changesMsg.Body()
+			if err != nil {
+				return 0, err
+			}
+			if string(changesBody) == "null" {
+				continue
+			}
+			var changesBatch [][]interface{}
+			if err := base.JSONUnmarshal(changesBody, &changesBatch); err != nil {
+				return 0, fmt.Errorf("Error unmarshalling changes %s: %w", changesBody, err)
+			}
+			for _, change := range changesBatch {
+				if len(change) >= 3 && change[1] == docID && change[2] == revID {
+					return time.Since(startTime), nil
+				}
+			}
+		case <-timer.C:
+			return 0, fmt.Errorf("Timed out after %v waiting for change for doc %q rev %s", timeout, docID, revID)
+		}
+	}
+}
+
 func (bt *BlipTester) SubscribeToChanges(continuous bool, changes chan<- *blip.Message) {
+	bt.subscribeToChanges(continuous, nil, changes)
+}
+
+// subscribeToChanges sends subChanges with the given additional properties, and returns the subChanges response.
+func (bt *BlipTester) subscribeToChanges(continuous bool, properties blip.Properties, changes chan<- *blip.Message) *blip.Message {
 
 	// When this test sends subChanges, Sync Gateway will send a changes request that must be handled
 	bt.blipContext.HandlerForProfile["changes"] = func(request *blip.Message) {
@@ -1626,6 +2523,9 @@ func (bt *BlipTester) SubscribeToChanges(continuous bool, changes chan<- *blip.M
 	// Send subChanges to subscribe to changes, which will cause the "changes" profile handler above to be called back
 	subChangesRequest := blip.NewRequest()
 	subChangesRequest.SetProfile("subChanges")
+	for k, v := range properties {
+		subChangesRequest.Properties[k] = v
+	}
 	switch continuous {
 	case true:
 		subChangesRequest.Properties["continuous"] = "true"
@@ -1641,6 +2541,7 @@ func (bt *BlipTester) SubscribeToChanges(continuous bool, changes chan<- *blip.M
 	if subChangesResponse.SerialNumber() != subChangesRequest.SerialNumber() {
 		panic(fmt.Sprintf("subChangesResponse.SerialNumber() != subChangesRequest.SerialNumber().  %v != %v", subChangesResponse.SerialNumber(), subChangesRequest.SerialNumber()))
 	}
+	return subChangesResponse
 
 }
 
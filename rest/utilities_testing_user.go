@@ -14,9 +14,18 @@ import (
 )
 
 func MakeUser(t *testing.T, httpClient *http.Client, serverURL, username, password string, roles []string) {
+	MakeUserWithMetadata(t, httpClient, serverURL, username, password, roles, nil)
+}
+
+// MakeUserWithMetadata creates a Couchbase Server local user like MakeUser, also setting the given fields on the user.
+// The fields must be ones the Couchbase Server user API accepts, such as name (the user's full name) and groups.
+func MakeUserWithMetadata(t *testing.T, httpClient *http.Client, serverURL, username, password string, roles []string, metadata map[string]string) {
 	form := url.Values{}
 	form.Add("password", password)
 	form.Add("roles", strings.Join(roles, ","))
+	for field, value := range metadata {
+		form.Add(field, value)
+	}
 
 	retryWorker := func() (shouldRetry bool, err error, value interface{}) {
 		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/settings/rbac/users/local/%s", serverURL, username), strings.NewReader(form.Encode()))
@@ -45,6 +54,23 @@ func MakeUser(t *testing.T, httpClient *http.Client, serverURL, username, passwo
 	require.NoError(t, resp.(*http.Response).Body.Close(), "Error closing response body")
 }
 
+// GetUser returns the Couchbase Server local user with the given username, as returned by the Couchbase Server user API.
+func GetUser(t *testing.T, httpClient *http.Client, serverURL, username string) (user map[string]interface{}) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/settings/rbac/users/local/%s", serverURL, username), nil)
+	require.NoError(t, err)
+	req.SetBasicAuth(base.TestClusterUsername(), base.TestClusterPassword())
+
+	resp, err := httpClient.Do(req)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close(), "Error closing response body")
+	}()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, base.JSONDecoder(resp.Body).Decode(&user))
+	return user
+}
+
 func DeleteUser(t *testing.T, httpClient *http.Client, serverURL, username string) {
 	retryWorker := func() (shouldRetry bool, err error, value interface{}) {
 		req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/settings/rbac/users/local/%s", serverURL, username), nil)